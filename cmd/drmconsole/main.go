@@ -0,0 +1,102 @@
+// Command drmconsole shows its standard input on a display as a text console,
+// without the kernel's fbcon, e.g. to show logs during boot or recovery:
+//
+//	journalctl -f | drmconsole /dev/dri/card0
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/inahga/inahgo/drm"
+	"github.com/inahga/inahgo/drm/console"
+)
+
+var (
+	connector = flag.String("connector", "first-connected", "connector of the display, as a selector such as HDMI-A-1, DP-2, 42 or make=DEL")
+	scale     = flag.Int("scale", 0, "size in pixels of a pixel of the font, or 0 to fit 80 columns and 25 rows")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] <path to gpu>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(flag.Arg(0)); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		os.Exit(1)
+	}
+}
+
+func run(path string) error {
+	card, err := drm.Open(path)
+	if err != nil {
+		return err
+	}
+	defer card.Close()
+
+	sel, err := drm.ParseSelector(*connector)
+	if err != nil {
+		return err
+	}
+	conn, err := card.SelectConnector(sel)
+	if err != nil {
+		return err
+	}
+	monitor, err := findMonitor(card, conn.ID)
+	if err != nil {
+		return err
+	}
+	c, err := console.Open(card, monitor, &console.Options{Scale: *scale})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(c, os.Stdin)
+		done <- err
+	}()
+
+	select {
+	case <-sig:
+		return nil
+	case err := <-done:
+		if err != nil {
+			return err
+		}
+	}
+	// Keep showing the end of the input until interrupted.
+	<-sig
+	return nil
+}
+
+// findMonitor returns the monitor driven by a connector.
+func findMonitor(card *drm.Card, connID uint32) (*drm.Monitor, error) {
+	monitors, err := card.Monitors()
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range monitors {
+		for _, tile := range m.Tiles {
+			if tile.ConnectorID == connID {
+				return &monitors[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("connector %d is not connected", connID)
+}
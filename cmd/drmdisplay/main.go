@@ -0,0 +1,169 @@
+// Command drmdisplay applies the profile of a display configuration that matches
+// the connected displays, autorandr-style but without X11. It can keep running
+// to re-apply the matching profile whenever displays are plugged or unplugged,
+// and can print the current state as a profile to start a configuration from.
+//
+// The outputs show a black screen allocated by drmdisplay, which the kernel
+// removes when drmdisplay exits, so it keeps running until interrupted.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/inahga/inahgo/drm"
+	"github.com/inahga/inahgo/drm/display"
+	"github.com/inahga/inahgo/internal/yaml"
+)
+
+var (
+	configPath  = flag.String("config", "", "path to the configuration, in JSON or YAML")
+	profileName = flag.String("profile", "", "profile to apply instead of the first one matching the connected displays")
+	testOnly    = flag.Bool("test", false, "only test whether the profile can be applied, then exit")
+	daemon      = flag.Bool("daemon", false, "re-apply the matching profile whenever displays are plugged or unplugged")
+	current     = flag.String("current", "", "print the current state as a profile of the given name, then exit")
+	jsonOutput  = flag.Bool("json", false, "print the current state as JSON rather than YAML")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] <path to gpu>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || (*configPath == "") == (*current == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(flag.Arg(0)); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		os.Exit(1)
+	}
+}
+
+func run(path string) error {
+	card, err := drm.Open(path)
+	if err != nil {
+		return err
+	}
+	defer card.Close()
+	if card.NodeType() == drm.NodeRender {
+		return fmt.Errorf("%s is a render node, which cannot set modes", path)
+	}
+	for _, c := range []uint64{drm.ClientCapUniversalPlanes, drm.ClientCapAtomic} {
+		if err := card.SetClientCap(c, 1); err != nil {
+			return fmt.Errorf("set client cap: %w", err)
+		}
+	}
+
+	if *current != "" {
+		return printCurrent(card, *current)
+	}
+	cfg, err := display.ReadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if *profileName != "" {
+		if cfg, err = onlyProfile(cfg, *profileName); err != nil {
+			return err
+		}
+	}
+	if *testOnly {
+		return test(card, cfg)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	w := display.NewWatcher(card, cfg)
+	defer w.Close()
+
+	if *daemon {
+		err := w.Run(ctx, func(l *display.Layout, err error) {
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+				return
+			}
+			printLayout(l)
+		})
+		if err == context.Canceled {
+			return nil
+		}
+		return err
+	}
+
+	l, err := w.Apply()
+	if err != nil {
+		return err
+	}
+	printLayout(l)
+	<-ctx.Done()
+	return nil
+}
+
+// onlyProfile returns a configuration holding only the named profile.
+func onlyProfile(cfg *display.Config, name string) (*display.Config, error) {
+	for _, p := range cfg.Profiles {
+		if p.Name == name {
+			return &display.Config{Profiles: []display.Profile{p}}, nil
+		}
+	}
+	return nil, fmt.Errorf("no profile %s in %s", name, *configPath)
+}
+
+func test(card *drm.Card, cfg *display.Config) error {
+	displays, err := display.Displays(card)
+	if err != nil {
+		return err
+	}
+	p, matched, err := cfg.Match(displays)
+	if err != nil {
+		return err
+	}
+	l, err := display.Plan(card, p, matched)
+	if err != nil {
+		return fmt.Errorf("profile %s: %w", p.Name, err)
+	}
+	if _, err := l.Apply(card, true); err != nil {
+		return fmt.Errorf("profile %s: %w", p.Name, err)
+	}
+	printLayout(l)
+	return nil
+}
+
+func printLayout(l *display.Layout) {
+	fmt.Printf("profile %s, screen %dx%d\n", l.Profile.Name, l.Width, l.Height)
+	for _, p := range l.Outputs {
+		name := p.Display.Connector.Name()
+		if p.Mode == nil {
+			fmt.Printf("  %s: disabled\n", name)
+			continue
+		}
+		fmt.Printf("  %s: %s@%d on crtc %d, showing %dx%d+%d+%d\n", name, p.Mode.Name, p.Mode.VRefresh,
+			p.CRTCID, p.Rect.Dx(), p.Rect.Dy(), p.Rect.Min.X, p.Rect.Min.Y)
+	}
+}
+
+func printCurrent(card *drm.Card, name string) error {
+	p, err := display.Current(card, name)
+	if err != nil {
+		return err
+	}
+	cfg := display.Config{Profiles: []display.Profile{*p}}
+	var out []byte
+	if *jsonOutput {
+		if out, err = json.MarshalIndent(cfg, "", "    "); err != nil {
+			return err
+		}
+		out = append(out, '\n')
+	} else if out, err = yaml.Marshal(cfg); err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// diff prints the differences between the trees of two cards or snapshots.
+func diff(a, b string) error {
+	snapA, err := capture(a)
+	if err != nil {
+		return err
+	}
+	snapB, err := capture(b)
+	if err != nil {
+		return err
+	}
+	if err := filter(snapA); err != nil {
+		return err
+	}
+	if err := filter(snapB); err != nil {
+		return err
+	}
+
+	_, err = os.Stdout.WriteString(diffTrees(tree(snapA), tree(snapB)))
+	return err
+}
+
+// diffTrees returns the differences between two trees, one per line: "~" for
+// changed values, "-" for nodes only in a, and "+" for nodes only in b.
+func diffTrees(a, b *node) string {
+	var out strings.Builder
+	if a.value != b.value {
+		fmt.Fprintf(&out, "~ %s: %s -> %s\n", a.label, a.value, b.value)
+	}
+	diffChildren(&out, nil, a, b)
+	return out.String()
+}
+
+// diffChildren writes the differences between the children of two nodes,
+// matched by label. Nodes only present on one side are written without their
+// children.
+func diffChildren(out *strings.Builder, path []string, a, b *node) {
+	childrenA, childrenB := byLabel(a.children), byLabel(b.children)
+	for _, child := range a.children {
+		other, ok := childrenB[child.label]
+		if !ok {
+			fmt.Fprintf(out, "- %s\n", strings.Join(append(path, child.line()), " > "))
+			continue
+		}
+		childPath := append(path[:len(path):len(path)], child.label)
+		if child.value != other.value {
+			fmt.Fprintf(out, "~ %s: %s -> %s\n", strings.Join(childPath, " > "), child.value, other.value)
+		}
+		diffChildren(out, childPath, child, other)
+	}
+	for _, child := range b.children {
+		if _, ok := childrenA[child.label]; !ok {
+			fmt.Fprintf(out, "+ %s\n", strings.Join(append(path, child.line()), " > "))
+		}
+	}
+}
+
+// byLabel indexes nodes by label. Labels are made unique by numbering repeated
+// ones, e.g. modes of the same name and refresh rate.
+func byLabel(nodes []*node) map[string]*node {
+	ret := make(map[string]*node, len(nodes))
+	for _, n := range nodes {
+		label := n.label
+		for i := 2; ret[n.label] != nil; i++ {
+			n.label = fmt.Sprintf("%s #%d", label, i)
+		}
+		ret[n.label] = n
+	}
+	return ret
+}
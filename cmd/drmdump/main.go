@@ -1,127 +1,220 @@
+// Command drmdump prints the state of a DRM card, or of a snapshot previously
+// written by drmdump, as a tree, JSON or YAML. It can also compare two cards or
+// snapshots.
 package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/inahga/inahgo/drm"
+	"github.com/inahga/inahgo/internal/yaml"
 )
 
+var (
+	jsonOutput = flag.Bool("json", false, "print the snapshot as JSON, which can be replayed")
+	yamlOutput = flag.Bool("yaml", false, "print the snapshot as YAML")
+	connectors = flag.String("connector", "", "comma-separated names or IDs of the connectors to show, "+
+		"along with the encoder, CRTC, planes and framebuffers driving them")
+	only = flag.String("only", "", "comma-separated sections to show, of: "+strings.Join(sections, ", "))
+)
+
+var sections = []string{"connectors", "encoders", "crtcs", "planes", "framebuffers"}
+
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "usage: %s <path to gpu>\n", os.Args[0])
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "usage: %s [flags] <path to gpu or snapshot>\n", os.Args[0])
+		fmt.Fprintf(out, "       %s diff <path to gpu or snapshot> <path to gpu or snapshot>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var err error
+	switch {
+	case flag.NArg() == 3 && flag.Arg(0) == "diff":
+		err = diff(flag.Arg(1), flag.Arg(2))
+	case flag.NArg() == 1:
+		err = dump(flag.Arg(0))
+	default:
+		flag.Usage()
 		os.Exit(2)
 	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		os.Exit(1)
+	}
+}
 
-	card, err := drm.Open(os.Args[1])
+// open opens a card, or a snapshot written by drmdump, which is replayed.
+func open(path string) (*drm.Card, error) {
+	fi, err := os.Stat(path)
 	if err != nil {
-		panic(fmt.Errorf("open: %s", err))
+		return nil, err
+	}
+	if fi.Mode()&os.ModeDevice != 0 {
+		return drm.Open(path)
 	}
-	defer card.Close()
 
-	type connector struct {
-		Connector  *drm.ModeConnector
-		Properties []*drm.ModeProperty
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
 	}
-	dump := struct {
-		Version      *drm.Version
-		Resources    *drm.ModeResources
-		CRTCs        []*drm.ModeCRTC
-		Encoders     []*drm.ModeEncoder
-		Connectors   []connector
-		Blobs        []*drm.ModeBlob
-		Planes       []*drm.ModePlane
-		Framebuffers []*drm.ModeFramebuffer
-	}{}
-
-	ver, err := card.Version()
+	defer f.Close()
+	snap, err := drm.ReadSnapshot(f)
 	if err != nil {
-		panic(fmt.Errorf("version: %s", err))
+		return nil, err
 	}
-	dump.Version = ver
+	return drm.NewReplay(snap)
+}
 
-	for _, cap := range []uint64{drm.ClientCapAtomic, drm.ClientCapUniversalPlanes,
-		drm.ClientCapWritebackConnectors} {
-		if err := card.SetClientCap(cap, 1); err != nil {
-			panic(fmt.Errorf("setcap: %w", err))
+// capture returns a snapshot of a card or replayed snapshot, with every client
+// capability that reveals more state set. Capabilities the card lacks are
+// reported as errors of the snapshot.
+func capture(path string) (*drm.Snapshot, error) {
+	card, err := open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	defer card.Close()
+
+	var capErrors []drm.SnapshotError
+	for _, c := range []struct {
+		name string
+		cap  uint64
+	}{
+		{"atomic", drm.ClientCapAtomic},
+		{"universal planes", drm.ClientCapUniversalPlanes},
+		{"writeback connectors", drm.ClientCapWritebackConnectors},
+	} {
+		if err := card.SetClientCap(c.cap, 1); err != nil {
+			capErrors = append(capErrors, drm.SnapshotError{Object: "client cap " + c.name, Error: err.Error()})
 		}
 	}
 
-	res, err := card.ModeGetResources()
+	snap, err := card.Snapshot()
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %s: %w", path, err)
+	}
+	snap.Errors = append(capErrors, snap.Errors...)
+	return snap, nil
+}
+
+func dump(path string) error {
+	snap, err := capture(path)
 	if err != nil {
-		panic(fmt.Errorf("resources: %s", err))
+		return err
+	}
+	if err := filter(snap); err != nil {
+		return err
 	}
-	dump.Resources = res
 
-	for _, conn := range res.ConnectorIDs {
-		c, err := card.ModeGetConnector(conn)
-		if err != nil {
-			panic(fmt.Errorf("connector: %s", err))
+	var out []byte
+	switch {
+	case *jsonOutput:
+		if out, err = json.MarshalIndent(snap, "", "    "); err != nil {
+			return err
+		}
+		out = append(out, '\n')
+	case *yamlOutput:
+		if out, err = yaml.Marshal(snap); err != nil {
+			return err
 		}
+	default:
+		out = []byte(tree(snap).String())
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
 
-		var properties []*drm.ModeProperty
-		for index, prop := range c.PropIDs {
-			p, err := card.ModeGetProperty(prop)
-			if err != nil {
-				panic(fmt.Errorf("property: %s", err))
+// filter removes the objects not selected by the -connector and -only flags
+// from a snapshot. Properties and blobs are kept, as the remaining objects may
+// refer to them.
+func filter(snap *drm.Snapshot) error {
+	if *connectors != "" {
+		names := make(map[string]bool)
+		for _, name := range strings.Split(*connectors, ",") {
+			names[strings.TrimSpace(name)] = true
+		}
+		var (
+			conns              []drm.SnapshotConnector
+			encoders, crtcs    = make(map[uint32]bool), make(map[uint32]bool)
+			framebuffers       = make(map[uint32]bool)
+			keptEnc, keptCRTCs = snap.Encoders[:0], snap.CRTCs[:0]
+			keptPlanes, keptFB = snap.Planes[:0], snap.Framebuffers[:0]
+		)
+		for _, conn := range snap.Connectors {
+			if names[conn.Name] || names[fmt.Sprint(conn.ID)] {
+				conns = append(conns, conn)
+				if conn.EncoderID != 0 {
+					encoders[conn.EncoderID] = true
+				}
 			}
-			properties = append(properties, p)
+		}
+		if len(conns) == 0 {
+			return fmt.Errorf("no connector %s", *connectors)
+		}
+		snap.Connectors = conns
 
-			if p.Flags&drm.ModePropBlob != 0 && c.PropValues[index] != 0 {
-				b, err := card.ModeGetBlob(uint32(c.PropValues[index]))
-				if err != nil {
-					panic(fmt.Errorf("blob: %s", err))
+		for _, enc := range snap.Encoders {
+			if encoders[enc.ID] {
+				keptEnc = append(keptEnc, enc)
+				if enc.CRTCID != 0 {
+					crtcs[enc.CRTCID] = true
 				}
-				dump.Blobs = append(dump.Blobs, b)
 			}
 		}
-
-		dump.Connectors = append(dump.Connectors, connector{
-			Connector:  c,
-			Properties: properties,
-		})
-	}
-
-	for _, crtc := range res.CRTCIDs {
-		c, err := card.ModeGetCRTC(crtc)
-		if err != nil {
-			panic(fmt.Errorf("crtc: %s", err))
+		for _, crtc := range snap.CRTCs {
+			if crtcs[crtc.ID] {
+				keptCRTCs = append(keptCRTCs, crtc)
+				framebuffers[crtc.FBID] = true
+			}
 		}
-		dump.CRTCs = append(dump.CRTCs, c)
-	}
-
-	for _, encoder := range res.EncoderIDs {
-		e, err := card.ModeGetEncoder(encoder)
-		if err != nil {
-			panic(fmt.Errorf("encoder: %s", err))
+		for _, plane := range snap.Planes {
+			if crtcs[plane.CRTCID] && plane.CRTCID != 0 {
+				keptPlanes = append(keptPlanes, plane)
+				framebuffers[plane.FBID] = true
+			}
 		}
-		dump.Encoders = append(dump.Encoders, e)
-	}
-
-	planes, err := card.ModeGetPlaneResources()
-	if err != nil {
-		panic(fmt.Errorf("planes: %s", err))
-	}
-	for _, id := range *planes {
-		plane, err := card.ModeGetPlane(id)
-		if err != nil {
-			panic(fmt.Errorf("plane: %s", err))
+		for _, fb := range snap.Framebuffers {
+			if framebuffers[fb.ID] {
+				keptFB = append(keptFB, fb)
+			}
 		}
-		dump.Planes = append(dump.Planes, plane)
+		snap.Encoders, snap.CRTCs, snap.Planes, snap.Framebuffers = keptEnc, keptCRTCs, keptPlanes, keptFB
 	}
 
-	for _, fb := range res.FBIDs {
-		f, err := card.ModeGetFramebuffer(fb)
-		if err != nil {
-			panic(fmt.Errorf("framebuffer: %s", err))
+	if *only != "" {
+		show := make(map[string]bool)
+		for _, section := range strings.Split(*only, ",") {
+			section = strings.ToLower(strings.TrimSpace(section))
+			known := false
+			for _, s := range sections {
+				known = known || s == section
+			}
+			if !known {
+				return fmt.Errorf("unknown section %q, expected one of: %s", section, strings.Join(sections, ", "))
+			}
+			show[section] = true
+		}
+		if !show["connectors"] {
+			snap.Connectors = nil
+		}
+		if !show["encoders"] {
+			snap.Encoders = nil
+		}
+		if !show["crtcs"] {
+			snap.CRTCs = nil
+		}
+		if !show["planes"] {
+			snap.Planes = nil
+		}
+		if !show["framebuffers"] {
+			snap.Framebuffers = nil
 		}
-		dump.Framebuffers = append(dump.Framebuffers, f)
-	}
-
-	b, err := json.MarshalIndent(dump, "", "    ")
-	if err != nil {
-		panic(fmt.Errorf("marshal: %s", err))
 	}
-	fmt.Println(string(b))
+	return nil
 }
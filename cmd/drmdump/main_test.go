@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/inahga/inahgo/drm"
+)
+
+// fakeCard is a fake device with two CRTCs and two HDMI connectors, each able
+// to use either encoder. Only the first connector is lit.
+type fakeCard struct {
+	dev        *drm.FakeDevice
+	card       *drm.Card
+	crtcs      []uint32
+	encoders   []uint32
+	connectors []uint32
+	fb         uint32
+}
+
+func newFakeCard(t *testing.T) *fakeCard {
+	t.Helper()
+	dev := drm.NewFakeDevice()
+	f := fakeCard{dev: dev, card: drm.NewWithBackend(dev)}
+	for _, cap := range []uint64{drm.ClientCapUniversalPlanes, drm.ClientCapAtomic} {
+		if err := f.card.SetClientCap(cap, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.crtcs = []uint32{dev.AddCRTC(), dev.AddCRTC()}
+	for i := 0; i < 2; i++ {
+		f.encoders = append(f.encoders, dev.AddEncoder(drm.ModeEncoderTMDS, f.crtcs))
+	}
+	for i := 0; i < 2; i++ {
+		id := dev.AddConnector(drm.ModeConnectorHDMIA, f.encoders)
+		dev.Plug(id, []drm.ModeInfo{drm.FakeMode(1920, 1080, 60)}, 600, 340, nil)
+		f.connectors = append(f.connectors, id)
+	}
+
+	buf, err := f.card.ModeCreateDumb(1080, 1920, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb, err := f.card.ModeAddFramebuffer(1920, 1080, buf.Pitch, 32, 24, buf.Handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.fb = fb.ID
+	f.set(t, f.crtcs[0], 0)
+	return &f
+}
+
+// set shows the framebuffer on a CRTC at an offset, driving the first
+// connector.
+func (f *fakeCard) set(t *testing.T, crtcID, x uint32) {
+	t.Helper()
+	conn, err := f.card.ModeGetConnector(f.connectors[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	mode := conn.Modes[0]
+	set := drm.ModeCRTC{Name: mode.Name, SetConnectors: []uint32{f.connectors[0]}}
+	set.ID, set.FBID, set.X, set.ModeValid = crtcID, f.fb, x, 1
+	set.Clock = mode.Clock
+	set.HDisplay, set.HSyncStart, set.HSyncEnd, set.HTotal =
+		mode.HDisplay, mode.HSyncStart, mode.HSyncEnd, mode.HTotal
+	set.VDisplay, set.VSyncStart, set.VSyncEnd, set.VTotal =
+		mode.VDisplay, mode.VSyncStart, mode.VSyncEnd, mode.VTotal
+	set.VRefresh, set.Flags, set.Type = mode.VRefresh, mode.Flags, mode.Type
+	if err := f.card.ModeSetCRTC(set); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// save writes a snapshot of the card for capture to read.
+func (f *fakeCard) save(t *testing.T) string {
+	t.Helper()
+	snap, err := f.card.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// setFlag sets a flag for the duration of a test.
+func setFlag(t *testing.T, flag *string, value string) {
+	t.Helper()
+	old := *flag
+	*flag = value
+	t.Cleanup(func() { *flag = old })
+}
+
+func TestFilterConnector(t *testing.T) {
+	f := newFakeCard(t)
+	snap, err := capture(f.save(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	setFlag(t, connectors, "HDMI-A-1")
+	if err := filter(snap); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the encoder and CRTC driving the connector are kept, not every one
+	// it could use.
+	var encs, crtcs, planes, fbs []uint32
+	for _, e := range snap.Encoders {
+		encs = append(encs, e.ID)
+	}
+	for _, c := range snap.CRTCs {
+		crtcs = append(crtcs, c.ID)
+	}
+	for _, p := range snap.Planes {
+		planes = append(planes, p.ID)
+	}
+	for _, fb := range snap.Framebuffers {
+		fbs = append(fbs, fb.ID)
+	}
+	if len(snap.Connectors) != 1 || snap.Connectors[0].ID != f.connectors[0] {
+		t.Errorf("kept connectors %+v", snap.Connectors)
+	}
+	if !reflect.DeepEqual(encs, []uint32{snap.Connectors[0].EncoderID}) ||
+		!reflect.DeepEqual(crtcs, f.crtcs[:1]) || len(planes) != 1 || !reflect.DeepEqual(fbs, []uint32{f.fb}) {
+		t.Errorf("kept encoders %v, crtcs %v, planes %v, framebuffers %v", encs, crtcs, planes, fbs)
+	}
+
+	// An unlit connector keeps no encoder.
+	snap, err = capture(f.save(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	setFlag(t, connectors, "HDMI-A-2")
+	if err := filter(snap); err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Connectors) != 1 || len(snap.Encoders) != 0 || len(snap.CRTCs) != 0 || len(snap.Planes) != 0 {
+		t.Errorf("unlit connector kept %+v", snap)
+	}
+
+	setFlag(t, connectors, "DP-1")
+	if err := filter(snap); err == nil {
+		t.Error("filter of a missing connector succeeded")
+	}
+}
+
+func TestFilterOnly(t *testing.T) {
+	snap, err := capture(newFakeCard(t).save(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	setFlag(t, only, "CRTCs, framebuffers")
+	if err := filter(snap); err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Connectors) != 0 || len(snap.Encoders) != 0 || len(snap.Planes) != 0 ||
+		len(snap.CRTCs) != 2 || len(snap.Framebuffers) != 1 {
+		t.Errorf("unexpected sections %+v", snap)
+	}
+	setFlag(t, only, "modes")
+	if err := filter(snap); err == nil {
+		t.Error("filter of an unknown section succeeded")
+	}
+}
+
+func TestTree(t *testing.T) {
+	f := newFakeCard(t)
+	snap, err := capture(f.save(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	setFlag(t, only, "connectors,crtcs")
+	if err := filter(snap); err != nil {
+		t.Fatal(err)
+	}
+	out := tree(snap).String()
+	for _, want := range []string{
+		"Driver: fake",
+		"├─Connectors\n│ ├─Connector ",
+		"(HDMI-A-1)\n│ │ ├─Status: connected\n│ │ ├─Physical size: 600x340 mm\n",
+		"│ │ ├─Modes\n│ │ │ └─1920x1080@60: ",
+		"└─CRTCs\n  ├─CRTC ",
+		"  │ ├─Mode: 1920x1080@60 ",
+		"  │ ├─Framebuffer: ",
+		"  └─CRTC ",
+		"    ├─Mode: none\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("tree does not contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "Encoders") || strings.Contains(out, "Planes") {
+		t.Errorf("tree shows hidden sections:\n%s", out)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	f := newFakeCard(t)
+	before, err := capture(f.save(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.set(t, f.crtcs[0], 8)
+	f.dev.Unplug(f.connectors[1])
+	after, err := capture(f.save(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := diffTrees(tree(before), tree(before)); got != "" {
+		t.Errorf("diff of a tree with itself:\n%s", got)
+	}
+	got := diffTrees(tree(before), tree(after))
+	conn := fmt.Sprintf("Connectors > Connector %d (HDMI-A-2)", f.connectors[1])
+	for _, want := range []string{
+		"~ " + conn + " > Status: connected -> disconnected\n",
+		"- " + conn + " > Modes\n",
+		fmt.Sprintf("~ CRTCs > CRTC %d > Framebuffer: %d at 0,0 -> %d at 8,0\n", f.crtcs[0], f.fb, f.fb),
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("diff does not contain %q:\n%s", want, got)
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(got, "\n"), "\n") {
+		if !strings.HasPrefix(line, "~ ") && !strings.HasPrefix(line, "- ") && !strings.HasPrefix(line, "+ ") {
+			t.Errorf("unexpected diff line %q", line)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/inahga/inahgo/drm"
+)
+
+// node is a line of the tree printed by drmdump. Nodes are compared by label
+// when diffing, so the label identifies the node among its siblings and the
+// value holds what may change.
+type node struct {
+	label    string
+	value    string
+	children []*node
+}
+
+func (n *node) add(label, format string, args ...interface{}) *node {
+	child := &node{label: label, value: fmt.Sprintf(format, args...)}
+	n.children = append(n.children, child)
+	return child
+}
+
+func (n *node) line() string {
+	if n.value == "" {
+		return n.label
+	}
+	return n.label + ": " + n.value
+}
+
+func (n *node) String() string {
+	var b strings.Builder
+	b.WriteString(n.line())
+	b.WriteByte('\n')
+	n.write(&b, "")
+	return b.String()
+}
+
+func (n *node) write(b *strings.Builder, prefix string) {
+	for i, child := range n.children {
+		branch, indent := "├─", "│ "
+		if i == len(n.children)-1 {
+			branch, indent = "└─", "  "
+		}
+		b.WriteString(prefix + branch + child.line() + "\n")
+		child.write(b, prefix+indent)
+	}
+}
+
+// tree returns the tree describing a snapshot.
+func tree(snap *drm.Snapshot) *node {
+	props := make(map[uint32]*drm.SnapshotProperty)
+	for i := range snap.Properties {
+		props[snap.Properties[i].ID] = &snap.Properties[i]
+	}
+	blobs := make(map[uint32][]byte)
+	for _, blob := range snap.Blobs {
+		blobs[blob.ID] = blob.Data
+	}
+	properties := func(n *node, values []drm.SnapshotPropertyValue) {
+		if len(values) == 0 {
+			return
+		}
+		list := n.add("Properties", "")
+		for _, v := range values {
+			prop, ok := props[v.ID]
+			if !ok {
+				list.add(fmt.Sprintf("Property %d", v.ID), "%d", v.Value)
+				continue
+			}
+			list.add(propertyLabel(prop), "%s", propertyValue(prop, v.Value, blobs))
+		}
+	}
+
+	d := snap.Driver
+	root := &node{label: "Driver", value: fmt.Sprintf("%s (%s) version %d.%d.%d (%s)", d.Name, d.Desc,
+		d.Major, d.Minor, d.PatchLevel, d.Date)}
+	root.add("Framebuffer size", "%dx%d to %dx%d", snap.MinWidth, snap.MinHeight, snap.MaxWidth, snap.MaxHeight)
+
+	if len(snap.Connectors) > 0 {
+		list := root.add("Connectors", "")
+		for _, conn := range snap.Connectors {
+			n := list.add(fmt.Sprintf("Connector %d (%s)", conn.ID, conn.Name), "")
+			n.add("Status", "%s", conn.Connection)
+			n.add("Physical size", "%dx%d mm", conn.MMWidth, conn.MMHeight)
+			n.add("Subpixel", "%s", conn.Subpixel)
+			n.add("Encoder", "%d", conn.EncoderID)
+			n.add("Possible encoders", "%s", ids(conn.Encoders))
+			if len(conn.Modes) > 0 {
+				modes := n.add("Modes", "")
+				for _, mode := range conn.Modes {
+					modes.add(modeLabel(&mode), "%s", modeValue(&mode))
+				}
+			}
+			properties(n, conn.Properties)
+		}
+	}
+
+	if len(snap.Encoders) > 0 {
+		list := root.add("Encoders", "")
+		for _, enc := range snap.Encoders {
+			n := list.add(fmt.Sprintf("Encoder %d", enc.ID), "")
+			n.add("Type", "%s", enc.Type)
+			n.add("CRTC", "%d", enc.CRTCID)
+			n.add("Possible CRTCs", "0x%x", enc.PossibleCRTCs)
+			n.add("Possible clones", "0x%x", enc.PossibleClones)
+		}
+	}
+
+	if len(snap.CRTCs) > 0 {
+		list := root.add("CRTCs", "")
+		for _, crtc := range snap.CRTCs {
+			n := list.add(fmt.Sprintf("CRTC %d", crtc.ID), "")
+			if crtc.Mode != nil {
+				n.add("Mode", "%s %s", modeLabel(crtc.Mode), modeValue(crtc.Mode))
+			} else {
+				n.add("Mode", "none")
+			}
+			n.add("Framebuffer", "%d at %d,%d", crtc.FBID, crtc.X, crtc.Y)
+			n.add("Gamma size", "%d", crtc.GammaSize)
+			properties(n, crtc.Properties)
+		}
+	}
+
+	if len(snap.Planes) > 0 {
+		list := root.add("Planes", "")
+		for _, plane := range snap.Planes {
+			n := list.add(fmt.Sprintf("Plane %d", plane.ID), "")
+			n.add("CRTC", "%d", plane.CRTCID)
+			n.add("Framebuffer", "%d", plane.FBID)
+			n.add("Possible CRTCs", "0x%x", plane.PossibleCRTCs)
+			n.add("Gamma size", "%d", plane.GammaSize)
+			formats := n.add("Formats", "")
+			for _, name := range plane.Formats {
+				f := formats.add(name, "")
+				if mods := plane.InFormats[name]; len(mods) > 0 {
+					f.value = strings.Join(mods, ", ")
+				}
+			}
+			properties(n, plane.Properties)
+		}
+	}
+
+	if len(snap.Framebuffers) > 0 {
+		list := root.add("Framebuffers", "")
+		for _, fb := range snap.Framebuffers {
+			list.add(fmt.Sprintf("Framebuffer %d", fb.ID), "%dx%d, pitch %d, %d bpp, depth %d",
+				fb.Width, fb.Height, fb.Pitch, fb.Bpp, fb.Depth)
+		}
+	}
+
+	if len(snap.Errors) > 0 {
+		list := root.add("Errors", "")
+		for _, e := range snap.Errors {
+			list.add(e.Object, "%s", e.Error)
+		}
+	}
+	return root
+}
+
+func ids(list []uint32) string {
+	var s []string
+	for _, id := range list {
+		s = append(s, fmt.Sprint(id))
+	}
+	return strings.Join(s, ", ")
+}
+
+func modeLabel(m *drm.SnapshotMode) string {
+	return fmt.Sprintf("%s@%d", m.Name, m.VRefresh)
+}
+
+func modeValue(m *drm.SnapshotMode) string {
+	s := fmt.Sprintf("%.2f MHz, h %d %d %d %d, v %d %d %d %d", float64(m.Clock)/1000,
+		m.HDisplay, m.HSyncStart, m.HSyncEnd, m.HTotal, m.VDisplay, m.VSyncStart, m.VSyncEnd, m.VTotal)
+	if len(m.Flags) > 0 {
+		s += ", " + strings.Join(m.Flags, " ")
+	}
+	if len(m.Type) > 0 {
+		s += " (" + strings.Join(m.Type, ", ") + ")"
+	}
+	return s
+}
+
+func propertyLabel(p *drm.SnapshotProperty) string {
+	attrs := []string{drm.PropertyTypeName(p.Flags)}
+	if p.Flags&drm.ModePropImmutable != 0 {
+		attrs = append(attrs, "immutable")
+	}
+	if p.Flags&drm.ModePropAtomic != 0 {
+		attrs = append(attrs, "atomic")
+	}
+	return fmt.Sprintf("%s (%s)", p.Name, strings.Join(attrs, ", "))
+}
+
+func propertyValue(p *drm.SnapshotProperty, value uint64, blobs map[uint32][]byte) string {
+	switch {
+	case p.Flags&drm.ModePropEnum != 0:
+		for _, enum := range p.Enums {
+			if enum.Value == value {
+				return enum.Name
+			}
+		}
+	case p.Flags&drm.ModePropBitmask != 0:
+		var names []string
+		for _, enum := range p.Enums {
+			if value&(1<<enum.Value) != 0 {
+				names = append(names, enum.Name)
+			}
+		}
+		return strings.Join(names, " | ")
+	case p.Flags&drm.ModePropBlob != 0:
+		if value == 0 {
+			return "none"
+		}
+		if data, ok := blobs[uint32(value)]; ok {
+			return fmt.Sprintf("blob %d, %d bytes", value, len(data))
+		}
+		return fmt.Sprintf("blob %d", value)
+	case p.Flags&drm.ModePropExtendedType == drm.ModePropSignedRange:
+		return fmt.Sprint(int64(value))
+	}
+	return fmt.Sprint(value)
+}
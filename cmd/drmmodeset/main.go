@@ -0,0 +1,421 @@
+// Command drmmodeset lists connectors and modes, sets a mode on a connector and
+// displays a test pattern until interrupted, much like libdrm's modetest.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/inahga/inahgo/drm"
+	"github.com/inahga/inahgo/drm/edid"
+	"github.com/inahga/inahgo/drm/pattern"
+)
+
+var (
+	list        = flag.Bool("list", false, "list connectors and their modes, then exit")
+	connector   = flag.String("connector", "first-connected", "connector to use, as a selector such as HDMI-A-1, DP-2, 42 or make=DEL")
+	modeName    = flag.String("mode", "", "mode to set as WIDTHxHEIGHT[@REFRESH], defaults to the preferred mode")
+	formatName  = flag.String("format", "XR24", "fourcc of the framebuffer pixel format")
+	patternName = flag.String("pattern", pattern.SMPTE.String(), "test pattern to display, one of: "+patternNames())
+	vrr         = flag.Bool("vrr", false, "enable variable refresh rate, and flip animated patterns at random rates within the display's range")
+)
+
+func patternNames() string {
+	var names []string
+	for _, p := range pattern.Patterns() {
+		names = append(names, p.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] <path to gpu>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(flag.Arg(0)); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		os.Exit(1)
+	}
+}
+
+func run(path string) error {
+	card, err := drm.Open(path)
+	if err != nil {
+		return err
+	}
+	defer card.Close()
+
+	res, err := card.ModeGetResources()
+	if err != nil {
+		return fmt.Errorf("resources: %w", err)
+	}
+	if *list {
+		return listConnectors(card, res)
+	}
+
+	p, err := pattern.Parse(*patternName)
+	if err != nil {
+		return err
+	}
+	format, err := drm.ParseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	info := drm.LookupFormat(format)
+	if info == nil {
+		return fmt.Errorf("unsupported format %s", *formatName)
+	}
+
+	conn, err := findConnector(card, *connector)
+	if err != nil {
+		return err
+	}
+	mode, err := findMode(conn, *modeName)
+	if err != nil {
+		return err
+	}
+	crtcID, err := findCRTC(card, res, conn)
+	if err != nil {
+		return err
+	}
+
+	// Buffers are destroyed only after the previous state has been restored, so
+	// that removing a framebuffer never disables the CRTC.
+	var bufs []*buffer
+	defer func() {
+		for _, buf := range bufs {
+			buf.destroy(card)
+		}
+	}()
+
+	saved, err := card.ModeGetCRTC(crtcID)
+	if err != nil {
+		return fmt.Errorf("crtc: %w", err)
+	}
+	if saved.SetConnectors, err = crtcConnectors(card, res, crtcID); err != nil {
+		return err
+	}
+	defer func() {
+		if err := restoreCRTC(card, saved); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: restore crtc: %s\n", os.Args[0], err)
+		}
+	}()
+
+	count := 1
+	if p.Animated() {
+		count = 2
+	}
+	for i := 0; i < count; i++ {
+		buf, err := newBuffer(card, info, uint32(mode.HDisplay), uint32(mode.VDisplay))
+		if err != nil {
+			return err
+		}
+		bufs = append(bufs, buf)
+		if err := pattern.Fill(buf.pattern, p, i); err != nil {
+			return err
+		}
+	}
+
+	set := drm.ModeCRTC{SetConnectors: []uint32{conn.ID}}
+	set.ID = crtcID
+	set.FBID = bufs[0].fbID
+	set.ModeValid = 1
+	set.Name = mode.Name
+	set.Clock = mode.Clock
+	set.HDisplay, set.HSyncStart, set.HSyncEnd, set.HTotal, set.HSkew =
+		mode.HDisplay, mode.HSyncStart, mode.HSyncEnd, mode.HTotal, mode.HSkew
+	set.VDisplay, set.VSyncStart, set.VSyncEnd, set.VTotal, set.VScan =
+		mode.VDisplay, mode.VSyncStart, mode.VSyncEnd, mode.VTotal, mode.VScan
+	set.VRefresh, set.Flags, set.Type = mode.VRefresh, mode.Flags, mode.Type
+	if err := card.ModeSetCRTC(set); err != nil {
+		return fmt.Errorf("set crtc: %w", err)
+	}
+	fmt.Printf("connector %d: %s@%d on crtc %d, format %s, pattern %s\n", conn.ID,
+		mode.Name, mode.VRefresh, crtcID, drm.FormatString(format), p)
+
+	presenter := card.NewPresenter(crtcID)
+	var vrrRange edid.RefreshRange
+	if *vrr {
+		if vrrRange, err = enableVRR(card, conn.ID, crtcID); err != nil {
+			return err
+		}
+		defer card.SetVRR(crtcID, false)
+		presenter.SetRefreshRange(float64(vrrRange.Min), float64(vrrRange.Max))
+		fmt.Printf("vrr enabled, %d-%d Hz\n", vrrRange.Min, vrrRange.Max)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	if !p.Animated() {
+		<-sig
+		return nil
+	}
+	for frame := 1; ; frame++ {
+		select {
+		case <-sig:
+			return nil
+		default:
+		}
+
+		buf := bufs[frame%len(bufs)]
+		if err := pattern.Fill(buf.pattern, p, frame); err != nil {
+			return err
+		}
+		at := time.Now()
+		if *vrr {
+			// Present at a random rate within the VRR range, to exercise it.
+			hz := vrrRange.Min + rand.Intn(vrrRange.Max-vrrRange.Min+1)
+			at = at.Add(time.Second / time.Duration(hz))
+		}
+		if _, err := presenter.Present(buf.fbID, at); err != nil {
+			return fmt.Errorf("present: %w", err)
+		}
+	}
+}
+
+// enableVRR enables variable refresh rate on the CRTC, and returns the refresh
+// range of the display attached to the connector.
+func enableVRR(card *drm.Card, connID, crtcID uint32) (edid.RefreshRange, error) {
+	capable, err := card.VRRCapable(connID)
+	if err != nil {
+		return edid.RefreshRange{}, err
+	}
+	if !capable {
+		return edid.RefreshRange{}, fmt.Errorf("connector %d is not vrr capable", connID)
+	}
+
+	data, err := card.ConnectorEDID(connID)
+	if err != nil {
+		return edid.RefreshRange{}, fmt.Errorf("edid: %w", err)
+	}
+	if data == nil {
+		return edid.RefreshRange{}, fmt.Errorf("connector %d has no edid", connID)
+	}
+	info, err := edid.Parse(data)
+	if err != nil {
+		return edid.RefreshRange{}, err
+	}
+	vrrRange, ok := info.VRRRange()
+	if !ok {
+		return edid.RefreshRange{}, fmt.Errorf("edid of connector %d has no vrr range", connID)
+	}
+	return vrrRange, card.SetVRR(crtcID, true)
+}
+
+func listConnectors(card *drm.Card, res *drm.ModeResources) error {
+	for _, id := range res.ConnectorIDs {
+		conn, err := card.ModeGetConnector(id)
+		if err != nil {
+			return fmt.Errorf("connector %d: %w", id, err)
+		}
+
+		fmt.Printf("connector %d: %s, %s, %dx%d mm\n", conn.ID, conn.Name(),
+			drm.ConnectionName(conn.Connection), conn.MMWidth, conn.MMHeight)
+		for _, mode := range conn.Modes {
+			preferred := ""
+			if mode.Type&drm.ModeTypePreferred != 0 {
+				preferred = " (preferred)"
+			}
+			fmt.Printf("  %s@%d %d kHz%s\n", mode.Name, mode.VRefresh, mode.Clock, preferred)
+		}
+	}
+	return nil
+}
+
+func findConnector(card *drm.Card, selector string) (*drm.ModeConnector, error) {
+	sel, err := drm.ParseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := card.SelectConnector(sel)
+	if err != nil {
+		return nil, err
+	}
+	if conn.Connection != drm.ModeConnected || len(conn.Modes) == 0 {
+		return nil, fmt.Errorf("connector %s is not connected", conn.Name())
+	}
+	return conn, nil
+}
+
+// findMode returns the mode of the connector matching name, given as
+// WIDTHxHEIGHT[@REFRESH]. An empty name selects the preferred mode.
+func findMode(conn *drm.ModeConnector, name string) (*drm.ModeInfo, error) {
+	if name == "" {
+		for i, mode := range conn.Modes {
+			if mode.Type&drm.ModeTypePreferred != 0 {
+				return &conn.Modes[i], nil
+			}
+		}
+		return &conn.Modes[0], nil
+	}
+
+	size, refresh := name, uint64(0)
+	if i := strings.IndexByte(name, '@'); i >= 0 {
+		var err error
+		if refresh, err = strconv.ParseUint(name[i+1:], 10, 32); err != nil {
+			return nil, fmt.Errorf("invalid refresh rate %q", name[i+1:])
+		}
+		size = name[:i]
+	}
+	for i, mode := range conn.Modes {
+		if mode.Name == size && (refresh == 0 || uint64(mode.VRefresh) == refresh) {
+			return &conn.Modes[i], nil
+		}
+	}
+	return nil, fmt.Errorf("connector %d has no mode %s", conn.ID, name)
+}
+
+// findCRTC returns the CRTC currently driving the connector, or else the first
+// CRTC that can drive one of its encoders.
+func findCRTC(card *drm.Card, res *drm.ModeResources, conn *drm.ModeConnector) (uint32, error) {
+	if conn.EncoderID != 0 {
+		enc, err := card.ModeGetEncoder(conn.EncoderID)
+		if err != nil {
+			return 0, fmt.Errorf("encoder %d: %w", conn.EncoderID, err)
+		}
+		if enc.CRTCID != 0 {
+			return enc.CRTCID, nil
+		}
+	}
+	for _, encID := range conn.EncoderIDs {
+		enc, err := card.ModeGetEncoder(encID)
+		if err != nil {
+			return 0, fmt.Errorf("encoder %d: %w", encID, err)
+		}
+		for i, crtcID := range res.CRTCIDs {
+			if enc.PossibleCRTCs&(1<<i) != 0 {
+				return crtcID, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("no crtc for connector %d", conn.ID)
+}
+
+// crtcConnectors returns the connectors a CRTC drives, e.g. several in clone
+// mode.
+func crtcConnectors(card *drm.Card, res *drm.ModeResources, crtcID uint32) ([]uint32, error) {
+	var ids []uint32
+	for _, id := range res.ConnectorIDs {
+		conn, err := card.ModeGetConnector(id)
+		if err != nil {
+			return nil, fmt.Errorf("connector %d: %w", id, err)
+		}
+		if conn.EncoderID == 0 {
+			continue
+		}
+		enc, err := card.ModeGetEncoder(conn.EncoderID)
+		if err != nil {
+			return nil, fmt.Errorf("encoder %d: %w", conn.EncoderID, err)
+		}
+		if enc.CRTCID == crtcID {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// restoreCRTC sets a CRTC back to its saved state, on the connectors it drove
+// then, which saved.SetConnectors holds.
+func restoreCRTC(card *drm.Card, saved *drm.ModeCRTC) error {
+	restore := *saved
+	if saved.ModeValid == 0 || saved.FBID == 0 || len(saved.SetConnectors) == 0 {
+		restore = drm.ModeCRTC{}
+		restore.ID = saved.ID
+	}
+	return card.ModeSetCRTC(restore)
+}
+
+type buffer struct {
+	dumb    *drm.ModeDumbBuffer
+	mem     []byte
+	fbID    uint32
+	pattern pattern.Buffer
+}
+
+// newBuffer allocates a dumb buffer large enough to hold every plane of the
+// format, and adds a framebuffer for it. Chroma planes are laid out after the
+// luma plane, with a pitch derived from the luma pitch.
+func newBuffer(card *drm.Card, info *drm.FormatInfo, width, height uint32) (*buffer, error) {
+	bpp, rows := info.BPP(), height
+	if info.NumPlanes > 1 {
+		bpp = 8
+	}
+	dumb, err := card.ModeCreateDumb(rows, width, bpp)
+	if err != nil {
+		return nil, fmt.Errorf("create dumb: %w", err)
+	}
+	if info.NumPlanes > 1 {
+		// Reallocate with enough rows for the chroma planes now that the pitch
+		// chosen by the driver is known.
+		extra := uint32(0)
+		for i := 1; i < int(info.NumPlanes); i++ {
+			_, h := info.PlaneSize(i, width, height)
+			extra += h * dumb.Pitch * uint32(info.CPP[i]) / uint32(info.HSub)
+		}
+		if err := card.ModeDestroyDumb(dumb.Handle); err != nil {
+			return nil, fmt.Errorf("destroy dumb: %w", err)
+		}
+		rows += (extra + dumb.Pitch - 1) / dumb.Pitch
+		if dumb, err = card.ModeCreateDumb(rows, width, bpp); err != nil {
+			return nil, fmt.Errorf("create dumb: %w", err)
+		}
+	}
+
+	buf := &buffer{dumb: dumb}
+	var handles, pitches, offsets [4]uint32
+	offset := uint32(0)
+	for i := 0; i < int(info.NumPlanes); i++ {
+		_, h := info.PlaneSize(i, width, height)
+		pitch := dumb.Pitch
+		if i > 0 {
+			pitch = dumb.Pitch * uint32(info.CPP[i]) / uint32(info.HSub)
+		}
+		handles[i], pitches[i], offsets[i] = dumb.Handle, pitch, offset
+		offset += pitch * h
+	}
+
+	fb, err := card.ModeAddFramebuffer2(width, height, info.Format, 0, handles, pitches,
+		offsets, [4]uint64{})
+	if err != nil {
+		buf.destroy(card)
+		return nil, fmt.Errorf("add framebuffer: %w", err)
+	}
+	buf.fbID = fb.ID
+
+	if buf.mem, err = card.MmapDumb(dumb); err != nil {
+		buf.destroy(card)
+		return nil, err
+	}
+	buf.pattern = pattern.Buffer{Format: info.Format, Width: int(width), Height: int(height)}
+	for i := 0; i < int(info.NumPlanes); i++ {
+		buf.pattern.Planes = append(buf.pattern.Planes, buf.mem[offsets[i]:])
+		buf.pattern.Pitches = append(buf.pattern.Pitches, int(pitches[i]))
+	}
+	return buf, nil
+}
+
+func (b *buffer) destroy(card *drm.Card) {
+	if b.mem != nil {
+		card.Munmap(b.mem)
+	}
+	if b.fbID != 0 {
+		card.ModeRemoveFramebuffer(b.fbID)
+	}
+	card.ModeDestroyDumb(b.dumb.Handle)
+}
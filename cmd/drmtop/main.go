@@ -0,0 +1,135 @@
+// Command drmtop shows which processes keep the GPU busy, like top, from the
+// usage the kernel reports in the fdinfo of DRM clients.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/inahga/inahgo/drm/usage"
+)
+
+var (
+	procRoot   = flag.String("proc", usage.DefaultProcRoot, "where procfs is mounted")
+	interval   = flag.Duration("interval", 2*time.Second, "time between updates")
+	iterations = flag.Int("n", 0, "number of updates to show before exiting, or 0 to run until interrupted")
+	batch      = flag.Bool("batch", false, "print updates one after the other instead of redrawing the screen")
+	all        = flag.Bool("all", false, "also show idle clients")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 0 || *interval <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	prev, err := usage.Scan(*procRoot)
+	if err != nil {
+		return err
+	}
+	for i := 0; *iterations == 0 || i < *iterations; i++ {
+		select {
+		case <-sigs:
+			return nil
+		case <-ticker.C:
+		}
+		cur, err := usage.Scan(*procRoot)
+		if err != nil {
+			return err
+		}
+		show(cur, usage.Utilization(prev, cur))
+		prev = cur
+	}
+	return nil
+}
+
+func show(s *usage.Sample, clients []usage.Usage) {
+	sort.SliceStable(clients, func(i, j int) bool { return clients[i].Max() > clients[j].Max() })
+	if !*batch {
+		// Move to the top left corner and clear the screen.
+		fmt.Print("\x1b[H\x1b[2J")
+	}
+	fmt.Printf("drmtop - %s, %d clients\n\n", s.Time.Format("15:04:05"), len(clients))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "PID\tCOMMAND\tDRIVER\tDEVICE\tCLIENT\tMEMORY\tENGINES")
+	for _, u := range clients {
+		if !*all && u.Max() == 0 {
+			continue
+		}
+		c := u.Client
+		var pids, comms []string
+		for _, p := range c.Processes {
+			pids = append(pids, strconv.Itoa(p.PID))
+			comms = append(comms, p.Comm)
+		}
+		dev := c.PDev
+		if dev == "" {
+			dev = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", strings.Join(pids, ","), strings.Join(comms, ","),
+			c.Driver, dev, c.ID, memory(c), engines(u))
+	}
+	w.Flush()
+	if *batch {
+		fmt.Println()
+	}
+}
+
+// memory returns the memory of all regions of a client, in a human readable
+// size.
+func memory(c *usage.Client) string {
+	var total uint64
+	for _, m := range c.Memory {
+		total += m.Total
+	}
+	switch {
+	case total >= 1<<30:
+		return fmt.Sprintf("%.1fG", float64(total)/(1<<30))
+	case total >= 1<<20:
+		return fmt.Sprintf("%.1fM", float64(total)/(1<<20))
+	}
+	return fmt.Sprintf("%dK", total>>10)
+}
+
+// engines returns the utilization of the engines of a client, ordered by name.
+func engines(u usage.Usage) string {
+	names := make([]string, 0, len(u.Engines))
+	for name := range u.Engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			b.WriteString("  ")
+		}
+		fmt.Fprintf(&b, "%s %5.1f%%", name, u.Engines[name]*100)
+	}
+	return b.String()
+}
@@ -0,0 +1,94 @@
+package drm
+
+import "unsafe"
+
+type atomicProperty struct {
+	propID uint32
+	value  uint64
+}
+
+// AtomicRequest collects property changes to be applied together by
+// ModeAtomicCommit. The zero value is an empty request.
+type AtomicRequest struct {
+	objIDs []uint32
+	props  map[uint32][]atomicProperty
+}
+
+// AddProperty sets a property of a mode object. Setting the same property of
+// the same object again replaces the previous value.
+func (r *AtomicRequest) AddProperty(objID, propID uint32, value uint64) {
+	if r.props == nil {
+		r.props = make(map[uint32][]atomicProperty)
+	}
+	props, ok := r.props[objID]
+	if !ok {
+		r.objIDs = append(r.objIDs, objID)
+	}
+	for i := range props {
+		if props[i].propID == propID {
+			props[i].value = value
+			return
+		}
+	}
+	r.props[objID] = append(props, atomicProperty{propID: propID, value: value})
+}
+
+// Len returns the number of properties set in the request.
+func (r *AtomicRequest) Len() int {
+	n := 0
+	for _, props := range r.props {
+		n += len(props)
+	}
+	return n
+}
+
+// Clone returns a copy of the request, which can be modified independently.
+func (r *AtomicRequest) Clone() *AtomicRequest {
+	ret := &AtomicRequest{objIDs: append([]uint32(nil), r.objIDs...)}
+	if r.props != nil {
+		ret.props = make(map[uint32][]atomicProperty, len(r.props))
+		for id, props := range r.props {
+			ret.props[id] = append([]atomicProperty(nil), props...)
+		}
+	}
+	return ret
+}
+
+// ModeAtomicCommit applies all property changes in the request at once. Flags
+// is a combination of ModeAtomic* and ModePageFlip* flags. If ModePageFlipEvent
+// is set, an EventFlipComplete carrying userData is sent for each CRTC in the
+// request. The card must have ClientCapAtomic set.
+func (c *Card) ModeAtomicCommit(req *AtomicRequest, flags uint32, userData uint64) error {
+	var (
+		objs       = make([]uint32, 0, len(req.objIDs))
+		countProps = make([]uint32, 0, len(req.objIDs))
+		props      = make([]uint32, 0, req.Len())
+		values     = make([]uint64, 0, req.Len())
+	)
+	for _, id := range req.objIDs {
+		objs = append(objs, id)
+		countProps = append(countProps, uint32(len(req.props[id])))
+		for _, prop := range req.props[id] {
+			props = append(props, prop.propID)
+			values = append(values, prop.value)
+		}
+	}
+
+	atomic := cModeAtomic{
+		flags:     flags,
+		countObjs: uint32(len(objs)),
+		userData:  userData,
+	}
+	if len(objs) > 0 {
+		atomic.objsPtr = uint64(uintptr(unsafe.Pointer(&objs[0])))
+		atomic.countPropsPtr = uint64(uintptr(unsafe.Pointer(&countProps[0])))
+	}
+	if len(props) > 0 {
+		atomic.propsPtr = uint64(uintptr(unsafe.Pointer(&props[0])))
+		atomic.propValuesPtr = uint64(uintptr(unsafe.Pointer(&values[0])))
+	}
+	if err := c.ioctl(ioctlModeAtomic, 0, unsafe.Pointer(&atomic)); err != nil {
+		return err
+	}
+	return nil
+}
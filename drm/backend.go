@@ -0,0 +1,63 @@
+package drm
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Backend carries out the requests of a Card. The default backend issues them
+// on a DRM device file; FakeDevice implements one in memory.
+type Backend interface {
+	// Ioctl performs a DRM ioctl, where data points to the argument structure
+	// of the request. It returns a syscall.Errno on failure.
+	Ioctl(request uint32, data unsafe.Pointer) error
+	// Mmap maps length bytes at offset, as returned by ModeMapDumb.
+	Mmap(offset int64, length int) ([]byte, error)
+	// Munmap releases memory obtained from Mmap.
+	Munmap(b []byte) error
+	// Read reads pending events, encoded as the kernel does.
+	Read(b []byte) (int, error)
+	Close() error
+}
+
+// NewWithBackend returns a card whose requests are carried out by b. The card
+// is a render node if b has a NodeType method reporting so, and a primary node
+// otherwise.
+func NewWithBackend(b Backend) *Card {
+	c := Card{backend: b}
+	if n, ok := b.(interface{ NodeType() NodeType }); ok {
+		c.node = n.NodeType()
+	}
+	return &c
+}
+
+// fileBackend carries out requests on a DRM device file.
+type fileBackend struct {
+	f *os.File
+}
+
+func (b fileBackend) Ioctl(request uint32, data unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, b.f.Fd(), uintptr(request),
+		uintptr(data)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (b fileBackend) Mmap(offset int64, length int) ([]byte, error) {
+	return syscall.Mmap(int(b.f.Fd()), offset, length, syscall.PROT_READ|syscall.PROT_WRITE,
+		syscall.MAP_SHARED)
+}
+
+func (b fileBackend) Munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
+
+func (b fileBackend) Read(data []byte) (int, error) {
+	return b.f.Read(data)
+}
+
+func (b fileBackend) Close() error {
+	return b.f.Close()
+}
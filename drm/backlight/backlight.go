@@ -0,0 +1,287 @@
+// Package backlight controls the brightness of internal panels through the
+// backlight devices of sysfs, and finds the device of a DRM connector.
+//
+// Writing the brightness requires root, or a udev rule granting write access
+// to the brightness attribute.
+package backlight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/inahga/inahgo/drm"
+)
+
+// DefaultSysfsRoot is where sysfs is mounted.
+const DefaultSysfsRoot = "/sys"
+
+// ErrNoBacklight is returned when a connector has no backlight device.
+var ErrNoBacklight = errors.New("no backlight device")
+
+// Type is the type of a backlight device, by which it is preferred when
+// several control the same panel.
+type Type int
+
+const (
+	// Firmware backlights are controlled through the firmware, e.g. ACPI.
+	Firmware Type = iota
+	// Platform backlights are controlled through a platform driver, e.g. a
+	// laptop vendor's.
+	Platform
+	// Raw backlights are controlled through the registers of the GPU or
+	// panel, whose brightness is usually linear in the value set.
+	Raw
+)
+
+func (t Type) String() string {
+	switch t {
+	case Firmware:
+		return "firmware"
+	case Platform:
+		return "platform"
+	case Raw:
+		return "raw"
+	}
+	return fmt.Sprintf("Type(%d)", int(t))
+}
+
+func parseType(s string) (Type, error) {
+	for t := Firmware; t <= Raw; t++ {
+		if s == t.String() {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown backlight type %q", s)
+}
+
+// Backlight is a backlight device, e.g. /sys/class/backlight/intel_backlight.
+type Backlight struct {
+	Name string
+	Type Type
+	// Max is the brightness at full power.
+	Max int
+
+	dir string
+	// device is the resolved path of the device the backlight belongs to.
+	device string
+}
+
+// List returns the backlight devices under the sysfs root, such as
+// DefaultSysfsRoot, ordered by preference.
+func List(sysfsRoot string) ([]*Backlight, error) {
+	class := filepath.Join(sysfsRoot, "class", "backlight")
+	entries, err := os.ReadDir(class)
+	if err != nil {
+		return nil, err
+	}
+	var ret []*Backlight
+	for _, e := range entries {
+		b, err := open(filepath.Join(class, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, b)
+	}
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].Type < ret[j].Type })
+	return ret, nil
+}
+
+func open(dir string) (*Backlight, error) {
+	b := Backlight{Name: filepath.Base(dir), dir: dir}
+	typ, err := readAttr(dir, "type")
+	if err != nil {
+		return nil, err
+	}
+	if b.Type, err = parseType(typ); err != nil {
+		return nil, fmt.Errorf("%s: %w", dir, err)
+	}
+	if b.Max, err = readInt(dir, "max_brightness"); err != nil {
+		return nil, err
+	}
+	if b.Max <= 0 {
+		return nil, fmt.Errorf("%s: max brightness is %d", dir, b.Max)
+	}
+	b.device, _ = filepath.EvalSymlinks(filepath.Join(dir, "device"))
+	return &b, nil
+}
+
+// ForConnector returns the backlight device of a connector of the card under
+// the sysfs root, such as DefaultSysfsRoot. Card is the name of the card's
+// primary node, e.g. "card0", and connector the kernel's name of the
+// connector, e.g. "eDP-1".
+//
+// A backlight belongs to the connector if its device is the connector, the
+// card's device, or the firmware's node of the card's device. Platform
+// backlights belong to every internal panel. Like desktop environments,
+// ForConnector prefers firmware backlights, then platform ones, then raw ones.
+// It returns ErrNoBacklight if none belongs to the connector, or the connector
+// is not an internal panel.
+func ForConnector(sysfsRoot, card, connector string) (*Backlight, error) {
+	typ, _, err := drm.ParseConnectorName(connector)
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case drm.ModeConnectorEDP, drm.ModeConnectorLVDS, drm.ModeConnectorDSI:
+	default:
+		return nil, fmt.Errorf("%s-%s: %w: not an internal panel", card, connector, ErrNoBacklight)
+	}
+
+	class := filepath.Join(sysfsRoot, "class", "drm")
+	connDir, err := filepath.EvalSymlinks(filepath.Join(class, card+"-"+connector))
+	if err != nil {
+		return nil, err
+	}
+	owners := map[string]bool{connDir: true}
+	if dev, err := filepath.EvalSymlinks(filepath.Join(class, card, "device")); err == nil {
+		owners[dev] = true
+		if fw, err := filepath.EvalSymlinks(filepath.Join(dev, "firmware_node")); err == nil {
+			owners[fw] = true
+		}
+	}
+
+	all, err := List(sysfsRoot)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%s-%s: %w", card, connector, ErrNoBacklight)
+		}
+		return nil, err
+	}
+	for _, b := range all {
+		if b.Type == Platform || owners[b.device] {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("%s-%s: %w", card, connector, ErrNoBacklight)
+}
+
+// Brightness returns the current brightness, from 0 to Max, as the hardware
+// reports it if the driver can read it back.
+func (b *Backlight) Brightness() (int, error) {
+	v, err := readInt(b.dir, "actual_brightness")
+	if errors.Is(err, os.ErrNotExist) {
+		return readInt(b.dir, "brightness")
+	}
+	return v, err
+}
+
+// SetBrightness sets the brightness, from 0 to Max.
+func (b *Backlight) SetBrightness(v int) error {
+	if v < 0 || v > b.Max {
+		return fmt.Errorf("%s: brightness %d out of range 0 to %d", b.Name, v, b.Max)
+	}
+	f, err := os.OpenFile(filepath.Join(b.dir, "brightness"), os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(strconv.Itoa(v)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// gamma is the exponent relating the perceived brightness of a raw backlight
+// to its value. Firmware and platform backlights usually map their levels to
+// perceived brightness already.
+const gamma = 2.2
+
+// Percent returns the perceived brightness, from 0 to 100.
+func (b *Backlight) Percent() (float64, error) {
+	v, err := b.Brightness()
+	if err != nil {
+		return 0, err
+	}
+	return b.percent(v), nil
+}
+
+// SetPercent sets the perceived brightness, from 0 to 100. Only 0 turns the
+// backlight off.
+func (b *Backlight) SetPercent(p float64) error {
+	return b.SetBrightness(b.value(p))
+}
+
+func (b *Backlight) percent(v int) float64 {
+	f := float64(v) / float64(b.Max)
+	if b.Type == Raw {
+		f = math.Pow(f, 1/gamma)
+	}
+	return f * 100
+}
+
+func (b *Backlight) value(p float64) int {
+	f := math.Max(0, math.Min(p, 100)) / 100
+	if b.Type == Raw {
+		f = math.Pow(f, gamma)
+	}
+	v := int(math.Round(f * float64(b.Max)))
+	if v == 0 && p > 0 {
+		v = 1
+	}
+	return v
+}
+
+// fadeInterval is the time between steps of a fade.
+const fadeInterval = time.Second / 60
+
+// Fade changes the perceived brightness to p, from 0 to 100, in steps over
+// the duration. It stops at the current step when ctx is done, returning its
+// error.
+func (b *Backlight) Fade(ctx context.Context, p float64, d time.Duration) error {
+	from, err := b.Percent()
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(fadeInterval)
+	defer ticker.Stop()
+	start := time.Now()
+	last := -1
+	for {
+		t := 1.0
+		if d > 0 {
+			t = math.Min(float64(time.Since(start))/float64(d), 1)
+		}
+		if v := b.value(from + (p-from)*t); v != last {
+			if err := b.SetBrightness(v); err != nil {
+				return err
+			}
+			last = v
+		}
+		if t == 1 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func readAttr(dir, name string) (string, error) {
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func readInt(dir, name string) (int, error) {
+	s, err := readAttr(dir, name)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", filepath.Join(dir, name), err)
+	}
+	return v, nil
+}
@@ -0,0 +1,149 @@
+package backlight
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSysfs lays out a laptop with an integrated GPU at 0000:00:02.0 driving
+// the panel eDP-1, whose backlight is controlled by both the GPU, as
+// intel_backlight, and ACPI, as acpi_video0.
+func fakeSysfs(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	gpu := "devices/pci0000:00/0000:00:02.0"
+	video := "devices/LNXSYSTM:00/LNXSYBUS:00/PNP0A08:00/LNXVIDEO:00"
+	conn := gpu + "/drm/card0/card0-eDP-1"
+	links := map[string]string{
+		gpu + "/firmware_node":                         video,
+		gpu + "/drm/card0/device":                      gpu,
+		"class/drm/card0":                              gpu + "/drm/card0",
+		"class/drm/card0-eDP-1":                        conn,
+		"class/drm/card0-HDMI-A-1":                     gpu + "/drm/card0/card0-HDMI-A-1",
+		conn + "/intel_backlight/device":               conn,
+		"class/backlight/intel_backlight":              conn + "/intel_backlight",
+		video + "/backlight/acpi_video0/device":        video,
+		"class/backlight/acpi_video0":                  video + "/backlight/acpi_video0",
+		"devices/pci0000:00/0000:01:00.0/nv_bl/device": "devices/pci0000:00/0000:01:00.0",
+		"class/backlight/nv_bl":                        "devices/pci0000:00/0000:01:00.0/nv_bl",
+	}
+	files := map[string]string{
+		conn + "/intel_backlight/type":                         "raw\n",
+		conn + "/intel_backlight/max_brightness":               "1000\n",
+		conn + "/intel_backlight/brightness":                   "500\n",
+		conn + "/intel_backlight/actual_brightness":            "500\n",
+		video + "/backlight/acpi_video0/type":                  "firmware\n",
+		video + "/backlight/acpi_video0/max_brightness":        "15\n",
+		video + "/backlight/acpi_video0/brightness":            "15\n",
+		"devices/pci0000:00/0000:01:00.0/nv_bl/type":           "raw\n",
+		"devices/pci0000:00/0000:01:00.0/nv_bl/max_brightness": "100\n",
+		"devices/pci0000:00/0000:01:00.0/nv_bl/brightness":     "100\n",
+		gpu + "/drm/card0/card0-HDMI-A-1/status":               "disconnected\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, target := range links {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(root, target), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join(root, target), path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestForConnector(t *testing.T) {
+	root := fakeSysfs(t)
+	b, err := ForConnector(root, "card0", "eDP-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Name != "acpi_video0" || b.Type != Firmware || b.Max != 15 {
+		t.Errorf("got %s, a %s backlight up to %d, want acpi_video0", b.Name, b.Type, b.Max)
+	}
+
+	// Without the firmware's backlight, the GPU's is used rather than that of
+	// the other GPU.
+	if err := os.Remove(filepath.Join(root, "class/backlight/acpi_video0")); err != nil {
+		t.Fatal(err)
+	}
+	if b, err = ForConnector(root, "card0", "eDP-1"); err != nil {
+		t.Fatal(err)
+	}
+	if b.Name != "intel_backlight" {
+		t.Errorf("got %s, want intel_backlight", b.Name)
+	}
+
+	if _, err := ForConnector(root, "card0", "HDMI-A-1"); !errors.Is(err, ErrNoBacklight) {
+		t.Errorf("external connector: got %v, want ErrNoBacklight", err)
+	}
+}
+
+func TestPercent(t *testing.T) {
+	root := fakeSysfs(t)
+	if err := os.Remove(filepath.Join(root, "class/backlight/acpi_video0")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ForConnector(root, "card0", "eDP-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := b.Percent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Half the power of a raw backlight looks brighter than half as bright.
+	if want := 100 * math.Pow(0.5, 1/gamma); math.Abs(p-want) > 1e-9 {
+		t.Errorf("percent: got %g, want %g", p, want)
+	}
+
+	brightness := func() string {
+		data, err := os.ReadFile(filepath.Join(b.dir, "brightness"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(data))
+	}
+	if err := b.SetPercent(50); err != nil {
+		t.Fatal(err)
+	}
+	if got := brightness(); got != "218" {
+		t.Errorf("brightness at 50%%: got %s, want 218", got)
+	}
+	if err := b.SetPercent(0.01); err != nil {
+		t.Fatal(err)
+	}
+	if got := brightness(); got != "1" {
+		t.Errorf("brightness at 0.01%%: got %s, want 1", got)
+	}
+	if err := b.SetBrightness(1001); err == nil {
+		t.Error("brightness above max: no error")
+	}
+
+	// The fake's actual brightness does not follow the brightness set, so the
+	// fade starts from 500.
+	if err := b.Fade(context.Background(), 100, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if got := brightness(); got != "1000" {
+		t.Errorf("brightness after fading to 100%%: got %s, want 1000", got)
+	}
+}
@@ -0,0 +1,172 @@
+package drm
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// hdrOutputMetadataLen is sizeof(struct hdr_output_metadata), including padding.
+const hdrOutputMetadataLen = 32
+
+// MarshalBinary encodes the metadata as the contents of an HDR_OUTPUT_METADATA
+// blob.
+func (m *HDROutputMetadata) MarshalBinary() ([]byte, error) {
+	b := make([]byte, hdrOutputMetadataLen)
+	binary.LittleEndian.PutUint32(b[0:], m.MetadataType)
+	b[4] = m.EOTF
+	b[5] = m.InfoframeType
+	for i, p := range m.DisplayPrimaries {
+		binary.LittleEndian.PutUint16(b[6+i*4:], p.X)
+		binary.LittleEndian.PutUint16(b[8+i*4:], p.Y)
+	}
+	binary.LittleEndian.PutUint16(b[18:], m.WhitePoint.X)
+	binary.LittleEndian.PutUint16(b[20:], m.WhitePoint.Y)
+	binary.LittleEndian.PutUint16(b[22:], m.MaxDisplayMasteringLuminance)
+	binary.LittleEndian.PutUint16(b[24:], m.MinDisplayMasteringLuminance)
+	binary.LittleEndian.PutUint16(b[26:], m.MaxCLL)
+	binary.LittleEndian.PutUint16(b[28:], m.MaxFALL)
+	return b, nil
+}
+
+// UnmarshalBinary decodes the contents of an HDR_OUTPUT_METADATA blob.
+func (m *HDROutputMetadata) UnmarshalBinary(b []byte) error {
+	if len(b) < hdrOutputMetadataLen {
+		return fmt.Errorf("hdr output metadata: short blob of %d bytes", len(b))
+	}
+	m.MetadataType = binary.LittleEndian.Uint32(b[0:])
+	m.EOTF = b[4]
+	m.InfoframeType = b[5]
+	for i := range m.DisplayPrimaries {
+		m.DisplayPrimaries[i].X = binary.LittleEndian.Uint16(b[6+i*4:])
+		m.DisplayPrimaries[i].Y = binary.LittleEndian.Uint16(b[8+i*4:])
+	}
+	m.WhitePoint.X = binary.LittleEndian.Uint16(b[18:])
+	m.WhitePoint.Y = binary.LittleEndian.Uint16(b[20:])
+	m.MaxDisplayMasteringLuminance = binary.LittleEndian.Uint16(b[22:])
+	m.MinDisplayMasteringLuminance = binary.LittleEndian.Uint16(b[24:])
+	m.MaxCLL = binary.LittleEndian.Uint16(b[26:])
+	m.MaxFALL = binary.LittleEndian.Uint16(b[28:])
+	return nil
+}
+
+// ConnectorEDID returns the raw EDID of a connector, or nil if the connector has
+// none, e.g. because it is disconnected. It can be decoded with the drm/edid
+// package.
+func (c *Card) ConnectorEDID(connectorID uint32) ([]byte, error) {
+	props, err := c.ObjectProperties(connectorID, ModeObjectConnector)
+	if err != nil {
+		return nil, err
+	}
+	prop, ok := props["EDID"]
+	if !ok || prop.Value == 0 {
+		return nil, nil
+	}
+	blob, err := c.ModeGetBlob(uint32(prop.Value))
+	if err != nil {
+		return nil, err
+	}
+	return blob.Data, nil
+}
+
+// HDROutputMetadata returns the HDR metadata currently set on a connector, or nil
+// if none is set.
+func (c *Card) HDROutputMetadata(connectorID uint32) (*HDROutputMetadata, error) {
+	props, err := c.ObjectProperties(connectorID, ModeObjectConnector)
+	if err != nil {
+		return nil, err
+	}
+	prop, ok := props["HDR_OUTPUT_METADATA"]
+	if !ok {
+		return nil, fmt.Errorf("connector %d does not support HDR output metadata", connectorID)
+	}
+	if prop.Value == 0 {
+		return nil, nil
+	}
+	blob, err := c.ModeGetBlob(uint32(prop.Value))
+	if err != nil {
+		return nil, err
+	}
+	var ret HDROutputMetadata
+	if err := ret.UnmarshalBinary(blob.Data); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// SetHDROutputMetadata sets the HDR metadata sent to the sink connected to a
+// connector. A nil metadata stops sending HDR metadata. The change takes effect
+// with the next modeset on drivers that require one.
+func (c *Card) SetHDROutputMetadata(connectorID uint32, metadata *HDROutputMetadata) error {
+	var blobID uint32
+	if metadata != nil {
+		data, err := metadata.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if blobID, err = c.ModeCreatePropBlob(data); err != nil {
+			return err
+		}
+		// The property holds its own reference to the blob.
+		defer c.ModeDestroyPropBlob(blobID)
+	}
+	return c.setConnectorProperty(connectorID, "HDR_OUTPUT_METADATA", uint64(blobID))
+}
+
+// SetColorspace sets the "Colorspace" property of a connector to one of the
+// Colorspace* values.
+func (c *Card) SetColorspace(connectorID uint32, colorspace string) error {
+	return c.setConnectorEnum(connectorID, "Colorspace", colorspace)
+}
+
+// SetBroadcastRGB sets the "Broadcast RGB" property of a connector to one of the
+// BroadcastRGB* values.
+func (c *Card) SetBroadcastRGB(connectorID uint32, mode string) error {
+	return c.setConnectorEnum(connectorID, "Broadcast RGB", mode)
+}
+
+// SetMaxBPC sets the "max bpc" property of a connector, which limits the number
+// of bits per color channel sent to the sink.
+func (c *Card) SetMaxBPC(connectorID uint32, bpc uint64) error {
+	props, err := c.ObjectProperties(connectorID, ModeObjectConnector)
+	if err != nil {
+		return err
+	}
+	prop, ok := props["max bpc"]
+	if !ok {
+		return fmt.Errorf("connector %d has no property max bpc", connectorID)
+	}
+	if prop.Flags&ModePropRange != 0 && len(prop.Values) == 2 &&
+		(bpc < prop.Values[0] || bpc > prop.Values[1]) {
+		return fmt.Errorf("max bpc %d is outside of the supported range %d-%d", bpc,
+			prop.Values[0], prop.Values[1])
+	}
+	return c.ModeConnectorSetProperty(connectorID, prop.PropID, bpc)
+}
+
+func (c *Card) setConnectorEnum(connectorID uint32, name, value string) error {
+	props, err := c.ObjectProperties(connectorID, ModeObjectConnector)
+	if err != nil {
+		return err
+	}
+	prop, ok := props[name]
+	if !ok {
+		return fmt.Errorf("connector %d has no property %s", connectorID, name)
+	}
+	v, err := prop.EnumValue(value)
+	if err != nil {
+		return err
+	}
+	return c.ModeConnectorSetProperty(connectorID, prop.PropID, v)
+}
+
+func (c *Card) setConnectorProperty(connectorID uint32, name string, value uint64) error {
+	props, err := c.ObjectProperties(connectorID, ModeObjectConnector)
+	if err != nil {
+		return err
+	}
+	id, err := props.ID(name)
+	if err != nil {
+		return fmt.Errorf("connector %d: %w", connectorID, err)
+	}
+	return c.ModeConnectorSetProperty(connectorID, id, value)
+}
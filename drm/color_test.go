@@ -0,0 +1,135 @@
+package drm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestHDROutputMetadataMarshal(t *testing.T) {
+	m := HDROutputMetadata{
+		EOTF:          EOTFSMPTEST2084,
+		InfoframeType: HDMIStaticMetadataType1,
+		DisplayPrimaries: [3]Chromaticity{
+			{X: 0x8a48, Y: 0x3908}, {X: 0x2134, Y: 0x9baa}, {X: 0x1996, Y: 0x08fc},
+		},
+		WhitePoint:                   Chromaticity{X: 0x3d13, Y: 0x4042},
+		MaxDisplayMasteringLuminance: 1000,
+		MinDisplayMasteringLuminance: 50,
+		MaxCLL:                       0x0102,
+		MaxFALL:                      0x0304,
+	}
+	// struct hdr_output_metadata: the __u32 metadata_type, 0 for HDMI static
+	// metadata type 1, followed by struct hdr_metadata_infoframe, whose eotf
+	// and metadata_type bytes precede the little endian __u16 fields, and 2
+	// bytes of padding.
+	want, err := hex.DecodeString("00000000" + "0200" +
+		"488a0839" + "3421aa9b" + "9619fc08" + "133d4240" +
+		"e803" + "3200" + "0201" + "0403" + "0000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("marshaled\n%x, want\n%x", got, want)
+	}
+
+	var back HDROutputMetadata
+	if err := back.UnmarshalBinary(got); err != nil {
+		t.Fatal(err)
+	}
+	if back != m {
+		t.Errorf("unmarshaled %+v, want %+v", back, m)
+	}
+	if err := back.UnmarshalBinary(got[:30]); err == nil {
+		t.Error("short blob unmarshaled")
+	}
+}
+
+// addColorProperties attaches the color properties of an HDMI connector to the
+// connector of s.
+func (s *fakeSetup) addColorProperties() {
+	colorspace := fakeEnum(0, ColorspaceDefault, ColorspaceBT2020RGB, ColorspaceBT2020YCC)
+	colorspace.Name = "Colorspace"
+	broadcast := fakeEnum(0, BroadcastRGBAutomatic, BroadcastRGBFull, BroadcastRGBLimited)
+	broadcast.Name = "Broadcast RGB"
+	s.dev.AddProperty(s.connector, colorspace, 0)
+	s.dev.AddProperty(s.connector, broadcast, 0)
+	s.dev.AddProperty(s.connector, ModeProperty{Name: "max bpc", Flags: ModePropRange,
+		Values: []uint64{8, 12}}, 8)
+	s.dev.AddProperty(s.connector, ModeProperty{Name: "HDR_OUTPUT_METADATA", Flags: ModePropBlob}, 0)
+}
+
+func TestSetHDROutputMetadata(t *testing.T) {
+	s := newFakeSetup(t)
+	if _, err := s.card.HDROutputMetadata(s.connector); err == nil {
+		t.Error("metadata of a connector without HDR support")
+	}
+	s.addColorProperties()
+	if m, err := s.card.HDROutputMetadata(s.connector); m != nil || err != nil {
+		t.Errorf("unset metadata is %+v, %v", m, err)
+	}
+
+	m := HDROutputMetadata{EOTF: EOTFSMPTEST2084, InfoframeType: HDMIStaticMetadataType1,
+		MaxCLL: 1000, MaxFALL: 400}
+	if err := s.card.SetHDROutputMetadata(s.connector, &m); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.card.HDROutputMetadata(s.connector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || *got != m {
+		t.Errorf("metadata is %+v, want %+v", got, m)
+	}
+
+	if err := s.card.SetHDROutputMetadata(s.connector, nil); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.dev.Property(s.connector, "HDR_OUTPUT_METADATA"); v != 0 {
+		t.Errorf("metadata blob %d left set", v)
+	}
+}
+
+func TestSetColorProperties(t *testing.T) {
+	s := newFakeSetup(t)
+	if err := s.card.SetColorspace(s.connector, ColorspaceBT2020RGB); err == nil {
+		t.Error("colorspace set on a connector without the property")
+	}
+	s.addColorProperties()
+
+	if err := s.card.SetColorspace(s.connector, ColorspaceBT2020RGB); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.card.SetBroadcastRGB(s.connector, BroadcastRGBLimited); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.card.SetMaxBPC(s.connector, 10); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]uint64{"Colorspace": 1, "Broadcast RGB": 2, "max bpc": 10} {
+		if v, _ := s.dev.Property(s.connector, name); v != want {
+			t.Errorf("%s is %d, want %d", name, v, want)
+		}
+	}
+
+	// Values the connector does not support are rejected before they reach the
+	// driver.
+	if err := s.card.SetColorspace(s.connector, ColorspaceDCIP3RGBD65); err == nil {
+		t.Error("unsupported colorspace set")
+	}
+	if err := s.card.SetBroadcastRGB(s.connector, "Limited"); err == nil {
+		t.Error("unknown broadcast RGB mode set")
+	}
+	for _, bpc := range []uint64{6, 16} {
+		if err := s.card.SetMaxBPC(s.connector, bpc); err == nil {
+			t.Errorf("max bpc %d set", bpc)
+		}
+	}
+	if v, _ := s.dev.Property(s.connector, "max bpc"); v != 10 {
+		t.Errorf("max bpc changed to %d", v)
+	}
+}
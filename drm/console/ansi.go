@@ -0,0 +1,303 @@
+package console
+
+import "image/color"
+
+// palette holds the 16 colors of the ANSI escape sequences, as the Linux
+// console shows them: the 8 normal colors, then their bright variants.
+var palette = [16]color.RGBA{
+	{0x00, 0x00, 0x00, 0xff}, {0xaa, 0x00, 0x00, 0xff}, {0x00, 0xaa, 0x00, 0xff}, {0xaa, 0x55, 0x00, 0xff},
+	{0x00, 0x00, 0xaa, 0xff}, {0xaa, 0x00, 0xaa, 0xff}, {0x00, 0xaa, 0xaa, 0xff}, {0xaa, 0xaa, 0xaa, 0xff},
+	{0x55, 0x55, 0x55, 0xff}, {0xff, 0x55, 0x55, 0xff}, {0x55, 0xff, 0x55, 0xff}, {0xff, 0xff, 0x55, 0xff},
+	{0x55, 0x55, 0xff, 0xff}, {0xff, 0x55, 0xff, 0xff}, {0x55, 0xff, 0xff, 0xff}, {0xff, 0xff, 0xff, 0xff},
+}
+
+const (
+	defaultForeground = 7
+	defaultBackground = 0
+)
+
+// ink is a color of text: an index in the 256 color palette of xterm, or a
+// 24 bit color.
+type ink struct {
+	index uint8
+	rgb   *color.RGBA
+}
+
+func (i ink) color() color.RGBA {
+	switch {
+	case i.rgb != nil:
+		return *i.rgb
+	case i.index < 16:
+		return palette[i.index]
+	case i.index < 232:
+		// A 6x6x6 cube of colors.
+		levels := [6]uint8{0, 95, 135, 175, 215, 255}
+		n := i.index - 16
+		return color.RGBA{levels[n/36], levels[n/6%6], levels[n%6], 0xff}
+	}
+	// A ramp of grays.
+	v := 8 + 10*(i.index-232)
+	return color.RGBA{v, v, v, 0xff}
+}
+
+// pen holds the attributes set by the SGR escape sequence.
+type pen struct {
+	fg, bg  ink
+	bold    bool
+	reverse bool
+}
+
+func (p *pen) reset() {
+	*p = pen{fg: ink{index: defaultForeground}, bg: ink{index: defaultBackground}}
+}
+
+// colors returns a cell of the pen's colors.
+func (p *pen) colors() cell {
+	fg := p.fg
+	if p.bold && fg.rgb == nil && fg.index < 8 {
+		// Bold text is drawn in the bright variant of its color.
+		fg.index += 8
+	}
+	c := cell{fg: fg.color(), bg: p.bg.color()}
+	if p.reverse {
+		c.fg, c.bg = c.bg, c.fg
+	}
+	return c
+}
+
+// sgr applies the parameters of an SGR escape sequence, "ESC [ ... m".
+func (p *pen) sgr(params []int) {
+	if len(params) == 0 {
+		params = []int{0}
+	}
+	for i := 0; i < len(params); i++ {
+		switch n := params[i]; {
+		case n == 0:
+			p.reset()
+		case n == 1:
+			p.bold = true
+		case n == 22:
+			p.bold = false
+		case n == 7:
+			p.reverse = true
+		case n == 27:
+			p.reverse = false
+		case n >= 30 && n <= 37:
+			p.fg = ink{index: uint8(n - 30)}
+		case n == 39:
+			p.fg = ink{index: defaultForeground}
+		case n >= 40 && n <= 47:
+			p.bg = ink{index: uint8(n - 40)}
+		case n == 49:
+			p.bg = ink{index: defaultBackground}
+		case n >= 90 && n <= 97:
+			p.fg = ink{index: uint8(n - 90 + 8)}
+		case n >= 100 && n <= 107:
+			p.bg = ink{index: uint8(n - 100 + 8)}
+		case n == 38 || n == 48:
+			// "38;5;N" selects a color of the palette, and "38;2;R;G;B" a
+			// 24 bit color.
+			var c ink
+			switch {
+			case i+2 < len(params) && params[i+1] == 5:
+				c.index = uint8(params[i+2])
+				i += 2
+			case i+4 < len(params) && params[i+1] == 2:
+				c.rgb = &color.RGBA{uint8(params[i+2]), uint8(params[i+3]), uint8(params[i+4]), 0xff}
+				i += 4
+			default:
+				return
+			}
+			if n == 38 {
+				p.fg = c
+			} else {
+				p.bg = c
+			}
+		}
+	}
+}
+
+// parser holds the state of an escape sequence being read.
+type parser struct {
+	state  int
+	params []int
+	// private is whether the sequence started with '?', as the DEC private
+	// modes do, which are ignored.
+	private bool
+}
+
+const (
+	stateText = iota
+	// stateEscape follows ESC.
+	stateEscape
+	// stateCSI follows "ESC [", until the final byte of the sequence.
+	stateCSI
+	// stateOSC follows "ESC ]", until BEL or "ESC \".
+	stateOSC
+	// stateOSCEscape follows ESC in stateOSC.
+	stateOSCEscape
+)
+
+// maxParams bounds the parameters kept of a sequence, against garbage.
+const maxParams = 16
+
+// input handles a character written to the console.
+func (c *Console) input(r rune) {
+	p := &c.parser
+	switch p.state {
+	case stateEscape:
+		switch r {
+		case '[':
+			p.state, p.params, p.private = stateCSI, p.params[:0], false
+		case ']':
+			p.state = stateOSC
+		case 'c':
+			// Reset to the initial state.
+			p.state = stateText
+			c.pen.reset()
+			c.clear(0, len(c.cells))
+			c.x, c.y = 0, 0
+		default:
+			p.state = stateText
+		}
+		return
+	case stateCSI:
+		switch {
+		case r >= '0' && r <= '9':
+			if len(p.params) == 0 {
+				p.params = append(p.params, 0)
+			}
+			if last := &p.params[len(p.params)-1]; *last < 1<<16 {
+				*last = *last*10 + int(r-'0')
+			}
+		case r == ';':
+			if len(p.params) == 0 {
+				p.params = append(p.params, 0)
+			}
+			if len(p.params) < maxParams {
+				p.params = append(p.params, 0)
+			}
+		case r == '?':
+			p.private = true
+		case r >= 0x40 && r <= 0x7e:
+			p.state = stateText
+			if !p.private {
+				c.csi(r, p.params)
+			}
+		case r < ' ':
+			// Control characters take effect in the middle of sequences.
+			c.control(r)
+		}
+		return
+	case stateOSC:
+		switch r {
+		case '\a':
+			p.state = stateText
+		case 0x1b:
+			p.state = stateOSCEscape
+		}
+		return
+	case stateOSCEscape:
+		p.state = stateText
+		if r != '\\' {
+			p.state = stateOSC
+		}
+		return
+	}
+
+	if r < ' ' || r == 0x7f {
+		c.control(r)
+		return
+	}
+	c.put(r)
+}
+
+// control handles a control character.
+func (c *Console) control(r rune) {
+	switch r {
+	case 0x1b:
+		c.parser.state = stateEscape
+	case '\n', '\v', '\f':
+		c.x = 0
+		c.lineFeed()
+	case '\r':
+		c.x = 0
+	case '\t':
+		c.x = (c.x/8 + 1) * 8
+		if c.x > c.cols {
+			c.x = c.cols
+		}
+	case '\b':
+		if c.x >= c.cols {
+			c.x = c.cols - 1
+		}
+		if c.x > 0 {
+			c.x--
+		}
+	}
+}
+
+// csi handles a control sequence, "ESC [ params final".
+func (c *Console) csi(final rune, params []int) {
+	// param returns a parameter, where 0 or a missing one stands for def.
+	param := func(i, def int) int {
+		if i < len(params) && params[i] != 0 {
+			return params[i]
+		}
+		return def
+	}
+	cursor := c.y*c.cols + c.x
+	if c.x >= c.cols {
+		cursor = c.y*c.cols + c.cols - 1
+	}
+	switch final {
+	case 'm':
+		c.pen.sgr(params)
+	case 'A':
+		c.moveTo(c.x, c.y-param(0, 1))
+	case 'B':
+		c.moveTo(c.x, c.y+param(0, 1))
+	case 'C':
+		c.moveTo(c.x+param(0, 1), c.y)
+	case 'D':
+		c.moveTo(c.x-param(0, 1), c.y)
+	case 'G':
+		c.moveTo(param(0, 1)-1, c.y)
+	case 'H', 'f':
+		c.moveTo(param(1, 1)-1, param(0, 1)-1)
+	case 'J':
+		switch param(0, 0) {
+		case 0:
+			c.clear(cursor, len(c.cells))
+		case 1:
+			c.clear(0, cursor+1)
+		case 2, 3:
+			c.clear(0, len(c.cells))
+		}
+	case 'K':
+		start := c.y * c.cols
+		switch param(0, 0) {
+		case 0:
+			c.clear(cursor, start+c.cols)
+		case 1:
+			c.clear(start, cursor+1)
+		case 2:
+			c.clear(start, start+c.cols)
+		}
+	}
+}
+
+// moveTo moves the cursor, keeping it on the screen.
+func (c *Console) moveTo(x, y int) {
+	c.x, c.y = clamp(x, 0, c.cols-1), clamp(y, 0, c.rows-1)
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
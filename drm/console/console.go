@@ -0,0 +1,321 @@
+// Package console draws a scrolling text console on a dumb framebuffer, to show
+// status and logs on a bare display, e.g. during boot or recovery, without the
+// kernel's fbcon. A Console is an io.Writer, which can be handed to a logger,
+// and understands the ANSI escape sequences of colored output.
+package console
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/inahga/inahgo/drm"
+	"github.com/inahga/inahgo/drm/pixconv"
+)
+
+// Options configures a console.
+type Options struct {
+	// Scale is the size in pixels of a pixel of the font, or 0 for the
+	// largest that fits 80 columns and 25 rows.
+	Scale int
+}
+
+// Console is a text console drawn on a framebuffer. Its methods may be called
+// concurrently.
+type Console struct {
+	mu sync.Mutex
+	fb *drm.DumbFramebuffer
+
+	scale      int
+	cols, rows int
+	cells      []cell
+	// dirty holds the rows changed since they were last drawn.
+	dirty []bool
+
+	// x and y are the position of the cursor. X is cols after writing to the
+	// last column, and the next character wraps to a new line.
+	x, y int
+	pen  pen
+
+	parser parser
+	// partial holds the start of a UTF-8 sequence split across writes.
+	partial []byte
+
+	pixels map[color.RGBA][]byte
+	line   []byte
+
+	// card, saved and sets are set by Open, to restore the CRTCs on Close:
+	// saved holds the CRTCs before, and sets their configurations by Open.
+	card  *drm.Card
+	saved []drm.ModeCRTC
+	sets  []drm.ModeCRTC
+}
+
+type cell struct {
+	r      rune
+	fg, bg color.RGBA
+}
+
+// New returns a console drawn on a framebuffer, which it clears. The
+// framebuffer stays the caller's.
+func New(fb *drm.DumbFramebuffer, opts *Options) (*Console, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	scale := opts.Scale
+	if scale <= 0 {
+		scale = fb.Width / (80 * glyphWidth)
+		if s := fb.Height / (25 * glyphHeight); s < scale {
+			scale = s
+		}
+		if scale < 1 {
+			scale = 1
+		}
+	}
+	c := Console{fb: fb, scale: scale, cols: fb.Width / (glyphWidth * scale),
+		rows: fb.Height / (glyphHeight * scale), pixels: make(map[color.RGBA][]byte)}
+	if c.cols == 0 || c.rows == 0 {
+		return nil, fmt.Errorf("framebuffer of %dx%d too small for a character", fb.Width, fb.Height)
+	}
+	c.cells = make([]cell, c.cols*c.rows)
+	c.dirty = make([]bool, c.rows)
+	c.line = make([]byte, c.cols*glyphWidth*scale*fb.CPP*glyphHeight*scale)
+	c.pen.reset()
+
+	// The margins past the last column and row are only drawn here.
+	bg, err := c.pixel(c.pen.colors().bg)
+	if err != nil {
+		return nil, err
+	}
+	if err := fb.Fill(fb.Bounds(), bg); err != nil {
+		return nil, err
+	}
+	c.clear(0, len(c.cells))
+	return &c, c.draw()
+}
+
+// Size returns the number of columns and rows of the console.
+func (c *Console) Size() (cols, rows int) {
+	return c.cols, c.rows
+}
+
+// Write draws text at the cursor, scrolling when it reaches the bottom. A line
+// feed also returns the cursor to the first column, as terminals do for the
+// output of programs. It returns an error only if the framebuffer could not be
+// updated.
+func (c *Console) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := len(p)
+	if len(c.partial) > 0 {
+		p = append(c.partial, p...)
+		c.partial = nil
+	}
+	for len(p) > 0 {
+		if p[0] < utf8.RuneSelf {
+			c.input(rune(p[0]))
+			p = p[1:]
+			continue
+		}
+		if !utf8.FullRune(p) {
+			c.partial = append([]byte(nil), p...)
+			break
+		}
+		r, size := utf8.DecodeRune(p)
+		c.input(r)
+		p = p[size:]
+	}
+	return n, c.draw()
+}
+
+// Clear clears the console and moves the cursor to the top left corner.
+func (c *Console) Clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clear(0, len(c.cells))
+	c.x, c.y = 0, 0
+	return c.draw()
+}
+
+// put draws a character at the cursor and advances it.
+func (c *Console) put(r rune) {
+	if c.x >= c.cols {
+		c.x = 0
+		c.lineFeed()
+	}
+	colors := c.pen.colors()
+	colors.r = r
+	c.cells[c.y*c.cols+c.x] = colors
+	c.dirty[c.y] = true
+	c.x++
+}
+
+// lineFeed moves the cursor down a row, scrolling at the bottom.
+func (c *Console) lineFeed() {
+	if c.y < c.rows-1 {
+		c.y++
+		return
+	}
+	copy(c.cells, c.cells[c.cols:])
+	c.clear(len(c.cells)-c.cols, len(c.cells))
+	for i := range c.dirty {
+		c.dirty[i] = true
+	}
+}
+
+// clear erases the cells from start to end, in the order of the screen, with the
+// background of the pen.
+func (c *Console) clear(start, end int) {
+	blank := c.pen.colors()
+	blank.r, blank.fg = ' ', blank.bg
+	for i := start; i < end; i++ {
+		c.cells[i] = blank
+	}
+	for y := start / c.cols; y < c.rows && y*c.cols < end; y++ {
+		c.dirty[y] = true
+	}
+}
+
+// draw draws the dirty rows into the framebuffer, and flushes the damage.
+func (c *Console) draw() error {
+	cw, ch := glyphWidth*c.scale, glyphHeight*c.scale
+	cpp := c.fb.CPP
+	stride := c.cols * cw * cpp
+	for y, dirty := range c.dirty {
+		if !dirty {
+			continue
+		}
+		for x, cl := range c.cells[y*c.cols : (y+1)*c.cols] {
+			fg, err := c.pixel(cl.fg)
+			if err != nil {
+				return err
+			}
+			bg, err := c.pixel(cl.bg)
+			if err != nil {
+				return err
+			}
+			g := glyph(cl.r)
+			for gy, bits := range g {
+				row := c.line[gy*c.scale*stride+x*cw*cpp:]
+				for gx := 0; gx < glyphWidth; gx++ {
+					pix := bg
+					if bits&(1<<gx) != 0 {
+						pix = fg
+					}
+					for i := 0; i < c.scale; i++ {
+						copy(row[((gx*c.scale)+i)*cpp:], pix)
+					}
+				}
+			}
+		}
+		// Repeat each row of the glyphs for the scale.
+		for gy := 0; gy < glyphHeight; gy++ {
+			src := c.line[gy*c.scale*stride : (gy*c.scale+1)*stride]
+			for i := 1; i < c.scale; i++ {
+				copy(c.line[(gy*c.scale+i)*stride:], src)
+			}
+		}
+		r := image.Rect(0, y*ch, c.cols*cw, (y+1)*ch)
+		if err := c.fb.Write(r, c.line, stride); err != nil {
+			return err
+		}
+		c.dirty[y] = false
+	}
+	return c.fb.Flush()
+}
+
+// pixel returns a color encoded in the framebuffer's format.
+func (c *Console) pixel(col color.RGBA) ([]byte, error) {
+	if pix, ok := c.pixels[col]; ok {
+		return pix, nil
+	}
+	b, err := pixconv.NewBuffer(c.fb.Format, 1, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := pixconv.Encode(b, image.NewUniform(col)); err != nil {
+		return nil, err
+	}
+	pix := b.Planes[0][:c.fb.CPP]
+	c.pixels[col] = pix
+	return pix, nil
+}
+
+// Open shows a console on a monitor of the card, e.g. one of Card.Monitors, on
+// a framebuffer of its own covering the whole monitor. Close restores what the
+// CRTCs showed before.
+func Open(card *drm.Card, m *drm.Monitor, opts *Options) (*Console, error) {
+	res, err := card.ModeGetResources()
+	if err != nil {
+		return nil, err
+	}
+	var saved []drm.ModeCRTC
+	for _, id := range res.CRTCIDs {
+		crtc, err := card.ModeGetCRTC(id)
+		if err != nil {
+			return nil, fmt.Errorf("crtc %d: %w", id, err)
+		}
+		saved = append(saved, *crtc)
+	}
+
+	fb, err := card.NewDumbFramebuffer(m.Width, m.Height, drm.FormatXRGB8888)
+	if err != nil {
+		return nil, err
+	}
+	c, err := New(fb, opts)
+	if err != nil {
+		fb.Release()
+		return nil, err
+	}
+	c.card, c.saved = card, saved
+	c.sets, err = card.SetMonitor(m, fb.ID)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close restores the CRTCs and releases the framebuffer of a console shown by
+// Open. It does nothing for consoles made by New.
+func (c *Console) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.card == nil {
+		return nil
+	}
+	// Errors do not stop the restoring, and the first one is returned.
+	var first error
+	for _, set := range c.sets {
+		if err := c.card.ModeSetCRTC(c.restore(set)); err != nil && first == nil {
+			first = fmt.Errorf("restore crtc %d: %w", set.ID, err)
+		}
+	}
+	// The framebuffer is removed only once no CRTC shows it, so that removing
+	// it never disables a CRTC.
+	if err := c.fb.Release(); err != nil && first == nil {
+		first = err
+	}
+	c.card = nil
+	return first
+}
+
+// restore returns the configuration a CRTC set by Open had before, showing its
+// previous framebuffer on the connector of set, or disabling it.
+func (c *Console) restore(set drm.ModeCRTC) drm.ModeCRTC {
+	for _, saved := range c.saved {
+		if saved.ID != set.ID {
+			continue
+		}
+		if saved.ModeValid != 0 && saved.FBID != 0 {
+			saved.SetConnectors = set.SetConnectors
+			return saved
+		}
+	}
+	var off drm.ModeCRTC
+	off.ID = set.ID
+	return off
+}
@@ -0,0 +1,157 @@
+package console
+
+import (
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/inahga/inahgo/drm"
+)
+
+func newConsole(t *testing.T, width, height int) (*Console, *drm.FakeDevice) {
+	t.Helper()
+	dev := drm.NewFakeDevice()
+	card := drm.NewWithBackend(dev)
+	fb, err := card.NewDumbFramebuffer(width, height, drm.FormatXRGB8888)
+	if err != nil {
+		t.Fatalf("new dumb framebuffer: %s", err)
+	}
+	t.Cleanup(func() { fb.Release() })
+	c, err := New(fb, &Options{Scale: 1})
+	if err != nil {
+		t.Fatalf("new console: %s", err)
+	}
+	return c, dev
+}
+
+// at returns the color of a pixel of the console's framebuffer.
+func at(c *Console, x, y int) color.RGBA {
+	p := c.fb.Mem[y*c.fb.Pitch+x*4:]
+	return color.RGBA{p[2], p[1], p[0], 0xff}
+}
+
+// text returns the characters of a row of the console.
+func text(c *Console, y int) string {
+	var b strings.Builder
+	for _, cl := range c.cells[y*c.cols : (y+1)*c.cols] {
+		b.WriteRune(cl.r)
+	}
+	return strings.TrimRight(b.String(), " ")
+}
+
+func write(t *testing.T, c *Console, s string) {
+	t.Helper()
+	if n, err := c.Write([]byte(s)); err != nil || n != len(s) {
+		t.Fatalf("write %q: %d, %v", s, n, err)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	c, dev := newConsole(t, 80, 36)
+	if cols, rows := c.Size(); cols != 10 || rows != 4 {
+		t.Fatalf("size is %dx%d", cols, rows)
+	}
+	write(t, c, "H")
+	// The first row of H is 0x33: two pixels on, two off, two on.
+	for x, on := range []bool{true, true, false, false, true, true, false, false} {
+		want := palette[defaultBackground]
+		if on {
+			want = palette[defaultForeground]
+		}
+		if got := at(c, x, 0); got != want {
+			t.Errorf("pixel %d of H is %v, want %v", x, got, want)
+		}
+	}
+	if rects := dev.DirtyRects(c.fb.ID); len(rects) == 0 {
+		t.Error("writing did not report damage")
+	}
+
+	write(t, c, "\x1b[31mA\x1b[0m")
+	// The first row of A is 0x0C.
+	if got := at(c, 8+2, 0); got != palette[1] {
+		t.Errorf("red A is %v", got)
+	}
+	write(t, c, "\x1b[1;34mB\x1b[38;2;1;2;3mC\x1b[7mD")
+	if got := c.cells[2].fg; got != palette[12] {
+		t.Errorf("bold blue is %v", got)
+	}
+	if got := c.cells[3].fg; got != (color.RGBA{1, 2, 3, 0xff}) {
+		t.Errorf("24 bit color is %v", got)
+	}
+	if got := c.cells[4]; got.bg != (color.RGBA{1, 2, 3, 0xff}) || got.fg != palette[defaultBackground] {
+		t.Errorf("reverse is %v", got)
+	}
+}
+
+func TestScroll(t *testing.T) {
+	c, _ := newConsole(t, 80, 32)
+	write(t, c, "one\ntwo\nthree\nfour\nfive")
+	for y, want := range []string{"two", "three", "four", "five"} {
+		if got := text(c, y); got != want {
+			t.Errorf("row %d is %q, want %q", y, got, want)
+		}
+	}
+
+	// Long lines wrap, and the cursor waits at the end of a full line.
+	write(t, c, "\x1b[2J\x1b[H0123456789")
+	if c.y != 0 || text(c, 1) != "" {
+		t.Errorf("full line moved the cursor to row %d", c.y)
+	}
+	write(t, c, "ab\tc\r\x1b[Kx")
+	if got := text(c, 0); got != "0123456789" {
+		t.Errorf("row 0 is %q", got)
+	}
+	if got := text(c, 1); got != "x" {
+		t.Errorf("row 1 is %q", got)
+	}
+}
+
+func TestSplitWrites(t *testing.T) {
+	c, _ := newConsole(t, 80, 8)
+	for _, b := range []byte("\x1b[32mé\x1b]0;title\ax") {
+		write(t, c, string([]byte{b}))
+	}
+	if c.cells[0].r != 'é' || c.cells[0].fg != palette[2] {
+		t.Errorf("first cell is %+v", c.cells[0])
+	}
+	if got := text(c, 0); got != "éx" {
+		t.Errorf("row is %q", got)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dev := drm.NewFakeDevice()
+	card := drm.NewWithBackend(dev)
+	crtc := dev.AddCRTC()
+	_, conn := dev.AddOutput(drm.ModeConnectorHDMIA, []uint32{crtc})
+	dev.Plug(conn, []drm.ModeInfo{drm.FakeMode(1280, 720, 60)}, 600, 340, nil)
+
+	monitors, err := card.Monitors()
+	if err != nil || len(monitors) != 1 {
+		t.Fatalf("monitors: %v, %v", monitors, err)
+	}
+	c, err := Open(card, &monitors[0], nil)
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	if cols, rows := c.Size(); cols != 80 || rows != 45 {
+		t.Errorf("size is %dx%d", cols, rows)
+	}
+	state, err := card.ModeGetCRTC(crtc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.FBID != c.fb.ID {
+		t.Errorf("crtc shows framebuffer %d, want %d", state.FBID, c.fb.ID)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("close: %s", err)
+	}
+	if state, err = card.ModeGetCRTC(crtc); err != nil {
+		t.Fatal(err)
+	}
+	if state.FBID != 0 || state.ModeValid != 0 {
+		t.Errorf("crtc not disabled on close: %+v", state)
+	}
+}
@@ -28,6 +28,22 @@ type cSetClientCap struct {
 	value      uint64
 }
 
+type cUnique struct {
+	uniqueLen kernelSize
+	unique    uint64 // ptr to a []byte
+}
+
+type cAuth struct {
+	magic uint32
+}
+
+type cSetVersion struct {
+	diMajor cint
+	diMinor cint
+	ddMajor cint
+	ddMinor cint
+}
+
 type cModeInfo struct {
 	Clock      uint32
 	HDisplay   uint16
@@ -107,6 +123,13 @@ type cModeConnectorSetProperty struct {
 	connectorID uint32
 }
 
+type cModeObjSetProperty struct {
+	value   uint64
+	propID  uint32
+	objID   uint32
+	objType uint32
+}
+
 type cModeCRTC struct {
 	setConnectorsPtr uint64 // ptr to a []uint32
 	countConnectors  uint32
@@ -213,3 +236,75 @@ type cModeFBCmd struct {
 	Depth  uint32
 	Handle uint32 // driver specific handle to a buffer
 }
+
+type cModeFBCmd2 struct {
+	ID          uint32
+	Width       uint32
+	Height      uint32
+	PixelFormat uint32
+	Flags       uint32
+
+	Handles  [4]uint32
+	Pitches  [4]uint32 // pitch for each plane
+	Offsets  [4]uint32 // offset of each plane
+	Modifier [4]uint64 // ie, tiling, compress
+}
+
+type cModeCRTCPageFlip struct {
+	crtcID   uint32
+	fbID     uint32
+	flags    uint32
+	reserved uint32
+	userData uint64
+}
+
+type cEvent struct {
+	typ    uint32
+	length uint32
+}
+
+type cEventVblank struct {
+	cEvent
+	userData uint64
+	tvSec    uint32
+	tvUsec   uint32
+	sequence uint32
+	crtcID   uint32 // 0 on older kernels that do not support this
+}
+
+type cModeAtomic struct {
+	flags         uint32
+	countObjs     uint32
+	objsPtr       uint64 // ptr to a []uint32
+	countPropsPtr uint64 // ptr to a []uint32
+	propsPtr      uint64 // ptr to a []uint32
+	propValuesPtr uint64 // ptr to a []uint64
+	reserved      uint64
+	userData      uint64
+}
+
+type cModeCreateBlob struct {
+	data   uint64 // ptr to a []uint8
+	length uint32
+	blobID uint32
+}
+
+type cModeDestroyBlob struct {
+	blobID uint32
+}
+
+type cModeFBDirtyCmd struct {
+	fbID     uint32
+	flags    uint32
+	color    uint32
+	numClips uint32
+	clipsPtr uint64 // ptr to a []cClipRect
+}
+
+type cClipRect struct {
+	x1, y1, x2, y2 uint16
+}
+
+type cModeRect struct {
+	x1, y1, x2, y2 int32
+}
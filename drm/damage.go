@@ -0,0 +1,157 @@
+package drm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"unsafe"
+)
+
+// ModeFBDirtyMaxClips is the number of rectangles the kernel accepts in a
+// single ModeDirtyFB call.
+const ModeFBDirtyMaxClips = 256
+
+// ModeDirtyFB reports the parts of a framebuffer that changed, for drivers that
+// only update the display where told to, such as those of USB displays and SPI
+// panels. Without rectangles, the whole framebuffer is damaged. Beyond
+// ModeFBDirtyMaxClips rectangles, their bounds are reported instead.
+//
+// Drivers that do not need damage reports fail with an error matching
+// ErrNotSupported.
+func (c *Card) ModeDirtyFB(fbID uint32, rects []image.Rectangle) error {
+	if len(rects) > ModeFBDirtyMaxClips {
+		rects = []image.Rectangle{boundsOf(rects)}
+	}
+	clips := make([]cClipRect, 0, len(rects))
+	for _, r := range rects {
+		r = r.Intersect(image.Rect(0, 0, 1<<16-1, 1<<16-1))
+		if r.Empty() {
+			continue
+		}
+		clips = append(clips, cClipRect{uint16(r.Min.X), uint16(r.Min.Y), uint16(r.Max.X), uint16(r.Max.Y)})
+	}
+	if len(rects) > 0 && len(clips) == 0 {
+		return nil
+	}
+	cmd := cModeFBDirtyCmd{fbID: fbID, numClips: uint32(len(clips))}
+	if len(clips) > 0 {
+		cmd.clipsPtr = uint64(uintptr(unsafe.Pointer(&clips[0])))
+	}
+	return c.ioctl(ioctlModeDirtyFB, fbID, unsafe.Pointer(&cmd))
+}
+
+// boundsOf returns the smallest rectangle containing rects.
+func boundsOf(rects []image.Rectangle) image.Rectangle {
+	var b image.Rectangle
+	for _, r := range rects {
+		b = b.Union(r)
+	}
+	return b
+}
+
+// CreateDamageClips creates a blob of damage rectangles, in framebuffer
+// coordinates, for the FB_DAMAGE_CLIPS property of a plane. The blob should be
+// destroyed with ModeDestroyPropBlob once the commit using it is done.
+func (c *Card) CreateDamageClips(rects []image.Rectangle) (uint32, error) {
+	var b bytes.Buffer
+	for _, r := range rects {
+		if r.Empty() {
+			continue
+		}
+		binary.Write(&b, binary.LittleEndian, cModeRect{int32(r.Min.X), int32(r.Min.Y),
+			int32(r.Max.X), int32(r.Max.Y)})
+	}
+	if b.Len() == 0 {
+		return 0, nil
+	}
+	return c.ModeCreatePropBlob(b.Bytes())
+}
+
+// AddPlaneDamage adds the damage of the framebuffer shown by a plane to an
+// atomic request, through the FB_DAMAGE_CLIPS property. It returns the ID of
+// the blob of rectangles, to destroy with ModeDestroyPropBlob once the commit
+// is done, or 0 if there is no damage to report. Drivers without the property
+// update the whole plane, and the request is left alone.
+//
+// The damage only holds for the commit it is added to, in which the plane
+// should show the same framebuffer as before; changing the framebuffer or the
+// source rectangle damages the whole plane.
+func (c *Card) AddPlaneDamage(req *AtomicRequest, planeID uint32, rects []image.Rectangle) (uint32, error) {
+	props, err := c.ObjectProperties(planeID, ModeObjectPlane)
+	if err != nil {
+		return 0, err
+	}
+	prop, ok := props["FB_DAMAGE_CLIPS"]
+	if !ok || len(rects) == 0 {
+		return 0, nil
+	}
+	blobID, err := c.CreateDamageClips(rects)
+	if err != nil {
+		return 0, fmt.Errorf("plane %d: damage clips: %w", planeID, err)
+	}
+	if blobID != 0 {
+		req.AddProperty(planeID, prop.PropID, uint64(blobID))
+	}
+	return blobID, nil
+}
+
+// maxDamageRects is the number of rectangles Damage keeps before merging them
+// into their bounds. Drivers handle a few large rectangles better than many
+// small ones.
+const maxDamageRects = 16
+
+// Damage accumulates the parts of a framebuffer that changed. Rectangles that
+// overlap or share part of an edge are merged into their bounds. The zero value
+// has no damage.
+type Damage struct {
+	rects []image.Rectangle
+}
+
+// Add damages a rectangle.
+func (d *Damage) Add(r image.Rectangle) {
+	r = r.Canon()
+	if r.Empty() {
+		return
+	}
+	// Merging may make the union touch rectangles it did not touch before, so
+	// merge until no rectangle touches it.
+	for merged := true; merged; {
+		merged = false
+		for i := 0; i < len(d.rects); i++ {
+			if touches(d.rects[i], r) {
+				r = r.Union(d.rects[i])
+				d.rects = append(d.rects[:i], d.rects[i+1:]...)
+				merged = true
+				i--
+			}
+		}
+	}
+	d.rects = append(d.rects, r)
+	if len(d.rects) > maxDamageRects {
+		d.rects = []image.Rectangle{boundsOf(d.rects)}
+	}
+}
+
+// touches returns whether two rectangles overlap or share part of an edge.
+func touches(a, b image.Rectangle) bool {
+	x := a.Min.X < b.Max.X && b.Min.X < a.Max.X
+	y := a.Min.Y < b.Max.Y && b.Min.Y < a.Max.Y
+	return (x || a.Max.X == b.Min.X || b.Max.X == a.Min.X) && y ||
+		x && (a.Max.Y == b.Min.Y || b.Max.Y == a.Min.Y)
+}
+
+// Rects returns the damaged rectangles, which do not overlap.
+func (d *Damage) Rects() []image.Rectangle {
+	return append([]image.Rectangle(nil), d.rects...)
+}
+
+// Empty returns whether nothing is damaged.
+func (d *Damage) Empty() bool {
+	return len(d.rects) == 0
+}
+
+// Reset clears the damage.
+func (d *Damage) Reset() {
+	d.rects = d.rects[:0]
+}
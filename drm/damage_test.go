@@ -0,0 +1,129 @@
+package drm
+
+import (
+	"encoding/binary"
+	"image"
+	"reflect"
+	"testing"
+)
+
+func TestDamage(t *testing.T) {
+	var d Damage
+	d.Add(image.Rect(0, 0, 10, 10))
+	d.Add(image.Rect(20, 0, 30, 10))
+	// Touching a corner only does not merge.
+	d.Add(image.Rect(30, 10, 35, 15))
+	if got := d.Rects(); len(got) != 3 {
+		t.Fatalf("got %v, want 3 rectangles", got)
+	}
+	// Sharing an edge with the first rectangle makes the union overlap the
+	// second, which is merged as well.
+	d.Add(image.Rect(10, 0, 20, 5))
+	want := []image.Rectangle{image.Rect(30, 10, 35, 15), image.Rect(0, 0, 30, 10)}
+	if got := d.Rects(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	d.Reset()
+	for i := 0; i <= maxDamageRects; i++ {
+		d.Add(image.Rect(2*i, 2*i, 2*i+1, 2*i+1))
+	}
+	want = []image.Rectangle{image.Rect(0, 0, 2*maxDamageRects+1, 2*maxDamageRects+1)}
+	if got := d.Rects(); !reflect.DeepEqual(got, want) {
+		t.Errorf("beyond %d rectangles: got %v, want %v", maxDamageRects, got, want)
+	}
+}
+
+func TestDumbFramebufferDirtyFB(t *testing.T) {
+	s := newFakeSetup(t)
+	f, err := s.card.NewDumbFramebuffer(64, 32, FormatXRGB8888)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Release()
+
+	if err := f.Fill(image.Rect(60, 30, 70, 40), []byte{0xff, 0, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	src := make([]byte, 4*4*2)
+	for i := range src {
+		src[i] = 0xaa
+	}
+	if err := f.Write(image.Rect(-2, 0, 2, 2), src, 16); err != nil {
+		t.Fatal(err)
+	}
+	if f.Mem[30*f.Pitch+60*4] != 0xff || f.Mem[31*f.Pitch+63*4] != 0xff || f.Mem[29*f.Pitch+60*4] != 0 {
+		t.Error("fill did not set the clipped rectangle")
+	}
+	if f.Mem[0] != 0xaa || f.Mem[f.Pitch+7] != 0xaa || f.Mem[8] != 0 {
+		t.Error("write did not copy the clipped rectangle")
+	}
+
+	if err := f.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := []image.Rectangle{image.Rect(60, 30, 64, 32), image.Rect(0, 0, 2, 2)}
+	if got := s.dev.DirtyRects(f.ID); !reflect.DeepEqual(got, want) {
+		t.Errorf("dirty: got %v, want %v", got, want)
+	}
+	if err := f.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.dev.DirtyRects(f.ID); got != nil {
+		t.Errorf("flush without damage reported %v", got)
+	}
+
+	if err := s.card.ModeDirtyFB(f.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.dev.DirtyRects(f.ID); !reflect.DeepEqual(got, []image.Rectangle{f.Bounds()}) {
+		t.Errorf("dirty without rectangles: got %v, want the whole framebuffer", got)
+	}
+}
+
+func TestPlaneDamage(t *testing.T) {
+	s := newFakeSetup(t)
+	if err := s.card.SetClientCap(ClientCapAtomic, 1); err != nil {
+		t.Fatal(err)
+	}
+	plane := s.dev.primaryPlane(s.crtc)
+	f, err := s.card.NewDumbFramebuffer(64, 32, FormatXRGB8888)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Release()
+
+	// Without the property, nothing is added.
+	f.Damage(image.Rect(0, 0, 8, 8))
+	var req AtomicRequest
+	if id, err := f.AddPlaneDamage(&req, plane); err != nil || id != 0 || req.Len() != 0 {
+		t.Fatalf("without FB_DAMAGE_CLIPS: got blob %d, %d properties, %v", id, req.Len(), err)
+	}
+
+	s.dev.AddProperty(plane, ModeProperty{Name: "FB_DAMAGE_CLIPS", Flags: ModePropBlob | ModePropAtomic}, 0)
+	f.Damage(image.Rect(4, 4, 100, 8))
+	id, err := f.AddPlaneDamage(&req, plane)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.card.ModeAtomicCommit(&req, 0, 0); err != nil {
+		t.Fatalf("commit: %s", err)
+	}
+	if v, _ := s.dev.Property(plane, "FB_DAMAGE_CLIPS"); uint32(v) != id {
+		t.Fatalf("FB_DAMAGE_CLIPS is %d, want blob %d", v, id)
+	}
+	blob, err := s.card.ModeGetBlob(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got [4]int32
+	for i := range got {
+		got[i] = int32(binary.LittleEndian.Uint32(blob.Data[4*i:]))
+	}
+	if want := [4]int32{4, 4, 64, 8}; len(blob.Data) != 16 || got != want {
+		t.Errorf("damage clips: got %v in %d bytes, want %v", got, len(blob.Data), want)
+	}
+	if !reflect.DeepEqual(f.Damaged(), []image.Rectangle(nil)) {
+		t.Errorf("damage left after adding it: %v", f.Damaged())
+	}
+}
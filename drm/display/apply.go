@@ -0,0 +1,420 @@
+package display
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/inahga/inahgo/drm"
+)
+
+// Layout is how a profile is shown on a card. All outputs show parts of one
+// screen, which is scanned out from a single framebuffer.
+type Layout struct {
+	Profile *Profile
+	// Width and Height are the size of the screen, which covers every enabled
+	// output.
+	Width  int
+	Height int
+	// Outputs are in the order of the profile's outputs.
+	Outputs []Placement
+}
+
+// Placement is an output of a profile placed on a display.
+type Placement struct {
+	Output  *Output
+	Display *Display
+	// CRTCID and Mode are those driving the display, and Rect is the area of the
+	// screen it shows. They are only set if the output is enabled.
+	CRTCID uint32
+	Mode   *drm.ModeInfo
+	Rect   image.Rectangle
+}
+
+// Plan works out the layout of a profile on a card, given the display matched
+// by each output. It picks the mode and CRTC of each enabled output, and
+// checks that the screen fits within the card's framebuffer size limits.
+func Plan(card *drm.Card, p *Profile, displays []*Display) (*Layout, error) {
+	if len(displays) != len(p.Outputs) {
+		return nil, fmt.Errorf("profile %s has %d outputs, but %d displays were given", p.Name,
+			len(p.Outputs), len(displays))
+	}
+	res, err := card.ModeGetResources()
+	if err != nil {
+		return nil, err
+	}
+
+	l := Layout{Profile: p, Outputs: make([]Placement, len(p.Outputs))}
+	var enabled []int
+	for i := range p.Outputs {
+		o, d := &p.Outputs[i], displays[i]
+		l.Outputs[i] = Placement{Output: o, Display: d}
+		if !o.IsEnabled() {
+			continue
+		}
+		if o.X < 0 || o.Y < 0 {
+			return nil, fmt.Errorf("output %s: negative position %d,%d", o, o.X, o.Y)
+		}
+		mode, err := findMode(d.Connector, o.Mode)
+		if err != nil {
+			return nil, fmt.Errorf("output %s: %w", o, err)
+		}
+		scale := o.Scale
+		if scale == 0 {
+			scale = 1
+		}
+		w := int(math.Round(float64(mode.HDisplay) * scale))
+		h := int(math.Round(float64(mode.VDisplay) * scale))
+		if o.Rotation == 90 || o.Rotation == 270 {
+			w, h = h, w
+		}
+		l.Outputs[i].Mode, l.Outputs[i].Rect = mode, image.Rect(o.X, o.Y, o.X+w, o.Y+h)
+		if l.Width < o.X+w {
+			l.Width = o.X + w
+		}
+		if l.Height < o.Y+h {
+			l.Height = o.Y + h
+		}
+		enabled = append(enabled, i)
+	}
+	if len(enabled) == 0 {
+		return &l, nil
+	}
+	if l.Width > int(res.MaxWidth) || l.Height > int(res.MaxHeight) {
+		return nil, fmt.Errorf("screen of %dx%d exceeds the maximum of %dx%d", l.Width, l.Height,
+			res.MaxWidth, res.MaxHeight)
+	}
+
+	conns := make([]*drm.ModeConnector, len(enabled))
+	for j, i := range enabled {
+		conns[j] = displays[i].Connector
+	}
+	crtcs, err := card.AssignCRTCs(conns)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range enabled {
+		l.Outputs[i].CRTCID = crtcs[j]
+	}
+	return &l, nil
+}
+
+// findMode returns the mode of a connector given as WIDTHxHEIGHT[@REFRESH], or
+// its preferred mode if name is empty. Without a refresh rate, the preferred
+// mode of that size is picked, or else the one of the highest refresh rate.
+func findMode(conn *drm.ModeConnector, name string) (*drm.ModeInfo, error) {
+	var width, height, refresh int
+	if name != "" {
+		var err error
+		if width, height, refresh, err = parseMode(name); err != nil {
+			return nil, err
+		}
+	}
+	var ret *drm.ModeInfo
+	for i := range conn.Modes {
+		m := &conn.Modes[i]
+		if name == "" {
+			if m.Type&drm.ModeTypePreferred != 0 {
+				return m, nil
+			}
+			continue
+		}
+		if int(m.HDisplay) != width || int(m.VDisplay) != height ||
+			(refresh != 0 && int(m.VRefresh) != refresh) {
+			continue
+		}
+		if ret == nil || m.Type&drm.ModeTypePreferred != 0 ||
+			(ret.Type&drm.ModeTypePreferred == 0 && m.VRefresh > ret.VRefresh) {
+			ret = m
+		}
+	}
+	if name == "" && len(conn.Modes) > 0 {
+		return &conn.Modes[0], nil
+	}
+	if ret == nil {
+		return nil, fmt.Errorf("connector %s has no mode %s", conn.Name(), name)
+	}
+	return ret, nil
+}
+
+// Framebuffer is the screen shown by an applied layout. The outputs go dark when
+// it is released, and when the card is closed.
+type Framebuffer struct {
+	card   *drm.Card
+	ID     uint32
+	handle uint32
+	Width  int
+	Height int
+}
+
+func newFramebuffer(card *drm.Card, width, height int) (*Framebuffer, error) {
+	dumb, err := card.ModeCreateDumb(uint32(height), uint32(width), 32)
+	if err != nil {
+		return nil, fmt.Errorf("create dumb: %w", err)
+	}
+	fb, err := card.ModeAddFramebuffer2(uint32(width), uint32(height), drm.FormatXRGB8888, 0,
+		[4]uint32{dumb.Handle}, [4]uint32{dumb.Pitch}, [4]uint32{}, [4]uint64{})
+	if err != nil {
+		card.ModeDestroyDumb(dumb.Handle)
+		return nil, fmt.Errorf("add framebuffer: %w", err)
+	}
+	return &Framebuffer{card: card, ID: fb.ID, handle: dumb.Handle, Width: width, Height: height}, nil
+}
+
+// Release removes the framebuffer, which disables the planes showing it.
+func (f *Framebuffer) Release() error {
+	var errs []error
+	if err := f.card.ModeRemoveFramebuffer(f.ID); err != nil {
+		errs = append(errs, err)
+	}
+	if err := f.card.ModeDestroyDumb(f.handle); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("release framebuffer %d: %w", f.ID, errs[0])
+	}
+	return nil
+}
+
+// Apply shows the layout in a single atomic commit, which is first tested. The
+// screen is a new, black framebuffer shown on the primary plane of each enabled
+// output. Every CRTC, connector and plane the layout does not use is disabled.
+//
+// If testOnly is set, the commit is only tested and nil is returned. The card
+// must have ClientCapAtomic and ClientCapUniversalPlanes set.
+func (l *Layout) Apply(card *drm.Card, testOnly bool) (*Framebuffer, error) {
+	var fb *Framebuffer
+	if l.Width > 0 && l.Height > 0 {
+		var err error
+		if fb, err = newFramebuffer(card, l.Width, l.Height); err != nil {
+			return nil, err
+		}
+	}
+	release := func() {
+		if fb != nil {
+			fb.Release()
+		}
+	}
+
+	req, blobs, err := l.request(card, fb)
+	defer func() {
+		for _, id := range blobs {
+			card.ModeDestroyPropBlob(id)
+		}
+	}()
+	if err != nil {
+		release()
+		return nil, err
+	}
+	if err := card.ModeAtomicCommit(req, drm.ModeAtomicTestOnly|drm.ModeAtomicAllowModeset, 0); err != nil {
+		release()
+		return nil, fmt.Errorf("test commit: %w", err)
+	}
+	if testOnly {
+		release()
+		return nil, nil
+	}
+	if err := card.ModeAtomicCommit(req, drm.ModeAtomicAllowModeset, 0); err != nil {
+		release()
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	return fb, nil
+}
+
+// request builds the atomic request applying the layout. It returns the blobs
+// it created, which can be destroyed once the request is committed.
+func (l *Layout) request(card *drm.Card, fb *Framebuffer) (*drm.AtomicRequest, []uint32, error) {
+	res, err := card.ModeGetResources()
+	if err != nil {
+		return nil, nil, err
+	}
+	planeRes, err := card.ModeGetPlaneResources()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		req     = &drm.AtomicRequest{}
+		blobs   []uint32
+		crtcs   = make(map[uint32]*Placement)
+		conns   = make(map[uint32]*Placement)
+		primary = make(map[uint32]bool)
+	)
+	for i := range l.Outputs {
+		if p := &l.Outputs[i]; p.Mode != nil {
+			crtcs[p.CRTCID] = p
+			conns[p.Display.Connector.ID] = p
+		}
+	}
+
+	for _, id := range res.CRTCIDs {
+		props, err := card.ObjectProperties(id, drm.ModeObjectCrtc)
+		if err != nil {
+			return nil, blobs, fmt.Errorf("crtc %d: %w", id, err)
+		}
+		obj := object{req: req, id: id, kind: "crtc", props: props}
+		p, ok := crtcs[id]
+		if !ok {
+			obj.set("ACTIVE", 0)
+			obj.set("MODE_ID", 0)
+			if err := obj.err; err != nil {
+				return nil, blobs, err
+			}
+			continue
+		}
+		data, err := p.Mode.MarshalBinary()
+		if err != nil {
+			return nil, blobs, err
+		}
+		blob, err := card.ModeCreatePropBlob(data)
+		if err != nil {
+			return nil, blobs, fmt.Errorf("mode blob: %w", err)
+		}
+		blobs = append(blobs, blob)
+		obj.set("ACTIVE", 1)
+		obj.set("MODE_ID", uint64(blob))
+		if p.Output.VRR != nil {
+			obj.setBool("VRR_ENABLED", *p.Output.VRR)
+		}
+		if err := obj.err; err != nil {
+			return nil, blobs, fmt.Errorf("output %s: %w", p.Output, err)
+		}
+	}
+
+	for _, id := range res.ConnectorIDs {
+		props, err := card.ObjectProperties(id, drm.ModeObjectConnector)
+		if err != nil {
+			return nil, blobs, fmt.Errorf("connector %d: %w", id, err)
+		}
+		obj := object{req: req, id: id, kind: "connector", props: props}
+		p, ok := conns[id]
+		if !ok {
+			obj.set("CRTC_ID", 0)
+			if err := obj.err; err != nil {
+				return nil, blobs, err
+			}
+			continue
+		}
+		o := p.Output
+		obj.set("CRTC_ID", uint64(p.CRTCID))
+		obj.setEnum("scaling mode", o.Scaling)
+		obj.setEnum("Colorspace", o.Colorspace)
+		obj.setEnum("Broadcast RGB", o.BroadcastRGB)
+		if o.MaxBPC != 0 {
+			obj.set("max bpc", o.MaxBPC)
+		}
+		if err := obj.err; err != nil {
+			return nil, blobs, fmt.Errorf("output %s: %w", o, err)
+		}
+	}
+
+	for _, id := range *planeRes {
+		plane, err := card.ModeGetPlane(id)
+		if err != nil {
+			return nil, blobs, fmt.Errorf("plane %d: %w", id, err)
+		}
+		props, err := card.ObjectProperties(id, drm.ModeObjectPlane)
+		if err != nil {
+			return nil, blobs, fmt.Errorf("plane %d: %w", id, err)
+		}
+		obj := object{req: req, id: id, kind: "plane", props: props}
+
+		var p *Placement
+		if typ, ok := props["type"]; ok && typ.Value == drm.PlaneTypePrimary {
+			for i, crtcID := range res.CRTCIDs {
+				if q, ok := crtcs[crtcID]; ok && !primary[crtcID] && plane.PossibleCRTCs&(1<<i) != 0 {
+					p = q
+					primary[crtcID] = true
+					break
+				}
+			}
+		}
+		if p == nil {
+			obj.set("FB_ID", 0)
+			obj.set("CRTC_ID", 0)
+			if err := obj.err; err != nil {
+				return nil, blobs, err
+			}
+			continue
+		}
+
+		// Source coordinates are in 16.16 fixed point.
+		obj.set("FB_ID", uint64(fb.ID))
+		obj.set("CRTC_ID", uint64(p.CRTCID))
+		obj.set("SRC_X", uint64(p.Rect.Min.X)<<16)
+		obj.set("SRC_Y", uint64(p.Rect.Min.Y)<<16)
+		obj.set("SRC_W", uint64(p.Rect.Dx())<<16)
+		obj.set("SRC_H", uint64(p.Rect.Dy())<<16)
+		obj.set("CRTC_X", 0)
+		obj.set("CRTC_Y", 0)
+		obj.set("CRTC_W", uint64(p.Mode.HDisplay))
+		obj.set("CRTC_H", uint64(p.Mode.VDisplay))
+		rotation, err := p.Output.rotation()
+		if err != nil {
+			return nil, blobs, fmt.Errorf("output %s: %w", p.Output, err)
+		}
+		// Planes without a rotation property are never rotated.
+		if _, ok := props["rotation"]; ok || rotation != drm.ModeRotate0 {
+			obj.set("rotation", rotation)
+		}
+		if err := obj.err; err != nil {
+			return nil, blobs, fmt.Errorf("output %s: %w", p.Output, err)
+		}
+	}
+
+	for id, p := range crtcs {
+		if !primary[id] {
+			return nil, blobs, fmt.Errorf("output %s: crtc %d has no primary plane", p.Output, id)
+		}
+	}
+	return req, blobs, nil
+}
+
+// object adds the properties of a mode object to an atomic request, by name.
+// The first error is kept in err.
+type object struct {
+	req   *drm.AtomicRequest
+	id    uint32
+	kind  string
+	props drm.Properties
+	err   error
+}
+
+func (o *object) set(name string, value uint64) {
+	if o.err != nil {
+		return
+	}
+	prop, ok := o.props[name]
+	if !ok {
+		o.err = fmt.Errorf("%s %d has no property %s", o.kind, o.id, name)
+		return
+	}
+	o.req.AddProperty(o.id, prop.PropID, value)
+}
+
+func (o *object) setBool(name string, value bool) {
+	if value {
+		o.set(name, 1)
+	} else {
+		o.set(name, 0)
+	}
+}
+
+// setEnum sets an enum property by the name of its value, unless the name is
+// empty.
+func (o *object) setEnum(name, value string) {
+	if o.err != nil || value == "" {
+		return
+	}
+	prop, ok := o.props[name]
+	if !ok {
+		o.err = fmt.Errorf("%s %d has no property %s", o.kind, o.id, name)
+		return
+	}
+	v, err := prop.EnumValue(value)
+	if err != nil {
+		o.err = err
+		return
+	}
+	o.req.AddProperty(o.id, prop.PropID, v)
+}
@@ -0,0 +1,259 @@
+// Package display applies declarative display configurations. A configuration
+// holds profiles, each describing the state of a set of displays: the mode,
+// position, rotation, scaling and color settings of every output, or that it
+// is disabled. The profile whose outputs match the connected displays is
+// applied in a single atomic commit, and can be re-applied whenever displays
+// are plugged or unplugged.
+//
+// Configurations are written in JSON or YAML:
+//
+//	profiles:
+//	- name: docked
+//	  outputs:
+//	  - match: eDP-1
+//	    enabled: false
+//	  - match: make=DEL,model=DELL U2720Q
+//	    mode: 3840x2160@60
+//	    scale: 1.5
+//	  - edid: 5c1e0a3b7f2d4e61
+//	    x: 5760
+//	    rotation: 90
+//	- name: mobile
+//	  outputs:
+//	  - match: eDP-1
+package display
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/inahga/inahgo/drm"
+	"github.com/inahga/inahgo/internal/yaml"
+)
+
+// Config is a list of profiles. The first profile matching the connected
+// displays is applied.
+type Config struct {
+	Profiles []Profile `json:"profiles"`
+}
+
+// Profile is the desired state of a set of displays.
+type Profile struct {
+	Name string `json:"name"`
+	// Outputs must each match a distinct connected connector, and every
+	// connected connector must be matched, for the profile to apply.
+	Outputs []Output `json:"outputs"`
+}
+
+// Output is the desired state of one display.
+type Output struct {
+	// Match is a drm.Selector, e.g. "HDMI-A-1" or "make=DEL,serial=ABC123".
+	Match string `json:"match,omitempty"`
+	// EDID is the Fingerprint of the display's EDID. If both Match and EDID are
+	// set, the display must match both.
+	EDID string `json:"edid,omitempty"`
+	// Enabled defaults to true.
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// Mode is WIDTHxHEIGHT[@REFRESH]. It defaults to the preferred mode.
+	Mode string `json:"mode,omitempty"`
+	// X and Y are the position of the output's top left corner on the screen,
+	// which is shared by all outputs.
+	X int `json:"x,omitempty"`
+	Y int `json:"y,omitempty"`
+	// Rotation is 0, 90, 180 or 270 degrees, counter-clockwise.
+	Rotation int `json:"rotation,omitempty"`
+	// Reflect is "x", "y" or "xy", and is applied before rotating.
+	Reflect string `json:"reflect,omitempty"`
+	// Scale is the size of the area of the screen shown by the output, relative
+	// to the mode: at 2, a 1920x1080 mode shows 3840x2160 pixels. It defaults
+	// to 1.
+	Scale float64 `json:"scale,omitempty"`
+	// Scaling is the "scaling mode" of the connector, one of the
+	// drm.ScalingMode* values.
+	Scaling string `json:"scaling,omitempty"`
+
+	// Colorspace is one of the drm.Colorspace* values.
+	Colorspace string `json:"colorspace,omitempty"`
+	// BroadcastRGB is one of the drm.BroadcastRGB* values.
+	BroadcastRGB string `json:"broadcast_rgb,omitempty"`
+	// MaxBPC limits the bits per color channel sent to the display.
+	MaxBPC uint64 `json:"max_bpc,omitempty"`
+	// VRR enables or disables variable refresh rate. It is left alone if unset.
+	VRR *bool `json:"vrr,omitempty"`
+
+	selector *drm.Selector
+}
+
+// IsEnabled returns whether the output is enabled.
+func (o *Output) IsEnabled() bool {
+	return o.Enabled == nil || *o.Enabled
+}
+
+// String returns how the output is matched.
+func (o *Output) String() string {
+	switch {
+	case o.Match != "" && o.EDID != "":
+		return fmt.Sprintf("%s (edid %s)", o.Match, o.EDID)
+	case o.EDID != "":
+		return "edid " + o.EDID
+	}
+	return o.Match
+}
+
+// ParseConfig parses a configuration written in JSON or YAML, and checks that
+// it is valid.
+func ParseConfig(data []byte) (*Config, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) == 0 || trimmed[0] != '{' {
+		var err error
+		if data, err = yaml.ToJSON(data); err != nil {
+			return nil, err
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var cfg Config
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// ReadConfig reads and parses a configuration file.
+func ReadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ParseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate checks that every profile and output is valid, and parses the
+// selectors of the outputs.
+func (c *Config) Validate() error {
+	names := make(map[string]bool)
+	for i := range c.Profiles {
+		p := &c.Profiles[i]
+		if p.Name == "" {
+			return fmt.Errorf("profile %d has no name", i+1)
+		}
+		if names[p.Name] {
+			return fmt.Errorf("duplicate profile %s", p.Name)
+		}
+		names[p.Name] = true
+		if err := p.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks that every output of the profile is valid, and parses their
+// selectors.
+func (p *Profile) Validate() error {
+	if len(p.Outputs) == 0 {
+		return fmt.Errorf("profile %s has no outputs", p.Name)
+	}
+	for i := range p.Outputs {
+		if err := p.Outputs[i].validate(); err != nil {
+			return fmt.Errorf("profile %s: output %d: %w", p.Name, i+1, err)
+		}
+	}
+	return nil
+}
+
+func (o *Output) validate() error {
+	if o.Match == "" && o.EDID == "" {
+		return fmt.Errorf("no match or edid")
+	}
+	if o.Match != "" {
+		sel, err := drm.ParseSelector(o.Match)
+		if err != nil {
+			return err
+		}
+		o.selector = sel
+	}
+	if o.Mode != "" {
+		if _, _, _, err := parseMode(o.Mode); err != nil {
+			return err
+		}
+	}
+	if _, err := o.rotation(); err != nil {
+		return err
+	}
+	if o.Scale < 0 {
+		return fmt.Errorf("invalid scale %g", o.Scale)
+	}
+	return nil
+}
+
+// rotation returns the value of the "rotation" plane property for the output.
+func (o *Output) rotation() (uint64, error) {
+	var ret uint64
+	switch o.Rotation {
+	case 0:
+		ret = drm.ModeRotate0
+	case 90:
+		ret = drm.ModeRotate90
+	case 180:
+		ret = drm.ModeRotate180
+	case 270:
+		ret = drm.ModeRotate270
+	default:
+		return 0, fmt.Errorf("invalid rotation %d, expected 0, 90, 180 or 270", o.Rotation)
+	}
+	switch o.Reflect {
+	case "":
+	case "x":
+		ret |= drm.ModeReflectX
+	case "y":
+		ret |= drm.ModeReflectY
+	case "xy":
+		ret |= drm.ModeReflectX | drm.ModeReflectY
+	default:
+		return 0, fmt.Errorf("invalid reflect %q, expected x, y or xy", o.Reflect)
+	}
+	return ret, nil
+}
+
+// parseMode parses a mode given as WIDTHxHEIGHT[@REFRESH]. Refresh is 0 if not
+// given.
+func parseMode(s string) (width, height, refresh int, err error) {
+	size := s
+	if i := strings.IndexByte(s, '@'); i >= 0 {
+		if refresh, err = strconv.Atoi(s[i+1:]); err != nil || refresh <= 0 {
+			return 0, 0, 0, fmt.Errorf("invalid refresh rate in mode %q", s)
+		}
+		size = s[:i]
+	}
+	i := strings.IndexByte(size, 'x')
+	if i < 0 {
+		return 0, 0, 0, fmt.Errorf("invalid mode %q, expected WIDTHxHEIGHT[@REFRESH]", s)
+	}
+	width, err1 := strconv.Atoi(size[:i])
+	height, err2 := strconv.Atoi(size[i+1:])
+	if err1 != nil || err2 != nil || width <= 0 || height <= 0 {
+		return 0, 0, 0, fmt.Errorf("invalid mode %q, expected WIDTHxHEIGHT[@REFRESH]", s)
+	}
+	return width, height, refresh, nil
+}
+
+// Fingerprint identifies a display by its EDID. It is the first 8 bytes of the
+// SHA-256 hash of the EDID, in hexadecimal.
+func Fingerprint(edid []byte) string {
+	sum := sha256.Sum256(edid)
+	return hex.EncodeToString(sum[:8])
+}
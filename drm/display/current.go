@@ -0,0 +1,147 @@
+package display
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/inahga/inahgo/drm"
+)
+
+// Current returns a profile describing the current state of the connected
+// displays, which can be saved to a configuration. Outputs match both the
+// connector name and the EDID fingerprint, so the profile applies only when the
+// same displays are plugged into the same connectors. Settings the card does not
+// have properties for are left out.
+func Current(card *drm.Card, name string) (*Profile, error) {
+	displays, err := Displays(card)
+	if err != nil {
+		return nil, err
+	}
+	planes, err := primaryPlanes(card)
+	if err != nil {
+		return nil, err
+	}
+
+	p := Profile{Name: name}
+	for i := range displays {
+		o, err := currentOutput(card, &displays[i], planes)
+		if err != nil {
+			return nil, fmt.Errorf("connector %s: %w", displays[i].Connector.Name(), err)
+		}
+		p.Outputs = append(p.Outputs, *o)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// primaryPlanes returns the properties of the primary planes in use, by the ID
+// of their CRTC. It is empty unless the card has ClientCapUniversalPlanes set.
+func primaryPlanes(card *drm.Card) (map[uint32]drm.Properties, error) {
+	planeRes, err := card.ModeGetPlaneResources()
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[uint32]drm.Properties)
+	for _, id := range *planeRes {
+		plane, err := card.ModeGetPlane(id)
+		if err != nil {
+			return nil, fmt.Errorf("plane %d: %w", id, err)
+		}
+		if plane.CRTCID == 0 {
+			continue
+		}
+		props, err := card.ObjectProperties(id, drm.ModeObjectPlane)
+		if err != nil {
+			return nil, fmt.Errorf("plane %d: %w", id, err)
+		}
+		if typ, ok := props["type"]; ok && typ.Value == drm.PlaneTypePrimary {
+			ret[plane.CRTCID] = props
+		}
+	}
+	return ret, nil
+}
+
+func currentOutput(card *drm.Card, d *Display, planes map[uint32]drm.Properties) (*Output, error) {
+	conn := d.Connector
+	o := Output{Match: conn.Name(), EDID: d.Fingerprint}
+
+	var crtc *drm.ModeCRTC
+	if conn.EncoderID != 0 {
+		enc, err := card.ModeGetEncoder(conn.EncoderID)
+		if err != nil {
+			return nil, fmt.Errorf("encoder %d: %w", conn.EncoderID, err)
+		}
+		if enc.CRTCID != 0 {
+			if crtc, err = card.ModeGetCRTC(enc.CRTCID); err != nil {
+				return nil, fmt.Errorf("crtc %d: %w", enc.CRTCID, err)
+			}
+		}
+	}
+	if crtc == nil || crtc.ModeValid == 0 {
+		disabled := false
+		o.Enabled = &disabled
+		return &o, nil
+	}
+	o.Mode = fmt.Sprintf("%dx%d@%d", crtc.HDisplay, crtc.VDisplay, crtc.VRefresh)
+	o.X, o.Y = int(crtc.X), int(crtc.Y)
+
+	if props, ok := planes[crtc.ID]; ok {
+		if rotation, ok := props["rotation"]; ok {
+			switch rotation.Value & drm.ModeRotateMask {
+			case drm.ModeRotate90:
+				o.Rotation = 90
+			case drm.ModeRotate180:
+				o.Rotation = 180
+			case drm.ModeRotate270:
+				o.Rotation = 270
+			}
+			switch rotation.Value & drm.ModeReflectMask {
+			case drm.ModeReflectX:
+				o.Reflect = "x"
+			case drm.ModeReflectY:
+				o.Reflect = "y"
+			case drm.ModeReflectMask:
+				o.Reflect = "xy"
+			}
+		}
+		// The scale is that of the source rectangle, in 16.16 fixed point, to the
+		// mode.
+		if srcW, ok := props["SRC_W"]; ok && crtc.HDisplay != 0 {
+			width := float64(crtc.HDisplay)
+			if o.Rotation == 90 || o.Rotation == 270 {
+				width = float64(crtc.VDisplay)
+			}
+			if scale := math.Round(float64(srcW.Value)/65536/width*1000) / 1000; scale != 1 && scale != 0 {
+				o.Scale = scale
+			}
+		}
+	}
+
+	props, err := card.ObjectProperties(conn.ID, drm.ModeObjectConnector)
+	if err != nil {
+		return nil, err
+	}
+	for name, field := range map[string]*string{
+		"scaling mode":  &o.Scaling,
+		"Colorspace":    &o.Colorspace,
+		"Broadcast RGB": &o.BroadcastRGB,
+	} {
+		if prop, ok := props[name]; ok {
+			*field, _ = prop.EnumName()
+		}
+	}
+	if prop, ok := props["max bpc"]; ok {
+		o.MaxBPC = prop.Value
+	}
+	crtcProps, err := card.ObjectProperties(crtc.ID, drm.ModeObjectCrtc)
+	if err != nil {
+		return nil, fmt.Errorf("crtc %d: %w", crtc.ID, err)
+	}
+	if prop, ok := crtcProps["VRR_ENABLED"]; ok {
+		enabled := prop.Value != 0
+		o.VRR = &enabled
+	}
+	return &o, nil
+}
@@ -0,0 +1,327 @@
+package display
+
+import (
+	"image"
+	"strings"
+	"testing"
+
+	"github.com/inahga/inahgo/drm"
+)
+
+// laptop is a fake laptop with an eDP panel and an HDMI port, each with its own
+// encoder, either of which can drive both CRTCs.
+type laptop struct {
+	dev         *drm.FakeDevice
+	card        *drm.Card
+	crtcs       [2]uint32
+	edp, hdmi   uint32
+	monitorEDID []byte
+}
+
+func newLaptop(t *testing.T) *laptop {
+	t.Helper()
+	dev := drm.NewFakeDevice()
+	s := laptop{dev: dev, card: drm.NewWithBackend(dev), monitorEDID: []byte("fake monitor edid")}
+	s.crtcs[0], s.crtcs[1] = dev.AddCRTC(), dev.AddCRTC()
+	_, s.edp = dev.AddOutput(drm.ModeConnectorEDP, s.crtcs[:])
+	_, s.hdmi = dev.AddOutput(drm.ModeConnectorHDMIA, s.crtcs[:])
+	dev.Plug(s.edp, []drm.ModeInfo{drm.FakeMode(1920, 1200, 60)}, 300, 190, nil)
+	for _, c := range []uint64{drm.ClientCapAtomic, drm.ClientCapUniversalPlanes} {
+		if err := s.card.SetClientCap(c, 1); err != nil {
+			t.Fatalf("set client cap: %s", err)
+		}
+	}
+	return &s
+}
+
+func (s *laptop) plugMonitor() {
+	s.dev.Plug(s.hdmi, []drm.ModeInfo{drm.FakeMode(2560, 1440, 60), drm.FakeMode(1920, 1080, 75),
+		drm.FakeMode(1920, 1080, 60)}, 600, 340, s.monitorEDID)
+}
+
+// crtc returns the mode and framebuffer of the CRTC driving a connector, or nil
+// if it is disabled.
+func (s *laptop) crtc(t *testing.T, connectorID uint32) *drm.ModeCRTC {
+	t.Helper()
+	crtcID, ok := s.dev.Property(connectorID, "CRTC_ID")
+	if !ok {
+		t.Fatalf("connector %d has no CRTC_ID", connectorID)
+	}
+	if crtcID == 0 {
+		return nil
+	}
+	crtc, err := s.card.ModeGetCRTC(uint32(crtcID))
+	if err != nil {
+		t.Fatalf("get crtc: %s", err)
+	}
+	if active, _ := s.dev.Property(crtc.ID, "ACTIVE"); active == 0 {
+		t.Fatalf("crtc %d of connector %d is inactive", crtc.ID, connectorID)
+	}
+	return crtc
+}
+
+const testConfig = `
+# Docked at the desk, with the lid closed.
+profiles:
+- name: docked
+  outputs:
+  - match: eDP-1
+    enabled: false
+  - edid: %s
+    mode: 1920x1080
+    x: 0
+- name: mobile
+  outputs:
+  - match: eDP-1
+`
+
+func (s *laptop) config(t *testing.T) *Config {
+	t.Helper()
+	cfg, err := ParseConfig([]byte(strings.Replace(testConfig, "%s", Fingerprint(s.monitorEDID), 1)))
+	if err != nil {
+		t.Fatalf("parse config: %s", err)
+	}
+	return cfg
+}
+
+func TestParseConfig(t *testing.T) {
+	yamlConfig := `
+profiles:
+- name: desk
+  outputs:
+  - match: HDMI-A-1,make=DEL
+    mode: 2560x1440@60
+    x: 1920
+    rotation: 90
+    reflect: x
+    scale: 1.5
+    colorspace: BT2020_RGB
+    broadcast_rgb: Full
+    max_bpc: 10
+    vrr: true
+`
+	jsonConfig := `{"profiles": [{"name": "desk", "outputs": [{"match": "HDMI-A-1,make=DEL",
+		"mode": "2560x1440@60", "x": 1920, "rotation": 90, "reflect": "x", "scale": 1.5,
+		"colorspace": "BT2020_RGB", "broadcast_rgb": "Full", "max_bpc": 10, "vrr": true}]}]}`
+	for _, data := range []string{yamlConfig, jsonConfig} {
+		cfg, err := ParseConfig([]byte(data))
+		if err != nil {
+			t.Fatalf("parse %s: %s", data, err)
+		}
+		o := cfg.Profiles[0].Outputs[0]
+		if o.Match != "HDMI-A-1,make=DEL" || o.Mode != "2560x1440@60" || o.X != 1920 || o.Rotation != 90 ||
+			o.Reflect != "x" || o.Scale != 1.5 || o.Colorspace != drm.ColorspaceBT2020RGB ||
+			o.BroadcastRGB != drm.BroadcastRGBFull || o.MaxBPC != 10 || o.VRR == nil || !*o.VRR || !o.IsEnabled() {
+			t.Errorf("parse %s: got %+v", data, o)
+		}
+	}
+
+	for _, bad := range []string{
+		"profiles:\n- name: a\n  outputs:\n  - mode: 1920x1080\n",
+		"profiles:\n- name: a\n  outputs:\n  - match: HDMI-A-1\n    rotation: 45\n",
+		"profiles:\n- name: a\n  outputs:\n  - match: HDMI-A-1\n    mode: 1920\n",
+		"profiles:\n- name: a\n  outputs:\n  - match: HDMI-A-1\n    colour: red\n",
+		"profiles:\n- name: a\n  outputs:\n  - match: color=red\n",
+		"profiles:\n- name: a\n  outputs: []\n",
+		"profiles:\n- name: a\n  outputs:\n  - match: DP-1\n- name: a\n  outputs:\n  - match: DP-2\n",
+	} {
+		if _, err := ParseConfig([]byte(bad)); err == nil {
+			t.Errorf("parse %q: no error", bad)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	s := newLaptop(t)
+	cfg := s.config(t)
+
+	for _, test := range []struct {
+		plugged bool
+		profile string
+	}{
+		{false, "mobile"},
+		{true, "docked"},
+	} {
+		if test.plugged {
+			s.plugMonitor()
+		}
+		displays, err := Displays(s.card)
+		if err != nil {
+			t.Fatalf("displays: %s", err)
+		}
+		p, matched, err := cfg.Match(displays)
+		if err != nil {
+			t.Fatalf("match: %s", err)
+		}
+		if p.Name != test.profile {
+			t.Errorf("matched profile %s, want %s", p.Name, test.profile)
+		}
+		for i, d := range matched {
+			if ok, _ := p.Outputs[i].matches(d); !ok {
+				t.Errorf("output %s matched to connector %s", &p.Outputs[i], d.Connector.Name())
+			}
+		}
+	}
+
+	// A different monitor on the same port matches no profile.
+	s.dev.Plug(s.hdmi, []drm.ModeInfo{drm.FakeMode(1920, 1080, 60)}, 600, 340, []byte("other edid"))
+	displays, err := Displays(s.card)
+	if err != nil {
+		t.Fatalf("displays: %s", err)
+	}
+	if _, _, err := cfg.Match(displays); err != ErrNoProfile {
+		t.Errorf("match of unknown monitor: got %v, want ErrNoProfile", err)
+	}
+}
+
+func TestWatcherApply(t *testing.T) {
+	s := newLaptop(t)
+	s.plugMonitor()
+	w := NewWatcher(s.card, s.config(t))
+	defer w.Close()
+
+	l, err := w.Apply()
+	if err != nil {
+		t.Fatalf("apply: %s", err)
+	}
+	if l.Profile.Name != "docked" || l.Width != 1920 || l.Height != 1080 {
+		t.Fatalf("applied profile %s with screen %dx%d", l.Profile.Name, l.Width, l.Height)
+	}
+	if crtc := s.crtc(t, s.edp); crtc != nil {
+		t.Errorf("eDP is driven by crtc %d, want disabled", crtc.ID)
+	}
+	crtc := s.crtc(t, s.hdmi)
+	if crtc == nil || crtc.HDisplay != 1920 || crtc.VDisplay != 1080 || crtc.VRefresh != 75 {
+		t.Fatalf("HDMI crtc: got %+v, want 1920x1080@75", crtc)
+	}
+	docked := crtc.FBID
+
+	if l, err := w.Apply(); l != nil || err != nil {
+		t.Errorf("apply without changes: got %v, %v", l, err)
+	}
+
+	s.dev.Unplug(s.hdmi)
+	if l, err = w.Apply(); err != nil {
+		t.Fatalf("apply after unplug: %s", err)
+	}
+	if l.Profile.Name != "mobile" {
+		t.Errorf("applied profile %s after unplug, want mobile", l.Profile.Name)
+	}
+	if crtc := s.crtc(t, s.edp); crtc == nil || crtc.HDisplay != 1920 || crtc.VDisplay != 1200 {
+		t.Errorf("eDP crtc: got %+v, want 1920x1200", crtc)
+	}
+	if _, err := s.card.ModeGetFramebuffer(docked); err == nil {
+		t.Errorf("framebuffer %d of the previous layout was not released", docked)
+	}
+}
+
+func TestApplyLayout(t *testing.T) {
+	s := newLaptop(t)
+	s.plugMonitor()
+	planes, err := s.card.ModeGetPlaneResources()
+	if err != nil {
+		t.Fatalf("plane resources: %s", err)
+	}
+	rotation := drm.ModeProperty{Name: "rotation", Flags: drm.ModePropBitmask}
+	for i, name := range []string{"rotate-0", "rotate-90", "rotate-180", "rotate-270", "reflect-x", "reflect-y"} {
+		rotation.Enums = append(rotation.Enums, drm.ModePropertyEnum{Value: uint64(i), Name: name})
+	}
+	for _, id := range *planes {
+		s.dev.AddProperty(id, rotation, drm.ModeRotate0)
+	}
+
+	p := Profile{Name: "side by side", Outputs: []Output{
+		{Match: "eDP-1"},
+		{Match: "HDMI-1", X: 1920, Rotation: 90, Scale: 0.5},
+	}}
+	if err := p.Validate(); err != nil {
+		t.Fatalf("validate: %s", err)
+	}
+	displays, err := Displays(s.card)
+	if err != nil {
+		t.Fatalf("displays: %s", err)
+	}
+	matched, err := p.Match(displays)
+	if err != nil || matched == nil {
+		t.Fatalf("match: %v, %v", matched, err)
+	}
+	l, err := Plan(s.card, &p, matched)
+	if err != nil {
+		t.Fatalf("plan: %s", err)
+	}
+	// The rotated monitor shows 720x1280 pixels to the right of the panel.
+	if l.Width != 1920+720 || l.Height != 1280 {
+		t.Errorf("screen of %dx%d, want %dx%d", l.Width, l.Height, 1920+720, 1280)
+	}
+	if got, want := l.Outputs[1].Rect, image.Rect(1920, 0, 1920+720, 1280); got != want {
+		t.Errorf("monitor shows %v, want %v", got, want)
+	}
+	if l.Outputs[0].CRTCID == l.Outputs[1].CRTCID {
+		t.Errorf("both outputs use crtc %d", l.Outputs[0].CRTCID)
+	}
+
+	if fb, err := l.Apply(s.card, true); fb != nil || err != nil {
+		t.Fatalf("test apply: got %v, %v", fb, err)
+	}
+	if crtc := s.crtc(t, s.hdmi); crtc != nil {
+		t.Fatalf("test apply changed the state")
+	}
+	fb, err := l.Apply(s.card, false)
+	if err != nil {
+		t.Fatalf("apply: %s", err)
+	}
+	defer fb.Release()
+
+	var plane uint32
+	for _, id := range *planes {
+		if crtc, _ := s.dev.Property(id, "CRTC_ID"); uint32(crtc) == l.Outputs[1].CRTCID {
+			plane = id
+		}
+	}
+	for name, want := range map[string]uint64{
+		"FB_ID":    uint64(fb.ID),
+		"SRC_X":    1920 << 16,
+		"SRC_W":    720 << 16,
+		"SRC_H":    1280 << 16,
+		"CRTC_W":   2560,
+		"CRTC_H":   1440,
+		"rotation": drm.ModeRotate90,
+	} {
+		if got, _ := s.dev.Property(plane, name); got != want {
+			t.Errorf("plane %d %s: got %d, want %d", plane, name, got, want)
+		}
+	}
+
+	current, err := Current(s.card, "current")
+	if err != nil {
+		t.Fatalf("current: %s", err)
+	}
+	if len(current.Outputs) != 2 {
+		t.Fatalf("current has %d outputs, want 2", len(current.Outputs))
+	}
+	o := current.Outputs[1]
+	if o.Match != "HDMI-A-1" || o.EDID != Fingerprint(s.monitorEDID) || o.Mode != "2560x1440@60" ||
+		o.X != 1920 || o.Rotation != 90 || o.Scale != 0.5 || !o.IsEnabled() {
+		t.Errorf("current monitor output: got %+v", o)
+	}
+}
+
+func TestApplyMissingProperty(t *testing.T) {
+	s := newLaptop(t)
+	p := Profile{Name: "hdr", Outputs: []Output{{Match: "eDP-1", Colorspace: drm.ColorspaceBT2020RGB}}}
+	displays, err := Displays(s.card)
+	if err != nil {
+		t.Fatalf("displays: %s", err)
+	}
+	matched, err := p.Match(displays)
+	if err != nil || matched == nil {
+		t.Fatalf("match: %v, %v", matched, err)
+	}
+	l, err := Plan(s.card, &p, matched)
+	if err != nil {
+		t.Fatalf("plan: %s", err)
+	}
+	if _, err := l.Apply(s.card, false); err == nil || !strings.Contains(err.Error(), "Colorspace") {
+		t.Errorf("apply with missing Colorspace property: got %v", err)
+	}
+}
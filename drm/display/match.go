@@ -0,0 +1,144 @@
+package display
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/inahga/inahgo/drm"
+	"github.com/inahga/inahgo/drm/edid"
+)
+
+// ErrNoProfile is returned when no profile matches the connected displays.
+var ErrNoProfile = errors.New("no profile matches the connected displays")
+
+// Display is a connected connector, along with the EDID of its display.
+type Display struct {
+	Connector *drm.ModeConnector
+	// EDID is the raw EDID, or nil if the display has none.
+	EDID []byte
+	// Info is the decoded EDID, or nil if the EDID is missing or invalid.
+	Info *edid.EDID
+	// Fingerprint is the Fingerprint of the EDID, or empty if there is none.
+	Fingerprint string
+}
+
+// Displays returns the connected displays of a card, in the order of the card's
+// resources.
+func Displays(card *drm.Card) ([]Display, error) {
+	res, err := card.ModeGetResources()
+	if err != nil {
+		return nil, err
+	}
+	var ret []Display
+	for _, id := range res.ConnectorIDs {
+		conn, err := card.ModeGetConnector(id)
+		if err != nil {
+			return nil, err
+		}
+		if conn.Connection != drm.ModeConnected || len(conn.Modes) == 0 {
+			continue
+		}
+		data, err := card.ConnectorEDID(id)
+		if err != nil {
+			return nil, err
+		}
+		d := Display{Connector: conn, EDID: data}
+		if data != nil {
+			d.Fingerprint = Fingerprint(data)
+			// Displays with a broken EDID can still be matched by name.
+			d.Info, _ = edid.Parse(data)
+		}
+		ret = append(ret, d)
+	}
+	return ret, nil
+}
+
+// displaysKey identifies a set of displays, so that hotplug events that leave it
+// unchanged can be ignored.
+func displaysKey(displays []Display) string {
+	var keys []string
+	for _, d := range displays {
+		keys = append(keys, d.Connector.Name()+"="+d.Fingerprint)
+	}
+	return strings.Join(keys, ",")
+}
+
+// matches returns whether the output matches a display.
+func (o *Output) matches(d *Display) (bool, error) {
+	if o.EDID != "" && !strings.EqualFold(o.EDID, d.Fingerprint) {
+		return false, nil
+	}
+	if o.Match == "" {
+		return true, nil
+	}
+	if o.selector == nil {
+		sel, err := drm.ParseSelector(o.Match)
+		if err != nil {
+			return false, err
+		}
+		o.selector = sel
+	}
+	return o.selector.Match(d.Connector, d.Info), nil
+}
+
+// Match returns the display matched by each output of the profile, in the order
+// of the outputs, or nil if the profile does not match the displays. A profile
+// matches if each output matches a distinct display and every display is
+// matched.
+func (p *Profile) Match(displays []Display) ([]*Display, error) {
+	if len(p.Outputs) != len(displays) {
+		return nil, nil
+	}
+	candidates := make([][]int, len(p.Outputs))
+	for i := range p.Outputs {
+		for j := range displays {
+			ok, err := p.Outputs[i].matches(&displays[j])
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				candidates[i] = append(candidates[i], j)
+			}
+		}
+	}
+
+	ret := make([]*Display, len(p.Outputs))
+	used := make([]bool, len(displays))
+	var assign func(i int) bool
+	assign = func(i int) bool {
+		if i == len(p.Outputs) {
+			return true
+		}
+		for _, j := range candidates[i] {
+			if used[j] {
+				continue
+			}
+			used[j], ret[i] = true, &displays[j]
+			if assign(i + 1) {
+				return true
+			}
+			used[j] = false
+		}
+		return false
+	}
+	if !assign(0) {
+		return nil, nil
+	}
+	return ret, nil
+}
+
+// Match returns the first profile matching the displays, along with the display
+// matched by each of its outputs. It returns ErrNoProfile if no profile
+// matches.
+func (c *Config) Match(displays []Display) (*Profile, []*Display, error) {
+	for i := range c.Profiles {
+		matched, err := c.Profiles[i].Match(displays)
+		if err != nil {
+			return nil, nil, err
+		}
+		if matched != nil {
+			return &c.Profiles[i], matched, nil
+		}
+	}
+	return nil, nil, ErrNoProfile
+}
@@ -0,0 +1,147 @@
+package display
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/inahga/inahgo/drm"
+)
+
+// Debounce is how long Watcher.Run waits for hotplug events to settle before
+// re-applying the configuration. Plugging a display usually sends several
+// events in quick succession.
+const Debounce = 500 * time.Millisecond
+
+// Watcher applies the profile of a configuration that matches the connected
+// displays, and keeps the framebuffer of the applied layout. The card must have
+// ClientCapAtomic and ClientCapUniversalPlanes set.
+type Watcher struct {
+	card *drm.Card
+	cfg  *Config
+	fb   *Framebuffer
+	// key identifies the displays the current layout was applied for, if
+	// applied is set.
+	key     string
+	applied bool
+}
+
+// NewWatcher returns a Watcher that has not applied anything.
+func NewWatcher(card *drm.Card, cfg *Config) *Watcher {
+	return &Watcher{card: card, cfg: cfg}
+}
+
+// Apply applies the profile matching the connected displays, unless it has
+// already been applied for the same displays, in which case it returns nil. The
+// framebuffer of the previous layout is released once the new one is shown.
+func (w *Watcher) Apply() (*Layout, error) {
+	displays, err := Displays(w.card)
+	if err != nil {
+		return nil, err
+	}
+	key := displaysKey(displays)
+	if w.applied && key == w.key {
+		return nil, nil
+	}
+	p, matched, err := w.cfg.Match(displays)
+	if err != nil {
+		return nil, err
+	}
+	l, err := Plan(w.card, p, matched)
+	if err != nil {
+		return nil, fmt.Errorf("profile %s: %w", p.Name, err)
+	}
+	fb, err := l.Apply(w.card, false)
+	if err != nil {
+		return nil, fmt.Errorf("profile %s: %w", p.Name, err)
+	}
+	if w.fb != nil {
+		w.fb.Release()
+	}
+	w.fb, w.key, w.applied = fb, key, true
+	return l, nil
+}
+
+// Run calls Apply, then calls it again after hotplug events of the card until
+// the context is canceled. Report, if not nil, is called with the result of
+// every Apply that applied a layout or failed.
+func (w *Watcher) Run(ctx context.Context, report func(*Layout, error)) error {
+	major, minor, err := w.card.Device()
+	if err != nil {
+		return err
+	}
+	conn, err := drm.ListenUevents()
+	if err != nil {
+		return err
+	}
+	// Closing conn on return ends the reading goroutine below.
+	defer conn.Close()
+
+	hotplug := make(chan struct{}, 1)
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			event, err := conn.Read()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			if !event.IsHotplug() {
+				continue
+			}
+			if maj, min, ok := event.Device(); !ok || maj != major || min != minor {
+				continue
+			}
+			select {
+			case hotplug <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	apply := func() {
+		l, err := w.Apply()
+		if (l != nil || err != nil) && report != nil {
+			report(l, err)
+		}
+	}
+	apply()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-readErr:
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		case <-hotplug:
+		}
+
+		timer := time.NewTimer(Debounce)
+	settle:
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-hotplug:
+				timer.Reset(Debounce)
+			case <-timer.C:
+				break settle
+			}
+		}
+		apply()
+	}
+}
+
+// Close releases the framebuffer of the applied layout, which disables the
+// outputs showing it.
+func (w *Watcher) Close() error {
+	var err error
+	if w.fb != nil {
+		err = w.fb.Release()
+	}
+	w.fb, w.key, w.applied = nil, "", false
+	return err
+}
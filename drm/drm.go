@@ -7,29 +7,61 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"sync"
 	"unsafe"
 )
 
 type Card struct {
-	fd *os.File
+	// fd is the device file of the card, or nil if it uses another backend.
+	fd      *os.File
+	backend Backend
+	node    NodeType
+
+	mu sync.Mutex
+	// transaction is the pending transaction, reverted when the card is closed
+	// or drops master.
+	transaction *Transaction
 }
 
 func New(fd *os.File) *Card {
-	return &Card{fd: fd}
+	return &Card{fd: fd, backend: fileBackend{fd}, node: nodeType(fd)}
 }
 
-func Open(path string) (*Card, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
+// Close reverts the pending transaction, if any, and closes the card.
+func (c *Card) Close() error {
+	var errs []error
+	if err := c.revertTransaction(); err != nil {
+		errs = append(errs, err)
 	}
-	return &Card{
-		fd: f,
-	}, nil
+	if err := c.backend.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	return joinErrors(errs)
 }
 
-func (c *Card) Close() error {
-	return c.fd.Close()
+// revertTransaction reverts the pending transaction, if any.
+func (c *Card) revertTransaction() error {
+	c.mu.Lock()
+	t := c.transaction
+	c.mu.Unlock()
+	if t == nil {
+		return nil
+	}
+	if err := t.Revert(); err != nil && err != ErrTransactionDone {
+		return err
+	}
+	return nil
+}
+
+// joinErrors returns the first of errs, noting how many more there are.
+func joinErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return fmt.Errorf("%w (and %d more errors)", errs[0], len(errs)-1)
 }
 
 func cToGoString(b []byte) string {
@@ -38,8 +70,8 @@ func cToGoString(b []byte) string {
 
 func (c *Card) Version() (*Version, error) {
 	var ver cVersion
-	if err := ioctl(c.fd, ioctlVersion, uintptr(unsafe.Pointer(&ver))); err != nil {
-		return nil, fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlVersion, 0, unsafe.Pointer(&ver)); err != nil {
+		return nil, err
 	}
 
 	var name, date, desc []byte
@@ -56,34 +88,49 @@ func (c *Card) Version() (*Version, error) {
 		ver.desc = uint64(uintptr(unsafe.Pointer(&desc[0])))
 	}
 
-	if err := ioctl(c.fd, ioctlVersion, uintptr(unsafe.Pointer(&ver))); err != nil {
-		return nil, fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlVersion, 0, unsafe.Pointer(&ver)); err != nil {
+		return nil, err
 	}
 	return &Version{
 		Major:      ver.major,
 		Minor:      ver.minor,
 		PatchLevel: ver.patchlevel,
-		Name:       cToGoString(name[:len(name)-1]),
-		Date:       cToGoString(date[:len(date)-1]),
-		Desc:       cToGoString(desc[:len(desc)-1]),
+		Name:       versionString(name),
+		Date:       versionString(date),
+		Desc:       versionString(desc),
 	}, nil
 }
 
+// versionString returns a string of Version without the terminator the buffer
+// was allocated with. Buffers of empty strings are nil.
+func versionString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return cToGoString(b[:len(b)-1])
+}
+
 func (c *Card) SetClientCap(cap uint64, val uint64) error {
 	setcap := cSetClientCap{
 		capability: cap,
 		value:      val,
 	}
-	if err := ioctl(c.fd, ioctlSetClientCap, uintptr(unsafe.Pointer(&setcap))); err != nil {
-		return fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlSetClientCap, 0, unsafe.Pointer(&setcap)); err != nil {
+		return err
 	}
 	return nil
 }
 
 func (c *Card) SetMaster() error {
-	return ioctl(c.fd, ioctlSetMaster, 0)
+	return c.ioctl(ioctlSetMaster, 0, nil)
 }
 
+// DropMaster reverts the pending transaction, if any, and drops master. Master
+// is dropped even if reverting fails.
 func (c *Card) DropMaster() error {
-	return ioctl(c.fd, ioctlDropMaster, 0)
+	revertErr := c.revertTransaction()
+	if err := c.ioctl(ioctlDropMaster, 0, nil); err != nil {
+		return err
+	}
+	return revertErr
 }
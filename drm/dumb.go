@@ -0,0 +1,162 @@
+package drm
+
+import (
+	"errors"
+	"fmt"
+	"image"
+)
+
+// DumbFramebuffer is a framebuffer backed by a dumb buffer mapped into memory,
+// for drawing with the CPU. Drawing through its methods accumulates the damage
+// that Flush and AddPlaneDamage report to the driver.
+type DumbFramebuffer struct {
+	card *Card
+	buf  *ModeDumbBuffer
+
+	// ID is the ID of the framebuffer.
+	ID     uint32
+	Format uint32
+	Width  int
+	Height int
+	// Pitch is the number of bytes between the starts of two rows of Mem.
+	Pitch int
+	// CPP is the number of bytes of a pixel.
+	CPP int
+	// Mem is the memory of the buffer. Writing to it directly must be
+	// reported with Damage.
+	Mem []byte
+
+	damage Damage
+}
+
+// NewDumbFramebuffer creates a dumb buffer of a single plane RGB format, adds a
+// framebuffer for it and maps it into memory. The card must have been opened
+// for writing.
+func (c *Card) NewDumbFramebuffer(width, height int, format uint32) (*DumbFramebuffer, error) {
+	info := LookupFormat(format)
+	if info == nil || info.NumPlanes != 1 || info.IsYUV || info.BPP()%8 != 0 {
+		return nil, fmt.Errorf("cannot create a dumb framebuffer of format %s", FormatString(format))
+	}
+	buf, err := c.ModeCreateDumb(uint32(height), uint32(width), info.BPP())
+	if err != nil {
+		return nil, err
+	}
+	f := DumbFramebuffer{card: c, buf: buf, Format: format, Width: width, Height: height,
+		Pitch: int(buf.Pitch), CPP: int(info.CPP[0])}
+	fb, err := c.ModeAddFramebuffer2(uint32(width), uint32(height), format, 0,
+		[4]uint32{buf.Handle}, [4]uint32{buf.Pitch}, [4]uint32{}, [4]uint64{})
+	if err != nil {
+		c.ModeDestroyDumb(buf.Handle)
+		return nil, err
+	}
+	f.ID = fb.ID
+	if f.Mem, err = c.MmapDumb(buf); err != nil {
+		c.ModeRemoveFramebuffer(f.ID)
+		c.ModeDestroyDumb(buf.Handle)
+		return nil, err
+	}
+	return &f, nil
+}
+
+// Bounds returns the rectangle of the framebuffer.
+func (f *DumbFramebuffer) Bounds() image.Rectangle {
+	return image.Rect(0, 0, f.Width, f.Height)
+}
+
+// Damage reports that a rectangle of Mem was written to directly.
+func (f *DumbFramebuffer) Damage(r image.Rectangle) {
+	f.damage.Add(r.Intersect(f.Bounds()))
+}
+
+// Damaged returns the rectangles damaged since the last Flush or
+// AddPlaneDamage.
+func (f *DumbFramebuffer) Damaged() []image.Rectangle {
+	return f.damage.Rects()
+}
+
+// Fill sets every pixel of a rectangle to pixel, a pixel encoded in the
+// framebuffer's format of CPP bytes.
+func (f *DumbFramebuffer) Fill(r image.Rectangle, pixel []byte) error {
+	if len(pixel) != f.CPP {
+		return fmt.Errorf("pixel of %d bytes for a format of %d", len(pixel), f.CPP)
+	}
+	r = r.Intersect(f.Bounds())
+	if r.Empty() {
+		return nil
+	}
+	row := f.row(r, 0)
+	for i := 0; i < len(row); i += f.CPP {
+		copy(row[i:], pixel)
+	}
+	for y := 1; y < r.Dy(); y++ {
+		copy(f.row(r, y), row)
+	}
+	f.damage.Add(r)
+	return nil
+}
+
+// Write copies pixels encoded in the framebuffer's format into a rectangle.
+// Src holds rows of the rectangle's width, stride bytes apart. Parts of the
+// rectangle outside the framebuffer are skipped.
+func (f *DumbFramebuffer) Write(r image.Rectangle, src []byte, stride int) error {
+	if stride < r.Dx()*f.CPP || len(src) < (r.Dy()-1)*stride+r.Dx()*f.CPP {
+		return errors.New("source too short for the rectangle")
+	}
+	clipped := r.Intersect(f.Bounds())
+	if clipped.Empty() {
+		return nil
+	}
+	skip := (clipped.Min.X - r.Min.X) * f.CPP
+	for y := 0; y < clipped.Dy(); y++ {
+		copy(f.row(clipped, y), src[(clipped.Min.Y-r.Min.Y+y)*stride+skip:])
+	}
+	f.damage.Add(clipped)
+	return nil
+}
+
+// row returns row y of a rectangle inside the framebuffer.
+func (f *DumbFramebuffer) row(r image.Rectangle, y int) []byte {
+	start := (r.Min.Y+y)*f.Pitch + r.Min.X*f.CPP
+	return f.Mem[start : start+r.Dx()*f.CPP]
+}
+
+// Flush reports the damage to the driver with ModeDirtyFB, then clears it. It
+// does nothing without damage, and drivers that do not need damage reports are
+// not an error.
+func (f *DumbFramebuffer) Flush() error {
+	if f.damage.Empty() {
+		return nil
+	}
+	err := f.card.ModeDirtyFB(f.ID, f.damage.Rects())
+	if err != nil && !errors.Is(err, ErrNotSupported) {
+		return err
+	}
+	f.damage.Reset()
+	return nil
+}
+
+// AddPlaneDamage adds the damage to an atomic request updating the plane that
+// shows the framebuffer, as Card.AddPlaneDamage does, then clears it.
+func (f *DumbFramebuffer) AddPlaneDamage(req *AtomicRequest, planeID uint32) (uint32, error) {
+	blobID, err := f.card.AddPlaneDamage(req, planeID, f.damage.Rects())
+	if err != nil {
+		return 0, err
+	}
+	f.damage.Reset()
+	return blobID, nil
+}
+
+// Release unmaps the buffer and removes the framebuffer and buffer.
+func (f *DumbFramebuffer) Release() error {
+	var errs []error
+	if err := f.card.Munmap(f.Mem); err != nil {
+		errs = append(errs, err)
+	}
+	if err := f.card.ModeRemoveFramebuffer(f.ID); err != nil {
+		errs = append(errs, err)
+	}
+	if err := f.card.ModeDestroyDumb(f.buf.Handle); err != nil {
+		errs = append(errs, err)
+	}
+	return joinErrors(errs)
+}
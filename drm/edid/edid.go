@@ -0,0 +1,492 @@
+// Package edid decodes the Extended Display Identification Data of a display,
+// as returned by drm.Card.ConnectorEDID. It covers the base block and the parts
+// of the CTA-861 extension needed for configuring outputs.
+package edid
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// BlockLen is the length of an EDID block.
+const BlockLen = 128
+
+var header = []byte{0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00}
+
+var (
+	ErrInvalidHeader = errors.New("edid: invalid header")
+	ErrChecksum      = errors.New("edid: invalid checksum")
+)
+
+// EDID is a decoded EDID.
+type EDID struct {
+	// Manufacturer is the three letter PNP ID of the manufacturer, e.g. "DEL".
+	Manufacturer string
+	ProductCode  uint16
+	SerialNumber uint32
+	// Week and Year of manufacture. Week is 0 if unspecified.
+	Week int
+	Year int
+
+	Version  uint8
+	Revision uint8
+
+	// WidthCM and HeightCM are the physical size of the display, or 0 if the size
+	// is unknown or variable.
+	WidthCM  uint8
+	HeightCM uint8
+
+	// Name and SerialString come from the display descriptors, and are empty if
+	// the EDID does not have them.
+	Name         string
+	SerialString string
+
+	// Chromaticity holds the color characteristics of the display.
+	Chromaticity Chromaticity
+
+	// RangeLimits holds the display range limits descriptor, or nil if the EDID
+	// does not have one.
+	RangeLimits *RangeLimits
+	// ContinuousFrequency indicates that the display accepts any timing within
+	// its RangeLimits, which is how many adaptive sync displays advertise their
+	// refresh range.
+	ContinuousFrequency bool
+	// AdaptiveSync holds the refresh ranges from DisplayID Adaptive-Sync data
+	// blocks.
+	AdaptiveSync []RefreshRange
+
+	// Tile holds the DisplayID tiled display topology, or nil if the display is
+	// not part of a tiled display.
+	Tile *TiledTopology
+
+	// Colorimetry is a bitmask of the Colorimetry* values supported by the display,
+	// as advertised in the CTA-861 colorimetry data block.
+	Colorimetry uint16
+	// HDR holds the HDR static metadata capabilities of the display, or nil if the
+	// display does not advertise any.
+	HDR *HDRStaticMetadata
+
+	// Extensions holds the raw extension blocks.
+	Extensions [][]byte
+}
+
+// Point is a CIE 1931 xy coordinate.
+type Point struct {
+	X float64
+	Y float64
+}
+
+// Chromaticity holds the primaries and white point of a display.
+type Chromaticity struct {
+	Red   Point
+	Green Point
+	Blue  Point
+	White Point
+}
+
+// RangeLimits is the display range limits descriptor.
+type RangeLimits struct {
+	// MinVRate and MaxVRate are the vertical refresh rate limits in Hz.
+	MinVRate int
+	MaxVRate int
+	// MinHRate and MaxHRate are the horizontal rate limits in kHz.
+	MinHRate int
+	MaxHRate int
+	// MaxPixelClock is the maximum pixel clock in MHz, or 0 if unspecified.
+	MaxPixelClock int
+}
+
+// TiledTopology describes one tile of a display made of several tiles, each
+// driven by its own connector, e.g. a 5K or 8K monitor.
+type TiledTopology struct {
+	// SingleEnclosure indicates that all tiles are in a single physical
+	// enclosure, i.e. they make up one monitor.
+	SingleEnclosure bool
+	NumHTiles       int
+	NumVTiles       int
+	// HLoc and VLoc are the location of this tile, starting at 0 in the top left.
+	HLoc int
+	VLoc int
+	// HSize and VSize are the size of this tile in pixels.
+	HSize int
+	VSize int
+	// Bezel is the size of the bezels around this tile in pixels, or nil if the
+	// display does not specify it.
+	Bezel *Bezel
+	// TopologyID identifies the tiled display, and is the same for all of its
+	// tiles. It holds the manufacturer, product code and serial number.
+	TopologyID [8]byte
+}
+
+// Bezel holds the sizes of the bezels around a tile, in pixels.
+type Bezel struct {
+	Top    int
+	Bottom int
+	Right  int
+	Left   int
+}
+
+// RefreshRange is a range of refresh rates in Hz.
+type RefreshRange struct {
+	Min int
+	Max int
+}
+
+// VRRRange returns the variable refresh rate range of the display, preferring
+// DisplayID Adaptive-Sync data over the range limits descriptor. Ranges whose
+// minimum is 0 or above their maximum are ignored.
+func (e *EDID) VRRRange() (RefreshRange, bool) {
+	for _, r := range e.AdaptiveSync {
+		if r.valid() {
+			return r, true
+		}
+	}
+	if e.ContinuousFrequency && e.RangeLimits != nil {
+		r := RefreshRange{Min: e.RangeLimits.MinVRate, Max: e.RangeLimits.MaxVRate}
+		if r.valid() {
+			return r, true
+		}
+	}
+	return RefreshRange{}, false
+}
+
+func (r RefreshRange) valid() bool {
+	return r.Min > 0 && r.Min <= r.Max
+}
+
+// Bits of EDID.Colorimetry, from the CTA-861 colorimetry data block.
+const (
+	ColorimetryXVYCC601 uint16 = 1 << iota
+	ColorimetryXVYCC709
+	ColorimetrySYCC601
+	ColorimetryOpYCC601
+	ColorimetryOpRGB
+	ColorimetryBT2020CYCC
+	ColorimetryBT2020YCC
+	ColorimetryBT2020RGB
+	_
+	_
+	_
+	_
+	_
+	_
+	_
+	ColorimetryDCIP3
+)
+
+// Bits of HDRStaticMetadata.EOTFs.
+const (
+	EOTFTraditionalGammaSDR uint8 = 1 << iota
+	EOTFTraditionalGammaHDR
+	EOTFSMPTEST2084
+	EOTFHLG
+)
+
+// HDRStaticMetadata is the CTA-861 HDR static metadata data block, which
+// describes the HDR capabilities of a display.
+type HDRStaticMetadata struct {
+	// EOTFs is a bitmask of the supported EOTF* transfer functions.
+	EOTFs uint8
+	// Descriptors is a bitmask of the supported static metadata descriptors,
+	// where bit 0 is static metadata type 1.
+	Descriptors uint8
+	// MaxLuminance is the desired content max luminance, MaxFrameAvgLuminance the
+	// desired content max frame-average luminance, and MinLuminance the desired
+	// content min luminance, all in cd/m2. They are 0 if unspecified.
+	MaxLuminance         float64
+	MaxFrameAvgLuminance float64
+	MinLuminance         float64
+}
+
+// SupportsEOTF returns whether the display supports one of the EOTF* transfer
+// functions.
+func (h *HDRStaticMetadata) SupportsEOTF(eotf uint8) bool {
+	return h.EOTFs&eotf != 0
+}
+
+// Display descriptor tags.
+const (
+	descriptorSerial = 0xff
+	descriptorText   = 0xfe
+	descriptorRange  = 0xfd
+	descriptorName   = 0xfc
+)
+
+// Parse decodes an EDID, including its extension blocks. Like the kernel, it
+// rejects an EDID whose base block has an invalid checksum, and skips extension
+// blocks with invalid checksums.
+func Parse(data []byte) (*EDID, error) {
+	if len(data) < BlockLen {
+		return nil, fmt.Errorf("edid: short base block of %d bytes", len(data))
+	}
+	if !bytes.Equal(data[:len(header)], header) {
+		return nil, ErrInvalidHeader
+	}
+	if !validChecksum(data[:BlockLen]) {
+		return nil, ErrChecksum
+	}
+
+	mfg := binary.BigEndian.Uint16(data[8:])
+	ret := EDID{
+		Manufacturer: string([]byte{
+			byte(mfg>>10&0x1f) + 'A' - 1,
+			byte(mfg>>5&0x1f) + 'A' - 1,
+			byte(mfg&0x1f) + 'A' - 1,
+		}),
+		ProductCode:  binary.LittleEndian.Uint16(data[10:]),
+		SerialNumber: binary.LittleEndian.Uint32(data[12:]),
+		Week:         int(data[16]),
+		Year:         int(data[17]) + 1990,
+		Version:      data[18],
+		Revision:     data[19],
+		WidthCM:      data[21],
+		HeightCM:     data[22],
+		Chromaticity: parseChromaticity(data[25:35]),
+	}
+	if ret.Version == 1 && ret.Revision >= 4 {
+		// Bit 0 of the feature support byte was redefined by EDID 1.4.
+		ret.ContinuousFrequency = data[24]&0x01 != 0
+	}
+	if ret.Week == 0xff {
+		// Week 0xff indicates that the year is the model year.
+		ret.Week = 0
+	}
+
+	for i := 54; i+18 <= 126; i += 18 {
+		ret.parseDescriptor(data[i : i+18])
+	}
+
+	count := int(data[126])
+	for i := 1; i <= count; i++ {
+		if len(data) < (i+1)*BlockLen {
+			return nil, fmt.Errorf("edid: missing extension block %d of %d", i, count)
+		}
+		ext := data[i*BlockLen : (i+1)*BlockLen]
+		if !validChecksum(ext) {
+			continue
+		}
+		ret.Extensions = append(ret.Extensions, ext)
+		switch ext[0] {
+		case extensionCTA:
+			ret.parseCTA(ext)
+		case extensionDisplayID:
+			ret.parseDisplayID(ext)
+		}
+	}
+	return &ret, nil
+}
+
+// validChecksum returns whether the bytes of a block sum to 0.
+func validChecksum(block []byte) bool {
+	var sum byte
+	for _, b := range block {
+		sum += b
+	}
+	return sum == 0
+}
+
+func parseChromaticity(b []byte) Chromaticity {
+	coord := func(high byte, low byte, shift uint) float64 {
+		return float64(uint16(high)<<2|uint16(low>>shift&0x3)) / 1024
+	}
+	return Chromaticity{
+		Red:   Point{coord(b[2], b[0], 6), coord(b[3], b[0], 4)},
+		Green: Point{coord(b[4], b[0], 2), coord(b[5], b[0], 0)},
+		Blue:  Point{coord(b[6], b[1], 6), coord(b[7], b[1], 4)},
+		White: Point{coord(b[8], b[1], 2), coord(b[9], b[1], 0)},
+	}
+}
+
+func (e *EDID) parseDescriptor(d []byte) {
+	if d[0] != 0 || d[1] != 0 {
+		// Detailed timing descriptor.
+		return
+	}
+	switch d[3] {
+	case descriptorName:
+		e.Name = descriptorString(d[5:])
+	case descriptorSerial:
+		e.SerialString = descriptorString(d[5:])
+	case descriptorRange:
+		e.RangeLimits = parseRangeLimits(d)
+		if e.Version == 1 && e.Revision < 4 {
+			// Before EDID 1.4 every display with range limits accepts
+			// continuous frequencies.
+			e.ContinuousFrequency = true
+		}
+	}
+}
+
+func parseRangeLimits(d []byte) *RangeLimits {
+	// The offsets in byte 4 were added by EDID 1.4, and add 255 to the
+	// corresponding limit.
+	offset := func(bit uint) int {
+		if d[4]&(1<<bit) != 0 {
+			return 255
+		}
+		return 0
+	}
+	return &RangeLimits{
+		MinVRate:      int(d[5]) + offset(0),
+		MaxVRate:      int(d[6]) + offset(1),
+		MinHRate:      int(d[7]) + offset(2),
+		MaxHRate:      int(d[8]) + offset(3),
+		MaxPixelClock: int(d[9]) * 10,
+	}
+}
+
+// descriptorString decodes the text of a display descriptor, which is terminated
+// by a newline and padded with spaces.
+func descriptorString(b []byte) string {
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		b = b[:i]
+	}
+	return string(bytes.TrimRight(b, " \x00"))
+}
+
+const (
+	extensionCTA       = 0x02
+	extensionDisplayID = 0x70
+
+	ctaTagExtended = 7
+
+	ctaExtendedColorimetry = 0x05
+	ctaExtendedHDRStatic   = 0x06
+)
+
+func (e *EDID) parseCTA(ext []byte) {
+	// Data blocks are located between byte 4 and the offset of the first
+	// detailed timing descriptor, which is 0 if there are neither. Blocks of
+	// extensions giving an offset within the header or the checksum are
+	// skipped.
+	end := int(ext[2])
+	if end < 4 || end >= len(ext) {
+		return
+	}
+	for i := 4; i < end; {
+		tag, length := ext[i]>>5, int(ext[i]&0x1f)
+		if i+1+length > end {
+			return
+		}
+		block := ext[i+1 : i+1+length]
+		i += 1 + length
+
+		if tag != ctaTagExtended || len(block) < 1 {
+			continue
+		}
+		switch block[0] {
+		case ctaExtendedColorimetry:
+			if len(block) >= 3 {
+				// The low bits of the second byte are gamut metadata profiles.
+				e.Colorimetry = uint16(block[1]) | uint16(block[2]&0x80)<<8
+			} else if len(block) >= 2 {
+				e.Colorimetry = uint16(block[1])
+			}
+		case ctaExtendedHDRStatic:
+			e.HDR = parseHDRStatic(block[1:])
+		}
+	}
+}
+
+func parseHDRStatic(b []byte) *HDRStaticMetadata {
+	if len(b) < 2 {
+		return nil
+	}
+	ret := HDRStaticMetadata{EOTFs: b[0] & 0x3f, Descriptors: b[1]}
+	// Luminance values are coded as defined by CTA-861-G, section 7.5.13.
+	if len(b) >= 3 && b[2] != 0 {
+		ret.MaxLuminance = 50 * math.Pow(2, float64(b[2])/32)
+	}
+	if len(b) >= 4 && b[3] != 0 {
+		ret.MaxFrameAvgLuminance = 50 * math.Pow(2, float64(b[3])/32)
+	}
+	if len(b) >= 5 && ret.MaxLuminance != 0 {
+		ret.MinLuminance = ret.MaxLuminance * math.Pow(float64(b[4])/255, 2) / 100
+	}
+	return &ret
+}
+
+const (
+	displayIDTiledDisplay  = 0x12
+	displayID2TiledDisplay = 0x28
+	displayIDAdaptiveSync  = 0x2b
+)
+
+// parseDisplayID decodes a DisplayID section embedded in an EDID extension.
+func (e *EDID) parseDisplayID(ext []byte) {
+	// The section header follows the extension tag: version, number of payload
+	// bytes, product type and extension count.
+	if len(ext) < 5 {
+		return
+	}
+	end := 5 + int(ext[2])
+	if end > len(ext) {
+		end = len(ext)
+	}
+	for i := 5; i+3 <= end; {
+		tag, length := ext[i], int(ext[i+2])
+		if tag == 0 || i+3+length > end {
+			return
+		}
+		block := ext[i+3 : i+3+length]
+		i += 3 + length
+
+		switch tag {
+		case displayIDAdaptiveSync:
+			e.AdaptiveSync = append(e.AdaptiveSync, parseAdaptiveSync(block)...)
+		case displayIDTiledDisplay, displayID2TiledDisplay:
+			if tile := parseTiledTopology(block); tile != nil {
+				e.Tile = tile
+			}
+		}
+	}
+}
+
+// parseAdaptiveSync decodes the descriptors of a DisplayID 2.1 Adaptive-Sync
+// data block. Each 6 byte descriptor holds the minimum refresh rate in byte 2,
+// and the maximum refresh rate minus one in the low 10 bits of bytes 3 and 4.
+func parseAdaptiveSync(b []byte) []RefreshRange {
+	var ret []RefreshRange
+	for ; len(b) >= 6; b = b[6:] {
+		ret = append(ret, RefreshRange{
+			Min: int(b[2]),
+			Max: int(uint16(b[3])|uint16(b[4]&0x3)<<8) + 1,
+		})
+	}
+	return ret
+}
+
+// parseTiledTopology decodes a DisplayID tiled display topology data block, the
+// same way as the kernel's drm_parse_tiled_block.
+func parseTiledTopology(b []byte) *TiledTopology {
+	if len(b) < 21 {
+		return nil
+	}
+	capability, topo, size, bezel := b[0], b[1:4], b[4:8], b[8:13]
+
+	ret := TiledTopology{
+		SingleEnclosure: capability&0x80 != 0,
+		NumHTiles:       int(topo[0]>>4|(topo[2]>>2)&0x30) + 1,
+		NumVTiles:       int(topo[0]&0xf|topo[2]&0x30) + 1,
+		HLoc:            int(topo[1]>>4 | (topo[2]>>2&0x3)<<4),
+		VLoc:            int(topo[1]&0xf | (topo[2]&0x3)<<4),
+		HSize:           int(uint16(size[0])|uint16(size[1])<<8) + 1,
+		VSize:           int(uint16(size[2])|uint16(size[3])<<8) + 1,
+	}
+	copy(ret.TopologyID[:], b[13:21])
+
+	// Bezel sizes are given in units of a tenth of the pixel multiplier, which is
+	// 0 if the display does not specify bezels.
+	if multiplier := int(bezel[0]); multiplier != 0 {
+		ret.Bezel = &Bezel{
+			Top:    int(bezel[1]) * multiplier / 10,
+			Bottom: int(bezel[2]) * multiplier / 10,
+			Right:  int(bezel[3]) * multiplier / 10,
+			Left:   int(bezel[4]) * multiplier / 10,
+		}
+	}
+	return &ret
+}
@@ -0,0 +1,285 @@
+package edid
+
+import (
+	"encoding/hex"
+	"errors"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// The EDIDs below are modeled on those of real displays, with their checksums.
+
+// dellU2720Q is a 4K HDR display with a CTA-861 extension holding colorimetry
+// and HDR static metadata data blocks.
+const dellU2720Q = "00ffffffffffff0010acf6a032314a4c0c1f0104b53c22783aee91a3544c9926" +
+	"0f5054210800d1c081c08180010101010101010101014dd000a0f0703e803020" +
+	"3500544f2100001a000000ff00414243313233340a2020202020000000fc0044" +
+	"454c4c205532373230510a20000000fd00184c1e8c3c010a202020202020012a" +
+	"02031170e305c000e606050162623f4110000000000000000000000000000000" +
+	"0000000000000000000000000000000000000000000000000000000000000000" +
+	"0000000000000000000000000000000000000000000000000000000000000000" +
+	"000000000000000000000000000000000000000000000000000000000000008c"
+
+// samsungSyncMaster is an EDID 1.3 display without extensions, with a model
+// year instead of a week of manufacture.
+const samsungSyncMaster = "00ffffffffffff004c2d150230324a4dff120103802f1e782a2aee91a3544c99" +
+	"260f50210800d1c081c08180010101010101010101019a29a0d0518422305098" +
+	"360098ff1000001c000000fd00384b1e5111000a202020202020000000fc0053" +
+	"796e634d61737465720a2020000000ff00483958533132333435360a202000eb"
+
+// lgUltraFine is the right tile of a 5K display made of two tiles, with a
+// DisplayID 2.0 extension holding the tiled display topology and an
+// Adaptive-Sync range.
+const lgUltraFine = "00ffffffffffff001e6d095b01010000141a0104b53c22781aee91a3544c9926" +
+	"0f5054210800d1c081c0818001010101010101010101e26800a0a0402e603020" +
+	"360081902100001a000000fc004c4720556c74726146696e650a000000ff0036" +
+	"30354e54565331413132330a000000100000000000000000000000000000018e" +
+	"702022000028001680101000ff093f0b0a050503031e6d095b01010000002b00" +
+	"060000308f000093000000000000000000000000000000000000000000000000" +
+	"0000000000000000000000000000000000000000000000000000000000000000" +
+	"0000000000000000000000000000000000000000000000000000000000000090"
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// luminance decodes a CTA-861 luminance code.
+func luminance(code float64) float64 {
+	return 50 * math.Pow(2, code/32)
+}
+
+func TestParse(t *testing.T) {
+	maxLum := luminance(0x62)
+	for _, tc := range []struct {
+		name string
+		data string
+		want EDID
+	}{{
+		name: "dell",
+		data: dellU2720Q,
+		want: EDID{
+			Manufacturer: "DEL", ProductCode: 0xa0f6, SerialNumber: 0x4c4a3132,
+			Week: 12, Year: 2021, Version: 1, Revision: 4, WidthCM: 60, HeightCM: 34,
+			Name: "DELL U2720Q", SerialString: "ABC1234",
+			RangeLimits: &RangeLimits{MinVRate: 24, MaxVRate: 76, MinHRate: 30, MaxHRate: 140, MaxPixelClock: 600},
+			Colorimetry: ColorimetryBT2020YCC | ColorimetryBT2020RGB,
+			HDR: &HDRStaticMetadata{
+				EOTFs: EOTFTraditionalGammaSDR | EOTFSMPTEST2084, Descriptors: 1,
+				MaxLuminance: maxLum, MaxFrameAvgLuminance: maxLum,
+				MinLuminance: maxLum * math.Pow(0x3f/255.0, 2) / 100,
+			},
+		},
+	}, {
+		name: "samsung",
+		data: samsungSyncMaster,
+		want: EDID{
+			Manufacturer: "SAM", ProductCode: 0x0215, SerialNumber: 0x4d4a3230,
+			Week: 0, Year: 2008, Version: 1, Revision: 3, WidthCM: 47, HeightCM: 30,
+			Name: "SyncMaster", SerialString: "H9XS123456",
+			RangeLimits:         &RangeLimits{MinVRate: 56, MaxVRate: 75, MinHRate: 30, MaxHRate: 81, MaxPixelClock: 170},
+			ContinuousFrequency: true,
+		},
+	}, {
+		name: "lg",
+		data: lgUltraFine,
+		want: EDID{
+			Manufacturer: "GSM", ProductCode: 0x5b09, SerialNumber: 0x0101,
+			Week: 20, Year: 2016, Version: 1, Revision: 4, WidthCM: 60, HeightCM: 34,
+			Name: "LG UltraFine", SerialString: "605NTVS1A123",
+			AdaptiveSync: []RefreshRange{{Min: 48, Max: 144}},
+			Tile: &TiledTopology{
+				SingleEnclosure: true, NumHTiles: 2, NumVTiles: 1, HLoc: 1, VLoc: 0,
+				HSize: 2560, VSize: 2880,
+				Bezel:      &Bezel{Top: 5, Bottom: 5, Right: 3, Left: 3},
+				TopologyID: [8]byte{0x1e, 0x6d, 0x09, 0x5b, 0x01, 0x01, 0x00, 0x00},
+			},
+		},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := decodeHex(t, tc.data)
+			got, err := Parse(data)
+			if err != nil {
+				t.Fatalf("parse: %s", err)
+			}
+			if len(got.Extensions) != len(data)/BlockLen-1 {
+				t.Errorf("%d extensions", len(got.Extensions))
+			}
+			got.Extensions, got.Chromaticity = nil, Chromaticity{}
+			if !reflect.DeepEqual(*got, tc.want) {
+				t.Errorf("parsed\n%+v\nwant\n%+v", *got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseChromaticity(t *testing.T) {
+	e, err := Parse(decodeHex(t, dellU2720Q))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The coordinates of sRGB primaries and D65, to 10 bits.
+	for _, c := range []struct {
+		name string
+		got  Point
+		want Point
+	}{
+		{"red", e.Chromaticity.Red, Point{0.64, 0.33}},
+		{"green", e.Chromaticity.Green, Point{0.30, 0.60}},
+		{"blue", e.Chromaticity.Blue, Point{0.15, 0.06}},
+		{"white", e.Chromaticity.White, Point{0.3127, 0.329}},
+	} {
+		if math.Abs(c.got.X-c.want.X) > 0.002 || math.Abs(c.got.Y-c.want.Y) > 0.002 {
+			t.Errorf("%s is %v, want %v", c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	dell := decodeHex(t, dellU2720Q)
+	for _, tc := range []struct {
+		name string
+		data func() []byte
+		err  error
+	}{
+		{"empty", func() []byte { return nil }, nil},
+		{"short base block", func() []byte { return dell[:100] }, nil},
+		{"missing extension", func() []byte { return dell[:BlockLen+10] }, nil},
+		{"header", func() []byte {
+			b := append([]byte(nil), dell...)
+			b[1] = 0
+			return b
+		}, ErrInvalidHeader},
+		{"base checksum", func() []byte {
+			b := append([]byte(nil), dell...)
+			b[20]++
+			return b
+		}, ErrChecksum},
+	} {
+		_, err := Parse(tc.data())
+		if err == nil || (tc.err != nil && !errors.Is(err, tc.err)) {
+			t.Errorf("%s: parse returned %v, want %v", tc.name, err, tc.err)
+		}
+	}
+
+	// An extension with an invalid checksum is skipped.
+	b := append([]byte(nil), dell...)
+	b[BlockLen+5]++
+	e, err := Parse(b)
+	if err != nil {
+		t.Fatalf("bad extension checksum: %s", err)
+	}
+	if len(e.Extensions) != 0 || e.HDR != nil || e.Colorimetry != 0 {
+		t.Errorf("extension with a bad checksum parsed: %+v", e)
+	}
+}
+
+// TestParseCorrupt checks that no truncation or corruption of the data blocks
+// makes Parse panic.
+func TestParseCorrupt(t *testing.T) {
+	for _, s := range []string{dellU2720Q, samsungSyncMaster, lgUltraFine} {
+		data := decodeHex(t, s)
+		for n := 0; n <= len(data); n++ {
+			Parse(data[:n])
+		}
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for _, s := range []string{dellU2720Q, lgUltraFine} {
+		orig := decodeHex(t, s)
+		for i := 0; i < 2000; i++ {
+			data := append([]byte(nil), orig...)
+			ext := data[BlockLen:]
+			for j := 0; j < 1+rng.Intn(8); j++ {
+				ext[rng.Intn(BlockLen-1)] = byte(rng.Intn(256))
+			}
+			fixChecksum(ext)
+			if _, err := Parse(data); err != nil {
+				t.Fatalf("parse of corrupt extension %x: %s", ext, err)
+			}
+		}
+	}
+}
+
+// fixChecksum sets the checksum of a block to match its other bytes.
+func fixChecksum(block []byte) {
+	block[BlockLen-1] = 0
+	for _, b := range block[:BlockLen-1] {
+		block[BlockLen-1] -= b
+	}
+}
+
+func TestParseCTAOffset(t *testing.T) {
+	dell := decodeHex(t, dellU2720Q)
+	// The data blocks of the extension end at byte 0x11. Offsets of 0, for
+	// neither data blocks nor timing descriptors, and invalid offsets leave
+	// none to parse.
+	for _, offset := range []byte{0, 1, 3, 4, 128, 255} {
+		data := append([]byte(nil), dell...)
+		ext := data[BlockLen:]
+		ext[2] = offset
+		fixChecksum(ext)
+		e, err := Parse(data)
+		if err != nil {
+			t.Fatalf("offset %d: %s", offset, err)
+		}
+		if len(e.Extensions) != 1 || e.HDR != nil || e.Colorimetry != 0 {
+			t.Errorf("offset %d: parsed data blocks %+v", offset, e)
+		}
+	}
+}
+
+func TestVRRRange(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data string
+		want RefreshRange
+		ok   bool
+	}{
+		{"adaptive sync", lgUltraFine, RefreshRange{48, 144}, true},
+		{"range limits of EDID 1.3", samsungSyncMaster, RefreshRange{56, 75}, true},
+		// EDID 1.4 displays must set the continuous frequency bit.
+		{"range limits without continuous frequency", dellU2720Q, RefreshRange{}, false},
+	} {
+		e, err := Parse(decodeHex(t, tc.data))
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if got, ok := e.VRRRange(); got != tc.want || ok != tc.ok {
+			t.Errorf("%s: range is %v, %v, want %v, %v", tc.name, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestVRRRangeDegenerate(t *testing.T) {
+	limits := func(min, max int) *RangeLimits {
+		return &RangeLimits{MinVRate: min, MaxVRate: max}
+	}
+	for _, tc := range []struct {
+		name string
+		e    EDID
+		want RefreshRange
+		ok   bool
+	}{
+		{"adaptive sync from 0", EDID{AdaptiveSync: []RefreshRange{{0, 144}}}, RefreshRange{}, false},
+		{"adaptive sync inverted", EDID{AdaptiveSync: []RefreshRange{{144, 48}}}, RefreshRange{}, false},
+		{"adaptive sync of one rate", EDID{AdaptiveSync: []RefreshRange{{60, 60}}}, RefreshRange{60, 60}, true},
+		{"second adaptive sync range", EDID{AdaptiveSync: []RefreshRange{{0, 0}, {40, 120}}}, RefreshRange{40, 120}, true},
+		{"invalid adaptive sync falls back to range limits",
+			EDID{AdaptiveSync: []RefreshRange{{0, 0}}, ContinuousFrequency: true, RangeLimits: limits(48, 75)},
+			RefreshRange{48, 75}, true},
+		{"range limits from 0", EDID{ContinuousFrequency: true, RangeLimits: limits(0, 75)}, RefreshRange{}, false},
+		{"range limits inverted", EDID{ContinuousFrequency: true, RangeLimits: limits(75, 48)}, RefreshRange{}, false},
+		{"no range", EDID{ContinuousFrequency: true}, RefreshRange{}, false},
+	} {
+		if got, ok := tc.e.VRRRange(); got != tc.want || ok != tc.ok {
+			t.Errorf("%s: range is %v, %v, want %v, %v", tc.name, got, ok, tc.want, tc.ok)
+		}
+	}
+}
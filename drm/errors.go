@@ -0,0 +1,71 @@
+package drm
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// Errors that an IoctlError matches with errors.Is, depending on its errno.
+var (
+	// ErrNotMaster is returned by ioctls that require the caller to be DRM master
+	// or root, e.g. modesetting ioctls while another process is master.
+	ErrNotMaster = errors.New("not drm master")
+	// ErrNotSupported is returned by ioctls that the driver or kernel does not
+	// implement, and for capabilities it does not know.
+	ErrNotSupported = errors.New("not supported")
+	// ErrNoSuchObject is returned by ioctls about a mode object that does not
+	// exist, e.g. a connector that was unplugged.
+	ErrNoSuchObject = errors.New("no such object")
+	// ErrRenderNode is returned by ioctls that render nodes do not allow, which
+	// are all but those for rendering and buffer sharing.
+	ErrRenderNode = errors.New("not allowed on render nodes")
+)
+
+// IoctlError is the error returned when a DRM ioctl fails.
+type IoctlError struct {
+	// Op is the name of the ioctl, e.g. "MODE_GETCRTC".
+	Op string
+	// ObjectID is the ID of the mode object the ioctl was about, or 0.
+	ObjectID uint32
+	Errno    syscall.Errno
+	// RenderNode is set if the ioctl failed because the card is a render node.
+	RenderNode bool
+}
+
+func (e *IoctlError) Error() string {
+	if e.RenderNode {
+		return fmt.Sprintf("ioctl %s: %s", e.Op, ErrRenderNode)
+	}
+	if e.ObjectID != 0 {
+		return fmt.Sprintf("ioctl %s on object %d: %s", e.Op, e.ObjectID, e.Errno)
+	}
+	return fmt.Sprintf("ioctl %s: %s", e.Op, e.Errno)
+}
+
+func (e *IoctlError) Unwrap() error {
+	return e.Errno
+}
+
+// Is reports whether the error matches one of ErrNotMaster, ErrNotSupported,
+// ErrNoSuchObject or ErrRenderNode. The kernel returns EINVAL both for unknown
+// ioctls and for invalid arguments, so EINVAL only matches ErrNotSupported for
+// the ioctls that use it to reject unknown capabilities.
+func (e *IoctlError) Is(target error) bool {
+	switch target {
+	case ErrNotMaster:
+		return e.Errno == syscall.EACCES && !e.RenderNode
+	case ErrNotSupported:
+		switch e.Errno {
+		case syscall.EOPNOTSUPP, syscall.ENOTTY, syscall.ENOSYS:
+			return true
+		case syscall.EINVAL:
+			return e.Op == "GET_CAP" || e.Op == "SET_CLIENT_CAP"
+		}
+	case ErrNoSuchObject:
+		return e.Errno == syscall.ENOENT
+	case ErrRenderNode:
+		return e.RenderNode
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package drm
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+)
+
+// eventBufferLen is the size of the buffer used to read events, which is large
+// enough to hold several events at once.
+const eventBufferLen = 1024
+
+// ReadEvents blocks until at least one event is available on the card, then
+// returns all pending events. Events of unknown type are skipped.
+func (c *Card) ReadEvents() ([]Event, error) {
+	buf := make([]byte, eventBufferLen)
+	n, err := c.backend.Read(buf)
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+	return parseEvents(buf[:n])
+}
+
+func parseEvents(buf []byte) ([]Event, error) {
+	var ret []Event
+	for len(buf) > 0 {
+		if len(buf) < int(unsafe.Sizeof(cEvent{})) {
+			return ret, fmt.Errorf("short event header: %d bytes", len(buf))
+		}
+		hdr := *(*cEvent)(unsafe.Pointer(&buf[0]))
+		if hdr.length < uint32(unsafe.Sizeof(cEvent{})) || int(hdr.length) > len(buf) {
+			return ret, fmt.Errorf("invalid event length: %d", hdr.length)
+		}
+
+		switch hdr.typ {
+		case EventVblank, EventFlipComplete:
+			if hdr.length < uint32(unsafe.Sizeof(cEventVblank{})) {
+				return ret, fmt.Errorf("short vblank event: %d bytes", hdr.length)
+			}
+			vbl := *(*cEventVblank)(unsafe.Pointer(&buf[0]))
+			ret = append(ret, Event{
+				Type:     hdr.typ,
+				UserData: vbl.userData,
+				Sequence: vbl.sequence,
+				CRTCID:   vbl.crtcID,
+				Time:     time.Unix(int64(vbl.tvSec), int64(vbl.tvUsec)*int64(time.Microsecond)),
+			})
+		}
+		buf = buf[hdr.length:]
+	}
+	return ret, nil
+}
@@ -0,0 +1,1649 @@
+package drm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// FakeDevice is an in-memory DRM device, for testing code that uses a Card on
+// machines without a GPU. It models CRTCs, encoders, connectors, planes and
+// their properties, property blobs, dumb buffers, framebuffers, leases and
+// writeback, with the count/fill semantics of the kernel's ioctls, and is used
+// through NewWithBackend.
+//
+// The device checks that the objects and property values of a request exist
+// and are in range, but not that the hardware could display the result. It
+// serves a single client, which starts out as master.
+type FakeDevice struct {
+	mu sync.Mutex
+
+	nextID     uint32
+	nextHandle uint32
+	master     bool
+	caps       map[uint64]uint64
+
+	props      map[uint32]*ModeProperty
+	objects    map[uint32]*fakeObject
+	crtcs      []uint32
+	encoders   []uint32
+	connectors []uint32
+	planes     []uint32
+	typeIDs    map[uint32]uint32
+
+	blobs   map[uint32]*fakeBlob
+	fbs     map[uint32]*fakeFramebuffer
+	dumbs   map[uint32]*fakeDumb
+	lessees map[uint32][]uint32
+
+	events    []byte
+	sequences map[uint32]uint32
+
+	// writebacks holds the writeback jobs committed while holdWriteback is
+	// set.
+	writebacks    []fakeWriteback
+	holdWriteback bool
+
+	// magics holds the magic tokens handed out by GET_MAGIC, and whether they
+	// were authenticated. busID is reported once a DI version of 1.1 or later
+	// was negotiated.
+	magics    map[uint32]bool
+	nextMagic uint32
+	busID     string
+
+	node NodeType
+
+	// replay is set for devices serving a snapshot, which report the recorded
+	// state of the objects and refuse changes.
+	replay bool
+	driver *SnapshotDriver
+	limits [4]uint32
+}
+
+// fakeObject is a mode object, along with its properties in the order they
+// were attached.
+type fakeObject struct {
+	kind       uint32
+	propIDs    []uint32
+	propValues []uint64
+
+	// Fields of the object's kind, as reported by the GET ioctls.
+	crtc      *cModeCRTC
+	encoder   *cModeGetEncoder
+	connector *fakeConnector
+	plane     *fakePlane
+}
+
+type fakeConnector struct {
+	cModeGetConnector
+	encoders []uint32
+	modes    []cModeInfo
+}
+
+type fakePlane struct {
+	possibleCRTCs uint32
+	formats       []uint32
+
+	// Recorded state of replayed planes.
+	crtcID, fbID, gammaSize uint32
+}
+
+type fakeBlob struct {
+	data []byte
+	// user is set for blobs created by the client, which it may destroy.
+	user bool
+}
+
+type fakeFramebuffer struct {
+	cModeFBCmd2
+	bpp, depth uint32
+	// dirty holds the rectangles reported with DIRTYFB.
+	dirty []image.Rectangle
+}
+
+// fakeWriteback is a writeback job: the copy of the framebuffer src into dst,
+// after which the write end of the pipe of the out fence, if any, is closed.
+type fakeWriteback struct {
+	src, dst uint32
+	x, y     uint32
+	fence    int
+}
+
+type fakeDumb struct {
+	cModeCreateDumb
+	offset uint64
+	data   []byte
+}
+
+// NewFakeDevice returns a device without any mode objects.
+func NewFakeDevice() *FakeDevice {
+	return &FakeDevice{
+		master:    true,
+		caps:      make(map[uint64]uint64),
+		props:     make(map[uint32]*ModeProperty),
+		objects:   make(map[uint32]*fakeObject),
+		typeIDs:   make(map[uint32]uint32),
+		blobs:     make(map[uint32]*fakeBlob),
+		fbs:       make(map[uint32]*fakeFramebuffer),
+		dumbs:     make(map[uint32]*fakeDumb),
+		lessees:   make(map[uint32][]uint32),
+		sequences: make(map[uint32]uint32),
+		magics:    make(map[uint32]bool),
+		limits:    [4]uint32{1, 16384, 1, 16384},
+	}
+}
+
+// DirtyRects returns the rectangles of a framebuffer reported with DIRTYFB since
+// the last call.
+func (d *FakeDevice) DirtyRects(fbID uint32) []image.Rectangle {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fb, ok := d.fbs[fbID]
+	if !ok {
+		return nil
+	}
+	dirty := fb.dirty
+	fb.dirty = nil
+	return dirty
+}
+
+// SetNodeType sets whether the device poses as a primary or a render node.
+// Cards created afterwards with NewWithBackend take it on.
+func (d *FakeDevice) SetNodeType(t NodeType) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.node = t
+}
+
+// NodeType returns whether the device poses as a primary or a render node.
+func (d *FakeDevice) NodeType() NodeType {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.node
+}
+
+// FakeMode returns a mode of the given size and refresh rate, with blanking
+// intervals loosely based on CVT reduced blanking.
+func FakeMode(width, height, refresh int) ModeInfo {
+	var m ModeInfo
+	m.HDisplay = uint16(width)
+	m.HSyncStart = uint16(width + 48)
+	m.HSyncEnd = uint16(width + 80)
+	m.HTotal = uint16(width + 160)
+	m.VDisplay = uint16(height)
+	m.VSyncStart = uint16(height + 3)
+	m.VSyncEnd = uint16(height + 9)
+	m.VTotal = uint16(height + 40)
+	m.VRefresh = uint32(refresh)
+	m.Clock = uint32(int(m.HTotal) * int(m.VTotal) * refresh / 1000)
+	m.Flags = ModeFlagPHSync | ModeFlagNVSync
+	m.Type = ModeTypeDriver
+	m.Name = fmt.Sprintf("%dx%d", width, height)
+	copy(m.name[:displayModeLen-1], m.Name)
+	return m
+}
+
+// Standard properties of the fake's objects.
+var (
+	fakeRange01    = ModeProperty{Flags: ModePropRange, Values: []uint64{0, 1}}
+	fakeRangeU32   = ModeProperty{Flags: ModePropRange, Values: []uint64{0, 1<<32 - 1}}
+	fakeRangeS32   = ModeProperty{Flags: ModePropSignedRange, Values: []uint64{uint64(1<<64 - 1<<31), 1<<31 - 1}}
+	fakeRangeU64   = ModeProperty{Flags: ModePropRange, Values: []uint64{0, 1<<64 - 1}}
+	fakeBlobProp   = ModeProperty{Flags: ModePropBlob}
+	fakeObjectFB   = ModeProperty{Flags: ModePropObject, Values: []uint64{uint64(ModeObjectFb)}}
+	fakeObjectCRTC = ModeProperty{Flags: ModePropObject, Values: []uint64{uint64(ModeObjectCrtc)}}
+)
+
+func fakeEnum(flags uint32, names ...string) ModeProperty {
+	p := ModeProperty{Flags: flags | ModePropEnum}
+	for i, name := range names {
+		p.Values = append(p.Values, uint64(i))
+		p.Enums = append(p.Enums, ModePropertyEnum{Value: uint64(i), Name: name})
+	}
+	return p
+}
+
+// AddCRTC adds a CRTC, along with its primary plane which supports the
+// XRGB8888 and ARGB8888 formats. It returns the ID of the CRTC.
+func (d *FakeDevice) AddCRTC() uint32 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	id := d.newObject(ModeObjectCrtc)
+	d.objects[id].crtc = &cModeCRTC{ID: id, GammaSize: 256}
+	d.crtcs = append(d.crtcs, id)
+	d.attach(id, "ACTIVE", fakeRange01, ModePropAtomic, 0)
+	d.attach(id, "MODE_ID", fakeBlobProp, ModePropAtomic, 0)
+
+	d.addPlane(PlaneTypePrimary, 1<<(len(d.crtcs)-1), []uint32{FormatXRGB8888, FormatARGB8888})
+	return id
+}
+
+// AddPlane adds a plane of one of the PlaneType* types, which can be used by the
+// given CRTCs and supports the given formats with the linear modifier.
+func (d *FakeDevice) AddPlane(typ uint64, crtcIDs []uint32, formats []uint32) uint32 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.addPlane(typ, d.crtcMask(crtcIDs), formats)
+}
+
+func (d *FakeDevice) addPlane(typ uint64, possibleCRTCs uint32, formats []uint32) uint32 {
+	id := d.newObject(ModeObjectPlane)
+	d.objects[id].plane = &fakePlane{possibleCRTCs: possibleCRTCs, formats: formats}
+	d.planes = append(d.planes, id)
+
+	d.attach(id, "type", fakeEnum(ModePropImmutable, "Overlay", "Primary", "Cursor"), 0, typ)
+	d.attach(id, "FB_ID", fakeObjectFB, ModePropAtomic, 0)
+	d.attach(id, "CRTC_ID", fakeObjectCRTC, ModePropAtomic, 0)
+	for _, name := range []string{"SRC_X", "SRC_Y", "SRC_W", "SRC_H", "CRTC_W", "CRTC_H"} {
+		d.attach(id, name, fakeRangeU32, ModePropAtomic, 0)
+	}
+	d.attach(id, "CRTC_X", fakeRangeS32, ModePropAtomic, 0)
+	d.attach(id, "CRTC_Y", fakeRangeS32, ModePropAtomic, 0)
+	d.attach(id, "IN_FORMATS", fakeBlobProp, ModePropImmutable, uint64(d.newBlob(fakeInFormats(formats), false)))
+	return id
+}
+
+// fakeInFormats encodes an IN_FORMATS blob listing the linear modifier for
+// every format.
+func fakeInFormats(formats []uint32) []byte {
+	le := binary.LittleEndian
+	b := make([]byte, inFormatsHeaderLen+4*len(formats))
+	le.PutUint32(b[0:], 1)
+	le.PutUint32(b[8:], uint32(len(formats)))
+	le.PutUint32(b[12:], inFormatsHeaderLen)
+	for i, format := range formats {
+		le.PutUint32(b[inFormatsHeaderLen+4*i:], format)
+	}
+	for start := 0; start < len(formats); start += 64 {
+		var mod [inFormatsModifierLen]byte
+		n := len(formats) - start
+		if n > 64 {
+			n = 64
+		}
+		le.PutUint64(mod[0:], 1<<n-1)
+		le.PutUint32(mod[8:], uint32(start))
+		le.PutUint64(mod[16:], FormatModLinear)
+		b = append(b, mod[:]...)
+	}
+	le.PutUint32(b[16:], uint32((len(formats)+63)/64))
+	le.PutUint32(b[20:], uint32(inFormatsHeaderLen+4*len(formats)))
+	return b
+}
+
+// AddEncoder adds an encoder of one of the ModeEncoder* types, which can feed
+// the given CRTCs.
+func (d *FakeDevice) AddEncoder(typ uint32, crtcIDs []uint32) uint32 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	id := d.newObject(ModeObjectEncoder)
+	d.objects[id].encoder = &cModeGetEncoder{ID: id, Type: typ, PossibleCRTCs: d.crtcMask(crtcIDs)}
+	d.encoders = append(d.encoders, id)
+	return id
+}
+
+// AddConnector adds a disconnected connector of one of the ModeConnector* types,
+// which can be driven by the given encoders.
+func (d *FakeDevice) AddConnector(typ uint32, encoderIDs []uint32) uint32 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	id := d.newObject(ModeObjectConnector)
+	d.typeIDs[typ]++
+	conn := fakeConnector{encoders: encoderIDs}
+	conn.ID, conn.Type, conn.TypeID, conn.Connection = id, typ, d.typeIDs[typ], ModeDisconnected
+	d.objects[id].connector = &conn
+	d.connectors = append(d.connectors, id)
+
+	d.attach(id, "EDID", fakeBlobProp, ModePropImmutable, 0)
+	d.attach(id, "DPMS", fakeEnum(0, "On", "Standby", "Suspend", "Off"), 0, uint64(PowerOn))
+	d.attach(id, "link-status", fakeEnum(0, "Good", "Bad"), 0, ModeLinkStatusGood)
+	d.attach(id, "CRTC_ID", fakeObjectCRTC, ModePropAtomic, 0)
+	return id
+}
+
+// AddOutput adds a disconnected connector of one of the ModeConnector* types,
+// along with an encoder of the type drivers use for it, which can feed the
+// given CRTCs. It returns the IDs of the encoder and connector.
+func (d *FakeDevice) AddOutput(typ uint32, crtcIDs []uint32) (encoderID, connectorID uint32) {
+	encType := ModeEncoderTMDS
+	switch typ {
+	case ModeConnectorVGA:
+		encType = ModeEncoderDAC
+	case ModeConnectorLVDS:
+		encType = ModeEncoderLVDS
+	case ModeConnectorComposite, ModeConnectorSVIDEO, ModeConnectorComponent, ModeConnector9PinDIN,
+		ModeConnectorTV:
+		encType = ModeEncoderTVDAC
+	case ModeConnectorVirtual, ModeConnectorWriteback:
+		encType = ModeEncoderVirtual
+	case ModeConnectorDSI:
+		encType = ModeEncoderDSI
+	case ModeConnectorDPI:
+		encType = ModeEncoderDPI
+	}
+	encoderID = d.AddEncoder(encType, crtcIDs)
+	return encoderID, d.AddConnector(typ, []uint32{encoderID})
+}
+
+// AddWriteback adds a writeback connector, along with a virtual encoder which
+// can feed the given CRTCs, that can write the given formats. Committing a
+// framebuffer to it copies the framebuffer of the primary plane of its CRTC,
+// which must have the same format and the size of the mode, then signals the
+// out fence. It returns the ID of the connector.
+func (d *FakeDevice) AddWriteback(crtcIDs []uint32, formats []uint32) uint32 {
+	_, id := d.AddOutput(ModeConnectorWriteback, crtcIDs)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	data := make([]byte, 4*len(formats))
+	for i, f := range formats {
+		binary.LittleEndian.PutUint32(data[4*i:], f)
+	}
+	d.attach(id, "WRITEBACK_FB_ID", fakeObjectFB, ModePropAtomic, 0)
+	d.attach(id, "WRITEBACK_OUT_FENCE_PTR", fakeRangeU64, ModePropAtomic, 0)
+	d.attach(id, "WRITEBACK_PIXEL_FORMATS", fakeBlobProp, ModePropImmutable, uint64(d.newBlob(data, false)))
+	return id
+}
+
+// HoldWriteback sets whether writeback jobs are held back, as if the hardware
+// had yet to write the frame. Held jobs neither write their framebuffer nor
+// signal their out fence until HoldWriteback(false).
+func (d *FakeDevice) HoldWriteback(hold bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.holdWriteback = hold
+	if !hold {
+		for _, job := range d.writebacks {
+			d.runWriteback(job)
+		}
+		d.writebacks = nil
+	}
+}
+
+// Plug marks a connector as connected to a sink with the given modes, the first
+// of which is preferred, and physical size. The EDID may be nil.
+func (d *FakeDevice) Plug(connectorID uint32, modes []ModeInfo, widthMM, heightMM uint32, edid []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	conn := d.objects[connectorID].connector
+	conn.Connection, conn.MMWidth, conn.MMHeight = ModeConnected, widthMM, heightMM
+	conn.modes = conn.modes[:0]
+	for i, mode := range modes {
+		m := mode.cModeInfo
+		if i == 0 {
+			m.Type |= ModeTypePreferred
+		}
+		conn.modes = append(conn.modes, m)
+	}
+	var blobID uint64
+	if edid != nil {
+		blobID = uint64(d.newBlob(edid, false))
+	}
+	d.replaceBlob(connectorID, "EDID", blobID)
+}
+
+// Unplug marks a connector as disconnected. Like the kernel, it leaves the
+// connector's configuration alone.
+func (d *FakeDevice) Unplug(connectorID uint32) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	conn := d.objects[connectorID].connector
+	conn.Connection, conn.MMWidth, conn.MMHeight, conn.modes = ModeDisconnected, 0, 0, nil
+	d.replaceBlob(connectorID, "EDID", 0)
+}
+
+// AddProperty attaches a property to an object, e.g. "vrr_capable" to a
+// connector. Prop describes the property, and its PropID is ignored. Properties
+// with the same name and flags share their ID, as in the kernel.
+func (d *FakeDevice) AddProperty(objectID uint32, prop ModeProperty, value uint64) uint32 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.attach(objectID, prop.Name, prop, 0, value)
+}
+
+// SetProperty changes the value of a property of an object, as the driver
+// would, e.g. when a link fails. It panics if the object has no such property.
+func (d *FakeDevice) SetProperty(objectID uint32, name string, value uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.setValue(objectID, d.mustPropID(objectID, name), value)
+}
+
+// SetBlobProperty sets a blob property of an object, e.g. "TILE" or "PATH" of a
+// connector, to a new blob holding data, attaching the property first if
+// needed. Nil data unsets the property.
+func (d *FakeDevice) SetBlobProperty(objectID uint32, name string, data []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.propID(objectID, name) == 0 {
+		d.attach(objectID, name, fakeBlobProp, ModePropImmutable, 0)
+	}
+	var blobID uint64
+	if data != nil {
+		blobID = uint64(d.newBlob(data, false))
+	}
+	d.replaceBlob(objectID, name, blobID)
+}
+
+// Property returns the current value of a property of an object, or false if
+// the object has no such property.
+func (d *FakeDevice) Property(objectID uint32, name string) (uint64, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	id := d.propID(objectID, name)
+	if id == 0 {
+		return 0, false
+	}
+	return d.value(objectID, id), true
+}
+
+// Dumb returns the memory of a dumb buffer.
+func (d *FakeDevice) Dumb(handle uint32) ([]byte, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dumb, ok := d.dumbs[handle]
+	if !ok {
+		return nil, false
+	}
+	return dumb.data, true
+}
+
+func (d *FakeDevice) newObject(kind uint32) uint32 {
+	d.nextID++
+	d.objects[d.nextID] = &fakeObject{kind: kind}
+	return d.nextID
+}
+
+func (d *FakeDevice) newBlob(data []byte, user bool) uint32 {
+	d.nextID++
+	d.blobs[d.nextID] = &fakeBlob{data: append([]byte(nil), data...), user: user}
+	return d.nextID
+}
+
+func (d *FakeDevice) crtcMask(crtcIDs []uint32) uint32 {
+	var mask uint32
+	for _, id := range crtcIDs {
+		for i, crtc := range d.crtcs {
+			if crtc == id {
+				mask |= 1 << i
+			}
+		}
+	}
+	return mask
+}
+
+// attach attaches a property to an object, creating the property if no property
+// with the same name and flags exists.
+func (d *FakeDevice) attach(objectID uint32, name string, prop ModeProperty, flags uint32,
+	value uint64) uint32 {
+	prop.Name, prop.Flags = name, prop.Flags|flags
+
+	var id uint32
+	for propID, p := range d.props {
+		if p.Name == prop.Name && p.Flags == prop.Flags {
+			id = propID
+		}
+	}
+	if id == 0 {
+		d.nextID++
+		id = d.nextID
+		prop.PropID = id
+		d.props[id] = &prop
+	}
+	obj := d.objects[objectID]
+	obj.propIDs = append(obj.propIDs, id)
+	obj.propValues = append(obj.propValues, value)
+	return id
+}
+
+func (d *FakeDevice) propID(objectID uint32, name string) uint32 {
+	obj, ok := d.objects[objectID]
+	if !ok {
+		return 0
+	}
+	for _, id := range obj.propIDs {
+		if d.props[id].Name == name {
+			return id
+		}
+	}
+	return 0
+}
+
+func (d *FakeDevice) mustPropID(objectID uint32, name string) uint32 {
+	id := d.propID(objectID, name)
+	if id == 0 {
+		panic(fmt.Sprintf("fake: object %d has no property %s", objectID, name))
+	}
+	return id
+}
+
+func (d *FakeDevice) value(objectID, propID uint32) uint64 {
+	obj := d.objects[objectID]
+	for i, id := range obj.propIDs {
+		if id == propID {
+			return obj.propValues[i]
+		}
+	}
+	return 0
+}
+
+func (d *FakeDevice) setValue(objectID, propID uint32, value uint64) {
+	obj := d.objects[objectID]
+	for i, id := range obj.propIDs {
+		if id == propID {
+			obj.propValues[i] = value
+		}
+	}
+}
+
+// named returns the value of the named property of an object, or 0.
+func (d *FakeDevice) named(objectID uint32, name string) uint64 {
+	if id := d.propID(objectID, name); id != 0 {
+		return d.value(objectID, id)
+	}
+	return 0
+}
+
+func (d *FakeDevice) setNamed(objectID uint32, name string, value uint64) {
+	d.setValue(objectID, d.mustPropID(objectID, name), value)
+}
+
+// replaceBlob sets a blob property to blobID, destroying the blob it held
+// unless the client created it.
+func (d *FakeDevice) replaceBlob(objectID uint32, name string, blobID uint64) {
+	old := d.named(objectID, name)
+	if blob, ok := d.blobs[uint32(old)]; ok && !blob.user && old != blobID {
+		delete(d.blobs, uint32(old))
+	}
+	d.setNamed(objectID, name, blobID)
+}
+
+// primaryPlane returns the primary plane of a CRTC.
+func (d *FakeDevice) primaryPlane(crtcID uint32) uint32 {
+	mask := d.crtcMask([]uint32{crtcID})
+	for _, id := range d.planes {
+		if d.named(id, "type") == PlaneTypePrimary && d.objects[id].plane.possibleCRTCs&mask != 0 {
+			return id
+		}
+	}
+	return 0
+}
+
+// userPtr returns the user pointer stored in a field of an ioctl argument.
+func userPtr(field *uint64) unsafe.Pointer {
+	return *(*unsafe.Pointer)(unsafe.Pointer(field))
+}
+
+// copyOut implements the count/fill protocol of the kernel: values are copied
+// to the user array if it is large enough, and the count is set to the number
+// of values.
+func copyOut[T any](ptr *uint64, count *uint32, values []T) {
+	if *ptr != 0 && int(*count) >= len(values) && len(values) > 0 {
+		copy(unsafe.Slice((*T)(userPtr(ptr)), len(values)), values)
+	}
+	*count = uint32(len(values))
+}
+
+// copyIn returns the user array of count values at ptr.
+func copyIn[T any](ptr *uint64, count uint32) []T {
+	if *ptr == 0 || count == 0 {
+		return nil
+	}
+	return append([]T(nil), unsafe.Slice((*T)(userPtr(ptr)), count)...)
+}
+
+func copyString(ptr *uint64, length *kernelSize, s string) {
+	if *ptr != 0 {
+		n := int(*length)
+		if n > len(s) {
+			n = len(s)
+		}
+		copy(unsafe.Slice((*byte)(userPtr(ptr)), n), s)
+	}
+	*length = kernelSize(len(s))
+}
+
+// Ioctl implements Backend.
+func (d *FakeDevice) Ioctl(request uint32, data unsafe.Pointer) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.replay {
+		switch request {
+		case ioctlModeSetCRTC, ioctlModeSetProperty, ioctlModeObjSetProperty,
+			ioctlModeCreatePropBlob, ioctlModeDestroyPropBlob, ioctlModeAddFB, ioctlModeAddFB2,
+			ioctlModeRmFB, ioctlModePageFlip, ioctlModeCreateDumb, ioctlModeDestroyDumb,
+			ioctlModeAtomic, ioctlModeCreateLease, ioctlModeRevokeLease:
+			return syscall.EPERM
+		}
+	}
+
+	switch request {
+	case ioctlVersion:
+		return d.version((*cVersion)(data))
+	case ioctlSetClientCap:
+		return d.setClientCap((*cSetClientCap)(data))
+	case ioctlGetMagic:
+		d.nextMagic++
+		d.magics[d.nextMagic] = false
+		(*cAuth)(data).magic = d.nextMagic
+		return nil
+	case ioctlAuthMagic:
+		return d.authMagic((*cAuth)(data))
+	case ioctlGetUnique:
+		arg := (*cUnique)(data)
+		if arg.uniqueLen >= kernelSize(len(d.busID)) && len(d.busID) > 0 {
+			copy(unsafe.Slice((*byte)(userPtr(&arg.unique)), len(d.busID)), d.busID)
+		}
+		arg.uniqueLen = kernelSize(len(d.busID))
+		return nil
+	case ioctlSetVersion:
+		return d.setVersion((*cSetVersion)(data))
+	case ioctlSetMaster:
+		d.master = true
+		return nil
+	case ioctlDropMaster:
+		if !d.master {
+			return syscall.EINVAL
+		}
+		d.master = false
+		return nil
+	case ioctlModeGetResources:
+		return d.getResources((*cModeCardRes)(data))
+	case ioctlModeGetCRTC:
+		return d.getCRTC((*cModeCRTC)(data))
+	case ioctlModeSetCRTC:
+		return d.setCRTC((*cModeCRTC)(data))
+	case ioctlModeGetEncoder:
+		return d.getEncoder((*cModeGetEncoder)(data))
+	case ioctlModeGetConnector:
+		return d.getConnector((*cModeGetConnector)(data))
+	case ioctlModeGetProperty:
+		return d.getProperty((*cModeGetProperty)(data))
+	case ioctlModeSetProperty:
+		arg := (*cModeConnectorSetProperty)(data)
+		return d.setProperty(arg.connectorID, ModeObjectConnector, arg.propID, arg.value)
+	case ioctlModeObjGetProperties:
+		return d.objGetProperties((*cModeObjGetProperties)(data))
+	case ioctlModeObjSetProperty:
+		arg := (*cModeObjSetProperty)(data)
+		return d.setProperty(arg.objID, arg.objType, arg.propID, arg.value)
+	case ioctlModeGetPropBlob:
+		return d.getBlob((*cModeGetBlob)(data))
+	case ioctlModeCreatePropBlob:
+		return d.createBlob((*cModeCreateBlob)(data))
+	case ioctlModeDestroyPropBlob:
+		return d.destroyBlob((*cModeDestroyBlob)(data))
+	case ioctlModeGetFB:
+		return d.getFB((*cModeFBCmd)(data))
+	case ioctlModeAddFB:
+		return d.addFB((*cModeFBCmd)(data))
+	case ioctlModeAddFB2:
+		return d.addFB2((*cModeFBCmd2)(data))
+	case ioctlModeRmFB:
+		return d.rmFB(*(*uint32)(data))
+	case ioctlModeDirtyFB:
+		return d.dirtyFB((*cModeFBDirtyCmd)(data))
+	case ioctlModePageFlip:
+		return d.pageFlip((*cModeCRTCPageFlip)(data))
+	case ioctlModeCreateDumb:
+		return d.createDumb((*cModeCreateDumb)(data))
+	case ioctlModeMapDumb:
+		return d.mapDumb((*cModeMapDumb)(data))
+	case ioctlModeDestroyDumb:
+		return d.destroyDumb((*cModeDestroyDumb)(data))
+	case ioctlModeGetPlaneResources:
+		return d.getPlaneResources((*cModeGetPlaneRes)(data))
+	case ioctlModeGetPlane:
+		return d.getPlane((*cModeGetPlane)(data))
+	case ioctlModeAtomic:
+		return d.atomic((*cModeAtomic)(data))
+	case ioctlModeCreateLease:
+		return d.createLease((*cModeCreateLease)(data))
+	case ioctlModeListLessees:
+		arg := (*cModeListLessees)(data)
+		var ids []uint32
+		for id := range d.lessees {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		copyOut(&arg.lesseesPtr, &arg.countLessees, ids)
+		return nil
+	case ioctlModeGetLease:
+		// The lessor may use all objects.
+		arg := (*cModeGetLease)(data)
+		var ids []uint32
+		for _, list := range [][]uint32{d.crtcs, d.encoders, d.connectors, d.planes} {
+			ids = append(ids, list...)
+		}
+		copyOut(&arg.objectsPtr, &arg.countObjects, ids)
+		return nil
+	case ioctlModeRevokeLease:
+		arg := (*cModeRevokeLease)(data)
+		if !d.master {
+			return syscall.EACCES
+		}
+		if _, ok := d.lessees[arg.lesseeID]; !ok {
+			return syscall.ENOENT
+		}
+		delete(d.lessees, arg.lesseeID)
+		return nil
+	}
+	return syscall.EINVAL
+}
+
+// driverVersion returns the driver the device reports to VERSION.
+func (d *FakeDevice) driverVersion() SnapshotDriver {
+	if d.driver != nil {
+		return *d.driver
+	}
+	return SnapshotDriver{Name: "fake", Date: "20240101", Desc: "In-memory fake DRM device", Major: 1}
+}
+
+func (d *FakeDevice) version(arg *cVersion) error {
+	driver := d.driverVersion()
+	arg.major, arg.minor, arg.patchlevel = driver.Major, driver.Minor, driver.PatchLevel
+	copyString(&arg.name, &arg.namelen, driver.Name)
+	copyString(&arg.date, &arg.datelen, driver.Date)
+	copyString(&arg.desc, &arg.desclen, driver.Desc)
+	return nil
+}
+
+func (d *FakeDevice) authMagic(arg *cAuth) error {
+	if !d.master {
+		return syscall.EACCES
+	}
+	if _, ok := d.magics[arg.magic]; !ok || arg.magic == 0 {
+		return syscall.EINVAL
+	}
+	d.magics[arg.magic] = true
+	return nil
+}
+
+// setVersion checks the requested versions like the kernel does, which reports
+// the versions it implements whether or not they are accepted.
+func (d *FakeDevice) setVersion(arg *cSetVersion) error {
+	if !d.master {
+		return syscall.EACCES
+	}
+	driver := d.driverVersion()
+	var err error
+	if arg.diMajor != -1 {
+		if arg.diMajor != 1 || arg.diMinor < 0 || arg.diMinor > 4 {
+			err = syscall.EINVAL
+		} else if arg.diMinor >= 1 {
+			d.busID = "fake:0"
+		}
+	}
+	if err == nil && arg.ddMajor != -1 &&
+		(arg.ddMajor != driver.Major || arg.ddMinor < 0 || arg.ddMinor > driver.Minor) {
+		err = syscall.EINVAL
+	}
+	arg.diMajor, arg.diMinor, arg.ddMajor, arg.ddMinor = 1, 4, driver.Major, driver.Minor
+	return err
+}
+
+func (d *FakeDevice) setClientCap(arg *cSetClientCap) error {
+	if arg.capability < ClientCapStereo3D || arg.capability > ClientCapWritebackConnectors ||
+		arg.value > 1 {
+		return syscall.EINVAL
+	}
+	if arg.capability == ClientCapWritebackConnectors && d.caps[ClientCapAtomic] == 0 {
+		return syscall.EINVAL
+	}
+	d.caps[arg.capability] = arg.value
+	if arg.capability == ClientCapAtomic && arg.value != 0 {
+		d.caps[ClientCapUniversalPlanes] = 1
+	}
+	return nil
+}
+
+func (d *FakeDevice) getResources(arg *cModeCardRes) error {
+	fbs := make([]uint32, 0, len(d.fbs))
+	for id := range d.fbs {
+		fbs = append(fbs, id)
+	}
+	sort.Slice(fbs, func(i, j int) bool { return fbs[i] < fbs[j] })
+
+	copyOut(&arg.fbIDPtr, &arg.countFB, fbs)
+	copyOut(&arg.crtcIDPtr, &arg.countCRTC, d.crtcs)
+	copyOut(&arg.connectorIDPtr, &arg.countConnectors, d.connectors)
+	copyOut(&arg.encoderIDPtr, &arg.countEncoders, d.encoders)
+	arg.minWidth, arg.maxWidth, arg.minHeight, arg.maxHeight = d.limits[0], d.limits[1], d.limits[2], d.limits[3]
+	return nil
+}
+
+// object returns the object of the given kind, or ENOENT.
+func (d *FakeDevice) object(id, kind uint32) (*fakeObject, error) {
+	obj, ok := d.objects[id]
+	if !ok || (kind != ModeObjectAny && obj.kind != kind) {
+		return nil, syscall.ENOENT
+	}
+	return obj, nil
+}
+
+func (d *FakeDevice) mode(crtcID uint32) (cModeInfo, bool) {
+	blob, ok := d.blobs[uint32(d.named(crtcID, "MODE_ID"))]
+	if !ok || len(blob.data) < int(unsafe.Sizeof(cModeInfo{})) {
+		return cModeInfo{}, false
+	}
+	return *(*cModeInfo)(unsafe.Pointer(&blob.data[0])), true
+}
+
+func (d *FakeDevice) getCRTC(arg *cModeCRTC) error {
+	obj, err := d.object(arg.ID, ModeObjectCrtc)
+	if err != nil {
+		return err
+	}
+	*arg = *obj.crtc
+	if d.replay {
+		return nil
+	}
+	arg.cModeInfo, arg.ModeValid = cModeInfo{}, 0
+	if mode, ok := d.mode(arg.ID); ok {
+		arg.cModeInfo, arg.ModeValid = mode, 1
+	}
+	if plane := d.primaryPlane(arg.ID); plane != 0 && uint32(d.named(plane, "CRTC_ID")) == arg.ID {
+		arg.FBID = uint32(d.named(plane, "FB_ID"))
+		arg.X = uint32(d.named(plane, "SRC_X") >> 16)
+		arg.Y = uint32(d.named(plane, "SRC_Y") >> 16)
+	}
+	return nil
+}
+
+func (d *FakeDevice) setCRTC(arg *cModeCRTC) error {
+	if !d.master {
+		return syscall.EACCES
+	}
+	if _, err := d.object(arg.ID, ModeObjectCrtc); err != nil {
+		return err
+	}
+	conns := copyIn[uint32](&arg.setConnectorsPtr, arg.countConnectors)
+	for _, id := range conns {
+		if _, err := d.object(id, ModeObjectConnector); err != nil {
+			return err
+		}
+	}
+	plane := d.primaryPlane(arg.ID)
+	fbID := arg.FBID
+	if arg.ModeValid != 0 {
+		if fbID == 0 && plane != 0 {
+			fbID = uint32(d.named(plane, "FB_ID"))
+		}
+		if _, ok := d.fbs[fbID]; !ok {
+			return syscall.ENOENT
+		}
+		if len(conns) == 0 {
+			return syscall.EINVAL
+		}
+	}
+
+	for _, id := range d.connectors {
+		if uint32(d.named(id, "CRTC_ID")) == arg.ID {
+			d.setNamed(id, "CRTC_ID", 0)
+		}
+	}
+	if arg.ModeValid == 0 {
+		d.replaceBlob(arg.ID, "MODE_ID", 0)
+		d.setNamed(arg.ID, "ACTIVE", 0)
+		if plane != 0 {
+			d.setNamed(plane, "FB_ID", 0)
+			d.setNamed(plane, "CRTC_ID", 0)
+		}
+		return nil
+	}
+
+	mode := arg.cModeInfo
+	d.replaceBlob(arg.ID, "MODE_ID", uint64(d.newBlob(
+		unsafe.Slice((*byte)(unsafe.Pointer(&mode)), unsafe.Sizeof(mode)), false)))
+	d.setNamed(arg.ID, "ACTIVE", 1)
+	for _, id := range conns {
+		d.setNamed(id, "CRTC_ID", uint64(arg.ID))
+		// As in the kernel, the modeset retrains the link.
+		d.setNamed(id, "link-status", ModeLinkStatusGood)
+	}
+	if plane != 0 {
+		for name, value := range map[string]uint64{
+			"FB_ID": uint64(fbID), "CRTC_ID": uint64(arg.ID),
+			"SRC_X": uint64(arg.X) << 16, "SRC_Y": uint64(arg.Y) << 16,
+			"SRC_W": uint64(mode.HDisplay) << 16, "SRC_H": uint64(mode.VDisplay) << 16,
+			"CRTC_X": 0, "CRTC_Y": 0,
+			"CRTC_W": uint64(mode.HDisplay), "CRTC_H": uint64(mode.VDisplay),
+		} {
+			d.setNamed(plane, name, value)
+		}
+	}
+	return nil
+}
+
+func (d *FakeDevice) getEncoder(arg *cModeGetEncoder) error {
+	obj, err := d.object(arg.ID, ModeObjectEncoder)
+	if err != nil {
+		return err
+	}
+	*arg = *obj.encoder
+	if d.replay {
+		return nil
+	}
+	for _, id := range d.connectors {
+		if d.currentEncoder(id) == arg.ID {
+			arg.CRTCID = uint32(d.named(id, "CRTC_ID"))
+		}
+	}
+	return nil
+}
+
+// currentEncoder returns the encoder driving a connector, which is its first
+// encoder if the connector is in use.
+func (d *FakeDevice) currentEncoder(connectorID uint32) uint32 {
+	conn := d.objects[connectorID].connector
+	if d.named(connectorID, "CRTC_ID") == 0 || len(conn.encoders) == 0 {
+		return 0
+	}
+	return conn.encoders[0]
+}
+
+// visibleProps returns the properties of an object the client may see, which
+// excludes atomic properties unless it has ClientCapAtomic set.
+func (d *FakeDevice) visibleProps(obj *fakeObject) ([]uint32, []uint64) {
+	var (
+		ids    []uint32
+		values []uint64
+	)
+	for i, id := range obj.propIDs {
+		if d.props[id].Flags&ModePropAtomic != 0 && d.caps[ClientCapAtomic] == 0 {
+			continue
+		}
+		ids = append(ids, id)
+		values = append(values, obj.propValues[i])
+	}
+	return ids, values
+}
+
+func (d *FakeDevice) getConnector(arg *cModeGetConnector) error {
+	obj, err := d.object(arg.ID, ModeObjectConnector)
+	if err != nil {
+		return err
+	}
+	conn := obj.connector
+	ids, values := d.visibleProps(obj)
+	countProps := arg.countProps
+
+	copyOut(&arg.encodersPtr, &arg.countEncoders, conn.encoders)
+	copyOut(&arg.modesPtr, &arg.countModes, conn.modes)
+	copyOut(&arg.propsPtr, &arg.countProps, ids)
+	copyOut(&arg.propValuesPtr, &countProps, values)
+
+	arg.EncoderID = conn.EncoderID
+	if !d.replay {
+		arg.EncoderID = d.currentEncoder(arg.ID)
+	}
+	arg.Type, arg.TypeID, arg.Connection = conn.Type, conn.TypeID, conn.Connection
+	arg.MMWidth, arg.MMHeight, arg.Subpixel = conn.MMWidth, conn.MMHeight, conn.Subpixel
+	return nil
+}
+
+func (d *FakeDevice) getProperty(arg *cModeGetProperty) error {
+	prop, ok := d.props[arg.propID]
+	if !ok {
+		return syscall.ENOENT
+	}
+	arg.flags = prop.Flags
+	arg.name = [propNameLen]byte{}
+	copy(arg.name[:propNameLen-1], prop.Name)
+
+	var enums []cModePropertyEnum
+	for _, enum := range prop.Enums {
+		e := cModePropertyEnum{value: enum.Value}
+		copy(e.name[:propNameLen-1], enum.Name)
+		enums = append(enums, e)
+	}
+	copyOut(&arg.valuesPtr, &arg.countValues, prop.Values)
+	copyOut(&arg.enumBlobPtr, &arg.countEnumBlobs, enums)
+	return nil
+}
+
+func (d *FakeDevice) objGetProperties(arg *cModeObjGetProperties) error {
+	obj, err := d.object(arg.objID, arg.objType)
+	if err != nil {
+		return err
+	}
+	ids, values := d.visibleProps(obj)
+	countProps := arg.countProps
+	copyOut(&arg.propsPtr, &arg.countProps, ids)
+	copyOut(&arg.propValuesPtr, &countProps, values)
+	arg.objType = obj.kind
+	return nil
+}
+
+// checkValue returns whether a value is valid for a property that the client may
+// set.
+func (d *FakeDevice) checkValue(prop *ModeProperty, value uint64) error {
+	if prop.Flags&ModePropImmutable != 0 {
+		return syscall.EINVAL
+	}
+	switch {
+	case prop.Flags&ModePropRange != 0:
+		if value < prop.Values[0] || value > prop.Values[1] {
+			return syscall.EINVAL
+		}
+	case prop.Flags&ModePropExtendedType == ModePropSignedRange:
+		if int64(value) < int64(prop.Values[0]) || int64(value) > int64(prop.Values[1]) {
+			return syscall.EINVAL
+		}
+	case prop.Flags&ModePropEnum != 0:
+		for _, v := range prop.Values {
+			if v == value {
+				return nil
+			}
+		}
+		return syscall.EINVAL
+	case prop.Flags&ModePropBlob != 0:
+		if _, ok := d.blobs[uint32(value)]; value != 0 && !ok {
+			return syscall.ENOENT
+		}
+	case prop.Flags&ModePropExtendedType == ModePropObject:
+		if value == 0 {
+			return nil
+		}
+		if uint32(prop.Values[0]) == ModeObjectFb {
+			if _, ok := d.fbs[uint32(value)]; !ok {
+				return syscall.ENOENT
+			}
+		} else if _, err := d.object(uint32(value), uint32(prop.Values[0])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *FakeDevice) setProperty(objID, kind, propID uint32, value uint64) error {
+	if !d.master {
+		return syscall.EACCES
+	}
+	obj, err := d.object(objID, kind)
+	if err != nil {
+		return err
+	}
+	var attached bool
+	for _, id := range obj.propIDs {
+		attached = attached || id == propID
+	}
+	if !attached {
+		return syscall.EINVAL
+	}
+	if err := d.checkValue(d.props[propID], value); err != nil {
+		return err
+	}
+	d.setValue(objID, propID, value)
+	return nil
+}
+
+func (d *FakeDevice) getBlob(arg *cModeGetBlob) error {
+	blob, ok := d.blobs[arg.blobID]
+	if !ok {
+		return syscall.ENOENT
+	}
+	if arg.data != 0 && int(arg.length) == len(blob.data) {
+		copy(unsafe.Slice((*byte)(userPtr(&arg.data)), len(blob.data)), blob.data)
+	}
+	arg.length = uint32(len(blob.data))
+	return nil
+}
+
+func (d *FakeDevice) createBlob(arg *cModeCreateBlob) error {
+	if arg.length == 0 {
+		return syscall.EINVAL
+	}
+	arg.blobID = d.newBlob(unsafe.Slice((*byte)(userPtr(&arg.data)), arg.length), true)
+	return nil
+}
+
+func (d *FakeDevice) destroyBlob(arg *cModeDestroyBlob) error {
+	blob, ok := d.blobs[arg.blobID]
+	if !ok {
+		return syscall.ENOENT
+	}
+	if !blob.user {
+		return syscall.EPERM
+	}
+	// Properties keep referring to the blob until they are changed, as the
+	// kernel keeps a reference to it.
+	if d.blobInUse(arg.blobID) {
+		blob.user = false
+	} else {
+		delete(d.blobs, arg.blobID)
+	}
+	return nil
+}
+
+// blobInUse returns whether a blob property of any object holds the blob.
+func (d *FakeDevice) blobInUse(blobID uint32) bool {
+	for _, obj := range d.objects {
+		for i, propID := range obj.propIDs {
+			if d.props[propID].Flags&ModePropBlob != 0 && obj.propValues[i] == uint64(blobID) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (d *FakeDevice) getFB(arg *cModeFBCmd) error {
+	fb, ok := d.fbs[arg.ID]
+	if !ok {
+		return syscall.ENOENT
+	}
+	arg.Width, arg.Height, arg.Pitch = fb.Width, fb.Height, fb.Pitches[0]
+	arg.Bpp, arg.Depth, arg.Handle = fb.bpp, fb.depth, fb.Handles[0]
+	return nil
+}
+
+func (d *FakeDevice) addFB(arg *cModeFBCmd) error {
+	var format uint32
+	switch {
+	case arg.Bpp == 32 && arg.Depth == 24:
+		format = FormatXRGB8888
+	case arg.Bpp == 32 && arg.Depth == 32:
+		format = FormatARGB8888
+	case arg.Bpp == 32 && arg.Depth == 30:
+		format = FormatXRGB2101010
+	case arg.Bpp == 16 && arg.Depth == 16:
+		format = FormatRGB565
+	case arg.Bpp == 24 && arg.Depth == 24:
+		format = FormatRGB888
+	default:
+		return syscall.EINVAL
+	}
+	fb := cModeFBCmd2{
+		Width:       arg.Width,
+		Height:      arg.Height,
+		PixelFormat: format,
+		Handles:     [4]uint32{arg.Handle},
+		Pitches:     [4]uint32{arg.Pitch},
+	}
+	if err := d.addFB2(&fb); err != nil {
+		return err
+	}
+	d.fbs[fb.ID].bpp, d.fbs[fb.ID].depth = arg.Bpp, arg.Depth
+	arg.ID = fb.ID
+	return nil
+}
+
+func (d *FakeDevice) addFB2(arg *cModeFBCmd2) error {
+	info := LookupFormat(arg.PixelFormat)
+	if info == nil || arg.Width == 0 || arg.Height == 0 {
+		return syscall.EINVAL
+	}
+	for i := 0; i < int(info.NumPlanes); i++ {
+		dumb, ok := d.dumbs[arg.Handles[i]]
+		if !ok {
+			return syscall.ENOENT
+		}
+		_, height := info.PlaneSize(i, arg.Width, arg.Height)
+		if arg.Pitches[i] == 0 || uint64(arg.Offsets[i])+uint64(arg.Pitches[i])*uint64(height) > dumb.Size {
+			return syscall.EINVAL
+		}
+	}
+	d.nextID++
+	arg.ID = d.nextID
+	d.fbs[arg.ID] = &fakeFramebuffer{cModeFBCmd2: *arg, bpp: info.BPP(), depth: uint32(info.Depth)}
+	return nil
+}
+
+// dirtyFB records the damage of a framebuffer. Without rectangles, the whole
+// framebuffer is damaged.
+func (d *FakeDevice) dirtyFB(arg *cModeFBDirtyCmd) error {
+	fb, ok := d.fbs[arg.fbID]
+	if !ok {
+		return syscall.ENOENT
+	}
+	if arg.numClips > ModeFBDirtyMaxClips {
+		return syscall.EINVAL
+	}
+	if arg.numClips == 0 {
+		fb.dirty = append(fb.dirty, image.Rect(0, 0, int(fb.Width), int(fb.Height)))
+		return nil
+	}
+	for _, c := range unsafe.Slice((*cClipRect)(userPtr(&arg.clipsPtr)), arg.numClips) {
+		fb.dirty = append(fb.dirty, image.Rect(int(c.x1), int(c.y1), int(c.x2), int(c.y2)))
+	}
+	return nil
+}
+
+func (d *FakeDevice) rmFB(id uint32) error {
+	if _, ok := d.fbs[id]; !ok {
+		return syscall.ENOENT
+	}
+	// Like the kernel, disable the planes scanning out the framebuffer.
+	for _, plane := range d.planes {
+		if uint32(d.named(plane, "FB_ID")) == id {
+			d.setNamed(plane, "FB_ID", 0)
+			d.setNamed(plane, "CRTC_ID", 0)
+		}
+	}
+	delete(d.fbs, id)
+	return nil
+}
+
+func (d *FakeDevice) pageFlip(arg *cModeCRTCPageFlip) error {
+	if !d.master {
+		return syscall.EACCES
+	}
+	if _, err := d.object(arg.crtcID, ModeObjectCrtc); err != nil {
+		return err
+	}
+	if _, ok := d.fbs[arg.fbID]; !ok {
+		return syscall.ENOENT
+	}
+	plane := d.primaryPlane(arg.crtcID)
+	if d.named(arg.crtcID, "ACTIVE") == 0 || plane == 0 {
+		return syscall.EINVAL
+	}
+	d.setNamed(plane, "FB_ID", uint64(arg.fbID))
+	if arg.flags&ModePageFlipEvent != 0 {
+		d.queueFlip(arg.crtcID, arg.userData)
+	}
+	return nil
+}
+
+// queueFlip queues an EventFlipComplete for a CRTC.
+func (d *FakeDevice) queueFlip(crtcID uint32, userData uint64) {
+	d.sequences[crtcID]++
+	now := time.Now()
+	ev := cEventVblank{
+		cEvent:   cEvent{typ: EventFlipComplete, length: uint32(unsafe.Sizeof(cEventVblank{}))},
+		userData: userData,
+		tvSec:    uint32(now.Unix()),
+		tvUsec:   uint32(now.Nanosecond() / 1000),
+		sequence: d.sequences[crtcID],
+		crtcID:   crtcID,
+	}
+	d.events = append(d.events, unsafe.Slice((*byte)(unsafe.Pointer(&ev)), unsafe.Sizeof(ev))...)
+}
+
+func (d *FakeDevice) createDumb(arg *cModeCreateDumb) error {
+	if arg.Width == 0 || arg.Height == 0 || arg.Bpp == 0 {
+		return syscall.EINVAL
+	}
+	// Pitches are aligned to 64 bytes, and sizes to pages, as many drivers do.
+	arg.Pitch = (arg.Width*((arg.Bpp+7)/8) + 63) &^ 63
+	arg.Size = (uint64(arg.Pitch)*uint64(arg.Height) + 4095) &^ 4095
+	d.nextHandle++
+	arg.Handle = d.nextHandle
+	d.dumbs[arg.Handle] = &fakeDumb{
+		cModeCreateDumb: *arg,
+		offset:          uint64(arg.Handle) << 32,
+		data:            make([]byte, arg.Size),
+	}
+	return nil
+}
+
+func (d *FakeDevice) mapDumb(arg *cModeMapDumb) error {
+	dumb, ok := d.dumbs[arg.handle]
+	if !ok {
+		return syscall.ENOENT
+	}
+	arg.offset = dumb.offset
+	return nil
+}
+
+func (d *FakeDevice) destroyDumb(arg *cModeDestroyDumb) error {
+	if _, ok := d.dumbs[arg.handle]; !ok {
+		return syscall.ENOENT
+	}
+	delete(d.dumbs, arg.handle)
+	return nil
+}
+
+func (d *FakeDevice) getPlaneResources(arg *cModeGetPlaneRes) error {
+	var ids []uint32
+	for _, id := range d.planes {
+		if d.caps[ClientCapUniversalPlanes] != 0 || d.named(id, "type") == PlaneTypeOverlay {
+			ids = append(ids, id)
+		}
+	}
+	copyOut(&arg.planeIDPtr, &arg.countPlanes, ids)
+	return nil
+}
+
+func (d *FakeDevice) getPlane(arg *cModeGetPlane) error {
+	obj, err := d.object(arg.ID, ModeObjectPlane)
+	if err != nil {
+		return err
+	}
+	arg.CRTCID = uint32(d.named(arg.ID, "CRTC_ID"))
+	arg.FBID = uint32(d.named(arg.ID, "FB_ID"))
+	arg.GammaSize = obj.plane.gammaSize
+	if d.replay {
+		arg.CRTCID, arg.FBID = obj.plane.crtcID, obj.plane.fbID
+	}
+	arg.PossibleCRTCs = obj.plane.possibleCRTCs
+	copyOut(&arg.formatTypePtr, &arg.countFormatTypes, obj.plane.formats)
+	return nil
+}
+
+func (d *FakeDevice) atomic(arg *cModeAtomic) error {
+	if d.caps[ClientCapAtomic] == 0 {
+		return syscall.EINVAL
+	}
+	const known = ModeAtomicTestOnly | ModeAtomicNonblock | ModeAtomicAllowModeset |
+		ModePageFlipEvent | ModePageFlipAsync
+	if arg.flags&^known != 0 ||
+		(arg.flags&ModeAtomicTestOnly != 0 && arg.flags&ModePageFlipEvent != 0) {
+		return syscall.EINVAL
+	}
+	if !d.master {
+		return syscall.EACCES
+	}
+
+	type change struct {
+		objID, propID uint32
+		value         uint64
+	}
+	var (
+		objs       = copyIn[uint32](&arg.objsPtr, arg.countObjs)
+		countProps = copyIn[uint32](&arg.countPropsPtr, arg.countObjs)
+		total      uint32
+		changes    []change
+	)
+	for _, n := range countProps {
+		total += n
+	}
+	props := copyIn[uint32](&arg.propsPtr, total)
+	values := copyIn[uint64](&arg.propValuesPtr, total)
+	if len(objs) != int(arg.countObjs) || len(props) != int(total) || len(values) != int(total) {
+		return syscall.EFAULT
+	}
+
+	modeset := false
+	i := 0
+	for n, objID := range objs {
+		obj, err := d.object(objID, ModeObjectAny)
+		if err != nil {
+			return err
+		}
+		for ; countProps[n] > 0; countProps[n]-- {
+			propID, value := props[i], values[i]
+			i++
+			p, ok := d.props[propID]
+			if !ok || d.propID(objID, p.Name) != propID {
+				return syscall.ENOENT
+			}
+			if err := d.checkValue(p, value); err != nil {
+				return err
+			}
+			name := p.Name
+			if d.value(objID, propID) != value && ((obj.kind == ModeObjectCrtc &&
+				(name == "ACTIVE" || name == "MODE_ID")) ||
+				(obj.kind == ModeObjectConnector && name == "CRTC_ID")) {
+				modeset = true
+			}
+			changes = append(changes, change{objID, propID, value})
+		}
+	}
+	if modeset && arg.flags&ModeAtomicAllowModeset == 0 {
+		return syscall.EINVAL
+	}
+
+	// Apply the changes, then check the resulting state, restoring the old one
+	// if it is invalid or only tested.
+	saved := make(map[uint32][]uint64)
+	for _, c := range changes {
+		if _, ok := saved[c.objID]; !ok {
+			saved[c.objID] = append([]uint64(nil), d.objects[c.objID].propValues...)
+		}
+		d.setValue(c.objID, c.propID, c.value)
+	}
+	err := d.checkState()
+	if err != nil || arg.flags&ModeAtomicTestOnly != 0 {
+		for id, values := range saved {
+			d.objects[id].propValues = values
+		}
+		return err
+	}
+
+	for _, id := range d.connectors {
+		if err := d.queueWriteback(id); err != nil {
+			return err
+		}
+	}
+
+	if arg.flags&ModePageFlipEvent != 0 {
+		crtcs := make(map[uint32]bool)
+		for _, c := range changes {
+			switch d.objects[c.objID].kind {
+			case ModeObjectCrtc:
+				crtcs[c.objID] = true
+			case ModeObjectPlane, ModeObjectConnector:
+				if crtc := uint32(d.named(c.objID, "CRTC_ID")); crtc != 0 {
+					crtcs[crtc] = true
+				}
+			}
+		}
+		for _, id := range d.crtcs {
+			if crtcs[id] {
+				d.queueFlip(id, arg.userData)
+			}
+		}
+	}
+	return nil
+}
+
+// checkState returns EINVAL if the current atomic state is inconsistent.
+func (d *FakeDevice) checkState() error {
+	for _, id := range d.crtcs {
+		if d.named(id, "ACTIVE") != 0 && d.named(id, "MODE_ID") == 0 {
+			return syscall.EINVAL
+		}
+	}
+	for _, id := range d.planes {
+		fb, crtc := d.named(id, "FB_ID"), d.named(id, "CRTC_ID")
+		if (fb == 0) != (crtc == 0) {
+			return syscall.EINVAL
+		}
+		if crtc == 0 {
+			continue
+		}
+		// Planes may stay on an inactive CRTC, but not on a disabled one.
+		if d.objects[id].plane.possibleCRTCs&d.crtcMask([]uint32{uint32(crtc)}) == 0 ||
+			d.named(uint32(crtc), "MODE_ID") == 0 {
+			return syscall.EINVAL
+		}
+		if fb := d.fbs[uint32(fb)]; fb != nil {
+			// The source rectangle, in 16.16 fixed point, must lie within the
+			// framebuffer.
+			if d.named(id, "SRC_X")+d.named(id, "SRC_W") > uint64(fb.Width)<<16 ||
+				d.named(id, "SRC_Y")+d.named(id, "SRC_H") > uint64(fb.Height)<<16 {
+				return syscall.ENOSPC
+			}
+		}
+	}
+	for _, id := range d.connectors {
+		if err := d.checkWriteback(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkWriteback returns EINVAL if the writeback job of a connector can not be
+// run: it needs an active CRTC with a primary plane, and a framebuffer of the
+// size of the mode in one of the formats of the connector and of the plane.
+func (d *FakeDevice) checkWriteback(connID uint32) error {
+	if d.propID(connID, "WRITEBACK_FB_ID") == 0 {
+		return nil
+	}
+	fbID, fence := d.named(connID, "WRITEBACK_FB_ID"), d.named(connID, "WRITEBACK_OUT_FENCE_PTR")
+	if fbID == 0 {
+		if fence != 0 {
+			return syscall.EINVAL
+		}
+		return nil
+	}
+	crtc := uint32(d.named(connID, "CRTC_ID"))
+	mode, ok := d.mode(crtc)
+	if !ok || d.named(crtc, "ACTIVE") == 0 {
+		return syscall.EINVAL
+	}
+	fb := d.fbs[uint32(fbID)]
+	if fb == nil || fb.Width != uint32(mode.HDisplay) || fb.Height != uint32(mode.VDisplay) {
+		return syscall.EINVAL
+	}
+	src := d.fbs[uint32(d.named(d.primaryPlane(crtc), "FB_ID"))]
+	if src == nil || src.PixelFormat != fb.PixelFormat {
+		return syscall.EINVAL
+	}
+	if formats, ok := d.blobs[uint32(d.named(connID, "WRITEBACK_PIXEL_FORMATS"))]; ok {
+		for i := 0; i+4 <= len(formats.data); i += 4 {
+			if binary.LittleEndian.Uint32(formats.data[i:]) == fb.PixelFormat {
+				return nil
+			}
+		}
+	}
+	return syscall.EINVAL
+}
+
+// queueWriteback takes the writeback job set on a connector by a commit, whose
+// properties do not keep their values, and runs it unless jobs are held back.
+func (d *FakeDevice) queueWriteback(connID uint32) error {
+	fbID := uint32(d.named(connID, "WRITEBACK_FB_ID"))
+	if fbID == 0 {
+		return nil
+	}
+	ptr := d.named(connID, "WRITEBACK_OUT_FENCE_PTR")
+	d.setNamed(connID, "WRITEBACK_FB_ID", 0)
+	d.setNamed(connID, "WRITEBACK_OUT_FENCE_PTR", 0)
+
+	plane := d.primaryPlane(uint32(d.named(connID, "CRTC_ID")))
+	job := fakeWriteback{src: uint32(d.named(plane, "FB_ID")), dst: fbID,
+		x: uint32(d.named(plane, "SRC_X") >> 16), y: uint32(d.named(plane, "SRC_Y") >> 16), fence: -1}
+	if ptr != 0 {
+		// A pipe stands in for the sync file: its read end polls readable
+		// once the write end is closed with a byte written.
+		var p [2]int
+		if err := syscall.Pipe2(p[:], syscall.O_CLOEXEC); err != nil {
+			return err
+		}
+		*(*int32)(userPtr(&ptr)) = int32(p[0])
+		job.fence = p[1]
+	}
+	if d.holdWriteback {
+		d.writebacks = append(d.writebacks, job)
+	} else {
+		d.runWriteback(job)
+	}
+	return nil
+}
+
+// runWriteback copies the pixels of a writeback job, and signals its fence.
+func (d *FakeDevice) runWriteback(job fakeWriteback) {
+	// The framebuffers may have been removed since the commit.
+	src, dst := d.fbs[job.src], d.fbs[job.dst]
+	if src != nil && dst != nil && job.x < src.Width {
+		from, to := d.dumbs[src.Handles[0]], d.dumbs[dst.Handles[0]]
+		cpp := LookupFormat(dst.PixelFormat).BPP() / 8
+		width := dst.Width
+		if src.Width-job.x < width {
+			width = src.Width - job.x
+		}
+		for y := uint32(0); from != nil && to != nil && y < dst.Height && job.y+y < src.Height; y++ {
+			s := from.data[src.Offsets[0]+(job.y+y)*src.Pitches[0]+job.x*cpp:]
+			copy(to.data[dst.Offsets[0]+y*dst.Pitches[0]:][:width*cpp], s[:width*cpp])
+		}
+	}
+	if job.fence >= 0 {
+		syscall.Write(job.fence, []byte{1})
+		syscall.Close(job.fence)
+	}
+}
+
+func (d *FakeDevice) createLease(arg *cModeCreateLease) error {
+	if !d.master {
+		return syscall.EACCES
+	}
+	objects := copyIn[uint32](&arg.objectIDs, arg.objectCount)
+	if len(objects) == 0 {
+		return syscall.EINVAL
+	}
+	for _, id := range objects {
+		if _, err := d.object(id, ModeObjectAny); err != nil {
+			return err
+		}
+	}
+	d.nextID++
+	d.lessees[d.nextID] = objects
+	// Leases are only recorded; there is no file for the lessee.
+	arg.lesseeID, arg.fd = d.nextID, ^uint32(0)
+	return nil
+}
+
+// Mmap implements Backend, returning the memory of the dumb buffer at offset.
+func (d *FakeDevice) Mmap(offset int64, length int) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, dumb := range d.dumbs {
+		if dumb.offset == uint64(offset) {
+			if length > len(dumb.data) {
+				return nil, syscall.EINVAL
+			}
+			return dumb.data[:length], nil
+		}
+	}
+	return nil, syscall.EINVAL
+}
+
+// Munmap implements Backend.
+func (d *FakeDevice) Munmap(b []byte) error {
+	return nil
+}
+
+// Read implements Backend, returning the pending events. Rather than block, it
+// fails with EAGAIN if there are none.
+func (d *FakeDevice) Read(b []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.events) == 0 {
+		return 0, syscall.EAGAIN
+	}
+	// Only whole events are returned.
+	n := 0
+	for n < len(d.events) {
+		length := int((*cEvent)(unsafe.Pointer(&d.events[n])).length)
+		if n+length > len(b) {
+			break
+		}
+		n += length
+	}
+	copy(b, d.events[:n])
+	d.events = d.events[n:]
+	return n, nil
+}
+
+// Close implements Backend, dropping the held writeback jobs without signaling
+// their fences.
+func (d *FakeDevice) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, job := range d.writebacks {
+		if job.fence >= 0 {
+			syscall.Close(job.fence)
+		}
+	}
+	d.writebacks = nil
+	return nil
+}
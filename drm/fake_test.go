@@ -0,0 +1,416 @@
+package drm
+
+import (
+	"errors"
+	"image"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+// fakeSetup is a fake device with one CRTC driving an HDMI connector through
+// one encoder.
+type fakeSetup struct {
+	dev       *FakeDevice
+	card      *Card
+	crtc      uint32
+	encoder   uint32
+	connector uint32
+}
+
+func newFakeSetup(t *testing.T) *fakeSetup {
+	t.Helper()
+	dev := NewFakeDevice()
+	s := fakeSetup{dev: dev, card: NewWithBackend(dev)}
+	s.crtc = dev.AddCRTC()
+	s.encoder, s.connector = dev.AddOutput(ModeConnectorHDMIA, []uint32{s.crtc})
+	dev.Plug(s.connector, []ModeInfo{FakeMode(1920, 1080, 60), FakeMode(1280, 720, 60)}, 600, 340, nil)
+	return &s
+}
+
+// framebuffer creates a framebuffer backed by a dumb buffer.
+func (s *fakeSetup) framebuffer(t *testing.T, width, height uint32) uint32 {
+	t.Helper()
+	buf, err := s.card.ModeCreateDumb(height, width, 32)
+	if err != nil {
+		t.Fatalf("create dumb: %s", err)
+	}
+	fb, err := s.card.ModeAddFramebuffer2(width, height, FormatXRGB8888, 0,
+		[4]uint32{buf.Handle}, [4]uint32{buf.Pitch}, [4]uint32{}, [4]uint64{})
+	if err != nil {
+		t.Fatalf("add framebuffer: %s", err)
+	}
+	return fb.ID
+}
+
+func (s *fakeSetup) modeset(t *testing.T, fbID uint32) {
+	t.Helper()
+	conn, err := s.card.ModeGetConnector(s.connector)
+	if err != nil {
+		t.Fatalf("get connector: %s", err)
+	}
+	set := ModeCRTC{cModeCRTC: cModeCRTC{ID: s.crtc, FBID: fbID, ModeValid: 1,
+		cModeInfo: conn.Modes[0].cModeInfo}, Name: conn.Modes[0].Name, SetConnectors: []uint32{s.connector}}
+	if err := s.card.ModeSetCRTC(set); err != nil {
+		t.Fatalf("set crtc: %s", err)
+	}
+}
+
+func TestFakeResources(t *testing.T) {
+	s := newFakeSetup(t)
+
+	res, err := s.card.ModeGetResources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res.CRTCIDs, []uint32{s.crtc}) ||
+		!reflect.DeepEqual(res.EncoderIDs, []uint32{s.encoder}) ||
+		!reflect.DeepEqual(res.ConnectorIDs, []uint32{s.connector}) || len(res.FBIDs) != 0 {
+		t.Errorf("unexpected resources %+v", res)
+	}
+
+	conn, err := s.card.ModeGetConnector(s.connector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.Connection != ModeConnected || conn.Type != ModeConnectorHDMIA || conn.TypeID != 1 ||
+		conn.MMWidth != 600 {
+		t.Errorf("unexpected connector %+v", conn.cModeGetConnector)
+	}
+	if len(conn.Modes) != 2 || conn.Modes[0].Name != "1920x1080" ||
+		conn.Modes[0].Type&ModeTypePreferred == 0 || conn.Modes[1].Type&ModeTypePreferred != 0 {
+		t.Errorf("unexpected modes %+v", conn.Modes)
+	}
+	if !reflect.DeepEqual(conn.EncoderIDs, []uint32{s.encoder}) {
+		t.Errorf("unexpected encoders %v", conn.EncoderIDs)
+	}
+
+	ver, err := s.card.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ver.Name != "fake" {
+		t.Errorf("unexpected driver name %q", ver.Name)
+	}
+}
+
+func TestFakeAtomicPropertiesHidden(t *testing.T) {
+	s := newFakeSetup(t)
+
+	props, err := s.card.ObjectProperties(s.connector, ModeObjectConnector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := props["CRTC_ID"]; ok {
+		t.Error("atomic property visible without ClientCapAtomic")
+	}
+	if prop, ok := props["DPMS"]; !ok || prop.Flags&ModePropEnum == 0 || len(prop.Enums) != 4 {
+		t.Errorf("unexpected DPMS property %+v", prop)
+	}
+
+	if err := s.card.SetClientCap(ClientCapAtomic, 1); err != nil {
+		t.Fatal(err)
+	}
+	if props, err = s.card.ObjectProperties(s.connector, ModeObjectConnector); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := props["CRTC_ID"]; !ok {
+		t.Error("atomic property hidden with ClientCapAtomic")
+	}
+}
+
+func TestFakeModeset(t *testing.T) {
+	s := newFakeSetup(t)
+	fb := s.framebuffer(t, 1920, 1080)
+	s.modeset(t, fb)
+
+	crtc, err := s.card.ModeGetCRTC(s.crtc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crtc.ModeValid != 1 || crtc.FBID != fb || crtc.HDisplay != 1920 || crtc.Name != "1920x1080" {
+		t.Errorf("unexpected crtc %+v", crtc)
+	}
+	conn, err := s.card.ModeGetConnector(s.connector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.EncoderID != s.encoder {
+		t.Errorf("connector driven by encoder %d, want %d", conn.EncoderID, s.encoder)
+	}
+	enc, err := s.card.ModeGetEncoder(s.encoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enc.CRTCID != s.crtc {
+		t.Errorf("encoder driving crtc %d, want %d", enc.CRTCID, s.crtc)
+	}
+
+	// Disabling the CRTC detaches its connectors.
+	if err := s.card.ModeSetCRTC(ModeCRTC{cModeCRTC: cModeCRTC{ID: s.crtc}}); err != nil {
+		t.Fatal(err)
+	}
+	if crtc, err = s.card.ModeGetCRTC(s.crtc); err != nil {
+		t.Fatal(err)
+	}
+	if crtc.ModeValid != 0 || crtc.FBID != 0 {
+		t.Errorf("crtc still enabled: %+v", crtc)
+	}
+}
+
+func TestFakeErrors(t *testing.T) {
+	s := newFakeSetup(t)
+
+	_, err := s.card.ModeGetConnector(1234)
+	if !errors.Is(err, ErrNoSuchObject) || !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("got %v, want ErrNoSuchObject", err)
+	}
+	var ioctlErr *IoctlError
+	if !errors.As(err, &ioctlErr) || ioctlErr.Op != "MODE_GETCONNECTOR" || ioctlErr.ObjectID != 1234 {
+		t.Errorf("unexpected error %#v", err)
+	}
+
+	if err := s.card.SetClientCap(1234, 1); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("got %v, want ErrNotSupported", err)
+	}
+
+	fb := s.framebuffer(t, 1920, 1080)
+	if err := s.card.DropMaster(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.card.ModePageFlip(s.crtc, fb, 0, 0); !errors.Is(err, ErrNotMaster) {
+		t.Errorf("got %v, want ErrNotMaster", err)
+	}
+}
+
+func TestFakeBlobs(t *testing.T) {
+	s := newFakeSetup(t)
+
+	id, err := s.card.ModeCreatePropBlob([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := s.card.ModeGetBlob(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(blob.Data) != "hello" {
+		t.Errorf("got blob %q", blob.Data)
+	}
+	if err := s.card.ModeDestroyPropBlob(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.card.ModeGetBlob(id); !errors.Is(err, ErrNoSuchObject) {
+		t.Errorf("got %v for destroyed blob", err)
+	}
+
+	s.dev.SetBlobProperty(s.connector, "TILE", []byte("1:1:2:1:1:0:1920:2160"))
+	tile, err := s.card.ConnectorTile(s.connector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tile == nil || tile.NumHTiles != 2 || tile.HLoc != 1 || tile.VSize != 2160 {
+		t.Errorf("unexpected tile %+v", tile)
+	}
+}
+
+func TestFakeDumbBuffer(t *testing.T) {
+	s := newFakeSetup(t)
+
+	buf, err := s.card.ModeCreateDumb(4, 4, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.card.MmapDumb(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[0] = 0xff
+	data, ok := s.dev.Dumb(buf.Handle)
+	if !ok || data[0] != 0xff {
+		t.Error("write to mapped dumb buffer not visible to the device")
+	}
+	if err := s.card.Munmap(b); err != nil {
+		t.Fatal(err)
+	}
+
+	fb, err := s.card.ModeAddFramebuffer(4, 4, buf.Pitch, 32, 24, buf.Handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.card.ModeGetFramebuffer(fb.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Width != 4 || got.Bpp != 32 || got.Depth != 24 || got.Handle != buf.Handle {
+		t.Errorf("unexpected framebuffer %+v", got)
+	}
+	if err := s.card.ModeRemoveFramebuffer(fb.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.card.ModeDestroyDumb(buf.Handle); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.card.ModeDestroyDumb(buf.Handle); !errors.Is(err, ErrNoSuchObject) {
+		t.Errorf("got %v destroying a destroyed buffer", err)
+	}
+}
+
+func TestFakePageFlip(t *testing.T) {
+	s := newFakeSetup(t)
+	fb := s.framebuffer(t, 1920, 1080)
+	s.modeset(t, fb)
+
+	next := s.framebuffer(t, 1920, 1080)
+	if err := s.card.ModePageFlip(s.crtc, next, ModePageFlipEvent, 42); err != nil {
+		t.Fatal(err)
+	}
+	events, err := s.card.ReadEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Type != EventFlipComplete || events[0].UserData != 42 ||
+		events[0].CRTCID != s.crtc || events[0].Sequence != 1 {
+		t.Errorf("unexpected events %+v", events)
+	}
+	crtc, err := s.card.ModeGetCRTC(s.crtc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crtc.FBID != next {
+		t.Errorf("crtc shows framebuffer %d, want %d", crtc.FBID, next)
+	}
+}
+
+func TestFakeAtomic(t *testing.T) {
+	s := newFakeSetup(t)
+	if err := s.card.SetClientCap(ClientCapAtomic, 1); err != nil {
+		t.Fatal(err)
+	}
+	s.modeset(t, s.framebuffer(t, 1920, 1080))
+
+	// Turning the CRTC off is a modeset.
+	err := s.card.SetCRTCPower(s.crtc, PowerOff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state, err := s.card.CRTCPower(s.crtc); err != nil || state != PowerOff {
+		t.Errorf("got %v, %v, want Off", state, err)
+	}
+
+	props, err := s.card.ObjectProperties(s.crtc, ModeObjectCrtc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	active, _ := props.ID("ACTIVE")
+	var req AtomicRequest
+	req.AddProperty(s.crtc, active, 1)
+	if err := s.card.ModeAtomicCommit(&req, 0, 0); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("got %v for modeset without ALLOW_MODESET", err)
+	}
+	if err := s.card.ModeAtomicCommit(&req, ModeAtomicTestOnly|ModeAtomicAllowModeset, 0); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := s.card.CRTCPower(s.crtc); state != PowerOff {
+		t.Error("TEST_ONLY commit was applied")
+	}
+
+	var unknown AtomicRequest
+	unknown.AddProperty(s.crtc, 1234, 1)
+	if err := s.card.ModeAtomicCommit(&unknown, ModeAtomicAllowModeset, 0); !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("got %v for an unknown property", err)
+	}
+}
+
+func TestFakeLeases(t *testing.T) {
+	s := newFakeSetup(t)
+
+	lease, err := s.card.ModeCreateLease([]uint32{s.crtc, s.connector}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lessees, err := s.card.ModeListLessees()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lessees, []uint32{lease.ID}) {
+		t.Errorf("got lessees %v", lessees)
+	}
+	if err := s.card.ModeRevokeLease(lease.ID); err != nil {
+		t.Fatal(err)
+	}
+	if lessees, err = s.card.ModeListLessees(); err != nil || len(lessees) != 0 {
+		t.Errorf("got lessees %v, %v after revoking", lessees, err)
+	}
+}
+
+func TestFakePlaneFormatModifiers(t *testing.T) {
+	s := newFakeSetup(t)
+	if err := s.card.SetClientCap(ClientCapUniversalPlanes, 1); err != nil {
+		t.Fatal(err)
+	}
+	planes, err := s.card.ModeGetPlaneResources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mods, err := s.card.PlaneFormatModifiers((*planes)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[uint32][]uint64{
+		FormatXRGB8888: {FormatModLinear},
+		FormatARGB8888: {FormatModLinear},
+	}
+	if !reflect.DeepEqual(mods, want) {
+		t.Errorf("got %v, want %v", mods, want)
+	}
+}
+
+func TestFakeAssignPlanes(t *testing.T) {
+	s := newFakeSetup(t)
+	for _, cap := range []uint64{ClientCapUniversalPlanes, ClientCapAtomic} {
+		if err := s.card.SetClientCap(cap, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	overlay := s.dev.AddPlane(PlaneTypeOverlay, []uint32{s.crtc}, []uint32{FormatARGB8888})
+	s.modeset(t, s.framebuffer(t, 1920, 1080))
+
+	full := image.Rect(0, 0, 1920, 1080)
+	small := image.Rect(0, 0, 256, 256)
+	layers := []Layer{
+		{FBID: s.framebuffer(t, 1920, 1080), Format: FormatXRGB8888, Src: full, Dst: full, Z: 0},
+		{FBID: s.framebuffer(t, 256, 256), Format: FormatARGB8888, Src: small, Dst: small, Z: 1},
+		{FBID: s.framebuffer(t, 256, 256), Format: FormatARGB8888, Src: small,
+			Dst: small.Add(image.Pt(128, 128)), Z: 2},
+	}
+	composition := Layer{FBID: s.framebuffer(t, 1920, 1080), Format: FormatXRGB8888, Src: full, Dst: full}
+
+	got, err := s.card.AssignPlanes(nil, s.crtc, layers, &composition, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// There is one overlay plane for two overlapping layers, so the lower one is
+	// composited with the background, below the overlay plane.
+	if !reflect.DeepEqual(got.Composited, []int{0, 1}) || got.Planes[2] != overlay {
+		t.Errorf("unexpected assignment %+v", got)
+	}
+	if err := s.card.ModeAtomicCommit(got.Request, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if fb, _ := s.dev.Property(overlay, "FB_ID"); uint32(fb) != layers[2].FBID {
+		t.Errorf("overlay shows framebuffer %d, want %d", fb, layers[2].FBID)
+	}
+
+	// Without composition, only layers that fit on planes can be shown.
+	if _, err := s.card.AssignPlanes(nil, s.crtc, layers, nil, 0); err == nil {
+		t.Error("assigned three layers to two planes")
+	}
+	got, err = s.card.AssignPlanes(nil, s.crtc, layers[1:], nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Composited) != 0 || got.Planes[1] != overlay {
+		t.Errorf("unexpected assignment %+v", got)
+	}
+}
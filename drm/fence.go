@@ -0,0 +1,90 @@
+package drm
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// ErrFenceTimeout is returned when a fence does not signal in time.
+var ErrFenceTimeout = errors.New("timed out waiting for fence")
+
+// OutFence receives a sync file from the kernel during an atomic commit, e.g.
+// through the WRITEBACK_OUT_FENCE_PTR or OUT_FENCE_PTR properties. The sync
+// file signals once the hardware has finished the corresponding work.
+type OutFence struct {
+	// fd is written by the kernel, so it must remain at a fixed address until
+	// the commit has returned.
+	fd int32
+}
+
+func newOutFence() *OutFence {
+	return &OutFence{fd: -1}
+}
+
+// ptr returns the value to set the fence pointer property to.
+func (f *OutFence) ptr() uint64 {
+	return uint64(uintptr(unsafe.Pointer(&f.fd)))
+}
+
+// Fd returns the sync file descriptor, or -1 if the commit has not filled it in.
+func (f *OutFence) Fd() int {
+	return int(f.fd)
+}
+
+// Wait blocks until the fence signals, or until the timeout elapses. A negative
+// timeout waits forever.
+func (f *OutFence) Wait(timeout time.Duration) error {
+	if f.fd < 0 {
+		return errors.New("fence has not been filled in by a commit")
+	}
+	ok, err := poll(int(f.fd), pollIn, timeout)
+	if err != nil {
+		return fmt.Errorf("poll: %w", err)
+	}
+	if !ok {
+		return ErrFenceTimeout
+	}
+	return nil
+}
+
+// Close closes the sync file.
+func (f *OutFence) Close() error {
+	if f.fd < 0 {
+		return nil
+	}
+	err := syscall.Close(int(f.fd))
+	f.fd = -1
+	return err
+}
+
+const pollIn = 0x1
+
+type cPollFd struct {
+	fd      int32
+	events  int16
+	revents int16
+}
+
+// poll waits for the given events on fd, returning false if the timeout elapsed
+// first. A negative timeout waits forever.
+func poll(fd int, events int16, timeout time.Duration) (bool, error) {
+	pfd := cPollFd{fd: int32(fd), events: events}
+	var ts *syscall.Timespec
+	if timeout >= 0 {
+		t := syscall.NsecToTimespec(timeout.Nanoseconds())
+		ts = &t
+	}
+	for {
+		n, _, errno := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&pfd)), 1,
+			uintptr(unsafe.Pointer(ts)), 0, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		} else if errno != 0 {
+			return false, errno
+		}
+		return n > 0, nil
+	}
+}
@@ -0,0 +1,159 @@
+package drm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Pixel formats, taken from drm/drm_fourcc.h. Formats are little endian unless
+// otherwise noted, i.e. FormatXRGB8888 is [31:0] x:R:G:B in memory.
+const (
+	FormatRGB565   uint32 = 'R' | 'G'<<8 | '1'<<16 | '6'<<24 // [15:0] R:G:B 5:6:5
+	FormatBGR565   uint32 = 'B' | 'G'<<8 | '1'<<16 | '6'<<24 // [15:0] B:G:R 5:6:5
+	FormatXRGB1555 uint32 = 'X' | 'R'<<8 | '1'<<16 | '5'<<24 // [15:0] x:R:G:B 1:5:5:5
+	FormatARGB1555 uint32 = 'A' | 'R'<<8 | '1'<<16 | '5'<<24 // [15:0] A:R:G:B 1:5:5:5
+
+	FormatRGB888 uint32 = 'R' | 'G'<<8 | '2'<<16 | '4'<<24 // [23:0] R:G:B
+	FormatBGR888 uint32 = 'B' | 'G'<<8 | '2'<<16 | '4'<<24 // [23:0] B:G:R
+
+	FormatXRGB8888 uint32 = 'X' | 'R'<<8 | '2'<<16 | '4'<<24 // [31:0] x:R:G:B
+	FormatXBGR8888 uint32 = 'X' | 'B'<<8 | '2'<<16 | '4'<<24 // [31:0] x:B:G:R
+	FormatRGBX8888 uint32 = 'R' | 'X'<<8 | '2'<<16 | '4'<<24 // [31:0] R:G:B:x
+	FormatBGRX8888 uint32 = 'B' | 'X'<<8 | '2'<<16 | '4'<<24 // [31:0] B:G:R:x
+	FormatARGB8888 uint32 = 'A' | 'R'<<8 | '2'<<16 | '4'<<24 // [31:0] A:R:G:B
+	FormatABGR8888 uint32 = 'A' | 'B'<<8 | '2'<<16 | '4'<<24 // [31:0] A:B:G:R
+	FormatRGBA8888 uint32 = 'R' | 'A'<<8 | '2'<<16 | '4'<<24 // [31:0] R:G:B:A
+	FormatBGRA8888 uint32 = 'B' | 'A'<<8 | '2'<<16 | '4'<<24 // [31:0] B:G:R:A
+
+	FormatXRGB2101010 uint32 = 'X' | 'R'<<8 | '3'<<16 | '0'<<24 // [31:0] x:R:G:B 2:10:10:10
+	FormatXBGR2101010 uint32 = 'X' | 'B'<<8 | '3'<<16 | '0'<<24 // [31:0] x:B:G:R 2:10:10:10
+	FormatARGB2101010 uint32 = 'A' | 'R'<<8 | '3'<<16 | '0'<<24 // [31:0] A:R:G:B 2:10:10:10
+	FormatABGR2101010 uint32 = 'A' | 'B'<<8 | '3'<<16 | '0'<<24 // [31:0] A:B:G:R 2:10:10:10
+
+	FormatYUYV uint32 = 'Y' | 'U'<<8 | 'Y'<<16 | 'V'<<24 // [31:0] Cr0:Y1:Cb0:Y0
+	FormatYVYU uint32 = 'Y' | 'V'<<8 | 'Y'<<16 | 'U'<<24 // [31:0] Cb0:Y1:Cr0:Y0
+	FormatUYVY uint32 = 'U' | 'Y'<<8 | 'V'<<16 | 'Y'<<24 // [31:0] Y1:Cr0:Y0:Cb0
+	FormatVYUY uint32 = 'V' | 'Y'<<8 | 'U'<<16 | 'Y'<<24 // [31:0] Y1:Cb0:Y0:Cr0
+
+	FormatNV12 uint32 = 'N' | 'V'<<8 | '1'<<16 | '2'<<24 // 2x2 subsampled Cr:Cb plane
+	FormatNV21 uint32 = 'N' | 'V'<<8 | '2'<<16 | '1'<<24 // 2x2 subsampled Cb:Cr plane
+	FormatNV16 uint32 = 'N' | 'V'<<8 | '1'<<16 | '6'<<24 // 2x1 subsampled Cr:Cb plane
+	FormatNV61 uint32 = 'N' | 'V'<<8 | '6'<<16 | '1'<<24 // 2x1 subsampled Cb:Cr plane
+
+	FormatYUV420 uint32 = 'Y' | 'U'<<8 | '1'<<16 | '2'<<24 // 2x2 subsampled Cb (1) and Cr (2) planes
+	FormatYVU420 uint32 = 'Y' | 'V'<<8 | '1'<<16 | '2'<<24 // 2x2 subsampled Cr (1) and Cb (2) planes
+)
+
+// FormatInfo describes the memory layout of a pixel format. It corresponds to
+// the kernel's struct drm_format_info.
+type FormatInfo struct {
+	Format uint32
+	// Depth is the color depth used by the legacy ModeAddFramebuffer, or 0 if the
+	// format cannot be expressed by depth and bpp.
+	Depth uint8
+	// NumPlanes is the number of memory planes in the format.
+	NumPlanes uint8
+	// CPP is the number of bytes per pixel of each plane. For packed YUV formats,
+	// this is the number of bytes of a pixel pair divided by two.
+	CPP [4]uint8
+	// HSub and VSub are the horizontal and vertical chroma subsampling factors.
+	HSub uint8
+	VSub uint8
+	// HasAlpha indicates whether the format contains an alpha channel.
+	HasAlpha bool
+	// IsYUV indicates whether the format is a YUV format.
+	IsYUV bool
+}
+
+// BPP returns the number of bits per pixel of the first plane.
+func (f *FormatInfo) BPP() uint32 {
+	return uint32(f.CPP[0]) * 8
+}
+
+// PlaneSize returns the width and height of the given plane for a buffer of
+// the given size, taking chroma subsampling into account.
+func (f *FormatInfo) PlaneSize(plane int, width, height uint32) (uint32, uint32) {
+	if plane == 0 {
+		return width, height
+	}
+	return (width + uint32(f.HSub) - 1) / uint32(f.HSub),
+		(height + uint32(f.VSub) - 1) / uint32(f.VSub)
+}
+
+var formats = []FormatInfo{
+	{Format: FormatRGB565, Depth: 16, NumPlanes: 1, CPP: [4]uint8{2}, HSub: 1, VSub: 1},
+	{Format: FormatBGR565, NumPlanes: 1, CPP: [4]uint8{2}, HSub: 1, VSub: 1},
+	{Format: FormatXRGB1555, Depth: 15, NumPlanes: 1, CPP: [4]uint8{2}, HSub: 1, VSub: 1},
+	{Format: FormatARGB1555, NumPlanes: 1, CPP: [4]uint8{2}, HSub: 1, VSub: 1, HasAlpha: true},
+	{Format: FormatRGB888, Depth: 24, NumPlanes: 1, CPP: [4]uint8{3}, HSub: 1, VSub: 1},
+	{Format: FormatBGR888, NumPlanes: 1, CPP: [4]uint8{3}, HSub: 1, VSub: 1},
+	{Format: FormatXRGB8888, Depth: 24, NumPlanes: 1, CPP: [4]uint8{4}, HSub: 1, VSub: 1},
+	{Format: FormatXBGR8888, NumPlanes: 1, CPP: [4]uint8{4}, HSub: 1, VSub: 1},
+	{Format: FormatRGBX8888, NumPlanes: 1, CPP: [4]uint8{4}, HSub: 1, VSub: 1},
+	{Format: FormatBGRX8888, NumPlanes: 1, CPP: [4]uint8{4}, HSub: 1, VSub: 1},
+	{Format: FormatARGB8888, Depth: 32, NumPlanes: 1, CPP: [4]uint8{4}, HSub: 1, VSub: 1, HasAlpha: true},
+	{Format: FormatABGR8888, NumPlanes: 1, CPP: [4]uint8{4}, HSub: 1, VSub: 1, HasAlpha: true},
+	{Format: FormatRGBA8888, NumPlanes: 1, CPP: [4]uint8{4}, HSub: 1, VSub: 1, HasAlpha: true},
+	{Format: FormatBGRA8888, NumPlanes: 1, CPP: [4]uint8{4}, HSub: 1, VSub: 1, HasAlpha: true},
+	{Format: FormatXRGB2101010, Depth: 30, NumPlanes: 1, CPP: [4]uint8{4}, HSub: 1, VSub: 1},
+	{Format: FormatXBGR2101010, NumPlanes: 1, CPP: [4]uint8{4}, HSub: 1, VSub: 1},
+	{Format: FormatARGB2101010, NumPlanes: 1, CPP: [4]uint8{4}, HSub: 1, VSub: 1, HasAlpha: true},
+	{Format: FormatABGR2101010, NumPlanes: 1, CPP: [4]uint8{4}, HSub: 1, VSub: 1, HasAlpha: true},
+	{Format: FormatYUYV, NumPlanes: 1, CPP: [4]uint8{2}, HSub: 2, VSub: 1, IsYUV: true},
+	{Format: FormatYVYU, NumPlanes: 1, CPP: [4]uint8{2}, HSub: 2, VSub: 1, IsYUV: true},
+	{Format: FormatUYVY, NumPlanes: 1, CPP: [4]uint8{2}, HSub: 2, VSub: 1, IsYUV: true},
+	{Format: FormatVYUY, NumPlanes: 1, CPP: [4]uint8{2}, HSub: 2, VSub: 1, IsYUV: true},
+	{Format: FormatNV12, NumPlanes: 2, CPP: [4]uint8{1, 2}, HSub: 2, VSub: 2, IsYUV: true},
+	{Format: FormatNV21, NumPlanes: 2, CPP: [4]uint8{1, 2}, HSub: 2, VSub: 2, IsYUV: true},
+	{Format: FormatNV16, NumPlanes: 2, CPP: [4]uint8{1, 2}, HSub: 2, VSub: 1, IsYUV: true},
+	{Format: FormatNV61, NumPlanes: 2, CPP: [4]uint8{1, 2}, HSub: 2, VSub: 1, IsYUV: true},
+	{Format: FormatYUV420, NumPlanes: 3, CPP: [4]uint8{1, 1, 1}, HSub: 2, VSub: 2, IsYUV: true},
+	{Format: FormatYVU420, NumPlanes: 3, CPP: [4]uint8{1, 1, 1}, HSub: 2, VSub: 2, IsYUV: true},
+}
+
+// Formats returns the description of every pixel format known to this package.
+func Formats() []FormatInfo {
+	ret := make([]FormatInfo, len(formats))
+	copy(ret, formats)
+	return ret
+}
+
+// LookupFormat returns the description of the given pixel format, or nil if the
+// format is unknown.
+func LookupFormat(format uint32) *FormatInfo {
+	for i := range formats {
+		if formats[i].Format == format {
+			info := formats[i]
+			return &info
+		}
+	}
+	return nil
+}
+
+// FormatString returns the four character code of a pixel format, e.g. "XR24".
+func FormatString(format uint32) string {
+	b := []byte{byte(format), byte(format >> 8), byte(format >> 16), byte(format >> 24)}
+	for _, c := range b {
+		if c < 0x20 || c > 0x7e {
+			return fmt.Sprintf("0x%08x", format)
+		}
+	}
+	return string(b)
+}
+
+// ParseFormat parses a four character code, e.g. "XR24", into a pixel format.
+// It also accepts the hexadecimal form returned by FormatString.
+func ParseFormat(s string) (uint32, error) {
+	if strings.HasPrefix(s, "0x") {
+		v, err := strconv.ParseUint(s[2:], 16, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid fourcc %q", s)
+		}
+		return uint32(v), nil
+	}
+	if len(s) != 4 {
+		return 0, fmt.Errorf("invalid fourcc %q", s)
+	}
+	return uint32(s[0]) | uint32(s[1])<<8 | uint32(s[2])<<16 | uint32(s[3])<<24, nil
+}
@@ -1,7 +1,7 @@
 package drm
 
 import (
-	"os"
+	"fmt"
 	"syscall"
 	"unsafe"
 )
@@ -42,24 +42,90 @@ func ioctlRequest(dir uint8, size uint16, typ, nr uint8) uint32 {
 		(uint32(typ) << iocTypeShift) | (uint32(nr) << iocNRShift)
 }
 
-func ioctl(fd *os.File, request uint32, data uintptr) error {
-	if _, _, err := syscall.Syscall(syscall.SYS_IOCTL, fd.Fd(), uintptr(request), data); err != 0 {
-		return err
+// ioctl calls an ioctl on the card, retrying it while it is interrupted, as
+// libdrm's drmIoctl does. ObjectID is the mode object the ioctl is about, if
+// any, and is only used to describe errors. Requests that render nodes do not
+// allow fail without reaching the kernel, which would fail them with EACCES.
+func (c *Card) ioctl(request, objectID uint32, data unsafe.Pointer) error {
+	if c.node == NodeRender && !renderAllowed(request) {
+		return &IoctlError{Op: ioctlName(request), ObjectID: objectID, Errno: syscall.EACCES, RenderNode: true}
 	}
-	return nil
+	for {
+		err := c.backend.Ioctl(request, data)
+		if err == nil {
+			return nil
+		}
+		errno, ok := err.(syscall.Errno)
+		if !ok {
+			return err
+		}
+		if errno == syscall.EINTR || errno == syscall.EAGAIN {
+			continue
+		}
+		return &IoctlError{Op: ioctlName(request), ObjectID: objectID, Errno: errno}
+	}
+}
+
+// ioctlName returns the name of an ioctl request, as in the kernel's
+// DRM_IOCTL_* macros without the prefix.
+func ioctlName(request uint32) string {
+	if name, ok := ioctlNames[uint8(request>>iocNRShift&iocNRMask)]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%08x", request)
+}
+
+var ioctlNames = map[uint8]string{
+	0x00: "VERSION",
+	0x01: "GET_UNIQUE",
+	0x02: "GET_MAGIC",
+	0x07: "SET_VERSION",
+	0x0c: "GET_CAP",
+	0x0d: "SET_CLIENT_CAP",
+	0x11: "AUTH_MAGIC",
+	0x1e: "SET_MASTER",
+	0x1f: "DROP_MASTER",
+	0xA0: "MODE_GETRESOURCES",
+	0xA1: "MODE_GETCRTC",
+	0xA2: "MODE_SETCRTC",
+	0xA6: "MODE_GETENCODER",
+	0xA7: "MODE_GETCONNECTOR",
+	0xAA: "MODE_GETPROPERTY",
+	0xAB: "MODE_SETPROPERTY",
+	0xAC: "MODE_GETPROPBLOB",
+	0xAD: "MODE_GETFB",
+	0xAE: "MODE_ADDFB",
+	0xAF: "MODE_RMFB",
+	0xB0: "MODE_PAGE_FLIP",
+	0xB1: "MODE_DIRTYFB",
+	0xB2: "MODE_CREATE_DUMB",
+	0xB3: "MODE_MAP_DUMB",
+	0xB4: "MODE_DESTROY_DUMB",
+	0xB5: "MODE_GETPLANERESOURCES",
+	0xB6: "MODE_GETPLANE",
+	0xB8: "MODE_ADDFB2",
+	0xB9: "MODE_OBJ_GETPROPERTIES",
+	0xBA: "MODE_OBJ_SETPROPERTY",
+	0xBC: "MODE_ATOMIC",
+	0xBD: "MODE_CREATEPROPBLOB",
+	0xBE: "MODE_DESTROYPROPBLOB",
+	0xC6: "MODE_CREATE_LEASE",
+	0xC7: "MODE_LIST_LESSEES",
+	0xC8: "MODE_GET_LEASE",
+	0xC9: "MODE_REVOKE_LEASE",
 }
 
 type unimplemented struct{}
 
 var (
 	ioctlVersion      = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cVersion{})), ioctlBase, 0x00)
-	ioctlGetUnique    = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(unimplemented{})), ioctlBase, 0x01)
-	ioctlGetMagic     = ioctlRequest(iocRead, uint16(unsafe.Sizeof(unimplemented{})), ioctlBase, 0x02)
+	ioctlGetUnique    = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cUnique{})), ioctlBase, 0x01)
+	ioctlGetMagic     = ioctlRequest(iocRead, uint16(unsafe.Sizeof(cAuth{})), ioctlBase, 0x02)
 	ioctlIrqBusid     = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(unimplemented{})), ioctlBase, 0x03)
 	ioctlGetMap       = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(unimplemented{})), ioctlBase, 0x04)
 	ioctlGetClient    = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(unimplemented{})), ioctlBase, 0x05)
 	ioctlGetStats     = ioctlRequest(iocRead, uint16(unsafe.Sizeof(unimplemented{})), ioctlBase, 0x06)
-	ioctlSetVersion   = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(unimplemented{})), ioctlBase, 0x07)
+	ioctlSetVersion   = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cSetVersion{})), ioctlBase, 0x07)
 	ioctlModesetCtl   = ioctlRequest(iocWrite, uint16(unsafe.Sizeof(unimplemented{})), ioctlBase, 0x08)
 	ioctlGemClose     = ioctlRequest(iocWrite, uint16(unsafe.Sizeof(unimplemented{})), ioctlBase, 0x09)
 	ioctlGemFlink     = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(unimplemented{})), ioctlBase, 0x0a)
@@ -68,7 +134,7 @@ var (
 	ioctlSetClientCap = ioctlRequest(iocWrite, uint16(unsafe.Sizeof(cSetClientCap{})), ioctlBase, 0x0d)
 
 	ioctlSetUnique = ioctlRequest(iocWrite, uint16(unsafe.Sizeof(unimplemented{})), ioctlBase, 0x10)
-	ioctlAuthMagic = ioctlRequest(iocWrite, uint16(unsafe.Sizeof(unimplemented{})), ioctlBase, 0x11)
+	ioctlAuthMagic = ioctlRequest(iocWrite, uint16(unsafe.Sizeof(cAuth{})), ioctlBase, 0x11)
 	ioctlBlock     = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(unimplemented{})), ioctlBase, 0x12)
 	ioctlUnblock   = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(unimplemented{})), ioctlBase, 0x13)
 	ioctlControl   = ioctlRequest(iocWrite, uint16(unsafe.Sizeof(unimplemented{})), ioctlBase, 0x14)
@@ -140,8 +206,8 @@ var (
 	ioctlModeGetFB       = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeFBCmd{})), ioctlBase, 0xAD)
 	ioctlModeAddFB       = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeFBCmd{})), ioctlBase, 0xAE)
 	ioctlModeRmFB        = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(uint32(0))), ioctlBase, 0xAF)
-	ioctlModePageFlip    = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(unimplemented{})), ioctlBase, 0xB0)
-	ioctlModeDirtyFB     = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(unimplemented{})), ioctlBase, 0xB1)
+	ioctlModePageFlip    = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeCRTCPageFlip{})), ioctlBase, 0xB0)
+	ioctlModeDirtyFB     = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeFBDirtyCmd{})), ioctlBase, 0xB1)
 
 	ioctlModeCreateDumb        = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeCreateDumb{})), ioctlBase, 0xB2)
 	ioctlModeMapDumb           = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeMapDumb{})), ioctlBase, 0xB3)
@@ -149,13 +215,13 @@ var (
 	ioctlModeGetPlaneResources = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeGetPlaneRes{})), ioctlBase, 0xB5)
 	ioctlModeGetPlane          = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeGetPlane{})), ioctlBase, 0xB6)
 	ioctlModeSetPlane          = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(unimplemented{})), ioctlBase, 0xB7)
-	ioctlModeAddFB2            = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(unimplemented{})), ioctlBase, 0xB8)
+	ioctlModeAddFB2            = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeFBCmd2{})), ioctlBase, 0xB8)
 	ioctlModeObjGetProperties  = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeObjGetProperties{})), ioctlBase, 0xB9)
-	ioctlModeObjSetProperty    = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(unimplemented{})), ioctlBase, 0xBA)
+	ioctlModeObjSetProperty    = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeObjSetProperty{})), ioctlBase, 0xBA)
 	ioctlModeCursor2           = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(unimplemented{})), ioctlBase, 0xBB)
-	ioctlModeAtomic            = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(unimplemented{})), ioctlBase, 0xBC)
-	ioctlModeCreatePropBlob    = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(unimplemented{})), ioctlBase, 0xBD)
-	ioctlModeDestroyPropBlob   = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(unimplemented{})), ioctlBase, 0xBE)
+	ioctlModeAtomic            = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeAtomic{})), ioctlBase, 0xBC)
+	ioctlModeCreatePropBlob    = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeCreateBlob{})), ioctlBase, 0xBD)
+	ioctlModeDestroyPropBlob   = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeDestroyBlob{})), ioctlBase, 0xBE)
 
 	ioctlSyncObjCreate     = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(unimplemented{})), ioctlBase, 0xBF)
 	ioctlSyncObjDestroy    = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(unimplemented{})), ioctlBase, 0xC0)
@@ -0,0 +1,61 @@
+package drm
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// flakyDevice is a fake device whose next ioctls fail with errs.
+type flakyDevice struct {
+	*FakeDevice
+	errs  []error
+	calls int
+}
+
+func (d *flakyDevice) Ioctl(request uint32, data unsafe.Pointer) error {
+	d.calls++
+	if len(d.errs) > 0 {
+		err := d.errs[0]
+		d.errs = d.errs[1:]
+		return err
+	}
+	return d.FakeDevice.Ioctl(request, data)
+}
+
+func TestIoctlRetry(t *testing.T) {
+	s := newFakeSetup(t)
+	dev := &flakyDevice{FakeDevice: s.dev}
+	card := NewWithBackend(dev)
+
+	// Interrupted ioctls are retried until they complete.
+	dev.errs = []error{syscall.EINTR, syscall.EAGAIN}
+	if crtc, err := card.ModeGetCRTC(s.crtc); err != nil || crtc.ID != s.crtc {
+		t.Errorf("get crtc returned %+v, %v", crtc, err)
+	}
+	if dev.calls != 3 {
+		t.Errorf("ioctl called %d times, want 3", dev.calls)
+	}
+
+	dev.calls, dev.errs = 0, []error{syscall.EINTR, syscall.EBUSY, syscall.EINTR}
+	_, err := card.ModeGetCRTC(s.crtc)
+	var ioctlErr *IoctlError
+	if !errors.As(err, &ioctlErr) {
+		t.Fatalf("get crtc returned %v", err)
+	}
+	if ioctlErr.Op != "MODE_GETCRTC" || ioctlErr.ObjectID != s.crtc || ioctlErr.Errno != syscall.EBUSY ||
+		!errors.Is(err, syscall.EBUSY) {
+		t.Errorf("unexpected error %+v", ioctlErr)
+	}
+	if dev.calls != 2 {
+		t.Errorf("failed ioctl called %d times, want 2", dev.calls)
+	}
+
+	// Errors other than errnos are returned as is.
+	other := errors.New("backend closed")
+	dev.errs = []error{other}
+	if _, err := card.ModeGetCRTC(s.crtc); err != other {
+		t.Errorf("get crtc returned %v, want %v", err, other)
+	}
+}
@@ -0,0 +1,384 @@
+package drm
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// The mode types marshal to JSON with stable field names, using the names of
+// enumerated values and flags rather than their numbers. Properties are given
+// by ID, as resolving their names requires the card.
+
+type modeJSON struct {
+	Name       string   `json:"name"`
+	Clock      uint32   `json:"clock"`
+	HDisplay   uint16   `json:"hdisplay"`
+	HSyncStart uint16   `json:"hsync_start"`
+	HSyncEnd   uint16   `json:"hsync_end"`
+	HTotal     uint16   `json:"htotal"`
+	HSkew      uint16   `json:"hskew"`
+	VDisplay   uint16   `json:"vdisplay"`
+	VSyncStart uint16   `json:"vsync_start"`
+	VSyncEnd   uint16   `json:"vsync_end"`
+	VTotal     uint16   `json:"vtotal"`
+	VScan      uint16   `json:"vscan"`
+	VRefresh   uint32   `json:"vrefresh"`
+	Flags      []string `json:"flags"`
+	Type       []string `json:"type"`
+}
+
+func newModeJSON(m *cModeInfo, name string) *modeJSON {
+	return &modeJSON{
+		Name:       name,
+		Clock:      m.Clock,
+		HDisplay:   m.HDisplay,
+		HSyncStart: m.HSyncStart,
+		HSyncEnd:   m.HSyncEnd,
+		HTotal:     m.HTotal,
+		HSkew:      m.HSkew,
+		VDisplay:   m.VDisplay,
+		VSyncStart: m.VSyncStart,
+		VSyncEnd:   m.VSyncEnd,
+		VTotal:     m.VTotal,
+		VScan:      m.VScan,
+		VRefresh:   m.VRefresh,
+		Flags:      ModeFlagNames(m.Flags),
+		Type:       ModeTypeNames(m.Type),
+	}
+}
+
+func (j *modeJSON) modeInfo() (cModeInfo, error) {
+	ret := cModeInfo{
+		Clock:      j.Clock,
+		HDisplay:   j.HDisplay,
+		HSyncStart: j.HSyncStart,
+		HSyncEnd:   j.HSyncEnd,
+		HTotal:     j.HTotal,
+		HSkew:      j.HSkew,
+		VDisplay:   j.VDisplay,
+		VSyncStart: j.VSyncStart,
+		VSyncEnd:   j.VSyncEnd,
+		VTotal:     j.VTotal,
+		VScan:      j.VScan,
+		VRefresh:   j.VRefresh,
+	}
+	copy(ret.name[:displayModeLen-1], j.Name)
+	var err error
+	if ret.Flags, err = ParseModeFlags(j.Flags); err != nil {
+		return ret, err
+	}
+	ret.Type, err = ParseModeType(j.Type)
+	return ret, err
+}
+
+func (m ModeInfo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(newModeJSON(&m.cModeInfo, m.Name))
+}
+
+func (m *ModeInfo) UnmarshalJSON(data []byte) error {
+	var j modeJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	info, err := j.modeInfo()
+	if err != nil {
+		return err
+	}
+	*m = ModeInfo{cModeInfo: info, Name: j.Name}
+	return nil
+}
+
+type propertyValueJSON struct {
+	ID    uint32 `json:"id"`
+	Value uint64 `json:"value"`
+}
+
+type connectorJSON struct {
+	ID         uint32              `json:"id"`
+	Name       string              `json:"name"`
+	Type       string              `json:"type"`
+	TypeID     uint32              `json:"type_id"`
+	Connection string              `json:"connection"`
+	MMWidth    uint32              `json:"mm_width"`
+	MMHeight   uint32              `json:"mm_height"`
+	Subpixel   string              `json:"subpixel"`
+	EncoderID  uint32              `json:"encoder_id"`
+	Encoders   []uint32            `json:"encoders"`
+	Modes      []ModeInfo          `json:"modes"`
+	Properties []propertyValueJSON `json:"properties"`
+}
+
+func (c ModeConnector) MarshalJSON() ([]byte, error) {
+	j := connectorJSON{
+		ID:         c.ID,
+		Name:       c.Name(),
+		Type:       ConnectorTypeName(c.Type),
+		TypeID:     c.TypeID,
+		Connection: ConnectionName(c.Connection),
+		MMWidth:    c.MMWidth,
+		MMHeight:   c.MMHeight,
+		Subpixel:   SubpixelName(c.Subpixel),
+		EncoderID:  c.EncoderID,
+		Encoders:   c.EncoderIDs,
+		Modes:      c.Modes,
+		Properties: []propertyValueJSON{},
+	}
+	for i, id := range c.PropIDs {
+		j.Properties = append(j.Properties, propertyValueJSON{ID: id, Value: c.PropValues[i]})
+	}
+	return json.Marshal(&j)
+}
+
+// UnmarshalJSON decodes a connector. The name is ignored, as it is derived from
+// the type and type ID.
+func (c *ModeConnector) UnmarshalJSON(data []byte) error {
+	var j connectorJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	ret := ModeConnector{EncoderIDs: j.Encoders, Modes: j.Modes}
+	ret.ID, ret.TypeID, ret.MMWidth, ret.MMHeight, ret.EncoderID = j.ID, j.TypeID, j.MMWidth,
+		j.MMHeight, j.EncoderID
+	var err error
+	if ret.Type, err = parseEnumName(connectorTypeNames, "connector type", j.Type); err != nil {
+		return err
+	}
+	if ret.Connection, err = parseEnumName(connectionNames, "connection", j.Connection); err != nil {
+		return err
+	}
+	if ret.Subpixel, err = parseEnumName(subpixelNames, "subpixel order", j.Subpixel); err != nil {
+		return err
+	}
+	for _, prop := range j.Properties {
+		ret.PropIDs = append(ret.PropIDs, prop.ID)
+		ret.PropValues = append(ret.PropValues, prop.Value)
+	}
+	*c = ret
+	return nil
+}
+
+type encoderJSON struct {
+	ID             uint32 `json:"id"`
+	Type           string `json:"type"`
+	CRTCID         uint32 `json:"crtc_id"`
+	PossibleCRTCs  uint32 `json:"possible_crtcs"`
+	PossibleClones uint32 `json:"possible_clones"`
+}
+
+func (e ModeEncoder) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&encoderJSON{
+		ID:             e.ID,
+		Type:           EncoderTypeName(e.Type),
+		CRTCID:         e.CRTCID,
+		PossibleCRTCs:  e.PossibleCRTCs,
+		PossibleClones: e.PossibleClones,
+	})
+}
+
+func (e *ModeEncoder) UnmarshalJSON(data []byte) error {
+	var j encoderJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	typ, err := parseEnumName(encoderTypeNames, "encoder type", j.Type)
+	if err != nil {
+		return err
+	}
+	*e = ModeEncoder{cModeGetEncoder{ID: j.ID, Type: typ, CRTCID: j.CRTCID,
+		PossibleCRTCs: j.PossibleCRTCs, PossibleClones: j.PossibleClones}}
+	return nil
+}
+
+type crtcJSON struct {
+	ID        uint32 `json:"id"`
+	FBID      uint32 `json:"fb_id"`
+	X         uint32 `json:"x"`
+	Y         uint32 `json:"y"`
+	GammaSize uint32 `json:"gamma_size"`
+	// Mode is null if the mode is not valid.
+	Mode          *modeJSON `json:"mode"`
+	SetConnectors []uint32  `json:"set_connectors,omitempty"`
+}
+
+func (c ModeCRTC) MarshalJSON() ([]byte, error) {
+	j := crtcJSON{ID: c.ID, FBID: c.FBID, X: c.X, Y: c.Y, GammaSize: c.GammaSize,
+		SetConnectors: c.SetConnectors}
+	if c.ModeValid != 0 {
+		j.Mode = newModeJSON(&c.cModeInfo, c.Name)
+	}
+	return json.Marshal(&j)
+}
+
+func (c *ModeCRTC) UnmarshalJSON(data []byte) error {
+	var j crtcJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	ret := ModeCRTC{SetConnectors: j.SetConnectors}
+	ret.ID, ret.FBID, ret.X, ret.Y, ret.GammaSize = j.ID, j.FBID, j.X, j.Y, j.GammaSize
+	if j.Mode != nil {
+		info, err := j.Mode.modeInfo()
+		if err != nil {
+			return err
+		}
+		ret.cModeInfo, ret.ModeValid, ret.Name = info, 1, j.Mode.Name
+	}
+	*c = ret
+	return nil
+}
+
+type planeJSON struct {
+	ID            uint32   `json:"id"`
+	CRTCID        uint32   `json:"crtc_id"`
+	FBID          uint32   `json:"fb_id"`
+	PossibleCRTCs uint32   `json:"possible_crtcs"`
+	GammaSize     uint32   `json:"gamma_size"`
+	Formats       []string `json:"formats"`
+}
+
+func (p ModePlane) MarshalJSON() ([]byte, error) {
+	j := planeJSON{ID: p.ID, CRTCID: p.CRTCID, FBID: p.FBID, PossibleCRTCs: p.PossibleCRTCs,
+		GammaSize: p.GammaSize, Formats: []string{}}
+	for _, format := range p.FormatTypes {
+		j.Formats = append(j.Formats, FormatString(format))
+	}
+	return json.Marshal(&j)
+}
+
+func (p *ModePlane) UnmarshalJSON(data []byte) error {
+	var j planeJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	ret := ModePlane{}
+	ret.ID, ret.CRTCID, ret.FBID, ret.PossibleCRTCs, ret.GammaSize = j.ID, j.CRTCID, j.FBID,
+		j.PossibleCRTCs, j.GammaSize
+	for _, s := range j.Formats {
+		format, err := ParseFormat(s)
+		if err != nil {
+			return err
+		}
+		ret.FormatTypes = append(ret.FormatTypes, format)
+	}
+	ret.countFormatTypes = uint32(len(ret.FormatTypes))
+	*p = ret
+	return nil
+}
+
+type propertyJSON struct {
+	ID        uint32             `json:"id"`
+	Name      string             `json:"name"`
+	Type      string             `json:"type"`
+	Immutable bool               `json:"immutable"`
+	Atomic    bool               `json:"atomic"`
+	Values    []uint64           `json:"values"`
+	Enums     []ModePropertyEnum `json:"enums"`
+}
+
+// propertyTypeFlags is the inverse of PropertyTypeName.
+var propertyTypeFlags = map[string]uint32{
+	"range":        ModePropRange,
+	"enum":         ModePropEnum,
+	"blob":         ModePropBlob,
+	"bitmask":      ModePropBitmask,
+	"object":       ModePropObject,
+	"signed range": ModePropSignedRange,
+	"unknown":      0,
+}
+
+func (p ModeProperty) MarshalJSON() ([]byte, error) {
+	return json.Marshal(newPropertyJSON(&p))
+}
+
+func newPropertyJSON(p *ModeProperty) *propertyJSON {
+	j := propertyJSON{
+		ID:        p.PropID,
+		Name:      p.Name,
+		Type:      PropertyTypeName(p.Flags),
+		Immutable: p.Flags&ModePropImmutable != 0,
+		Atomic:    p.Flags&ModePropAtomic != 0,
+		Values:    p.Values,
+		Enums:     p.Enums,
+	}
+	if j.Values == nil {
+		j.Values = []uint64{}
+	}
+	if j.Enums == nil {
+		j.Enums = []ModePropertyEnum{}
+	}
+	return &j
+}
+
+func (j *propertyJSON) property() (ModeProperty, error) {
+	flags, ok := propertyTypeFlags[j.Type]
+	if !ok {
+		return ModeProperty{}, fmt.Errorf("unknown property type %q", j.Type)
+	}
+	if j.Immutable {
+		flags |= ModePropImmutable
+	}
+	if j.Atomic {
+		flags |= ModePropAtomic
+	}
+	return ModeProperty{PropID: j.ID, Name: j.Name, Flags: flags, Values: j.Values, Enums: j.Enums}, nil
+}
+
+func (p *ModeProperty) UnmarshalJSON(data []byte) error {
+	var j propertyJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	prop, err := j.property()
+	if err != nil {
+		return err
+	}
+	*p = prop
+	return nil
+}
+
+type propertyValueOfJSON struct {
+	*propertyJSON
+	Value uint64 `json:"value"`
+}
+
+// MarshalJSON adds the value to the JSON of the property, which would
+// otherwise be dropped by the method promoted from ModeProperty.
+func (p Property) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&propertyValueOfJSON{propertyJSON: newPropertyJSON(&p.ModeProperty), Value: p.Value})
+}
+
+func (p *Property) UnmarshalJSON(data []byte) error {
+	j := propertyValueOfJSON{propertyJSON: &propertyJSON{}}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	prop, err := j.property()
+	if err != nil {
+		return err
+	}
+	*p = Property{ModeProperty: prop, Value: j.Value}
+	return nil
+}
+
+type framebufferJSON struct {
+	ID     uint32 `json:"id"`
+	Width  uint32 `json:"width"`
+	Height uint32 `json:"height"`
+	Pitch  uint32 `json:"pitch"`
+	Bpp    uint32 `json:"bpp"`
+	Depth  uint32 `json:"depth"`
+	Handle uint32 `json:"handle"`
+}
+
+func (f ModeFramebuffer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(framebufferJSON(f.cModeFBCmd))
+}
+
+func (f *ModeFramebuffer) UnmarshalJSON(data []byte) error {
+	var j framebufferJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*f = ModeFramebuffer{cModeFBCmd(j)}
+	return nil
+}
@@ -0,0 +1,84 @@
+package drm
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONNames(t *testing.T) {
+	s := newFakeSetup(t)
+	conn, err := s.card.ModeGetConnector(s.connector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Modes[0].Flags |= ModeFlagPHSync | ModeFlagNVSync | 2<<19
+	data, err := json.Marshal(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"name":"HDMI-A-1"`, `"type":"HDMI-A"`, `"connection":"connected"`,
+		`"subpixel":"unknown"`, `"flags":["phsync","nvsync","aspect:16:9"]`, `"type":["preferred","driver"]`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("%s does not contain %s", data, want)
+		}
+	}
+
+	var decoded ModeConnector
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	// The pointers and counts of the ioctl are not part of the JSON.
+	want := ModeConnector{EncoderIDs: conn.EncoderIDs, Modes: conn.Modes, PropIDs: conn.PropIDs,
+		PropValues: conn.PropValues}
+	want.ID, want.Type, want.TypeID, want.Connection = conn.ID, conn.Type, conn.TypeID, conn.Connection
+	want.MMWidth, want.MMHeight, want.Subpixel, want.EncoderID = conn.MMWidth, conn.MMHeight,
+		conn.Subpixel, conn.EncoderID
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("round trip changed connector:\n%+v\n%+v", decoded, want)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	s := newFakeSetup(t)
+	s.modeset(t, s.framebuffer(t, 1920, 1080))
+	if err := s.card.SetClientCap(ClientCapAtomic, 1); err != nil {
+		t.Fatal(err)
+	}
+	crtc, err := s.card.ModeGetCRTC(s.crtc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := s.card.ModeGetEncoder(s.encoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	planes, err := s.card.ModeGetPlaneResources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plane, err := s.card.ModeGetPlane((*planes)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	props, err := s.card.ObjectProperties(s.connector, ModeObjectConnector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prop := props["DPMS"]
+
+	for _, v := range []interface{}{crtc, enc, plane, prop, &prop.ModeProperty} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := reflect.New(reflect.TypeOf(v).Elem())
+		if err := json.Unmarshal(data, decoded.Interface()); err != nil {
+			t.Fatalf("%s: %s", data, err)
+		}
+		if !reflect.DeepEqual(decoded.Interface(), v) {
+			t.Errorf("round trip changed %T:\n%+v\n%+v", v, decoded.Interface(), v)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package drm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// LinkRecovery re-applies the configuration of CRTCs whose connectors lost their
+// link. When link training fails, e.g. on DisplayPort, the kernel sets the
+// connector's "link-status" property to Bad and sends a hotplug event; the sink
+// shows nothing until userspace performs a new modeset.
+type LinkRecovery struct {
+	card *Card
+
+	mu      sync.Mutex
+	configs map[uint32]ModeCRTC // keyed by CRTC ID
+}
+
+// NewLinkRecovery returns a LinkRecovery that has no configurations to recover.
+func (c *Card) NewLinkRecovery() *LinkRecovery {
+	return &LinkRecovery{card: c, configs: make(map[uint32]ModeCRTC)}
+}
+
+// Apply calls ModeSetCRTC, and remembers the configuration for recovery.
+func (l *LinkRecovery) Apply(set ModeCRTC) error {
+	if err := l.card.ModeSetCRTC(set); err != nil {
+		return err
+	}
+	l.Track(set)
+	return nil
+}
+
+// Track remembers a configuration that was applied with ModeSetCRTC. A
+// configuration without connectors stops tracking the CRTC.
+func (l *LinkRecovery) Track(set ModeCRTC) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(set.SetConnectors) == 0 {
+		delete(l.configs, set.ID)
+		return
+	}
+	set.SetConnectors = append([]uint32(nil), set.SetConnectors...)
+	l.configs[set.ID] = set
+}
+
+// Check looks for tracked connectors with a Bad link-status, and re-applies the
+// configuration of their CRTCs, which retrains the link. If the mode of a
+// configuration is no longer supported by a connector, its preferred mode is
+// used instead. Check returns the IDs of the CRTCs it re-applied.
+func (l *LinkRecovery) Check() ([]uint32, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var (
+		recovered []uint32
+		errs      []error
+	)
+	for crtcID, set := range l.configs {
+		bad := false
+		for _, connID := range set.SetConnectors {
+			props, err := l.card.ObjectProperties(connID, ModeObjectConnector)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("connector %d: %w", connID, err))
+				continue
+			}
+			if prop, ok := props["link-status"]; ok && prop.Value == ModeLinkStatusBad {
+				bad = true
+				if set, err = l.revalidateMode(set, connID); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+		if !bad {
+			continue
+		}
+
+		// The legacy SETCRTC ioctl resets link-status to Good.
+		if err := l.card.ModeSetCRTC(set); err != nil {
+			errs = append(errs, fmt.Errorf("crtc %d: %w", crtcID, err))
+			continue
+		}
+		l.configs[crtcID] = set
+		recovered = append(recovered, crtcID)
+	}
+	return recovered, joinErrors(errs)
+}
+
+// revalidateMode replaces the mode of set with the connector's preferred mode,
+// if the connector no longer supports it.
+func (l *LinkRecovery) revalidateMode(set ModeCRTC, connID uint32) (ModeCRTC, error) {
+	conn, err := l.card.ModeGetConnector(connID)
+	if err != nil {
+		return set, fmt.Errorf("connector %d: %w", connID, err)
+	}
+	var preferred *ModeInfo
+	for i, mode := range conn.Modes {
+		if mode.cModeInfo == set.cModeInfo {
+			return set, nil
+		}
+		if preferred == nil || mode.Type&ModeTypePreferred != 0 {
+			preferred = &conn.Modes[i]
+		}
+	}
+	if preferred == nil {
+		return set, fmt.Errorf("connector %d has no modes", connID)
+	}
+	set.cModeInfo = preferred.cModeInfo
+	set.Name = preferred.Name
+	return set, nil
+}
+
+// Run listens for hotplug events of the card, and calls Check after each one
+// until the context is canceled. Errors from Check are passed to onError, if it
+// is not nil.
+func (l *LinkRecovery) Run(ctx context.Context, onError func(error)) error {
+	major, minor, err := l.card.Device()
+	if err != nil {
+		return err
+	}
+	conn, err := ListenUevents()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// Canceling the context interrupts the read by closing conn.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		event, err := conn.Read()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if !event.IsHotplug() {
+			continue
+		}
+		if maj, min, ok := event.Device(); !ok || maj != major || min != minor {
+			continue
+		}
+		if _, err := l.Check(); err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
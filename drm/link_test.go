@@ -0,0 +1,91 @@
+package drm
+
+import (
+	"reflect"
+	"testing"
+)
+
+// apply sets the CRTC of s to the first mode of its connector through l.
+func (s *fakeSetup) apply(t *testing.T, l *LinkRecovery) ModeCRTC {
+	t.Helper()
+	conn, err := s.card.ModeGetConnector(s.connector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := ModeCRTC{cModeCRTC: cModeCRTC{ID: s.crtc, FBID: s.framebuffer(t, 1920, 1080), ModeValid: 1,
+		cModeInfo: conn.Modes[0].cModeInfo}, Name: conn.Modes[0].Name, SetConnectors: []uint32{s.connector}}
+	if err := l.Apply(set); err != nil {
+		t.Fatal(err)
+	}
+	return set
+}
+
+func TestLinkRecovery(t *testing.T) {
+	s := newFakeSetup(t)
+	l := s.card.NewLinkRecovery()
+	s.apply(t, l)
+	if recovered, err := l.Check(); len(recovered) != 0 || err != nil {
+		t.Errorf("good link recovered %v, %v", recovered, err)
+	}
+
+	s.dev.SetProperty(s.connector, "link-status", ModeLinkStatusBad)
+	recovered, err := l.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(recovered, []uint32{s.crtc}) {
+		t.Errorf("recovered %v", recovered)
+	}
+	if v, _ := s.dev.Property(s.connector, "link-status"); v != ModeLinkStatusGood {
+		t.Errorf("link-status is %d after recovery", v)
+	}
+	crtc, err := s.card.ModeGetCRTC(s.crtc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crtc.ModeValid == 0 || crtc.Name != "1920x1080" {
+		t.Errorf("crtc not restored: %+v", crtc)
+	}
+
+	// A configuration without connectors stops the tracking.
+	l.Track(ModeCRTC{cModeCRTC: cModeCRTC{ID: s.crtc}})
+	s.dev.SetProperty(s.connector, "link-status", ModeLinkStatusBad)
+	if recovered, err := l.Check(); len(recovered) != 0 || err != nil {
+		t.Errorf("untracked CRTC recovered %v, %v", recovered, err)
+	}
+}
+
+func TestLinkRecoveryRevalidatesMode(t *testing.T) {
+	s := newFakeSetup(t)
+	l := s.card.NewLinkRecovery()
+	s.apply(t, l)
+
+	// The link failed at the bandwidth of the mode, and the connector now only
+	// offers lower ones.
+	s.dev.Plug(s.connector, []ModeInfo{FakeMode(1024, 768, 60), FakeMode(1280, 720, 60)}, 600, 340, nil)
+	s.dev.SetProperty(s.connector, "link-status", ModeLinkStatusBad)
+	if _, err := l.Check(); err != nil {
+		t.Fatal(err)
+	}
+	crtc, err := s.card.ModeGetCRTC(s.crtc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crtc.Name != "1024x768" {
+		t.Errorf("crtc set to %s, want the preferred mode", crtc.Name)
+	}
+
+	// A connector without modes keeps the configuration, and reports an error.
+	s.dev.Plug(s.connector, nil, 600, 340, nil)
+	s.dev.SetProperty(s.connector, "link-status", ModeLinkStatusBad)
+	recovered, err := l.Check()
+	if err == nil {
+		t.Error("recovery of a connector without modes succeeded")
+	}
+	if !reflect.DeepEqual(recovered, []uint32{s.crtc}) {
+		t.Errorf("recovered %v", recovered)
+	}
+	if crtc, err = s.card.ModeGetCRTC(s.crtc); err != nil || crtc.Name != "1024x768" {
+		t.Errorf("crtc changed to %+v, %v", crtc, err)
+	}
+}
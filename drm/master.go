@@ -0,0 +1,104 @@
+package drm
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// InterfaceVersion is a version of the DRM interface (DI) and of the driver's
+// interface (DD), as negotiated by SetVersion.
+type InterfaceVersion struct {
+	DIMajor int32
+	DIMinor int32
+	DDMajor int32
+	DDMinor int32
+}
+
+// IsMaster returns whether the card's file is DRM master. The kernel has no
+// request for this, so like libdrm's drmIsMaster, it authenticates the invalid
+// magic 0: the kernel rejects it with EINVAL for masters, and with EACCES for
+// anyone else.
+func (c *Card) IsMaster() bool {
+	return errors.Is(c.AuthMagic(0), syscall.EINVAL)
+}
+
+// GetMagic returns a magic token identifying the card's file, which the master
+// can pass to AuthMagic to authenticate it. Clients of a primary node that are
+// not master must be authenticated to use rendering and buffer sharing ioctls.
+func (c *Card) GetMagic() (uint32, error) {
+	var auth cAuth
+	if err := c.ioctl(ioctlGetMagic, 0, unsafe.Pointer(&auth)); err != nil {
+		return 0, err
+	}
+	return auth.magic, nil
+}
+
+// AuthMagic authenticates the client that obtained magic with GetMagic. The card
+// must be master.
+func (c *Card) AuthMagic(magic uint32) error {
+	auth := cAuth{magic: magic}
+	return c.ioctl(ioctlAuthMagic, 0, unsafe.Pointer(&auth))
+}
+
+// Authenticate authenticates another file of the same device, e.g. one opened
+// to be handed to a helper process. The card must be master.
+func (c *Card) Authenticate(client *Card) error {
+	magic, err := client.GetMagic()
+	if err != nil {
+		return fmt.Errorf("get magic: %w", err)
+	}
+	if err := c.AuthMagic(magic); err != nil {
+		return fmt.Errorf("auth magic: %w", err)
+	}
+	return nil
+}
+
+// BusID returns the bus ID of the device, e.g. "pci:0000:01:00.0". The kernel
+// reports it once a master has negotiated interface version 1.1 or later with
+// SetVersion, and returns an empty string before then.
+func (c *Card) BusID() (string, error) {
+	var unique cUnique
+	if err := c.ioctl(ioctlGetUnique, 0, unsafe.Pointer(&unique)); err != nil {
+		return "", err
+	}
+	if unique.uniqueLen == 0 {
+		return "", nil
+	}
+	// The ID may change between the calls, in which case the kernel reports the
+	// new length without filling the buffer.
+	for {
+		b := make([]byte, unique.uniqueLen)
+		unique.unique = uint64(uintptr(unsafe.Pointer(&b[0])))
+		length := unique.uniqueLen
+		if err := c.ioctl(ioctlGetUnique, 0, unsafe.Pointer(&unique)); err != nil {
+			return "", err
+		}
+		if unique.uniqueLen <= length {
+			return cToGoString(b[:unique.uniqueLen]), nil
+		}
+	}
+}
+
+// SetVersion negotiates the interface version with the kernel and driver, and
+// returns the versions they implement. Fields of v set to -1 are not
+// negotiated. Requesting DI version 1.1 or later makes the kernel report the bus
+// ID. The card must be master.
+//
+// The kernel rejects a DI version other than 1.0 to 1.4, and a DD version whose
+// major version differs from the driver's or whose minor version is newer, with
+// an error wrapping EINVAL; the versions it implements are returned along with
+// the error.
+func (c *Card) SetVersion(v InterfaceVersion) (*InterfaceVersion, error) {
+	sv := cSetVersion{diMajor: v.DIMajor, diMinor: v.DIMinor, ddMajor: v.DDMajor, ddMinor: v.DDMinor}
+	err := c.ioctl(ioctlSetVersion, 0, unsafe.Pointer(&sv))
+	ret := &InterfaceVersion{DIMajor: sv.diMajor, DIMinor: sv.diMinor, DDMajor: sv.ddMajor, DDMinor: sv.ddMinor}
+	if err != nil {
+		if errors.Is(err, syscall.EINVAL) {
+			return ret, err
+		}
+		return nil, err
+	}
+	return ret, nil
+}
@@ -0,0 +1,82 @@
+package drm
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+)
+
+func TestMaster(t *testing.T) {
+	s := newFakeSetup(t)
+	if !s.card.IsMaster() {
+		t.Fatal("fake client is not master")
+	}
+
+	// The fake serves a single client, so a second card on the same device
+	// stands in for a helper process.
+	client := NewWithBackend(s.dev)
+	if err := s.card.Authenticate(client); err != nil {
+		t.Fatalf("authenticate: %s", err)
+	}
+	for magic, authenticated := range s.dev.magics {
+		if !authenticated {
+			t.Errorf("magic %d is not authenticated", magic)
+		}
+	}
+	if err := s.card.AuthMagic(12345); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("auth unknown magic: got %v, want EINVAL", err)
+	}
+
+	if err := s.card.DropMaster(); err != nil {
+		t.Fatalf("drop master: %s", err)
+	}
+	if s.card.IsMaster() {
+		t.Error("client is master after dropping master")
+	}
+	magic, err := client.GetMagic()
+	if err != nil {
+		t.Fatalf("get magic: %s", err)
+	}
+	if err := s.card.AuthMagic(magic); !errors.Is(err, syscall.EACCES) {
+		t.Errorf("auth magic without master: got %v, want EACCES", err)
+	}
+	if _, err := s.card.SetVersion(InterfaceVersion{1, 4, -1, -1}); !errors.Is(err, syscall.EACCES) {
+		t.Errorf("set version without master: got %v, want EACCES", err)
+	}
+}
+
+func TestSetVersion(t *testing.T) {
+	s := newFakeSetup(t)
+	id, err := s.card.BusID()
+	if err != nil {
+		t.Fatalf("bus id: %s", err)
+	}
+	if id != "" {
+		t.Errorf("bus id before negotiating: got %q, want none", id)
+	}
+
+	v, err := s.card.SetVersion(InterfaceVersion{1, 4, -1, -1})
+	if err != nil {
+		t.Fatalf("set version: %s", err)
+	}
+	if want := (InterfaceVersion{1, 4, 1, 0}); *v != want {
+		t.Errorf("set version: got %+v, want %+v", *v, want)
+	}
+	if id, err = s.card.BusID(); err != nil {
+		t.Fatalf("bus id: %s", err)
+	}
+	if id != "fake:0" {
+		t.Errorf("bus id: got %q, want fake:0", id)
+	}
+
+	v, err = s.card.SetVersion(InterfaceVersion{1, 9, -1, -1})
+	if !errors.Is(err, syscall.EINVAL) {
+		t.Fatalf("set unsupported version: got %v, want EINVAL", err)
+	}
+	if v == nil || v.DIMajor != 1 || v.DIMinor != 4 {
+		t.Errorf("set unsupported version: got %+v, want DI 1.4", v)
+	}
+	if _, err := s.card.SetVersion(InterfaceVersion{-1, -1, 2, 0}); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("set other driver major: got %v, want EINVAL", err)
+	}
+}
@@ -8,8 +8,8 @@ import (
 
 func (c *Card) ModeGetResources() (*ModeResources, error) {
 	var res cModeCardRes
-	if err := ioctl(c.fd, ioctlModeGetResources, uintptr(unsafe.Pointer(&res))); err != nil {
-		return nil, fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlModeGetResources, 0, unsafe.Pointer(&res)); err != nil {
+		return nil, err
 	}
 
 	ret := ModeResources{
@@ -36,8 +36,8 @@ func (c *Card) ModeGetResources() (*ModeResources, error) {
 	}
 	// A race could occur here if a hotplug event happens. Need logic to fire multiple
 	// times and check for consistency.
-	if err := ioctl(c.fd, ioctlModeGetResources, uintptr(unsafe.Pointer(&res))); err != nil {
-		return nil, fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlModeGetResources, 0, unsafe.Pointer(&res)); err != nil {
+		return nil, err
 	}
 
 	return &ret, nil
@@ -45,8 +45,8 @@ func (c *Card) ModeGetResources() (*ModeResources, error) {
 
 func (c *Card) ModeGetCRTC(crtcID uint32) (*ModeCRTC, error) {
 	crtc := cModeCRTC{ID: crtcID}
-	if err := ioctl(c.fd, ioctlModeGetCRTC, uintptr(unsafe.Pointer(&crtc))); err != nil {
-		return nil, fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlModeGetCRTC, crtcID, unsafe.Pointer(&crtc)); err != nil {
+		return nil, err
 	}
 	return &ModeCRTC{
 		cModeCRTC: crtc,
@@ -56,9 +56,6 @@ func (c *Card) ModeGetCRTC(crtcID uint32) (*ModeCRTC, error) {
 
 func (c *Card) ModeSetCRTC(set ModeCRTC) error {
 	crtc := cModeCRTC{
-		setConnectorsPtr: uint64(uintptr(unsafe.Pointer(&set.SetConnectors[0]))),
-		countConnectors:  uint32(len(set.SetConnectors)),
-
 		ID:        set.ID,
 		FBID:      set.FBID,
 		X:         set.X,
@@ -85,17 +82,22 @@ func (c *Card) ModeSetCRTC(set ModeCRTC) error {
 	for i := 0; i < displayModeLen && i < len(set.Name); i++ {
 		crtc.cModeInfo.name[i] = set.Name[i]
 	}
+	// An empty list of connectors together with a zero FBID disables the CRTC.
+	if len(set.SetConnectors) > 0 {
+		crtc.setConnectorsPtr = uint64(uintptr(unsafe.Pointer(&set.SetConnectors[0])))
+		crtc.countConnectors = uint32(len(set.SetConnectors))
+	}
 
-	if err := ioctl(c.fd, ioctlModeSetCRTC, uintptr(unsafe.Pointer(&crtc))); err != nil {
-		return fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlModeSetCRTC, set.ID, unsafe.Pointer(&crtc)); err != nil {
+		return err
 	}
 	return nil
 }
 
 func (c *Card) ModeGetPlane(id uint32) (*ModePlane, error) {
 	plane := cModeGetPlane{ID: id}
-	if err := ioctl(c.fd, ioctlModeGetPlane, uintptr(unsafe.Pointer(&plane))); err != nil {
-		return nil, fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlModeGetPlane, id, unsafe.Pointer(&plane)); err != nil {
+		return nil, err
 	}
 
 	ret := ModePlane{cModeGetPlane: plane}
@@ -103,16 +105,16 @@ func (c *Card) ModeGetPlane(id uint32) (*ModePlane, error) {
 		ret.FormatTypes = make([]uint32, plane.countFormatTypes)
 		plane.formatTypePtr = uint64(uintptr(unsafe.Pointer(&ret.FormatTypes[0])))
 	}
-	if err := ioctl(c.fd, ioctlModeGetPlane, uintptr(unsafe.Pointer(&plane))); err != nil {
-		return nil, fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlModeGetPlane, id, unsafe.Pointer(&plane)); err != nil {
+		return nil, err
 	}
 	return &ret, nil
 }
 
 func (c *Card) ModeGetPlaneResources() (*ModePlaneResources, error) {
 	res := cModeGetPlaneRes{}
-	if err := ioctl(c.fd, ioctlModeGetPlaneResources, uintptr(unsafe.Pointer(&res))); err != nil {
-		return nil, fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlModeGetPlaneResources, 0, unsafe.Pointer(&res)); err != nil {
+		return nil, err
 	}
 
 	var ret ModePlaneResources
@@ -120,24 +122,24 @@ func (c *Card) ModeGetPlaneResources() (*ModePlaneResources, error) {
 		ret = make([]uint32, res.countPlanes)
 		res.planeIDPtr = uint64(uintptr(unsafe.Pointer(&ret[0])))
 	}
-	if err := ioctl(c.fd, ioctlModeGetPlaneResources, uintptr(unsafe.Pointer(&res))); err != nil {
-		return nil, fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlModeGetPlaneResources, 0, unsafe.Pointer(&res)); err != nil {
+		return nil, err
 	}
 	return &ret, nil
 }
 
 func (c *Card) ModeGetEncoder(id uint32) (*ModeEncoder, error) {
 	encoder := cModeGetEncoder{ID: id}
-	if err := ioctl(c.fd, ioctlModeGetEncoder, uintptr(unsafe.Pointer(&encoder))); err != nil {
-		return nil, fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlModeGetEncoder, id, unsafe.Pointer(&encoder)); err != nil {
+		return nil, err
 	}
 	return &ModeEncoder{cModeGetEncoder: encoder}, nil
 }
 
 func (c *Card) ModeGetConnector(connectorID uint32) (*ModeConnector, error) {
 	conn := cModeGetConnector{ID: connectorID}
-	if err := ioctl(c.fd, ioctlModeGetConnector, uintptr(unsafe.Pointer(&conn))); err != nil {
-		return nil, fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlModeGetConnector, connectorID, unsafe.Pointer(&conn)); err != nil {
+		return nil, err
 	}
 
 	var modes []cModeInfo
@@ -158,8 +160,8 @@ func (c *Card) ModeGetConnector(connectorID uint32) (*ModeConnector, error) {
 		conn.propsPtr = uint64(uintptr(unsafe.Pointer(&ret.PropIDs[0])))
 		conn.propValuesPtr = uint64(uintptr(unsafe.Pointer(&ret.PropValues[0])))
 	}
-	if err := ioctl(c.fd, ioctlModeGetConnector, uintptr(unsafe.Pointer(&conn))); err != nil {
-		return nil, fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlModeGetConnector, connectorID, unsafe.Pointer(&conn)); err != nil {
+		return nil, err
 	}
 
 	for _, mode := range modes {
@@ -173,8 +175,8 @@ func (c *Card) ModeGetConnector(connectorID uint32) (*ModeConnector, error) {
 
 func (c *Card) ModeGetProperty(propID uint32) (*ModeProperty, error) {
 	prop := cModeGetProperty{propID: propID}
-	if err := ioctl(c.fd, ioctlModeGetProperty, uintptr(unsafe.Pointer(&prop))); err != nil {
-		return nil, fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlModeGetProperty, propID, unsafe.Pointer(&prop)); err != nil {
+		return nil, err
 	}
 
 	var enums []cModePropertyEnum
@@ -191,8 +193,8 @@ func (c *Card) ModeGetProperty(propID uint32) (*ModeProperty, error) {
 		enums = make([]cModePropertyEnum, prop.countEnumBlobs)
 		prop.enumBlobPtr = uint64(uintptr(unsafe.Pointer(&enums[0])))
 	}
-	if err := ioctl(c.fd, ioctlModeGetProperty, uintptr(unsafe.Pointer(&prop))); err != nil {
-		return nil, fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlModeGetProperty, propID, unsafe.Pointer(&prop)); err != nil {
+		return nil, err
 	}
 
 	for _, enum := range enums {
@@ -210,16 +212,16 @@ func (c *Card) ModeConnectorSetProperty(connectorID, propID uint32, value uint64
 		propID:      propID,
 		connectorID: connectorID,
 	}
-	if err := ioctl(c.fd, ioctlModeSetProperty, uintptr(unsafe.Pointer(&prop))); err != nil {
-		return fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlModeSetProperty, connectorID, unsafe.Pointer(&prop)); err != nil {
+		return err
 	}
 	return nil
 }
 
 func (c *Card) ModeObjGetProperties(id, kind uint32) (*ModeObjProperties, error) {
 	prop := cModeObjGetProperties{objID: id, objType: kind}
-	if err := ioctl(c.fd, ioctlModeObjGetProperties, uintptr(unsafe.Pointer(&prop))); err != nil {
-		return nil, fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlModeObjGetProperties, id, unsafe.Pointer(&prop)); err != nil {
+		return nil, err
 	}
 
 	ret := ModeObjProperties{ID: prop.objID, Type: prop.objType}
@@ -229,16 +231,31 @@ func (c *Card) ModeObjGetProperties(id, kind uint32) (*ModeObjProperties, error)
 		prop.propsPtr = uint64(uintptr(unsafe.Pointer(&ret.PropIDs[0])))
 		prop.propValuesPtr = uint64(uintptr(unsafe.Pointer(&ret.PropValues[0])))
 	}
-	if err := ioctl(c.fd, ioctlModeObjGetProperties, uintptr(unsafe.Pointer(&prop))); err != nil {
-		return nil, fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlModeObjGetProperties, id, unsafe.Pointer(&prop)); err != nil {
+		return nil, err
 	}
 	return &ret, nil
 }
 
+// ModeObjSetProperty sets a property of any mode object, e.g. a CRTC or plane.
+// Kind is one of the ModeObject* constants.
+func (c *Card) ModeObjSetProperty(id, kind, propID uint32, value uint64) error {
+	prop := cModeObjSetProperty{
+		value:   value,
+		propID:  propID,
+		objID:   id,
+		objType: kind,
+	}
+	if err := c.ioctl(ioctlModeObjSetProperty, id, unsafe.Pointer(&prop)); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (c *Card) ModeGetBlob(id uint32) (*ModeBlob, error) {
 	blob := cModeGetBlob{blobID: id}
-	if err := ioctl(c.fd, ioctlModeGetPropBlob, uintptr(unsafe.Pointer(&blob))); err != nil {
-		return nil, fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlModeGetPropBlob, id, unsafe.Pointer(&blob)); err != nil {
+		return nil, err
 	}
 
 	ret := ModeBlob{ID: blob.blobID}
@@ -246,20 +263,40 @@ func (c *Card) ModeGetBlob(id uint32) (*ModeBlob, error) {
 		ret.Data = make([]uint8, blob.length)
 		blob.data = uint64(uintptr(unsafe.Pointer(&ret.Data[0])))
 	}
-	if err := ioctl(c.fd, ioctlModeGetPropBlob, uintptr(unsafe.Pointer(&blob))); err != nil {
-		return nil, fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlModeGetPropBlob, id, unsafe.Pointer(&blob)); err != nil {
+		return nil, err
 	}
 	return &ret, nil
 }
 
+// ModeCreatePropBlob creates a property blob holding data, and returns its ID.
+func (c *Card) ModeCreatePropBlob(data []byte) (uint32, error) {
+	blob := cModeCreateBlob{length: uint32(len(data))}
+	if len(data) > 0 {
+		blob.data = uint64(uintptr(unsafe.Pointer(&data[0])))
+	}
+	if err := c.ioctl(ioctlModeCreatePropBlob, 0, unsafe.Pointer(&blob)); err != nil {
+		return 0, err
+	}
+	return blob.blobID, nil
+}
+
+func (c *Card) ModeDestroyPropBlob(id uint32) error {
+	blob := cModeDestroyBlob{blobID: id}
+	if err := c.ioctl(ioctlModeDestroyPropBlob, id, unsafe.Pointer(&blob)); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (c *Card) ModeCreateLease(objects []uint32, flags uint32) (*ModeLease, error) {
 	lease := cModeCreateLease{
 		objectIDs:   uint64(uintptr(unsafe.Pointer(&objects[0]))),
 		objectCount: uint32(len(objects)),
 		flags:       flags,
 	}
-	if err := ioctl(c.fd, ioctlModeCreateLease, uintptr(unsafe.Pointer(&lease))); err != nil {
-		return nil, fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlModeCreateLease, 0, unsafe.Pointer(&lease)); err != nil {
+		return nil, err
 	}
 	return &ModeLease{
 		Fd:      lease.fd,
@@ -270,8 +307,8 @@ func (c *Card) ModeCreateLease(objects []uint32, flags uint32) (*ModeLease, erro
 
 func (c *Card) ModeGetLease() ([]uint32, error) {
 	lease := cModeGetLease{}
-	if err := ioctl(c.fd, ioctlModeGetLease, uintptr(unsafe.Pointer(&lease))); err != nil {
-		return nil, fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlModeGetLease, 0, unsafe.Pointer(&lease)); err != nil {
+		return nil, err
 	}
 
 	var ret []uint32
@@ -279,16 +316,16 @@ func (c *Card) ModeGetLease() ([]uint32, error) {
 		ret = make([]uint32, lease.countObjects)
 		lease.objectsPtr = uint64(uintptr(unsafe.Pointer(&ret[0])))
 	}
-	if err := ioctl(c.fd, ioctlModeGetLease, uintptr(unsafe.Pointer(&lease))); err != nil {
-		return nil, fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlModeGetLease, 0, unsafe.Pointer(&lease)); err != nil {
+		return nil, err
 	}
 	return ret, nil
 }
 
 func (c *Card) ModeListLessees() ([]uint32, error) {
 	lease := cModeListLessees{}
-	if err := ioctl(c.fd, ioctlModeListLessees, uintptr(unsafe.Pointer(&lease))); err != nil {
-		return nil, fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlModeListLessees, 0, unsafe.Pointer(&lease)); err != nil {
+		return nil, err
 	}
 
 	var ret []uint32
@@ -296,16 +333,16 @@ func (c *Card) ModeListLessees() ([]uint32, error) {
 		ret = make([]uint32, lease.countLessees)
 		lease.lesseesPtr = uint64(uintptr(unsafe.Pointer(&ret[0])))
 	}
-	if err := ioctl(c.fd, ioctlModeListLessees, uintptr(unsafe.Pointer(&lease))); err != nil {
-		return nil, fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlModeListLessees, 0, unsafe.Pointer(&lease)); err != nil {
+		return nil, err
 	}
 	return ret, nil
 }
 
 func (c *Card) ModeRevokeLease(id uint32) error {
 	lease := cModeRevokeLease{lesseeID: id}
-	if err := ioctl(c.fd, ioctlModeRevokeLease, uintptr(unsafe.Pointer(&lease))); err != nil {
-		return fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlModeRevokeLease, id, unsafe.Pointer(&lease)); err != nil {
+		return err
 	}
 	return nil
 }
@@ -317,8 +354,8 @@ func (c *Card) ModeCreateDumb(height, width, bpp uint32) (*ModeDumbBuffer, error
 		Width:  width,
 		Bpp:    bpp,
 	}
-	if err := ioctl(c.fd, ioctlModeCreateDumb, uintptr(unsafe.Pointer(&buf))); err != nil {
-		return nil, fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlModeCreateDumb, 0, unsafe.Pointer(&buf)); err != nil {
+		return nil, err
 	}
 	return &ModeDumbBuffer{cModeCreateDumb: buf}, nil
 }
@@ -327,23 +364,42 @@ func (c *Card) ModeCreateDumb(height, width, bpp uint32) (*ModeDumbBuffer, error
 // to use in a subsequent mmap call.
 func (c *Card) ModeMapDumb(handle uint32) (uint64, error) {
 	dumb := cModeMapDumb{handle: handle}
-	if err := ioctl(c.fd, ioctlModeMapDumb, uintptr(unsafe.Pointer(&dumb))); err != nil {
-		return 0, fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlModeMapDumb, 0, unsafe.Pointer(&dumb)); err != nil {
+		return 0, err
 	}
 	return dumb.offset, nil
 }
 
+// MmapDumb maps a dumb scanout buffer into memory. The card must have been opened
+// for writing. The returned slice should be released with Munmap.
+func (c *Card) MmapDumb(buf *ModeDumbBuffer) ([]byte, error) {
+	offset, err := c.ModeMapDumb(buf.Handle)
+	if err != nil {
+		return nil, err
+	}
+	b, err := c.backend.Mmap(int64(offset), int(buf.Size))
+	if err != nil {
+		return nil, fmt.Errorf("mmap: %w", err)
+	}
+	return b, nil
+}
+
+// Munmap releases memory obtained from MmapDumb.
+func (c *Card) Munmap(b []byte) error {
+	return c.backend.Munmap(b)
+}
+
 func (c *Card) ModeDestroyDumb(handle uint32) error {
 	dumb := cModeDestroyDumb{handle: handle}
-	if err := ioctl(c.fd, ioctlModeDestroyDumb, uintptr(unsafe.Pointer(&dumb))); err != nil {
-		return fmt.Errorf("ioctl: %w", err)
+	if err := c.ioctl(ioctlModeDestroyDumb, 0, unsafe.Pointer(&dumb)); err != nil {
+		return err
 	}
 	return nil
 }
 
 func (c *Card) ModeGetFramebuffer(id uint32) (*ModeFramebuffer, error) {
 	fb := cModeFBCmd{ID: id}
-	if err := ioctl(c.fd, ioctlModeGetFB, uintptr(unsafe.Pointer(&fb))); err != nil {
+	if err := c.ioctl(ioctlModeGetFB, id, unsafe.Pointer(&fb)); err != nil {
 		return nil, err
 	}
 	return &ModeFramebuffer{cModeFBCmd: fb}, nil
@@ -358,12 +414,70 @@ func (c *Card) ModeAddFramebuffer(width, height, pitch, bpp, depth, handle uint3
 		Depth:  depth,
 		Handle: handle,
 	}
-	if err := ioctl(c.fd, ioctlModeAddFB, uintptr(unsafe.Pointer(&fb))); err != nil {
+	if err := c.ioctl(ioctlModeAddFB, 0, unsafe.Pointer(&fb)); err != nil {
 		return nil, err
 	}
 	return &ModeFramebuffer{cModeFBCmd: fb}, nil
 }
 
 func (c *Card) ModeRemoveFramebuffer(id uint32) error {
-	return ioctl(c.fd, ioctlModeRmFB, uintptr(unsafe.Pointer(&id)))
+	return c.ioctl(ioctlModeRmFB, id, unsafe.Pointer(&id))
+}
+
+// ModeAddFramebuffer2 adds a framebuffer described by a pixel format rather than
+// depth and bpp. Each of the handles, pitches and offsets describe one plane of
+// the buffer. Modifiers are only considered if ModeFBModifiers is set in flags.
+func (c *Card) ModeAddFramebuffer2(width, height, format, flags uint32, handles, pitches,
+	offsets [4]uint32, modifiers [4]uint64) (*ModeFramebuffer2, error) {
+	fb := cModeFBCmd2{
+		Width:       width,
+		Height:      height,
+		PixelFormat: format,
+		Flags:       flags,
+		Handles:     handles,
+		Pitches:     pitches,
+		Offsets:     offsets,
+		Modifier:    modifiers,
+	}
+	if err := c.ioctl(ioctlModeAddFB2, 0, unsafe.Pointer(&fb)); err != nil {
+		return nil, err
+	}
+	return &ModeFramebuffer2{cModeFBCmd2: fb}, nil
+}
+
+// ModePageFlip schedules a flip of the given CRTC to a new framebuffer. If
+// ModePageFlipEvent is set in flags, an EventFlipComplete carrying userData can
+// be read with ReadEvents once the flip has happened.
+func (c *Card) ModePageFlip(crtcID, fbID, flags uint32, userData uint64) error {
+	flip := cModeCRTCPageFlip{
+		crtcID:   crtcID,
+		fbID:     fbID,
+		flags:    flags,
+		userData: userData,
+	}
+	if err := c.ioctl(ioctlModePageFlip, crtcID, unsafe.Pointer(&flip)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// MarshalBinary encodes the mode as the contents of a MODE_ID blob, which sets
+// the mode of a CRTC in an atomic commit.
+func (m *ModeInfo) MarshalBinary() ([]byte, error) {
+	mode := m.cModeInfo
+	mode.name = [displayModeLen]byte{}
+	copy(mode.name[:displayModeLen-1], m.Name)
+	b := make([]byte, unsafe.Sizeof(mode))
+	copy(b, unsafe.Slice((*byte)(unsafe.Pointer(&mode)), len(b)))
+	return b, nil
+}
+
+// UnmarshalBinary decodes the contents of a MODE_ID blob.
+func (m *ModeInfo) UnmarshalBinary(b []byte) error {
+	if len(b) < int(unsafe.Sizeof(m.cModeInfo)) {
+		return fmt.Errorf("mode: short blob of %d bytes", len(b))
+	}
+	copy(unsafe.Slice((*byte)(unsafe.Pointer(&m.cModeInfo)), unsafe.Sizeof(m.cModeInfo)), b)
+	m.Name = cToGoString(m.name[:])
+	return nil
 }
@@ -0,0 +1,324 @@
+package drm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Format modifier vendors, from drm/drm_fourcc.h.
+const (
+	FormatModVendorNone      uint8 = 0
+	FormatModVendorIntel     uint8 = 0x01
+	FormatModVendorAMD       uint8 = 0x02
+	FormatModVendorNVIDIA    uint8 = 0x03
+	FormatModVendorSamsung   uint8 = 0x04
+	FormatModVendorQcom      uint8 = 0x05
+	FormatModVendorVivante   uint8 = 0x06
+	FormatModVendorBroadcom  uint8 = 0x07
+	FormatModVendorARM       uint8 = 0x08
+	FormatModVendorAllwinner uint8 = 0x09
+	FormatModVendorAmlogic   uint8 = 0x0a
+)
+
+// Format modifiers, from drm/drm_fourcc.h. Modifiers describe the tiling and
+// compression of a buffer. The vendor is stored in the top 8 bits.
+const (
+	FormatModInvalid uint64 = 0x00ffffffffffffff
+	FormatModLinear  uint64 = 0
+
+	FormatModIntelXTiled  = uint64(FormatModVendorIntel)<<56 | 1
+	FormatModIntelYTiled  = uint64(FormatModVendorIntel)<<56 | 2
+	FormatModIntelYfTiled = uint64(FormatModVendorIntel)<<56 | 3
+	FormatModIntel4Tiled  = uint64(FormatModVendorIntel)<<56 | 9
+
+	FormatModNVIDIATegraTiled = uint64(FormatModVendorNVIDIA)<<56 | 1
+
+	FormatModBroadcomVC4TTiled = uint64(FormatModVendorBroadcom)<<56 | 1
+	FormatModBroadcomUIF       = uint64(FormatModVendorBroadcom)<<56 | 6
+)
+
+var formatModVendorNames = map[uint8]string{
+	FormatModVendorNone:      "NONE",
+	FormatModVendorIntel:     "INTEL",
+	FormatModVendorAMD:       "AMD",
+	FormatModVendorNVIDIA:    "NVIDIA",
+	FormatModVendorSamsung:   "SAMSUNG",
+	FormatModVendorQcom:      "QCOM",
+	FormatModVendorVivante:   "VIVANTE",
+	FormatModVendorBroadcom:  "BROADCOM",
+	FormatModVendorARM:       "ARM",
+	FormatModVendorAllwinner: "ALLWINNER",
+	FormatModVendorAmlogic:   "AMLOGIC",
+}
+
+// ModifierVendor returns the vendor of a format modifier.
+func ModifierVendor(mod uint64) uint8 {
+	return uint8(mod >> 56)
+}
+
+// ModifierVendorName returns the name of the vendor of a format modifier, e.g.
+// "INTEL".
+func ModifierVendorName(mod uint64) string {
+	if name, ok := formatModVendorNames[ModifierVendor(mod)]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", ModifierVendor(mod))
+}
+
+var intelModifierNames = map[uint64]string{
+	1:  "X_TILED",
+	2:  "Y_TILED",
+	3:  "Yf_TILED",
+	4:  "Y_TILED_CCS",
+	5:  "Yf_TILED_CCS",
+	6:  "Y_TILED_GEN12_RC_CCS",
+	7:  "Y_TILED_GEN12_MC_CCS",
+	8:  "Y_TILED_GEN12_RC_CCS_CC",
+	9:  "4_TILED",
+	10: "4_TILED_DG2_RC_CCS",
+	11: "4_TILED_DG2_MC_CCS",
+	12: "4_TILED_DG2_RC_CCS_CC",
+	13: "4_TILED_MTL_RC_CCS",
+	14: "4_TILED_MTL_MC_CCS",
+	15: "4_TILED_MTL_RC_CCS_CC",
+}
+
+var broadcomModifierNames = map[uint64]string{
+	1: "VC4_T_TILED",
+	2: "SAND32",
+	3: "SAND64",
+	4: "SAND128",
+	5: "SAND256",
+	6: "UIF",
+}
+
+// ModifierName returns a human readable name of a format modifier, e.g.
+// "LINEAR", "INTEL_X_TILED" or "ARM_AFBC(BLOCK_SIZE=16x16,YTR,SPARSE)".
+// Modifiers that cannot be decoded are returned in hex, prefixed by their
+// vendor.
+func ModifierName(mod uint64) string {
+	switch mod {
+	case FormatModInvalid:
+		return "INVALID"
+	case FormatModLinear:
+		return "LINEAR"
+	}
+
+	val := mod & 0x00ffffffffffffff
+	vendor := ModifierVendorName(mod)
+	var name string
+	switch ModifierVendor(mod) {
+	case FormatModVendorIntel:
+		name = intelModifierNames[val]
+	case FormatModVendorBroadcom:
+		if n, ok := broadcomModifierNames[val&0xff]; ok {
+			name = n
+			if param := val >> 8; param != 0 {
+				name += fmt.Sprintf("(%d)", param)
+			}
+		}
+	case FormatModVendorNVIDIA:
+		name = nvidiaModifierName(val)
+	case FormatModVendorARM:
+		name = armModifierName(val)
+	case FormatModVendorAMD:
+		name = amdModifierName(val)
+	}
+	if name == "" {
+		return fmt.Sprintf("%s_0x%014x", vendor, val)
+	}
+	return vendor + "_" + name
+}
+
+func nvidiaModifierName(val uint64) string {
+	switch {
+	case val == 1:
+		return "TEGRA_TILED"
+	case val&0x10 == 0:
+		return ""
+	case val>>4 == 1:
+		// Legacy 16Bx2 block linear layout, with only a block height.
+		return fmt.Sprintf("16BX2_BLOCK(%d)", val&0xf)
+	}
+	return fmt.Sprintf("BLOCK_LINEAR_2D(h=%d,k=%d,g=%d,s=%d,c=%d)", val&0xf, val>>12&0xff,
+		val>>20&0x3, val>>22&0x1, val>>23&0x7)
+}
+
+const (
+	armTypeAFBC = 0x0
+	armTypeMisc = 0x1
+	armTypeAFRC = 0x2
+)
+
+var armAFBCBlockSizes = map[uint64]string{
+	1: "16x16",
+	2: "32x8",
+	3: "64x4",
+	4: "32x8_64x4",
+}
+
+var armAFBCFlags = []struct {
+	bit  uint64
+	name string
+}{
+	{1 << 4, "YTR"},
+	{1 << 5, "SPLIT"},
+	{1 << 6, "SPARSE"},
+	{1 << 7, "CBR"},
+	{1 << 8, "TILED"},
+	{1 << 9, "SC"},
+	{1 << 10, "DB"},
+	{1 << 11, "BCH"},
+	{1 << 12, "USM"},
+}
+
+func armModifierName(val uint64) string {
+	typ, mode := val>>52&0xf, val&0x000fffffffffffff
+	switch typ {
+	case armTypeAFBC:
+		fields := []string{"BLOCK_SIZE=" + armAFBCBlockSizes[mode&0xf]}
+		for _, flag := range armAFBCFlags {
+			if mode&flag.bit != 0 {
+				fields = append(fields, flag.name)
+			}
+		}
+		return "AFBC(" + strings.Join(fields, ",") + ")"
+	case armTypeMisc:
+		if mode == 1 {
+			return "16X16_BLOCK_U_INTERLEAVED"
+		}
+	case armTypeAFRC:
+		return fmt.Sprintf("AFRC(0x%x)", mode)
+	}
+	return ""
+}
+
+var amdTileVersions = map[uint64]string{
+	1: "GFX9",
+	2: "GFX10",
+	3: "GFX10_RBPLUS",
+	4: "GFX11",
+	5: "GFX12",
+}
+
+var amdTiles = map[uint64]string{
+	9:  "GFX9_64K_S",
+	10: "GFX9_64K_D",
+	25: "GFX9_64K_S_X",
+	26: "GFX9_64K_D_X",
+	27: "GFX9_64K_R_X",
+	31: "GFX11_256K_R_X",
+}
+
+func amdModifierName(val uint64) string {
+	field := func(shift, mask uint64) uint64 {
+		return val >> shift & mask
+	}
+	version, ok := amdTileVersions[field(0, 0xff)]
+	if !ok {
+		return ""
+	}
+	tile, ok := amdTiles[field(8, 0x1f)]
+	if !ok {
+		tile = fmt.Sprintf("%d", field(8, 0x1f))
+	}
+	fields := []string{"TILE=" + tile}
+	if field(13, 1) != 0 {
+		fields = append(fields, "DCC")
+		for _, flag := range []struct {
+			shift uint64
+			name  string
+		}{{14, "DCC_RETILE"}, {15, "DCC_PIPE_ALIGN"}, {16, "DCC_INDEPENDENT_64B"},
+			{17, "DCC_INDEPENDENT_128B"}, {20, "DCC_CONSTANT_ENCODE"}} {
+			if field(flag.shift, 1) != 0 {
+				fields = append(fields, flag.name)
+			}
+		}
+		fields = append(fields, fmt.Sprintf("DCC_MAX_COMPRESSED_BLOCK=%d", field(18, 0x3)))
+	}
+	fields = append(fields,
+		fmt.Sprintf("PIPE_XOR_BITS=%d", field(21, 0x7)),
+		fmt.Sprintf("BANK_XOR_BITS=%d", field(24, 0x7)),
+		fmt.Sprintf("PACKERS=%d", field(27, 0x7)),
+		fmt.Sprintf("RB=%d", field(30, 0x7)),
+		fmt.Sprintf("PIPE=%d", field(33, 0x7)),
+	)
+	return version + "(" + strings.Join(fields, ",") + ")"
+}
+
+// inFormatsHeaderLen is sizeof(struct drm_format_modifier_blob), and
+// inFormatsModifierLen is sizeof(struct drm_format_modifier).
+const (
+	inFormatsHeaderLen   = 24
+	inFormatsModifierLen = 24
+)
+
+// ParseInFormats decodes the IN_FORMATS blob of a plane, a struct
+// drm_format_modifier_blob, into the modifiers supported for each format.
+func ParseInFormats(data []byte) (map[uint32][]uint64, error) {
+	if len(data) < inFormatsHeaderLen {
+		return nil, fmt.Errorf("in formats: short blob of %d bytes", len(data))
+	}
+	le := binary.LittleEndian
+	var (
+		version         = le.Uint32(data[0:])
+		countFormats    = int(le.Uint32(data[8:]))
+		formatsOffset   = int(le.Uint32(data[12:]))
+		countModifiers  = int(le.Uint32(data[16:]))
+		modifiersOffset = int(le.Uint32(data[20:]))
+	)
+	if version != 1 {
+		return nil, fmt.Errorf("in formats: unsupported version %d", version)
+	}
+	if formatsOffset+countFormats*4 > len(data) ||
+		modifiersOffset+countModifiers*inFormatsModifierLen > len(data) {
+		return nil, fmt.Errorf("in formats: blob of %d bytes is too short for %d formats "+
+			"and %d modifiers", len(data), countFormats, countModifiers)
+	}
+
+	formats := make([]uint32, countFormats)
+	ret := make(map[uint32][]uint64, countFormats)
+	for i := range formats {
+		formats[i] = le.Uint32(data[formatsOffset+i*4:])
+		ret[formats[i]] = nil
+	}
+	for i := 0; i < countModifiers; i++ {
+		m := data[modifiersOffset+i*inFormatsModifierLen:]
+		// The mask selects up to 64 formats, starting at index offset.
+		mask, offset, mod := le.Uint64(m[0:]), int(le.Uint32(m[8:])), le.Uint64(m[16:])
+		for bit := 0; bit < 64; bit++ {
+			if mask&(1<<bit) != 0 && offset+bit < len(formats) {
+				ret[formats[offset+bit]] = append(ret[formats[offset+bit]], mod)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// PlaneFormatModifiers returns the modifiers supported by a plane for each of
+// its formats, from its IN_FORMATS property. If the driver does not support
+// modifiers, every format maps to nil, meaning the layout is implied.
+func (c *Card) PlaneFormatModifiers(planeID uint32) (map[uint32][]uint64, error) {
+	props, err := c.ObjectProperties(planeID, ModeObjectPlane)
+	if err != nil {
+		return nil, err
+	}
+	if prop, ok := props["IN_FORMATS"]; ok && prop.Value != 0 {
+		blob, err := c.ModeGetBlob(uint32(prop.Value))
+		if err != nil {
+			return nil, err
+		}
+		return ParseInFormats(blob.Data)
+	}
+
+	plane, err := c.ModeGetPlane(planeID)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[uint32][]uint64, len(plane.FormatTypes))
+	for _, format := range plane.FormatTypes {
+		ret[format] = nil
+	}
+	return ret, nil
+}
@@ -0,0 +1,135 @@
+package drm
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestModifierName(t *testing.T) {
+	arm := func(typ, mode uint64) uint64 { return uint64(FormatModVendorARM)<<56 | typ<<52 | mode }
+	amd := func(val uint64) uint64 { return uint64(FormatModVendorAMD)<<56 | val }
+	nvidia := func(c, s, g, k, h uint64) uint64 {
+		return uint64(FormatModVendorNVIDIA)<<56 | 0x10 | h | k<<12 | g<<20 | s<<22 | c<<23
+	}
+	broadcom := func(typ, param uint64) uint64 { return uint64(FormatModVendorBroadcom)<<56 | param<<8 | typ }
+
+	for _, tc := range []struct {
+		mod        uint64
+		name, vend string
+	}{
+		{FormatModLinear, "LINEAR", "NONE"},
+		{FormatModInvalid, "INVALID", "NONE"},
+		{FormatModIntelXTiled, "INTEL_X_TILED", "INTEL"},
+		{uint64(FormatModVendorIntel)<<56 | 6, "INTEL_Y_TILED_GEN12_RC_CCS", "INTEL"},
+		{FormatModIntel4Tiled, "INTEL_4_TILED", "INTEL"},
+		{uint64(FormatModVendorIntel)<<56 | 99, "INTEL_0x00000000000063", "INTEL"},
+
+		// AMD_FMT_MOD with a GFX9 64K_S tile, and with a GFX10 64K_R_X tile
+		// and DCC, as amdgpu advertises for its displayable surfaces.
+		{amd(1 | 9<<8 | 2<<21), "AMD_GFX9(TILE=GFX9_64K_S,PIPE_XOR_BITS=2,BANK_XOR_BITS=0," +
+			"PACKERS=0,RB=0,PIPE=0)", "AMD"},
+		{amd(2 | 27<<8 | 1<<13 | 1<<16 | 1<<18 | 3<<21 | 2<<27), "AMD_GFX10(TILE=GFX9_64K_R_X,DCC," +
+			"DCC_INDEPENDENT_64B,DCC_MAX_COMPRESSED_BLOCK=1,PIPE_XOR_BITS=3,BANK_XOR_BITS=0,PACKERS=2," +
+			"RB=0,PIPE=0)", "AMD"},
+		{amd(4 | 20<<8), "AMD_GFX11(TILE=20,PIPE_XOR_BITS=0,BANK_XOR_BITS=0,PACKERS=0,RB=0,PIPE=0)", "AMD"},
+		{amd(0x7f), "AMD_0x0000000000007f", "AMD"},
+
+		// DRM_FORMAT_MOD_ARM_AFBC with AFBC_FORMAT_MOD_* flags.
+		{arm(0, 1|1<<4|1<<6), "ARM_AFBC(BLOCK_SIZE=16x16,YTR,SPARSE)", "ARM"},
+		{arm(0, 2|1<<5|1<<6|1<<8), "ARM_AFBC(BLOCK_SIZE=32x8,SPLIT,SPARSE,TILED)", "ARM"},
+		{arm(1, 1), "ARM_16X16_BLOCK_U_INTERLEAVED", "ARM"},
+		{arm(2, 0x12), "ARM_AFRC(0x12)", "ARM"},
+		{arm(1, 2), "ARM_0x10000000000002", "ARM"},
+
+		// DRM_FORMAT_MOD_NVIDIA_BLOCK_LINEAR_2D(c, s, g, k, h), of which the
+		// legacy 16Bx2 layouts only set h.
+		{nvidia(1, 0, 2, 0xfe, 4), "NVIDIA_BLOCK_LINEAR_2D(h=4,k=254,g=2,s=0,c=1)", "NVIDIA"},
+		{nvidia(0, 0, 0, 0, 5), "NVIDIA_16BX2_BLOCK(5)", "NVIDIA"},
+		{FormatModNVIDIATegraTiled, "NVIDIA_TEGRA_TILED", "NVIDIA"},
+
+		// DRM_FORMAT_MOD_BROADCOM_SAND*_COL_HEIGHT(v).
+		{broadcom(4, 96), "BROADCOM_SAND128(96)", "BROADCOM"},
+		{broadcom(3, 0), "BROADCOM_SAND64", "BROADCOM"},
+		{FormatModBroadcomVC4TTiled, "BROADCOM_VC4_T_TILED", "BROADCOM"},
+		{FormatModBroadcomUIF, "BROADCOM_UIF", "BROADCOM"},
+
+		{0x42<<56 | 1, "0x42_0x00000000000001", "0x42"},
+	} {
+		if got := ModifierName(tc.mod); got != tc.name {
+			t.Errorf("name of 0x%016x is %s, want %s", tc.mod, got, tc.name)
+		}
+		if got := ModifierVendorName(tc.mod); got != tc.vend {
+			t.Errorf("vendor of 0x%016x is %s, want %s", tc.mod, got, tc.vend)
+		}
+	}
+}
+
+// inFormatsModifier is a struct drm_format_modifier.
+type inFormatsModifier struct {
+	formats  uint64
+	offset   uint32
+	modifier uint64
+}
+
+// inFormatsBlob encodes a struct drm_format_modifier_blob, aligning the
+// modifiers to 8 bytes as the kernel does.
+func inFormatsBlob(version uint32, formats []uint32, mods []inFormatsModifier) []byte {
+	le := binary.LittleEndian
+	modsOffset := (inFormatsHeaderLen + 4*len(formats) + 7) &^ 7
+	b := make([]byte, modsOffset+inFormatsModifierLen*len(mods))
+	le.PutUint32(b[0:], version)
+	le.PutUint32(b[8:], uint32(len(formats)))
+	le.PutUint32(b[12:], inFormatsHeaderLen)
+	le.PutUint32(b[16:], uint32(len(mods)))
+	le.PutUint32(b[20:], uint32(modsOffset))
+	for i, f := range formats {
+		le.PutUint32(b[inFormatsHeaderLen+4*i:], f)
+	}
+	for i, m := range mods {
+		p := b[modsOffset+inFormatsModifierLen*i:]
+		le.PutUint64(p[0:], m.formats)
+		le.PutUint32(p[8:], m.offset)
+		le.PutUint64(p[16:], m.modifier)
+	}
+	return b
+}
+
+func TestParseInFormats(t *testing.T) {
+	formats := []uint32{FormatXRGB8888, FormatARGB8888, FormatNV12}
+	data := inFormatsBlob(1, formats, []inFormatsModifier{
+		{0b111, 0, FormatModIntelXTiled},
+		{0b011, 0, FormatModIntelYTiled},
+		// The mask is relative to the offset, and ignores formats past the end.
+		{0b110, 1, FormatModIntel4Tiled},
+		{0b111, 0, FormatModLinear},
+	})
+	got, err := ParseInFormats(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[uint32][]uint64{
+		FormatXRGB8888: {FormatModIntelXTiled, FormatModIntelYTiled, FormatModLinear},
+		FormatARGB8888: {FormatModIntelXTiled, FormatModIntelYTiled, FormatModLinear},
+		FormatNV12:     {FormatModIntelXTiled, FormatModIntel4Tiled, FormatModLinear},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parsed %v, want %v", got, want)
+	}
+
+	// Formats without modifiers are listed all the same.
+	got, err = ParseInFormats(inFormatsBlob(1, formats[:1], nil))
+	if err != nil || !reflect.DeepEqual(got, map[uint32][]uint64{FormatXRGB8888: nil}) {
+		t.Errorf("parsed %v, %v", got, err)
+	}
+
+	for name, data := range map[string][]byte{
+		"short header":    data[:inFormatsHeaderLen-1],
+		"truncated":       data[:len(data)-1],
+		"unknown version": inFormatsBlob(2, formats, nil),
+	} {
+		if got, err := ParseInFormats(data); err == nil {
+			t.Errorf("%s: parsed %v", name, got)
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package drm
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/inahga/inahgo/drm/edid"
+)
+
+// TileInfo is the decoded "TILE" blob of a connector, which the kernel sets for
+// connectors driving one tile of a tiled display.
+type TileInfo struct {
+	// GroupID identifies the tiled display. It is the same for all of its tiles.
+	GroupID uint32
+	// SingleMonitor indicates that all tiles are in a single enclosure.
+	SingleMonitor bool
+	NumHTiles     int
+	NumVTiles     int
+	// HLoc and VLoc are the location of the tile, starting at 0 in the top left.
+	HLoc int
+	VLoc int
+	// HSize and VSize are the size of the tile in pixels.
+	HSize int
+	VSize int
+}
+
+// ParseTile decodes a TILE blob, which has the form
+// "group:single:num_h:num_v:h_loc:v_loc:h_size:v_size".
+func ParseTile(data []byte) (*TileInfo, error) {
+	fields := strings.Split(cToGoString(data), ":")
+	if len(fields) != 8 {
+		return nil, fmt.Errorf("invalid tile blob %q", cToGoString(data))
+	}
+	var v [8]int
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tile blob %q: %w", cToGoString(data), err)
+		}
+		v[i] = n
+	}
+	return &TileInfo{
+		GroupID:       uint32(v[0]),
+		SingleMonitor: v[1] != 0,
+		NumHTiles:     v[2],
+		NumVTiles:     v[3],
+		HLoc:          v[4],
+		VLoc:          v[5],
+		HSize:         v[6],
+		VSize:         v[7],
+	}, nil
+}
+
+// Path is the decoded "PATH" blob of a DisplayPort MST connector, which locates
+// the connector in a daisy chain, e.g. "mst:95-1-8".
+type Path struct {
+	// ParentConnectorID is the ID of the physical connector the chain starts at.
+	ParentConnectorID uint32
+	// Ports are the port numbers along the chain, starting at the parent.
+	Ports []int
+}
+
+func (p *Path) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "mst:%d", p.ParentConnectorID)
+	for _, port := range p.Ports {
+		fmt.Fprintf(&b, "-%d", port)
+	}
+	return b.String()
+}
+
+// ParsePath decodes a PATH blob.
+func ParsePath(data []byte) (*Path, error) {
+	s := cToGoString(data)
+	if !strings.HasPrefix(s, "mst:") {
+		return nil, fmt.Errorf("unsupported path %q", s)
+	}
+	fields := strings.Split(strings.TrimPrefix(s, "mst:"), "-")
+	parent, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path %q: %w", s, err)
+	}
+	ret := Path{ParentConnectorID: uint32(parent)}
+	for _, field := range fields[1:] {
+		port, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path %q: %w", s, err)
+		}
+		ret.Ports = append(ret.Ports, port)
+	}
+	return &ret, nil
+}
+
+// ConnectorTile returns the tile information of a connector, or nil if it does
+// not drive a tile of a tiled display.
+func (c *Card) ConnectorTile(connectorID uint32) (*TileInfo, error) {
+	data, err := c.connectorBlob(connectorID, "TILE")
+	if err != nil || data == nil {
+		return nil, err
+	}
+	return ParseTile(data)
+}
+
+// ConnectorPath returns the MST path of a connector, or nil if it is not an MST
+// connector.
+func (c *Card) ConnectorPath(connectorID uint32) (*Path, error) {
+	data, err := c.connectorBlob(connectorID, "PATH")
+	if err != nil || data == nil {
+		return nil, err
+	}
+	return ParsePath(data)
+}
+
+// connectorBlob returns the contents of the named blob property of a connector,
+// or nil if the property is unset.
+func (c *Card) connectorBlob(connectorID uint32, name string) ([]byte, error) {
+	props, err := c.ObjectProperties(connectorID, ModeObjectConnector)
+	if err != nil {
+		return nil, err
+	}
+	prop, ok := props[name]
+	if !ok || prop.Value == 0 {
+		return nil, nil
+	}
+	blob, err := c.ModeGetBlob(uint32(prop.Value))
+	if err != nil {
+		return nil, err
+	}
+	return blob.Data, nil
+}
+
+// Monitor is a logical monitor, driven by one connector or, for tiled displays,
+// by one connector per tile.
+type Monitor struct {
+	// GroupID is the tile group of a tiled display, or 0 if the monitor is driven
+	// by a single connector.
+	GroupID uint32
+	// Tiles are ordered from the top left to the bottom right.
+	Tiles []MonitorTile
+	// Width and Height are the size of the whole monitor in pixels.
+	Width  int
+	Height int
+}
+
+// MonitorTile is the part of a monitor driven by one connector.
+type MonitorTile struct {
+	ConnectorID uint32
+	// X and Y are the position of the tile within the monitor.
+	X int
+	Y int
+	// Width and Height are the size of the tile in pixels.
+	Width  int
+	Height int
+	// Bezel is the size of the bezels around the tile as advertised in the EDID,
+	// or nil if unknown.
+	Bezel *edid.Bezel
+}
+
+// Monitors returns the connected monitors, grouping the connectors of tiled
+// displays into one monitor. Untiled monitors are sized by their preferred mode.
+func (c *Card) Monitors() ([]Monitor, error) {
+	res, err := c.ModeGetResources()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		ret    []Monitor
+		groups = make(map[uint32]*Monitor)
+		order  []uint32
+	)
+	for _, id := range res.ConnectorIDs {
+		conn, err := c.ModeGetConnector(id)
+		if err != nil {
+			return nil, err
+		}
+		if conn.Connection != ModeConnected {
+			continue
+		}
+		tile, err := c.ConnectorTile(id)
+		if err != nil {
+			return nil, err
+		}
+
+		if tile == nil {
+			mode := preferredMode(conn)
+			if mode == nil {
+				continue
+			}
+			w, h := int(mode.HDisplay), int(mode.VDisplay)
+			ret = append(ret, Monitor{
+				Tiles:  []MonitorTile{{ConnectorID: id, Width: w, Height: h}},
+				Width:  w,
+				Height: h,
+			})
+			continue
+		}
+
+		m, ok := groups[tile.GroupID]
+		if !ok {
+			m = &Monitor{GroupID: tile.GroupID}
+			groups[tile.GroupID] = m
+			order = append(order, tile.GroupID)
+		}
+		m.Tiles = append(m.Tiles, MonitorTile{
+			ConnectorID: id,
+			// Tiles in the same row or column are all the same size.
+			X:      tile.HLoc * tile.HSize,
+			Y:      tile.VLoc * tile.VSize,
+			Width:  tile.HSize,
+			Height: tile.VSize,
+			Bezel:  c.tileBezel(id),
+		})
+	}
+
+	for _, id := range order {
+		m := groups[id]
+		sort.Slice(m.Tiles, func(i, j int) bool {
+			if m.Tiles[i].Y != m.Tiles[j].Y {
+				return m.Tiles[i].Y < m.Tiles[j].Y
+			}
+			return m.Tiles[i].X < m.Tiles[j].X
+		})
+		for _, t := range m.Tiles {
+			if t.X+t.Width > m.Width {
+				m.Width = t.X + t.Width
+			}
+			if t.Y+t.Height > m.Height {
+				m.Height = t.Y + t.Height
+			}
+		}
+		ret = append(ret, *m)
+	}
+	return ret, nil
+}
+
+// tileBezel returns the bezel of a tile from the connector's EDID, if any.
+func (c *Card) tileBezel(connectorID uint32) *edid.Bezel {
+	data, err := c.ConnectorEDID(connectorID)
+	if err != nil || data == nil {
+		return nil
+	}
+	info, err := edid.Parse(data)
+	if err != nil || info.Tile == nil {
+		return nil
+	}
+	return info.Tile.Bezel
+}
+
+// SetMonitor shows a framebuffer covering the whole monitor, driving each tile
+// with its own CRTC and scanning out its part of the framebuffer. It returns the
+// configurations applied. If a tile cannot be set, the CRTCs are restored to
+// their previous configuration before the error is returned.
+func (c *Card) SetMonitor(m *Monitor, fbID uint32) ([]ModeCRTC, error) {
+	res, err := c.ModeGetResources()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		sets  []ModeCRTC
+		conns []*ModeConnector
+	)
+	for _, tile := range m.Tiles {
+		conn, err := c.ModeGetConnector(tile.ConnectorID)
+		if err != nil {
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+	crtcs, err := c.assignCRTCs(res, conns)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, tile := range m.Tiles {
+		var mode *ModeInfo
+		for j, candidate := range conns[i].Modes {
+			if int(candidate.HDisplay) != tile.Width || int(candidate.VDisplay) != tile.Height {
+				continue
+			}
+			if mode == nil || candidate.Type&ModeTypePreferred != 0 {
+				mode = &conns[i].Modes[j]
+			}
+		}
+		if mode == nil {
+			return nil, fmt.Errorf("connector %d has no %dx%d mode", tile.ConnectorID,
+				tile.Width, tile.Height)
+		}
+		sets = append(sets, ModeCRTC{
+			cModeCRTC: cModeCRTC{
+				ID:        crtcs[i],
+				FBID:      fbID,
+				X:         uint32(tile.X),
+				Y:         uint32(tile.Y),
+				ModeValid: 1,
+				cModeInfo: mode.cModeInfo,
+			},
+			Name:          mode.Name,
+			SetConnectors: []uint32{tile.ConnectorID},
+		})
+	}
+
+	// The legacy API sets one CRTC at a time, so the previous configuration is
+	// recorded to undo the tiles already set if a later one fails.
+	prev := Transaction{card: c}
+	if err := prev.saveLegacy(res); err != nil {
+		return nil, err
+	}
+	for i, set := range sets {
+		if err := c.ModeSetCRTC(set); err != nil {
+			err = fmt.Errorf("crtc %d: %w", set.ID, err)
+			if i > 0 {
+				if rerr := prev.restoreLegacy(); rerr != nil {
+					err = fmt.Errorf("%w; restore: %v", err, rerr)
+				}
+			}
+			return nil, err
+		}
+	}
+	return sets, nil
+}
+
+// AssignCRTCs picks a distinct CRTC for each connector, preferring the CRTC a
+// connector is currently driven by. The returned IDs are in the order of conns.
+func (c *Card) AssignCRTCs(conns []*ModeConnector) ([]uint32, error) {
+	res, err := c.ModeGetResources()
+	if err != nil {
+		return nil, err
+	}
+	return c.assignCRTCs(res, conns)
+}
+
+// assignCRTCs picks a distinct CRTC for each connector, preferring the CRTC a
+// connector is currently driven by.
+func (c *Card) assignCRTCs(res *ModeResources, conns []*ModeConnector) ([]uint32, error) {
+	ret := make([]uint32, len(conns))
+	used := make(map[uint32]bool)
+
+	// possible holds the CRTCs each connector can be driven by, in order of
+	// preference.
+	possible := make([][]uint32, len(conns))
+	for i, conn := range conns {
+		if conn.EncoderID != 0 {
+			if enc, err := c.ModeGetEncoder(conn.EncoderID); err == nil && enc.CRTCID != 0 {
+				possible[i] = append(possible[i], enc.CRTCID)
+			}
+		}
+		for _, encID := range conn.EncoderIDs {
+			enc, err := c.ModeGetEncoder(encID)
+			if err != nil {
+				return nil, err
+			}
+			for j, crtcID := range res.CRTCIDs {
+				if enc.PossibleCRTCs&(1<<j) != 0 {
+					possible[i] = append(possible[i], crtcID)
+				}
+			}
+		}
+	}
+
+	var assign func(i int) bool
+	assign = func(i int) bool {
+		if i == len(conns) {
+			return true
+		}
+		for _, crtcID := range possible[i] {
+			if used[crtcID] {
+				continue
+			}
+			used[crtcID], ret[i] = true, crtcID
+			if assign(i + 1) {
+				return true
+			}
+			used[crtcID] = false
+		}
+		return false
+	}
+	if !assign(0) {
+		return nil, fmt.Errorf("not enough crtcs for %d connectors", len(conns))
+	}
+	return ret, nil
+}
+
+// preferredMode returns the preferred mode of a connector, or its first mode if
+// none is preferred.
+func preferredMode(conn *ModeConnector) *ModeInfo {
+	for i, mode := range conn.Modes {
+		if mode.Type&ModeTypePreferred != 0 {
+			return &conn.Modes[i]
+		}
+	}
+	if len(conn.Modes) > 0 {
+		return &conn.Modes[0]
+	}
+	return nil
+}
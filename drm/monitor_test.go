@@ -0,0 +1,142 @@
+package drm
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+func TestParseTile(t *testing.T) {
+	// The kernel's blob is NUL terminated.
+	got, err := ParseTile([]byte("7:1:2:1:1:0:2560:2880\x00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := TileInfo{GroupID: 7, SingleMonitor: true, NumHTiles: 2, NumVTiles: 1, HLoc: 1,
+		HSize: 2560, VSize: 2880}
+	if *got != want {
+		t.Errorf("parsed %+v, want %+v", *got, want)
+	}
+	for _, data := range []string{"", "1:1:2:1:1:0:2560", "1:1:2:1:1:0:2560:2880:0", "1:1:2:x:1:0:2560:2880"} {
+		if tile, err := ParseTile([]byte(data)); err == nil {
+			t.Errorf("parse of %q returned %+v", data, tile)
+		}
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	for _, tc := range []struct {
+		data string
+		want Path
+	}{
+		{"mst:95-1-8\x00", Path{ParentConnectorID: 95, Ports: []int{1, 8}}},
+		{"mst:42", Path{ParentConnectorID: 42}},
+	} {
+		got, err := ParsePath([]byte(tc.data))
+		if err != nil {
+			t.Errorf("parse %q: %s", tc.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, tc.want) {
+			t.Errorf("parse %q: %+v, want %+v", tc.data, *got, tc.want)
+		}
+		if s := got.String(); s+"\x00" != tc.data && s != tc.data {
+			t.Errorf("%+v formats as %q", *got, s)
+		}
+	}
+	for _, data := range []string{"", "sst:95", "mst:", "mst:95-x", "mst:-1"} {
+		if path, err := ParsePath([]byte(data)); err == nil {
+			t.Errorf("parse of %q returned %+v", data, path)
+		}
+	}
+}
+
+// failingCRTC is a fake device on which setting one CRTC fails.
+type failingCRTC struct {
+	*FakeDevice
+	crtc uint32
+}
+
+func (d failingCRTC) Ioctl(request uint32, data unsafe.Pointer) error {
+	if request == ioctlModeSetCRTC && (*cModeCRTC)(data).ID == d.crtc {
+		return syscall.EINVAL
+	}
+	return d.FakeDevice.Ioctl(request, data)
+}
+
+// newTiledSetup returns a fake device with a monitor of two tiles, each driven
+// through its own encoder and CRTC.
+func newTiledSetup(t *testing.T) (*fakeSetup, uint32, uint32) {
+	t.Helper()
+	s := newFakeSetup(t)
+	crtc2 := s.dev.AddCRTC()
+	_, conn2 := s.dev.AddOutput(ModeConnectorDisplayPort, []uint32{crtc2})
+	for i, id := range []uint32{s.connector, conn2} {
+		s.dev.Plug(id, []ModeInfo{FakeMode(1920, 2160, 60)}, 300, 340, nil)
+		s.dev.SetBlobProperty(id, "TILE", []byte(fmt.Sprintf("1:1:2:1:%d:0:1920:2160", i)))
+	}
+	return s, crtc2, conn2
+}
+
+func TestSetMonitor(t *testing.T) {
+	s, crtc2, conn2 := newTiledSetup(t)
+	monitors, err := s.card.Monitors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(monitors) != 1 || monitors[0].Width != 3840 || monitors[0].Height != 2160 {
+		t.Fatalf("unexpected monitors %+v", monitors)
+	}
+
+	fb := s.framebuffer(t, 3840, 2160)
+	sets, err := s.card.SetMonitor(&monitors[0], fb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != 2 || sets[0].ID != s.crtc || sets[1].ID != crtc2 || sets[1].X != 1920 ||
+		!reflect.DeepEqual(sets[1].SetConnectors, []uint32{conn2}) {
+		t.Errorf("unexpected configurations %+v", sets)
+	}
+	for _, id := range []uint32{s.crtc, crtc2} {
+		if crtc, err := s.card.ModeGetCRTC(id); err != nil || crtc.FBID != fb {
+			t.Errorf("crtc %d: %+v, %v", id, crtc, err)
+		}
+	}
+}
+
+func TestSetMonitorRestores(t *testing.T) {
+	s, crtc2, _ := newTiledSetup(t)
+	monitors, err := s.card.Monitors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := s.framebuffer(t, 1920, 2160)
+	s.modeset(t, prev)
+
+	card := NewWithBackend(failingCRTC{s.dev, crtc2})
+	if sets, err := card.SetMonitor(&monitors[0], s.framebuffer(t, 3840, 2160)); !errors.Is(err, syscall.EINVAL) {
+		t.Fatalf("set monitor returned %+v, %v", sets, err)
+	}
+	crtc, err := s.card.ModeGetCRTC(s.crtc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crtc.FBID != prev || crtc.X != 0 {
+		t.Errorf("first tile not restored: %+v", crtc)
+	}
+	if crtc, err = s.card.ModeGetCRTC(crtc2); err != nil || crtc.ModeValid != 0 {
+		t.Errorf("second tile set: %+v, %v", crtc, err)
+	}
+
+	// A missing mode fails before any CRTC is set.
+	monitors[0].Tiles[1].Height = 1080
+	if _, err := s.card.SetMonitor(&monitors[0], s.framebuffer(t, 3840, 2160)); err == nil {
+		t.Fatal("set monitor without a mode succeeded")
+	}
+	if crtc, err = s.card.ModeGetCRTC(s.crtc); err != nil || crtc.FBID != prev {
+		t.Errorf("first tile set: %+v, %v", crtc, err)
+	}
+}
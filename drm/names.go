@@ -0,0 +1,260 @@
+package drm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// These names are those used by the kernel, e.g. in the names of connectors in
+// sysfs and debugfs.
+
+var connectorTypeNames = []string{
+	ModeConnectorUnknown:     "Unknown",
+	ModeConnectorVGA:         "VGA",
+	ModeConnectorDVII:        "DVI-I",
+	ModeConnectorDVID:        "DVI-D",
+	ModeConnectorDVIA:        "DVI-A",
+	ModeConnectorComposite:   "Composite",
+	ModeConnectorSVIDEO:      "SVIDEO",
+	ModeConnectorLVDS:        "LVDS",
+	ModeConnectorComponent:   "Component",
+	ModeConnector9PinDIN:     "DIN",
+	ModeConnectorDisplayPort: "DP",
+	ModeConnectorHDMIA:       "HDMI-A",
+	ModeConnectorHDMIB:       "HDMI-B",
+	ModeConnectorTV:          "TV",
+	ModeConnectorEDP:         "eDP",
+	ModeConnectorVirtual:     "Virtual",
+	ModeConnectorDSI:         "DSI",
+	ModeConnectorDPI:         "DPI",
+	ModeConnectorWriteback:   "Writeback",
+	ModeConnectorSPI:         "SPI",
+	ModeConnectorUSB:         "USB",
+}
+
+var encoderTypeNames = []string{
+	ModeEncoderNone:    "None",
+	ModeEncoderDAC:     "DAC",
+	ModeEncoderTMDS:    "TMDS",
+	ModeEncoderLVDS:    "LVDS",
+	ModeEncoderTVDAC:   "TV",
+	ModeEncoderVirtual: "Virtual",
+	ModeEncoderDSI:     "DSI",
+	ModeEncoderDPMST:   "DP MST",
+	ModeEncoderDPI:     "DPI",
+}
+
+var connectionNames = []string{
+	ModeConnected:         "connected",
+	ModeDisconnected:      "disconnected",
+	ModeUnknownConnection: "unknown",
+}
+
+var subpixelNames = []string{
+	ModeSubpixelUnknown:       "unknown",
+	ModeSubpixelHorizontalRGB: "horizontal rgb",
+	ModeSubpixelHorizontalBGR: "horizontal bgr",
+	ModeSubpixelVerticalRGB:   "vertical rgb",
+	ModeSubpixelVerticalBGR:   "vertical bgr",
+	ModeSubpixelNone:          "none",
+}
+
+// Names of the bits of the mode flags below the stereo 3D layout, and of the
+// mode type.
+var (
+	modeFlagNames = []string{"phsync", "nhsync", "pvsync", "nvsync", "interlace", "dblscan",
+		"csync", "pcsync", "ncsync", "hskew", "bcast", "pixmux", "dblclk", "clkdiv2"}
+	modeTypeNames = []string{"builtin", "clock_c", "crtc_c", "preferred", "default", "userdef",
+		"driver"}
+	mode3DNames = []string{"", "3d:frame-packing", "3d:field-alternative",
+		"3d:line-alternative", "3d:side-by-side-full", "3d:l-depth", "3d:l-depth-gfx-gfx-depth",
+		"3d:top-and-bottom", "3d:side-by-side-half"}
+	modeAspectNames = []string{"", "aspect:4:3", "aspect:16:9", "aspect:64:27", "aspect:256:135"}
+)
+
+// enumName returns names[value], or the value in decimal if it has no name.
+func enumName(names []string, value uint32) string {
+	if int(value) < len(names) && names[value] != "" {
+		return names[value]
+	}
+	return strconv.FormatUint(uint64(value), 10)
+}
+
+// parseEnumName is the inverse of enumName.
+func parseEnumName(names []string, kind, s string) (uint32, error) {
+	for i, n := range names {
+		if n != "" && n == s {
+			return uint32(i), nil
+		}
+	}
+	v, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("unknown %s %q", kind, s)
+	}
+	return uint32(v), nil
+}
+
+// ConnectorTypeName returns the name of a connector type, e.g. "HDMI-A".
+func ConnectorTypeName(typ uint32) string { return enumName(connectorTypeNames, typ) }
+
+// EncoderTypeName returns the name of an encoder type, e.g. "TMDS".
+func EncoderTypeName(typ uint32) string { return enumName(encoderTypeNames, typ) }
+
+// ConnectionName returns the name of a connection status, e.g. "connected".
+func ConnectionName(connection uint32) string { return enumName(connectionNames, connection) }
+
+// SubpixelName returns the name of a subpixel order, e.g. "horizontal rgb".
+func SubpixelName(subpixel uint32) string { return enumName(subpixelNames, subpixel) }
+
+// ConnectorName returns the kernel's name for a connector, e.g. "HDMI-A-1".
+func ConnectorName(typ, typeID uint32) string {
+	return fmt.Sprintf("%s-%d", ConnectorTypeName(typ), typeID)
+}
+
+// ParseConnectorName is the inverse of ConnectorName, returning the type and type
+// ID of a connector named e.g. "HDMI-A-1".
+func ParseConnectorName(name string) (typ, typeID uint32, err error) {
+	i := strings.LastIndexByte(name, '-')
+	if i < 0 {
+		return 0, 0, fmt.Errorf("invalid connector name %q", name)
+	}
+	if typ, err = parseEnumName(connectorTypeNames, "connector type", name[:i]); err != nil {
+		return 0, 0, err
+	}
+	id, err := strconv.ParseUint(name[i+1:], 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid connector name %q", name)
+	}
+	return typ, uint32(id), nil
+}
+
+// Name returns the kernel's name for the connector, e.g. "HDMI-A-1".
+func (c *ModeConnector) Name() string {
+	return ConnectorName(c.Type, c.TypeID)
+}
+
+// XrandrConnectorName returns the name xrandr shows for a connector with the
+// modesetting driver, e.g. "HDMI-1". It differs from the kernel's name only for
+// HDMI type A connectors.
+func XrandrConnectorName(typ, typeID uint32) string {
+	if typ == ModeConnectorHDMIA {
+		return fmt.Sprintf("HDMI-%d", typeID)
+	}
+	return ConnectorName(typ, typeID)
+}
+
+// XrandrName returns the name xrandr shows for the connector, e.g. "HDMI-1".
+func (c *ModeConnector) XrandrName() string {
+	return XrandrConnectorName(c.Type, c.TypeID)
+}
+
+// bitNames returns the names of the bits set in value. Bits without a name are
+// given in hexadecimal.
+func bitNames(names []string, value uint32) []string {
+	ret := []string{}
+	for i := 0; i < 32 && value != 0; i++ {
+		if value&(1<<i) == 0 {
+			continue
+		}
+		value &^= 1 << i
+		if i < len(names) {
+			ret = append(ret, names[i])
+		} else {
+			ret = append(ret, fmt.Sprintf("0x%x", uint32(1)<<i))
+		}
+	}
+	return ret
+}
+
+// parseBitNames is the inverse of bitNames.
+func parseBitNames(names []string, kind string, list []string) (uint32, error) {
+	var ret uint32
+	for _, s := range list {
+		if strings.HasPrefix(s, "0x") {
+			v, err := strconv.ParseUint(s[2:], 16, 32)
+			if err != nil {
+				return 0, fmt.Errorf("invalid %s %q", kind, s)
+			}
+			ret |= uint32(v)
+			continue
+		}
+		i, err := parseEnumName(names, kind, s)
+		if err != nil || i >= uint32(len(names)) {
+			return 0, fmt.Errorf("unknown %s %q", kind, s)
+		}
+		ret |= 1 << i
+	}
+	return ret, nil
+}
+
+// ModeFlagNames returns the names of mode flags, e.g. "phsync" or
+// "aspect:16:9".
+func ModeFlagNames(flags uint32) []string {
+	ret := bitNames(modeFlagNames, flags&^(ModeFlag3DMask|ModeFlagPicAspectMask))
+	if v := (flags & ModeFlag3DMask) >> 14; v != 0 {
+		ret = append(ret, enumName(mode3DNames, v))
+	}
+	if v := (flags & ModeFlagPicAspectMask) >> 19; v != 0 {
+		ret = append(ret, enumName(modeAspectNames, v))
+	}
+	return ret
+}
+
+// ParseModeFlags is the inverse of ModeFlagNames.
+func ParseModeFlags(names []string) (uint32, error) {
+	var (
+		flags uint32
+		bits  []string
+	)
+	for _, s := range names {
+		switch {
+		case strings.HasPrefix(s, "3d:"):
+			v, err := parseEnumName(mode3DNames, "mode flag", s)
+			if err != nil || v == 0 || v > ModeFlag3DMask>>14 {
+				return 0, fmt.Errorf("unknown mode flag %q", s)
+			}
+			flags |= v << 14
+		case strings.HasPrefix(s, "aspect:"):
+			v, err := parseEnumName(modeAspectNames, "mode flag", s)
+			if err != nil || v == 0 || v > ModeFlagPicAspectMask>>19 {
+				return 0, fmt.Errorf("unknown mode flag %q", s)
+			}
+			flags |= v << 19
+		default:
+			bits = append(bits, s)
+		}
+	}
+	v, err := parseBitNames(modeFlagNames, "mode flag", bits)
+	return flags | v, err
+}
+
+// ModeTypeNames returns the names of the bits of a mode type, e.g.
+// "preferred". The deprecated ModeTypeClockC and ModeTypeCrtcC include
+// ModeTypeBuiltin, and are given as both names.
+func ModeTypeNames(typ uint32) []string { return bitNames(modeTypeNames, typ) }
+
+// ParseModeType is the inverse of ModeTypeNames.
+func ParseModeType(names []string) (uint32, error) {
+	return parseBitNames(modeTypeNames, "mode type", names)
+}
+
+// PropertyTypeName returns the name of the type of a property given its flags,
+// e.g. "enum".
+func PropertyTypeName(flags uint32) string {
+	switch {
+	case flags&ModePropRange != 0:
+		return "range"
+	case flags&ModePropEnum != 0:
+		return "enum"
+	case flags&ModePropBlob != 0:
+		return "blob"
+	case flags&ModePropBitmask != 0:
+		return "bitmask"
+	case flags&ModePropExtendedType == ModePropObject:
+		return "object"
+	case flags&ModePropExtendedType == ModePropSignedRange:
+		return "signed range"
+	}
+	return "unknown"
+}
@@ -0,0 +1,189 @@
+package drm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// NodeType is the type of a DRM device node. A device has a primary node,
+// /dev/dri/card*, which allows modesetting, and usually a render node,
+// /dev/dri/renderD*, which only allows rendering and buffer sharing, but does
+// not require DRM master or authentication.
+type NodeType int
+
+const (
+	NodePrimary NodeType = iota
+	NodeRender
+)
+
+func (t NodeType) String() string {
+	switch t {
+	case NodePrimary:
+		return "primary"
+	case NodeRender:
+		return "render"
+	}
+	return fmt.Sprintf("NodeType(%d)", int(t))
+}
+
+// Name prefixes of the device nodes in /dev/dri and /sys/class/drm.
+const (
+	primaryNodePrefix = "card"
+	renderNodePrefix  = "renderD"
+)
+
+// Where device nodes and sysfs are found, replaced by tests.
+var (
+	devDRIRoot = "/dev/dri"
+	sysfsRoot  = "/sys"
+)
+
+// Open opens a primary or render node for reading and writing, with the
+// close-on-exec flag set.
+func Open(path string) (*Card, error) {
+	return OpenFile(path, syscall.O_RDWR|syscall.O_CLOEXEC)
+}
+
+// OpenFile opens a primary or render node with the given open(2) flags. Unlike
+// os.OpenFile, it only sets O_CLOEXEC if flag has it, e.g. to hand the node over
+// to a child process.
+func OpenFile(path string, flag int) (*Card, error) {
+	fd, err := syscall.Open(path, flag, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return New(os.NewFile(uintptr(fd), path)), nil
+}
+
+// NodeType returns whether the card is a primary or a render node. Mode
+// setting requests on a render node fail with an error matching
+// ErrRenderNode, without reaching the kernel.
+func (c *Card) NodeType() NodeType {
+	return c.node
+}
+
+// RenderNodePath returns the path of the render node of the card's device. It
+// returns an error matching os.ErrNotExist if the device has none.
+func (c *Card) RenderNodePath() (string, error) {
+	return c.pairedNode(renderNodePrefix)
+}
+
+// PrimaryNodePath returns the path of the primary node of the card's device.
+// It returns an error matching os.ErrNotExist if the device has none, e.g. for
+// render-only devices.
+func (c *Card) PrimaryNodePath() (string, error) {
+	return c.pairedNode(primaryNodePrefix)
+}
+
+func (c *Card) pairedNode(prefix string) (string, error) {
+	major, minor, err := c.Device()
+	if err != nil {
+		return "", err
+	}
+	return pairedNode(major, minor, prefix)
+}
+
+// pairedNode returns the path of the node with the name prefix among the
+// nodes of the device of the node major:minor, which sysfs lists in the drm
+// directory of the device.
+func pairedNode(major, minor uint32, prefix string) (string, error) {
+	dir := filepath.Join(sysfsDevPath(major, minor), "device", "drm")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), prefix) {
+			return filepath.Join(devDRIRoot, e.Name()), nil
+		}
+	}
+	return "", &os.PathError{Op: "find " + prefix + "*", Path: dir, Err: os.ErrNotExist}
+}
+
+// nodeType returns the type of the node of the device file f. Files that are
+// not DRM device nodes are reported as primary nodes.
+func nodeType(f *os.File) NodeType {
+	major, minor, err := fileDevice(f)
+	if err != nil {
+		return NodePrimary
+	}
+	return nodeTypeOf(major, minor)
+}
+
+// nodeTypeOf returns the type of the node major:minor from its name in sysfs.
+// Without sysfs, it falls back to the minor numbers the kernel historically
+// assigned render nodes: 128 to 191.
+func nodeTypeOf(major, minor uint32) NodeType {
+	if link, err := os.Readlink(sysfsDevPath(major, minor)); err == nil {
+		if strings.HasPrefix(filepath.Base(link), renderNodePrefix) {
+			return NodeRender
+		}
+		return NodePrimary
+	}
+	if minor >= 128 && minor < 192 {
+		return NodeRender
+	}
+	return NodePrimary
+}
+
+// sysfsDevPath returns the sysfs directory of the character device
+// major:minor.
+func sysfsDevPath(major, minor uint32) string {
+	return filepath.Join(sysfsRoot, "dev", "char", fmt.Sprintf("%d:%d", major, minor))
+}
+
+// DeviceNumber returns the major and minor numbers of the character device
+// node at path, as logind and udev identify devices.
+func DeviceNumber(path string) (major, minor uint32, err error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return 0, 0, &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+	return charDevice(path, &st)
+}
+
+// fileDevice returns the device number of the character device file f.
+func fileDevice(f *os.File) (major, minor uint32, err error) {
+	var st syscall.Stat_t
+	if err := syscall.Fstat(int(f.Fd()), &st); err != nil {
+		return 0, 0, &os.PathError{Op: "fstat", Path: f.Name(), Err: err}
+	}
+	return charDevice(f.Name(), &st)
+}
+
+func charDevice(name string, st *syscall.Stat_t) (major, minor uint32, err error) {
+	if st.Mode&syscall.S_IFMT != syscall.S_IFCHR {
+		return 0, 0, fmt.Errorf("%s is not a character device", name)
+	}
+	major, minor = splitDev(uint64(st.Rdev))
+	return major, minor, nil
+}
+
+// splitDev splits a device number into its major and minor numbers, as glibc's
+// major and minor macros do.
+func splitDev(rdev uint64) (major, minor uint32) {
+	major = uint32((rdev>>8)&0xfff) | uint32((rdev>>32)&^0xfff)
+	minor = uint32(rdev&0xff) | uint32((rdev>>12)&^0xff)
+	return major, minor
+}
+
+// renderAllowed returns whether the kernel allows the request on render nodes,
+// as marked with DRM_RENDER_ALLOW in drm_ioctl.c. Driver-specific requests are
+// left to the kernel to check.
+func renderAllowed(request uint32) bool {
+	nr := uint8(request >> iocNRShift & iocNRMask)
+	if nr >= 0x40 && nr < 0xA0 {
+		return true
+	}
+	switch request {
+	case ioctlVersion, ioctlGemClose, ioctlGetCap, ioctlPrimeHandleToFd, ioctlPrimeFdToHandle,
+		ioctlSyncObjCreate, ioctlSyncObjDestroy, ioctlSyncObjHandleToFd, ioctlSyncObjFdToHandle,
+		ioctlSyncObjWait, ioctlSyncObjReset, ioctlSyncObjSignal, ioctlSyncObjTimelineWait,
+		ioctlSyncObjQuery, ioctlSyncObjTransfer, ioctlSyncObjTimelineSignal:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package drm
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenderNode(t *testing.T) {
+	dev := NewFakeDevice()
+	dev.SetNodeType(NodeRender)
+	card := NewWithBackend(dev)
+	if typ := card.NodeType(); typ != NodeRender {
+		t.Fatalf("node type: got %s, want render", typ)
+	}
+	if _, err := card.Version(); err != nil {
+		t.Errorf("version on render node: %s", err)
+	}
+	_, err := card.ModeGetResources()
+	if !errors.Is(err, ErrRenderNode) {
+		t.Fatalf("get resources on render node: got %v, want ErrRenderNode", err)
+	}
+	if errors.Is(err, ErrNotMaster) {
+		t.Errorf("get resources on render node matches ErrNotMaster")
+	}
+	if want := "ioctl MODE_GETRESOURCES: not allowed on render nodes"; err.Error() != want {
+		t.Errorf("error: got %q, want %q", err, want)
+	}
+}
+
+// fakeSysfs lays out the sysfs entries of a device with a primary node
+// card1 (226:1) and a render node renderD128 (226:128).
+func fakeSysfs(t *testing.T) {
+	t.Helper()
+	root := t.TempDir()
+	device := filepath.Join(root, "devices", "pci0000:00", "0000:00:02.0")
+	for _, name := range []string{"card1", "renderD128"} {
+		if err := os.MkdirAll(filepath.Join(device, "drm", name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(device, filepath.Join(device, "drm", name, "device")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	chars := filepath.Join(root, "dev", "char")
+	if err := os.MkdirAll(chars, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for dev, name := range map[string]string{"226:1": "card1", "226:128": "renderD128"} {
+		if err := os.Symlink(filepath.Join(device, "drm", name), filepath.Join(chars, dev)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldSysfs, oldDev := sysfsRoot, devDRIRoot
+	sysfsRoot, devDRIRoot = root, "/dev/dri"
+	t.Cleanup(func() { sysfsRoot, devDRIRoot = oldSysfs, oldDev })
+}
+
+// mkdev returns a device number, as glibc's makedev does.
+func mkdev(major, minor uint64) uint64 {
+	return (major&0xfff)<<8 | (major&^0xfff)<<32 | minor&0xff | (minor&^0xff)<<12
+}
+
+func TestPairedNode(t *testing.T) {
+	fakeSysfs(t)
+	if major, minor := splitDev(mkdev(226, 128)); major != 226 || minor != 128 {
+		t.Errorf("split device: got %d:%d, want 226:128", major, minor)
+	}
+	if major, minor := splitDev(mkdev(4095, 1<<20-1)); major != 4095 || minor != 1<<20-1 {
+		t.Errorf("split device: got %d:%d, want 4095:1048575", major, minor)
+	}
+
+	if typ := nodeTypeOf(226, 1); typ != NodePrimary {
+		t.Errorf("card1: got %s node, want primary", typ)
+	}
+	if typ := nodeTypeOf(226, 128); typ != NodeRender {
+		t.Errorf("renderD128: got %s node, want render", typ)
+	}
+	// Without sysfs entries, the minor number tells.
+	if typ := nodeTypeOf(226, 129); typ != NodeRender {
+		t.Errorf("226:129: got %s node, want render", typ)
+	}
+
+	if path, err := pairedNode(226, 1, renderNodePrefix); err != nil || path != "/dev/dri/renderD128" {
+		t.Errorf("render node of card1: got %q, %v", path, err)
+	}
+	if path, err := pairedNode(226, 128, primaryNodePrefix); err != nil || path != "/dev/dri/card1" {
+		t.Errorf("primary node of renderD128: got %q, %v", path, err)
+	}
+	if _, err := pairedNode(226, 2, renderNodePrefix); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("render node of unknown device: got %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestCardDevice(t *testing.T) {
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// /dev/null is 1:3 on every Linux system.
+	card := NewWithBackend(NewFakeDevice())
+	card.fd = f
+	if major, minor, err := card.Device(); major != 1 || minor != 3 || err != nil {
+		t.Errorf("device of %s: got %d:%d, %v", os.DevNull, major, minor, err)
+	}
+
+	dir, err := os.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Close()
+	card.fd = dir
+	if _, _, err := card.Device(); err == nil {
+		t.Error("device of a directory")
+	}
+	card.fd = nil
+	if _, err := card.RenderNodePath(); err == nil {
+		t.Error("render node of a card without a device file")
+	}
+}
+
+func TestDeviceNumber(t *testing.T) {
+	if major, minor, err := DeviceNumber(os.DevNull); major != 1 || minor != 3 || err != nil {
+		t.Errorf("device of %s: got %d:%d, %v", os.DevNull, major, minor, err)
+	}
+	if _, _, err := DeviceNumber(t.TempDir()); err == nil {
+		t.Error("device of a directory")
+	}
+	if _, _, err := DeviceNumber(filepath.Join(t.TempDir(), "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("device of a missing file: got %v, want os.ErrNotExist", err)
+	}
+}
@@ -0,0 +1,28 @@
+package pattern
+
+import (
+	"image/color"
+
+	"github.com/inahga/inahgo/drm/pixconv"
+)
+
+// Buffer describes the memory of a framebuffer to render into, e.g. a mapped dumb
+// buffer. Planes and Pitches hold the memory and the number of bytes per row of
+// each plane of the pixel format.
+type Buffer = pixconv.Buffer
+
+// Fill renders the given frame of a pattern into b. The frame number only
+// matters for animated patterns.
+func Fill(b Buffer, p Pattern, frame int) error {
+	if err := b.Validate(); err != nil {
+		return err
+	}
+	paint, err := p.painter(b.Width, b.Height, frame)
+	if err != nil {
+		return err
+	}
+	return pixconv.EncodeFunc(b, func(x, y int) color.RGBA64 {
+		c := paint(x, y)
+		return color.RGBA64{R: c.r, G: c.g, B: c.b, A: 0xffff}
+	})
+}
@@ -0,0 +1,187 @@
+// Package pattern renders test patterns into framebuffers, for bringing up and
+// verifying displays. Every pixel format of pixconv.Formats is supported.
+package pattern
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Pattern identifies a test pattern.
+type Pattern int
+
+const (
+	// SMPTE renders SMPTE RP 219 style color bars.
+	SMPTE Pattern = iota
+	// Gradient renders red, green, blue and white ramps, useful for spotting
+	// banding and verifying color depth.
+	Gradient
+	// Checkerboard renders alternating black and white squares.
+	Checkerboard
+	// TearLine renders a vertical bar that moves across the screen with every
+	// frame, making tearing visible when flips are not synchronized to vblank.
+	TearLine
+	// PixelGrid renders a single pixel checker with a grid and a border, making
+	// scaling, cropping and off by one errors visible.
+	PixelGrid
+)
+
+var patternNames = map[Pattern]string{
+	SMPTE:        "smpte",
+	Gradient:     "gradient",
+	Checkerboard: "checkerboard",
+	TearLine:     "tearline",
+	PixelGrid:    "pixelgrid",
+}
+
+// Patterns returns all known patterns.
+func Patterns() []Pattern {
+	return []Pattern{SMPTE, Gradient, Checkerboard, TearLine, PixelGrid}
+}
+
+func (p Pattern) String() string {
+	if name, ok := patternNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("Pattern(%d)", int(p))
+}
+
+// Parse returns the pattern with the given name.
+func Parse(name string) (Pattern, error) {
+	for p, n := range patternNames {
+		if strings.EqualFold(n, name) {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown pattern %q", name)
+}
+
+// Animated returns whether the pattern changes from frame to frame.
+func (p Pattern) Animated() bool {
+	return p == TearLine
+}
+
+// rgb is a color with 16 bits per channel.
+type rgb struct {
+	r, g, b uint16
+}
+
+func rgb8(r, g, b uint8) rgb {
+	return rgb{uint16(r) * 0x101, uint16(g) * 0x101, uint16(b) * 0x101}
+}
+
+var (
+	black = rgb8(0, 0, 0)
+	white = rgb8(255, 255, 255)
+	red   = rgb8(255, 0, 0)
+	gray  = rgb8(128, 128, 128)
+)
+
+// Colors are taken from libdrm's tests/util/pattern.c.
+var (
+	smpteTop = []rgb{
+		rgb8(192, 192, 192), // grey
+		rgb8(192, 192, 0),   // yellow
+		rgb8(0, 192, 192),   // cyan
+		rgb8(0, 192, 0),     // green
+		rgb8(192, 0, 192),   // magenta
+		rgb8(192, 0, 0),     // red
+		rgb8(0, 0, 192),     // blue
+	}
+	smpteMiddle = []rgb{
+		rgb8(0, 0, 192),     // blue
+		rgb8(19, 19, 19),    // black
+		rgb8(192, 0, 192),   // magenta
+		rgb8(19, 19, 19),    // black
+		rgb8(0, 192, 192),   // cyan
+		rgb8(19, 19, 19),    // black
+		rgb8(192, 192, 192), // grey
+	}
+	smpteBottom = []rgb{
+		rgb8(0, 33, 76),     // in-phase
+		rgb8(255, 255, 255), // super white
+		rgb8(50, 0, 106),    // quadrature
+		rgb8(19, 19, 19),    // black
+		rgb8(9, 9, 9),       // 3.5%
+		rgb8(19, 19, 19),    // 7.5%
+		rgb8(29, 29, 29),    // 11.5%
+		rgb8(19, 19, 19),    // black
+	}
+)
+
+const (
+	checkerSize   = 32
+	gridSpacing   = 16
+	tearLineWidth = 8
+	// tearLineStep is how many pixels the tear line moves each frame.
+	tearLineStep = 8
+)
+
+// painter returns the color of the pixel at x, y.
+type painter func(x, y int) rgb
+
+func (p Pattern) painter(width, height, frame int) (painter, error) {
+	switch p {
+	case SMPTE:
+		return func(x, y int) rgb {
+			switch {
+			case y < height*6/9:
+				return smpteTop[x*7/width]
+			case y < height*7/9:
+				return smpteMiddle[x*7/width]
+			case x < width*5/7:
+				return smpteBottom[x*4/(width*5/7)]
+			case x < width*6/7 && width >= 7:
+				return smpteBottom[(x-width*5/7)*3/(width/7)+4]
+			default:
+				return smpteBottom[7]
+			}
+		}, nil
+	case Gradient:
+		return func(x, y int) rgb {
+			v := uint16(0)
+			if width > 1 {
+				v = uint16(x * 0xffff / (width - 1))
+			}
+			switch y * 4 / height {
+			case 0:
+				return rgb{v, 0, 0}
+			case 1:
+				return rgb{0, v, 0}
+			case 2:
+				return rgb{0, 0, v}
+			default:
+				return rgb{v, v, v}
+			}
+		}, nil
+	case Checkerboard:
+		return func(x, y int) rgb {
+			if (x/checkerSize+y/checkerSize)%2 == 0 {
+				return white
+			}
+			return black
+		}, nil
+	case TearLine:
+		pos := (frame * tearLineStep) % width
+		return func(x, y int) rgb {
+			if d := x - pos; (d >= 0 && d < tearLineWidth) || d+width < tearLineWidth {
+				return white
+			}
+			return black
+		}, nil
+	case PixelGrid:
+		return func(x, y int) rgb {
+			switch {
+			case x == 0 || y == 0 || x == width-1 || y == height-1:
+				return red
+			case x%gridSpacing == 0 || y%gridSpacing == 0:
+				return white
+			case (x+y)%2 == 0:
+				return gray
+			default:
+				return black
+			}
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown pattern %d", int(p))
+}
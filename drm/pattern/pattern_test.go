@@ -0,0 +1,193 @@
+package pattern
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/inahga/inahgo/drm"
+	"github.com/inahga/inahgo/drm/pixconv"
+)
+
+// frame is a decoded frame of a pattern.
+type frame struct {
+	img  image.Image
+	info *drm.FormatInfo
+}
+
+// fill renders a frame of a pattern into a buffer of the given format, and
+// decodes it back.
+func fill(t *testing.T, format uint32, p Pattern, width, height, n int) frame {
+	t.Helper()
+	b, err := pixconv.NewBuffer(format, width, height, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Fill(b, p, n); err != nil {
+		t.Fatalf("fill %s: %s", p, err)
+	}
+	img, err := pixconv.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame{img, drm.LookupFormat(format)}
+}
+
+// at returns the 8 bit color of a pixel.
+func (f frame) at(x, y int) [3]uint8 {
+	c := color.RGBAModel.Convert(f.img.At(x, y)).(color.RGBA)
+	return [3]uint8{c.R, c.G, c.B}
+}
+
+// is returns whether the pixel at x, y is the color c, within the precision of
+// the format. Formats with subsampled chroma mix the colors of neighboring
+// pixels, so only their luma is compared.
+func (f frame) is(x, y int, c [3]uint8) bool {
+	got := f.at(x, y)
+	tolerance := 4
+	if !f.info.IsYUV && f.info.BPP() <= 16 {
+		tolerance = 8
+	}
+	if f.info.IsYUV && (f.info.HSub > 1 || f.info.VSub > 1) {
+		return near(luma(got), luma(c), tolerance)
+	}
+	for i := range got {
+		if !near(got[i], c[i], tolerance) {
+			return false
+		}
+	}
+	return true
+}
+
+func near(a, b uint8, tolerance int) bool {
+	d := int(a) - int(b)
+	return -tolerance <= d && d <= tolerance
+}
+
+// luma returns the limited range BT.601 luma of a color.
+func luma(c [3]uint8) uint8 {
+	return uint8((66*int(c[0])+129*int(c[1])+25*int(c[2])+128)>>8 + 16)
+}
+
+func to8(c rgb) [3]uint8 {
+	return [3]uint8{uint8(c.r >> 8), uint8(c.g >> 8), uint8(c.b >> 8)}
+}
+
+// forEachFormat runs a test for every format of pixconv.
+func forEachFormat(t *testing.T, test func(t *testing.T, format uint32)) {
+	for _, format := range pixconv.Formats() {
+		format := format
+		t.Run(drm.FormatString(format), func(t *testing.T) { test(t, format) })
+	}
+}
+
+func TestSMPTE(t *testing.T) {
+	forEachFormat(t, func(t *testing.T, format uint32) {
+		const width, height = 140, 90
+		f := fill(t, format, SMPTE, width, height, 0)
+		for i, want := range smpteTop {
+			// The middle of each of the 7 bars of the top 2/3.
+			if !f.is(i*20+10, 10, to8(want)) {
+				t.Errorf("top bar %d is %v, want %v", i, f.at(i*20+10, 10), to8(want))
+			}
+		}
+		for i, want := range smpteMiddle {
+			if !f.is(i*20+10, 65, to8(want)) {
+				t.Errorf("middle bar %d is %v, want %v", i, f.at(i*20+10, 65), to8(want))
+			}
+		}
+		// The bottom row has 4 bars over the first 5/7, then 3 over the next
+		// 1/7, then black.
+		for i, x := range []int{10, 35, 60, 85, 102, 109, 116, 130} {
+			if want := to8(smpteBottom[i]); !f.is(x, 85, want) {
+				t.Errorf("bottom bar %d at %d is %v, want %v", i, x, f.at(x, 85), want)
+			}
+		}
+	})
+}
+
+func TestGradient(t *testing.T) {
+	forEachFormat(t, func(t *testing.T, format uint32) {
+		const width, height = 256, 8
+		f := fill(t, format, Gradient, width, height, 0)
+		for row, channel := range []int{0, 1, 2} {
+			y := row * 2
+			for _, x := range []int{0, 128, 255} {
+				var want [3]uint8
+				want[channel] = uint8(x)
+				if !f.is(x, y, want) {
+					t.Errorf("ramp %d at %d is %v, want %v", row, x, f.at(x, y), want)
+				}
+			}
+		}
+		for _, x := range []int{0, 100, 255} {
+			if want := [3]uint8{uint8(x), uint8(x), uint8(x)}; !f.is(x, 7, want) {
+				t.Errorf("white ramp at %d is %v", x, f.at(x, 7))
+			}
+		}
+		// The ramps increase monotonically.
+		for x := 1; x < width; x++ {
+			if f.at(x, 7)[0] < f.at(x-1, 7)[0] {
+				t.Fatalf("white ramp decreases at %d", x)
+			}
+		}
+	})
+}
+
+func TestCheckerboardAndGrid(t *testing.T) {
+	forEachFormat(t, func(t *testing.T, format uint32) {
+		f := fill(t, format, Checkerboard, 64, 64, 0)
+		if !f.is(0, 0, to8(white)) || !f.is(32, 0, to8(black)) || !f.is(32, 32, to8(white)) {
+			t.Errorf("checkerboard squares are %v %v %v", f.at(0, 0), f.at(32, 0), f.at(32, 32))
+		}
+
+		f = fill(t, format, PixelGrid, 40, 40, 0)
+		for _, tc := range []struct {
+			x, y int
+			want rgb
+		}{
+			{0, 5, red}, {39, 5, red}, {5, 39, red},
+			{16, 5, white}, {5, 32, white},
+			{3, 5, gray}, {3, 6, black},
+		} {
+			if !f.is(tc.x, tc.y, to8(tc.want)) {
+				t.Errorf("pixel grid at %d,%d is %v, want %v", tc.x, tc.y, f.at(tc.x, tc.y), to8(tc.want))
+			}
+		}
+	})
+}
+
+func TestTearLine(t *testing.T) {
+	forEachFormat(t, func(t *testing.T, format uint32) {
+		const width = 64
+		for _, n := range []int{0, 1, 8} {
+			f := fill(t, format, TearLine, width, 4, n)
+			pos := n * tearLineStep % width
+			for x := 0; x < width; x++ {
+				want := to8(black)
+				if d := (x - pos + width) % width; d < tearLineWidth {
+					want = to8(white)
+				}
+				if !f.is(x, 2, want) {
+					t.Errorf("frame %d: pixel %d is %v, want %v", n, x, f.at(x, 2), want)
+				}
+			}
+		}
+	})
+}
+
+func TestParse(t *testing.T) {
+	for _, p := range Patterns() {
+		got, err := Parse(p.String())
+		if err != nil || got != p {
+			t.Errorf("parse %q: %v, %v", p, got, err)
+		}
+	}
+	if _, err := Parse("nope"); err == nil {
+		t.Error("parse of an unknown pattern succeeded")
+	}
+	b, _ := pixconv.NewBuffer(drm.FormatXRGB8888, 4, 4, 0)
+	if err := Fill(b, Pattern(99), 0); err == nil {
+		t.Error("fill of an unknown pattern succeeded")
+	}
+}
@@ -0,0 +1,125 @@
+package pixconv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+
+	"github.com/inahga/inahgo/drm"
+)
+
+// Decode converts a buffer to an image: an *image.RGBA64 for formats with more
+// than 8 bits per channel, and an *image.RGBA otherwise. Formats without alpha
+// decode as opaque.
+func Decode(src Buffer) (image.Image, error) {
+	if err := src.Validate(); err != nil {
+		return nil, err
+	}
+	r := image.Rect(0, 0, src.Width, src.Height)
+	if layout, ok := rgbLayouts[src.Format]; ok {
+		if layout.deep() {
+			img := image.NewRGBA64(r)
+			decodeRGB64(img, src, layout)
+			return img, nil
+		}
+		img := image.NewRGBA(r)
+		decodeRGB(img, src, layout)
+		return img, nil
+	}
+	img := image.NewRGBA(r)
+	if layout, ok := packedYUVLayouts[src.Format]; ok {
+		decodePackedYUV(img, src, layout)
+		return img, nil
+	}
+	if layout, ok := planarYUVLayouts[src.Format]; ok {
+		decodePlanarYUV(img, src, drm.LookupFormat(src.Format), layout)
+		return img, nil
+	}
+	return nil, fmt.Errorf("unsupported format %s", drm.FormatString(src.Format))
+}
+
+func decodeRGB(img *image.RGBA, src Buffer, layout rgbLayout) {
+	cpp := layout.cpp
+	opaque := layout.a.bits == 0
+	for y := 0; y < src.Height; y++ {
+		s := src.Planes[0][y*src.Pitches[0] : y*src.Pitches[0]+src.Width*cpp]
+		d := img.Pix[y*img.Stride : y*img.Stride+4*src.Width]
+		switch src.Format {
+		case drm.FormatABGR8888, drm.FormatXBGR8888:
+			copy(d, s)
+			if opaque {
+				for x := 3; x < len(d); x += 4 {
+					d[x] = 0xff
+				}
+			}
+		case drm.FormatARGB8888, drm.FormatXRGB8888:
+			for x := 0; x < len(d); x += 4 {
+				d[x], d[x+1], d[x+2], d[x+3] = s[x+2], s[x+1], s[x], s[x+3]
+				if opaque {
+					d[x+3] = 0xff
+				}
+			}
+		default:
+			for x := 0; x < src.Width; x++ {
+				var v uint32
+				switch cpp {
+				case 2:
+					v = uint32(binary.LittleEndian.Uint16(s[2*x:]))
+				case 3:
+					v = uint32(s[3*x]) | uint32(s[3*x+1])<<8 | uint32(s[3*x+2])<<16
+				default:
+					v = binary.LittleEndian.Uint32(s[4*x:])
+				}
+				r, g, b, a := layout.unpack(v)
+				d[4*x], d[4*x+1], d[4*x+2], d[4*x+3] = uint8(r>>8), uint8(g>>8), uint8(b>>8), uint8(a>>8)
+			}
+		}
+	}
+}
+
+func decodeRGB64(img *image.RGBA64, src Buffer, layout rgbLayout) {
+	for y := 0; y < src.Height; y++ {
+		s := src.Planes[0][y*src.Pitches[0]:]
+		d := img.Pix[y*img.Stride:]
+		for x := 0; x < src.Width; x++ {
+			r, g, b, a := layout.unpack(binary.LittleEndian.Uint32(s[4*x:]))
+			binary.BigEndian.PutUint16(d[8*x:], r)
+			binary.BigEndian.PutUint16(d[8*x+2:], g)
+			binary.BigEndian.PutUint16(d[8*x+4:], b)
+			binary.BigEndian.PutUint16(d[8*x+6:], a)
+		}
+	}
+}
+
+func decodePackedYUV(img *image.RGBA, src Buffer, layout packedYUV) {
+	for y := 0; y < src.Height; y++ {
+		s := src.Planes[0][y*src.Pitches[0]:]
+		d := img.Pix[y*img.Stride:]
+		for x := 0; x < src.Width; x++ {
+			pair := s[x&^1*2:]
+			l := pair[layout.y0]
+			if x&1 == 1 {
+				l = pair[layout.y1]
+			}
+			d[4*x], d[4*x+1], d[4*x+2] = rgb(l, pair[layout.u], pair[layout.v])
+			d[4*x+3] = 0xff
+		}
+	}
+}
+
+func decodePlanarYUV(img *image.RGBA, src Buffer, info *drm.FormatInfo, layout chromaOrder) {
+	hshift, vshift := uint(info.HSub/2), uint(info.VSub/2)
+	ucpp, vcpp := int(info.CPP[layout.uPlane]), int(info.CPP[layout.vPlane])
+	for y := 0; y < src.Height; y++ {
+		l := src.Planes[0][y*src.Pitches[0]:]
+		cy := y >> vshift
+		u := src.Planes[layout.uPlane][cy*src.Pitches[layout.uPlane]+layout.uOffset:]
+		v := src.Planes[layout.vPlane][cy*src.Pitches[layout.vPlane]+layout.vOffset:]
+		d := img.Pix[y*img.Stride:]
+		for x := 0; x < src.Width; x++ {
+			cx := x >> hshift
+			d[4*x], d[4*x+1], d[4*x+2] = rgb(l[x], u[cx*ucpp], v[cx*vcpp])
+			d[4*x+3] = 0xff
+		}
+	}
+}
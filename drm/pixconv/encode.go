@@ -0,0 +1,279 @@
+package pixconv
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+
+	"github.com/inahga/inahgo/drm"
+)
+
+// Encode converts src into dst, with the top left corner of src's bounds at the
+// buffer's origin. Parts of src outside the buffer are dropped, and parts of
+// the buffer outside src are left alone.
+//
+// Subsampled chroma is the average of the pixels sharing it. Images of type
+// *image.RGBA and *image.NRGBA are converted without going through
+// image.Image's methods.
+func Encode(dst Buffer, src image.Image) error {
+	if err := dst.Validate(); err != nil {
+		return err
+	}
+	b := src.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width > dst.Width {
+		width = dst.Width
+	}
+	if height > dst.Height {
+		height = dst.Height
+	}
+	if width <= 0 || height <= 0 {
+		return nil
+	}
+	encode(dst, width, height, &source{img: src, min: b.Min, width: width})
+	return nil
+}
+
+// EncodeFunc sets every pixel of dst to the color f returns for it, which is
+// premultiplied by alpha like those of color.RGBA64.
+func EncodeFunc(dst Buffer, f func(x, y int) color.RGBA64) error {
+	if err := dst.Validate(); err != nil {
+		return err
+	}
+	encode(dst, dst.Width, dst.Height, &source{f: f, width: dst.Width})
+	return nil
+}
+
+func encode(dst Buffer, width, height int, src *source) {
+	if layout, ok := rgbLayouts[dst.Format]; ok {
+		encodeRGB(dst, width, height, layout, src)
+	} else if layout, ok := packedYUVLayouts[dst.Format]; ok {
+		encodePackedYUV(dst, width, height, layout, src)
+	} else if layout, ok := planarYUVLayouts[dst.Format]; ok {
+		encodePlanarYUV(dst, width, height, drm.LookupFormat(dst.Format), layout, src)
+	}
+}
+
+// source reads the rows of an image, or of a function, as premultiplied RGBA.
+type source struct {
+	img   image.Image
+	f     func(x, y int) color.RGBA64
+	min   image.Point
+	width int
+
+	buf8  []uint8
+	buf16 []uint16
+}
+
+// deep returns whether the source has more than 8 bits per channel.
+func (s *source) deep() bool {
+	switch s.img.(type) {
+	case *image.RGBA, *image.NRGBA:
+		return false
+	}
+	return true
+}
+
+// row8 returns a row with 8 bits per channel.
+func (s *source) row8(y int) []uint8 {
+	switch img := s.img.(type) {
+	case *image.RGBA:
+		i := img.PixOffset(s.min.X, s.min.Y+y)
+		return img.Pix[i : i+4*s.width]
+	case *image.NRGBA:
+		i := img.PixOffset(s.min.X, s.min.Y+y)
+		p := img.Pix[i : i+4*s.width]
+		buf := s.buffer8()
+		for x := 0; x < len(p); x += 4 {
+			a := uint32(p[x+3])
+			if a == 0xff {
+				copy(buf[x:x+4], p[x:x+4])
+				continue
+			}
+			buf[x] = uint8((uint32(p[x])*a + 127) / 255)
+			buf[x+1] = uint8((uint32(p[x+1])*a + 127) / 255)
+			buf[x+2] = uint8((uint32(p[x+2])*a + 127) / 255)
+			buf[x+3] = uint8(a)
+		}
+		return buf
+	}
+	row := s.row16(y)
+	buf := s.buffer8()
+	for i, v := range row {
+		buf[i] = uint8(v >> 8)
+	}
+	return buf
+}
+
+// row16 returns a row with 16 bits per channel.
+func (s *source) row16(y int) []uint16 {
+	if s.buf16 == nil {
+		s.buf16 = make([]uint16, 4*s.width)
+	}
+	buf := s.buf16
+	switch img := s.img.(type) {
+	case *image.RGBA, *image.NRGBA:
+		for i, v := range s.row8(y) {
+			buf[i] = uint16(v) * 0x101
+		}
+	case *image.RGBA64:
+		i := img.PixOffset(s.min.X, s.min.Y+y)
+		p := img.Pix[i : i+8*s.width]
+		for i := range buf {
+			buf[i] = binary.BigEndian.Uint16(p[2*i:])
+		}
+	case image.RGBA64Image:
+		for x := 0; x < s.width; x++ {
+			c := img.RGBA64At(s.min.X+x, s.min.Y+y)
+			buf[4*x], buf[4*x+1], buf[4*x+2], buf[4*x+3] = c.R, c.G, c.B, c.A
+		}
+	case image.Image:
+		for x := 0; x < s.width; x++ {
+			r, g, b, a := img.At(s.min.X+x, s.min.Y+y).RGBA()
+			buf[4*x], buf[4*x+1], buf[4*x+2], buf[4*x+3] = uint16(r), uint16(g), uint16(b), uint16(a)
+		}
+	default:
+		for x := 0; x < s.width; x++ {
+			c := s.f(x, y)
+			buf[4*x], buf[4*x+1], buf[4*x+2], buf[4*x+3] = c.R, c.G, c.B, c.A
+		}
+	}
+	return buf
+}
+
+func (s *source) buffer8() []uint8 {
+	if s.buf8 == nil {
+		s.buf8 = make([]uint8, 4*s.width)
+	}
+	return s.buf8
+}
+
+func encodeRGB(dst Buffer, width, height int, layout rgbLayout, src *source) {
+	cpp := layout.cpp
+	deep := layout.deep() && src.deep()
+	var lut *[4][256]uint32
+	if !deep {
+		lut = layout.lut()
+	}
+	for y := 0; y < height; y++ {
+		d := dst.Planes[0][y*dst.Pitches[0] : y*dst.Pitches[0]+width*cpp]
+		if deep {
+			p := src.row16(y)
+			for x := 0; x < width; x++ {
+				binary.LittleEndian.PutUint32(d[4*x:], layout.pack(p[4*x], p[4*x+1], p[4*x+2], p[4*x+3]))
+			}
+			continue
+		}
+		p := src.row8(y)
+		switch dst.Format {
+		case drm.FormatABGR8888, drm.FormatXBGR8888:
+			// The bytes are in the order of image.RGBA's.
+			copy(d, p)
+		case drm.FormatARGB8888, drm.FormatXRGB8888:
+			for x := 0; x+4 <= len(d); x += 4 {
+				v := binary.LittleEndian.Uint32(p[x:])
+				binary.LittleEndian.PutUint32(d[x:], v&0xff00ff00|v>>16&0xff|v&0xff<<16)
+			}
+		default:
+			for x := 0; x < width; x++ {
+				c := p[4*x : 4*x+4 : 4*x+4]
+				v := lut[0][c[0]] | lut[1][c[1]] | lut[2][c[2]] | lut[3][c[3]]
+				switch cpp {
+				case 2:
+					binary.LittleEndian.PutUint16(d[2*x:], uint16(v))
+				case 3:
+					d[3*x], d[3*x+1], d[3*x+2] = byte(v), byte(v>>8), byte(v>>16)
+				default:
+					binary.LittleEndian.PutUint32(d[4*x:], v)
+				}
+			}
+		}
+	}
+}
+
+func encodePackedYUV(dst Buffer, width, height int, layout packedYUV, src *source) {
+	for y := 0; y < height; y++ {
+		d := dst.Planes[0][y*dst.Pitches[0]:]
+		p := src.row8(y)
+		for x := 0; x < width; x += 2 {
+			r, g, b := int(p[4*x]), int(p[4*x+1]), int(p[4*x+2])
+			y0 := luma(r, g, b)
+			y1 := y0
+			if x+1 < width {
+				r1, g1, b1 := int(p[4*x+4]), int(p[4*x+5]), int(p[4*x+6])
+				y1 = luma(r1, g1, b1)
+				r, g, b = (r+r1+1)/2, (g+g1+1)/2, (b+b1+1)/2
+			}
+			u, v := chroma(r, g, b)
+			pair := d[x*2:]
+			pair[layout.y0] = y0
+			pair[layout.u] = u
+			pair[layout.y1] = y1
+			pair[layout.v] = v
+		}
+	}
+}
+
+// encodePlanarYUV encodes the formats of planarYUVLayouts, which all subsample
+// chroma horizontally. Each chroma sample is computed from the 2x2 block of
+// pixels sharing it, or the 2x1 one without vertical subsampling. Blocks past
+// the right or bottom edge repeat the last pixels, which leaves their average
+// alone.
+func encodePlanarYUV(dst Buffer, width, height int, info *drm.FormatInfo, layout chromaOrder, src *source) {
+	vsub := int(info.VSub)
+	ucpp, vcpp := int(info.CPP[layout.uPlane]), int(info.CPP[layout.vPlane])
+	full := width &^ 1
+	var prev []uint8
+	for y := 0; y < height; y += vsub {
+		y1 := y
+		if vsub == 2 && y+1 < height {
+			y1++
+		}
+		p0 := src.row8(y)
+		p1 := p0
+		if y1 != y {
+			if _, ok := src.img.(*image.RGBA); !ok {
+				// The row is in a buffer the next one overwrites.
+				prev = append(prev[:0], p0...)
+				p0 = prev
+			}
+			p1 = src.row8(y1)
+		}
+		l0 := dst.Planes[0][y*dst.Pitches[0] : y*dst.Pitches[0]+width]
+		l1 := dst.Planes[0][y1*dst.Pitches[0] : y1*dst.Pitches[0]+width]
+		cy := y / vsub
+		u := dst.Planes[layout.uPlane][cy*dst.Pitches[layout.uPlane]+layout.uOffset:]
+		v := dst.Planes[layout.vPlane][cy*dst.Pitches[layout.vPlane]+layout.vOffset:]
+		for x := 0; x < full; x += 2 {
+			// A load reads the two pixels of a row.
+			c0, c1 := binary.LittleEndian.Uint64(p0[4*x:]), binary.LittleEndian.Uint64(p1[4*x:])
+			a, b := l0[x:x+2:x+2], l1[x:x+2:x+2]
+			a[0], a[1], b[0], b[1] = luma64(c0), luma64(c0>>32), luma64(c1), luma64(c1>>32)
+			u[x/2*ucpp], v[x/2*vcpp] = chroma64(c0, c1)
+		}
+		if full < width {
+			c0, c1 := uint64(binary.LittleEndian.Uint32(p0[4*full:])), uint64(binary.LittleEndian.Uint32(p1[4*full:]))
+			l0[full], l1[full] = luma64(c0), luma64(c1)
+			u[full/2*ucpp], v[full/2*vcpp] = chroma64(c0|c0<<32, c1|c1<<32)
+		}
+	}
+}
+
+// luma64 returns the luma of the pixel in the low 32 bits of c, in the byte
+// order of image.RGBA.
+func luma64(c uint64) uint8 {
+	return luma(int(c&0xff), int(c>>8&0xff), int(c>>16&0xff))
+}
+
+// chroma64 returns the chroma of the average of the 4 pixels in c0 and c1.
+func chroma64(c0, c1 uint64) (u, v uint8) {
+	// Sum the pixels of each column in 16 bit lanes: red and blue in rb, green
+	// and alpha in ga.
+	const m = 0x00ff00ff00ff00ff
+	rb := c0&m + c1&m
+	ga := c0>>8&m + c1>>8&m
+	r := int(rb&0xffff + rb>>32&0xffff)
+	g := int(ga&0xffff + ga>>32&0xffff)
+	b := int(rb>>16&0xffff + rb>>48)
+	return chroma((r+2)>>2, (g+2)>>2, (b+2)>>2)
+}
@@ -0,0 +1,252 @@
+// Package pixconv converts images between the image package and the memory
+// layouts of DRM pixel formats, and scales and rotates them for scanout.
+//
+// Colors are stored premultiplied by alpha, as the default "pixel blend mode"
+// of planes expects, and YUV formats use limited range BT.601.
+package pixconv
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/inahga/inahgo/drm"
+)
+
+// Buffer describes the memory of a framebuffer in a pixel format, e.g. a mapped
+// dumb buffer. Planes and Pitches hold the memory and the number of bytes per
+// row of each plane of the format. Pitches may be larger than the rows, whose
+// padding is left alone.
+type Buffer struct {
+	Format  uint32
+	Width   int
+	Height  int
+	Planes  [][]byte
+	Pitches []int
+}
+
+// NewBuffer allocates a buffer of a format, with the pitch of each plane
+// rounded up to a multiple of align bytes, or unpadded if align is 0.
+func NewBuffer(format uint32, width, height, align int) (Buffer, error) {
+	b := Buffer{Format: format, Width: width, Height: height}
+	info := drm.LookupFormat(format)
+	if info == nil {
+		return b, fmt.Errorf("unsupported format %s", drm.FormatString(format))
+	}
+	if width <= 0 || height <= 0 {
+		return b, fmt.Errorf("invalid buffer size %dx%d", width, height)
+	}
+	for i := 0; i < int(info.NumPlanes); i++ {
+		w, h := planeSize(info, i, width, height)
+		pitch := w * int(info.CPP[i])
+		if align > 0 {
+			pitch = (pitch + align - 1) / align * align
+		}
+		b.Planes = append(b.Planes, make([]byte, pitch*h))
+		b.Pitches = append(b.Pitches, pitch)
+	}
+	return b, nil
+}
+
+// DumbBuffer returns the buffer of a dumb framebuffer's memory. Writing to it
+// must be reported with the framebuffer's Damage method.
+func DumbBuffer(f *drm.DumbFramebuffer) Buffer {
+	return Buffer{Format: f.Format, Width: f.Width, Height: f.Height,
+		Planes: [][]byte{f.Mem}, Pitches: []int{f.Pitch}}
+}
+
+// Validate returns an error if the format is not supported or the planes are
+// too small for the size.
+func (b Buffer) Validate() error {
+	info := drm.LookupFormat(b.Format)
+	if info == nil || !supported(b.Format) {
+		return fmt.Errorf("unsupported format %s", drm.FormatString(b.Format))
+	}
+	if b.Width <= 0 || b.Height <= 0 {
+		return fmt.Errorf("invalid buffer size %dx%d", b.Width, b.Height)
+	}
+	if len(b.Planes) < int(info.NumPlanes) || len(b.Pitches) < int(info.NumPlanes) {
+		return fmt.Errorf("format %s needs %d planes", drm.FormatString(b.Format), info.NumPlanes)
+	}
+	for i := 0; i < int(info.NumPlanes); i++ {
+		w, h := planeSize(info, i, b.Width, b.Height)
+		if b.Pitches[i] < w*int(info.CPP[i]) || len(b.Planes[i]) < b.Pitches[i]*(h-1)+w*int(info.CPP[i]) {
+			return fmt.Errorf("plane %d is too small", i)
+		}
+	}
+	return nil
+}
+
+// planeSize returns the size of a plane in pixels of the plane.
+func planeSize(info *drm.FormatInfo, plane, width, height int) (int, int) {
+	w, h := info.PlaneSize(plane, uint32(width), uint32(height))
+	if info.IsYUV && info.NumPlanes == 1 {
+		// Packed YUV formats store whole pixel pairs.
+		w = (w + 1) &^ 1
+	}
+	return int(w), int(h)
+}
+
+// Formats returns the pixel formats the package can encode and decode, in
+// increasing order of their codes.
+func Formats() []uint32 {
+	var ret []uint32
+	for format := range rgbLayouts {
+		ret = append(ret, format)
+	}
+	for format := range packedYUVLayouts {
+		ret = append(ret, format)
+	}
+	for format := range planarYUVLayouts {
+		ret = append(ret, format)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret
+}
+
+func supported(format uint32) bool {
+	_, rgb := rgbLayouts[format]
+	_, packed := packedYUVLayouts[format]
+	_, planar := planarYUVLayouts[format]
+	return rgb || packed || planar
+}
+
+// channel describes the position of a color channel in a packed RGB pixel.
+type channel struct {
+	shift, bits uint
+}
+
+type rgbLayout struct {
+	r, g, b, a channel
+	cpp        int
+}
+
+var rgbLayouts = map[uint32]rgbLayout{
+	drm.FormatRGB565:      {r: channel{11, 5}, g: channel{5, 6}, b: channel{0, 5}, cpp: 2},
+	drm.FormatBGR565:      {r: channel{0, 5}, g: channel{5, 6}, b: channel{11, 5}, cpp: 2},
+	drm.FormatXRGB1555:    {r: channel{10, 5}, g: channel{5, 5}, b: channel{0, 5}, cpp: 2},
+	drm.FormatARGB1555:    {r: channel{10, 5}, g: channel{5, 5}, b: channel{0, 5}, a: channel{15, 1}, cpp: 2},
+	drm.FormatRGB888:      {r: channel{16, 8}, g: channel{8, 8}, b: channel{0, 8}, cpp: 3},
+	drm.FormatBGR888:      {r: channel{0, 8}, g: channel{8, 8}, b: channel{16, 8}, cpp: 3},
+	drm.FormatXRGB8888:    {r: channel{16, 8}, g: channel{8, 8}, b: channel{0, 8}, cpp: 4},
+	drm.FormatXBGR8888:    {r: channel{0, 8}, g: channel{8, 8}, b: channel{16, 8}, cpp: 4},
+	drm.FormatRGBX8888:    {r: channel{24, 8}, g: channel{16, 8}, b: channel{8, 8}, cpp: 4},
+	drm.FormatBGRX8888:    {r: channel{8, 8}, g: channel{16, 8}, b: channel{24, 8}, cpp: 4},
+	drm.FormatARGB8888:    {r: channel{16, 8}, g: channel{8, 8}, b: channel{0, 8}, a: channel{24, 8}, cpp: 4},
+	drm.FormatABGR8888:    {r: channel{0, 8}, g: channel{8, 8}, b: channel{16, 8}, a: channel{24, 8}, cpp: 4},
+	drm.FormatRGBA8888:    {r: channel{24, 8}, g: channel{16, 8}, b: channel{8, 8}, a: channel{0, 8}, cpp: 4},
+	drm.FormatBGRA8888:    {r: channel{8, 8}, g: channel{16, 8}, b: channel{24, 8}, a: channel{0, 8}, cpp: 4},
+	drm.FormatXRGB2101010: {r: channel{20, 10}, g: channel{10, 10}, b: channel{0, 10}, cpp: 4},
+	drm.FormatXBGR2101010: {r: channel{0, 10}, g: channel{10, 10}, b: channel{20, 10}, cpp: 4},
+	drm.FormatARGB2101010: {r: channel{20, 10}, g: channel{10, 10}, b: channel{0, 10}, a: channel{30, 2}, cpp: 4},
+	drm.FormatABGR2101010: {r: channel{0, 10}, g: channel{10, 10}, b: channel{20, 10}, a: channel{30, 2}, cpp: 4},
+}
+
+func (c channel) pack(v uint16) uint32 {
+	return uint32(v>>(16-c.bits)) << c.shift
+}
+
+// unpack extracts the channel from a pixel, scaled to 16 bits by repeating its
+// bits.
+func (c channel) unpack(p uint32) uint16 {
+	v := p >> c.shift & (1<<c.bits - 1)
+	var ret uint32
+	for s := 16 - int(c.bits); s > -int(c.bits); s -= int(c.bits) {
+		if s >= 0 {
+			ret |= v << uint(s)
+		} else {
+			ret |= v >> uint(-s)
+		}
+	}
+	return uint16(ret)
+}
+
+func (l rgbLayout) pack(r, g, b, a uint16) uint32 {
+	v := l.r.pack(r) | l.g.pack(g) | l.b.pack(b)
+	if l.a.bits > 0 {
+		v |= l.a.pack(a)
+	}
+	return v
+}
+
+func (l rgbLayout) unpack(p uint32) (r, g, b, a uint16) {
+	r, g, b, a = l.r.unpack(p), l.g.unpack(p), l.b.unpack(p), 0xffff
+	if l.a.bits > 0 {
+		a = l.a.unpack(p)
+	}
+	return
+}
+
+// lut returns the bits of each 8 bit value of red, green, blue and alpha in a
+// pixel, to pack pixels with lookups instead of shifts.
+func (l rgbLayout) lut() *[4][256]uint32 {
+	var t [4][256]uint32
+	for i := 0; i < 256; i++ {
+		v := uint16(i) * 0x101
+		t[0][i], t[1][i], t[2][i] = l.r.pack(v), l.g.pack(v), l.b.pack(v)
+		if l.a.bits > 0 {
+			t[3][i] = l.a.pack(v)
+		}
+	}
+	return &t
+}
+
+// deep returns whether a channel has more than 8 bits.
+func (l rgbLayout) deep() bool {
+	return l.r.bits > 8 || l.g.bits > 8 || l.b.bits > 8
+}
+
+// packedYUV holds the byte offsets of each component within a pixel pair.
+type packedYUV struct {
+	y0, u, y1, v int
+}
+
+var packedYUVLayouts = map[uint32]packedYUV{
+	drm.FormatYUYV: {y0: 0, u: 1, y1: 2, v: 3},
+	drm.FormatYVYU: {y0: 0, v: 1, y1: 2, u: 3},
+	drm.FormatUYVY: {u: 0, y0: 1, v: 2, y1: 3},
+	drm.FormatVYUY: {v: 0, y0: 1, u: 2, y1: 3},
+}
+
+// chromaOrder describes in which plane, and at which byte offset within that
+// plane's pixel, the Cb and Cr components of a planar format are stored.
+type chromaOrder struct {
+	uPlane, uOffset int
+	vPlane, vOffset int
+}
+
+var planarYUVLayouts = map[uint32]chromaOrder{
+	drm.FormatNV12:   {uPlane: 1, uOffset: 0, vPlane: 1, vOffset: 1},
+	drm.FormatNV21:   {uPlane: 1, uOffset: 1, vPlane: 1, vOffset: 0},
+	drm.FormatNV16:   {uPlane: 1, uOffset: 0, vPlane: 1, vOffset: 1},
+	drm.FormatNV61:   {uPlane: 1, uOffset: 1, vPlane: 1, vOffset: 0},
+	drm.FormatYUV420: {uPlane: 1, vPlane: 2},
+	drm.FormatYVU420: {uPlane: 2, vPlane: 1},
+}
+
+// luma and chroma convert an 8 bit color to limited range BT.601 YCbCr.
+func luma(r, g, b int) uint8 {
+	return uint8((66*r+129*g+25*b+128)>>8 + 16)
+}
+
+func chroma(r, g, b int) (u, v uint8) {
+	u = uint8((-38*r-74*g+112*b+128)>>8 + 128)
+	v = uint8((112*r-94*g-18*b+128)>>8 + 128)
+	return
+}
+
+// rgb converts limited range BT.601 YCbCr to an 8 bit color.
+func rgb(y, u, v uint8) (r, g, b uint8) {
+	c, d, e := 298*(int(y)-16), int(u)-128, int(v)-128
+	return clamp8((c + 409*e + 128) >> 8), clamp8((c - 100*d - 208*e + 128) >> 8),
+		clamp8((c + 516*d + 128) >> 8)
+}
+
+func clamp8(v int) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
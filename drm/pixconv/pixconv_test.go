@@ -0,0 +1,389 @@
+package pixconv
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"github.com/inahga/inahgo/drm"
+)
+
+// testImage returns an opaque image of blocks of 2x2 pixels of distinct colors,
+// so that subsampled chroma is exact.
+func testImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			bx, by := x/2, y/2
+			img.SetRGBA(x, y, color.RGBA{uint8(bx * 37), uint8(by * 59), uint8((bx + by) * 23), 0xff})
+		}
+	}
+	return img
+}
+
+func newBuffer(t testing.TB, format uint32, width, height, align int) Buffer {
+	t.Helper()
+	b, err := NewBuffer(format, width, height, align)
+	if err != nil {
+		t.Fatalf("new buffer: %s", err)
+	}
+	return b
+}
+
+// compare fails if a channel of two images differs by more than tolerance.
+func compare(t *testing.T, name string, want, got image.Image, tolerance int) {
+	t.Helper()
+	if want.Bounds() != got.Bounds() {
+		t.Fatalf("%s: bounds %v, want %v", name, got.Bounds(), want.Bounds())
+	}
+	b := want.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			w := color.RGBAModel.Convert(want.At(x, y)).(color.RGBA)
+			g := color.RGBAModel.Convert(got.At(x, y)).(color.RGBA)
+			for i, d := range []int{int(w.R) - int(g.R), int(w.G) - int(g.G), int(w.B) - int(g.B), int(w.A) - int(g.A)} {
+				if d < -tolerance || d > tolerance {
+					t.Fatalf("%s: pixel %d,%d channel %d is %v, want %v", name, x, y, i, g, w)
+				}
+			}
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	src := testImage(14, 10)
+	for format, layout := range rgbLayouts {
+		bits := layout.g.bits
+		if layout.r.bits < bits {
+			bits = layout.r.bits
+		}
+		if bits > 8 {
+			bits = 8
+		}
+		b := newBuffer(t, format, 14, 10, 64)
+		if err := Encode(b, src); err != nil {
+			t.Fatalf("%s: encode: %s", drm.FormatString(format), err)
+		}
+		img, err := Decode(b)
+		if err != nil {
+			t.Fatalf("%s: decode: %s", drm.FormatString(format), err)
+		}
+		compare(t, drm.FormatString(format), src, img, 1<<(8-bits))
+	}
+	for _, format := range []uint32{drm.FormatYUYV, drm.FormatUYVY, drm.FormatNV12, drm.FormatNV21,
+		drm.FormatNV16, drm.FormatYUV420, drm.FormatYVU420} {
+		b := newBuffer(t, format, 14, 10, 0)
+		if err := Encode(b, src); err != nil {
+			t.Fatalf("%s: encode: %s", drm.FormatString(format), err)
+		}
+		img, err := Decode(b)
+		if err != nil {
+			t.Fatalf("%s: decode: %s", drm.FormatString(format), err)
+		}
+		compare(t, drm.FormatString(format), src, img, 3)
+	}
+}
+
+func TestDeepRoundTrip(t *testing.T) {
+	src := image.NewRGBA64(image.Rect(0, 0, 4, 1))
+	for x := 0; x < 4; x++ {
+		v := uint16(x * 0x3ff * 64 / 3)
+		src.SetRGBA64(x, 0, color.RGBA64{v, 0xffff - v, v / 2, 0xffff})
+	}
+	b := newBuffer(t, drm.FormatXRGB2101010, 4, 1, 0)
+	if err := Encode(b, src); err != nil {
+		t.Fatalf("encode: %s", err)
+	}
+	img, err := Decode(b)
+	if err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+	for x := 0; x < 4; x++ {
+		want, got := src.RGBA64At(x, 0), img.(*image.RGBA64).RGBA64At(x, 0)
+		if want.R>>6 != got.R>>6 || want.G>>6 != got.G>>6 || want.B>>6 != got.B>>6 || got.A != 0xffff {
+			t.Errorf("pixel %d is %v, want %v", x, got, want)
+		}
+	}
+}
+
+func TestEncodeLeavesPadding(t *testing.T) {
+	b := newBuffer(t, drm.FormatRGB565, 5, 3, 64)
+	for i := range b.Planes[0] {
+		b.Planes[0][i] = 0xaa
+	}
+	if err := Encode(b, testImage(3, 2)); err != nil {
+		t.Fatalf("encode: %s", err)
+	}
+	for y := 0; y < 3; y++ {
+		for x := 0; x < b.Pitches[0]; x++ {
+			written := y < 2 && x < 3*2
+			if v := b.Planes[0][y*b.Pitches[0]+x]; !written && v != 0xaa {
+				t.Fatalf("byte %d of row %d is %#x", x, y, v)
+			}
+		}
+	}
+}
+
+func TestEncodePremultiplies(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{200, 100, 0, 0x80})
+	b := newBuffer(t, drm.FormatARGB8888, 1, 1, 0)
+	if err := Encode(b, src); err != nil {
+		t.Fatalf("encode: %s", err)
+	}
+	if got, want := b.Planes[0], []byte{0, 50, 100, 0x80}; string(got) != string(want) {
+		t.Errorf("pixel is %v, want %v", got, want)
+	}
+}
+
+// noiseImage returns an opaque image of random colors.
+func noiseImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	rng := rand.New(rand.NewSource(1))
+	rng.Read(img.Pix)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xff
+	}
+	return img
+}
+
+func TestEncodeChroma(t *testing.T) {
+	// Odd sizes leave blocks of 2 pixels, and of 1, at the edges.
+	const width, height = 15, 9
+	src := noiseImage(width, height)
+	nrgba := image.NewNRGBA(src.Rect)
+	copy(nrgba.Pix, src.Pix)
+	for format, layout := range planarYUVLayouts {
+		name := drm.FormatString(format)
+		info := drm.LookupFormat(format)
+		hsub, vsub := int(info.HSub), int(info.VSub)
+		b := newBuffer(t, format, width, height, 0)
+		if err := Encode(b, src); err != nil {
+			t.Fatalf("%s: encode: %s", name, err)
+		}
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				c := src.RGBAAt(x, y)
+				if got, want := b.Planes[0][y*b.Pitches[0]+x], luma(int(c.R), int(c.G), int(c.B)); got != want {
+					t.Fatalf("%s: luma of %d,%d is %d, want %d", name, x, y, got, want)
+				}
+			}
+		}
+		// Each chroma sample is that of the average of the pixels sharing it.
+		for cy := 0; cy < (height+vsub-1)/vsub; cy++ {
+			for cx := 0; cx < (width+hsub-1)/hsub; cx++ {
+				var r, g, bl, n int
+				for y := cy * vsub; y < (cy+1)*vsub && y < height; y++ {
+					for x := cx * hsub; x < (cx+1)*hsub && x < width; x++ {
+						c := src.RGBAAt(x, y)
+						r, g, bl, n = r+int(c.R), g+int(c.G), bl+int(c.B), n+1
+					}
+				}
+				wantU, wantV := chroma((r+n/2)/n, (g+n/2)/n, (bl+n/2)/n)
+				u := b.Planes[layout.uPlane][cy*b.Pitches[layout.uPlane]+cx*int(info.CPP[layout.uPlane])+layout.uOffset]
+				v := b.Planes[layout.vPlane][cy*b.Pitches[layout.vPlane]+cx*int(info.CPP[layout.vPlane])+layout.vOffset]
+				if u != wantU || v != wantV {
+					t.Fatalf("%s: chroma of %d,%d is %d,%d, want %d,%d", name, cx, cy, u, v, wantU, wantV)
+				}
+			}
+		}
+
+		// Sources read through a row buffer encode the same.
+		other := newBuffer(t, format, width, height, 0)
+		if err := Encode(other, nrgba); err != nil {
+			t.Fatalf("%s: encode: %s", name, err)
+		}
+		for i := range b.Planes {
+			if string(b.Planes[i]) != string(other.Planes[i]) {
+				t.Errorf("%s: plane %d of an NRGBA source differs", name, i)
+			}
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	b := newBuffer(t, drm.FormatNV12, 8, 8, 0)
+	b.Planes[1] = b.Planes[1][:len(b.Planes[1])-1]
+	if err := Encode(b, testImage(8, 8)); err == nil {
+		t.Error("encoded into a short chroma plane")
+	}
+	b = Buffer{Format: drm.FormatXRGB8888, Width: 4, Height: 4, Planes: [][]byte{make([]byte, 64)}, Pitches: []int{8}}
+	if err := Encode(b, testImage(4, 4)); err == nil {
+		t.Error("encoded with a pitch shorter than a row")
+	}
+}
+
+func scale(src image.Image, width, height int, f Filter) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	Scale(dst, src, f)
+	return dst
+}
+
+func orient(t *testing.T, src image.Image, o Orientation) *image.RGBA {
+	t.Helper()
+	w, h := o.Size(src.Bounds().Dx(), src.Bounds().Dy())
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	if err := Orient(dst, src, o); err != nil {
+		t.Fatalf("orient: %s", err)
+	}
+	return dst
+}
+
+func TestScale(t *testing.T) {
+	src := testImage(4, 4)
+	near := scale(src, 8, 8, Nearest)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if got, want := near.RGBAAt(x, y), src.RGBAAt(x/2, y/2); got != want {
+				t.Fatalf("nearest pixel %d,%d is %v, want %v", x, y, got, want)
+			}
+		}
+	}
+	compare(t, "nearest shrink", scale(src, 2, 2, Nearest), scale(src, 2, 2, Bilinear), 0)
+
+	edge := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	edge.SetRGBA(1, 0, color.RGBA{0xff, 0xff, 0xff, 0xff})
+	if got := scale(edge, 1, 1, Bilinear).RGBAAt(0, 0); got.R < 0x7f || got.R > 0x80 || got.A != 0x80 {
+		t.Errorf("bilinear shrink is %v", got)
+	}
+	wide := scale(edge, 4, 1, Bilinear)
+	for x, want := range []int{0, 0x40, 0xbf, 0xff} {
+		if got := int(wide.RGBAAt(x, 0).R); got < want-1 || got > want+1 {
+			t.Errorf("bilinear pixel %d is %#x, want %#x", x, got, want)
+		}
+	}
+}
+
+// TestScaleBilinearExact compares bilinear scaling with interpolating each
+// channel on its own.
+func TestScaleBilinearExact(t *testing.T) {
+	src := noiseImage(37, 23)
+	for _, size := range []image.Point{{50, 31}, {16, 9}, {37, 23}, {1, 1}} {
+		dst := scale(src, size.X, size.Y, Bilinear)
+		xs, ys := taps(size.X, 37, 1), taps(size.Y, 23, 1)
+		for y, ty := range ys {
+			for x, tx := range xs {
+				got := dst.RGBAAt(x, y)
+				for i, g := range []uint8{got.R, got.G, got.B, got.A} {
+					at := func(sx, sy int) int { return int(src.Pix[src.PixOffset(sx, sy)+i]) }
+					c0 := at(tx.i0, ty.i0)*(256-ty.w) + at(tx.i0, ty.i1)*ty.w
+					c1 := at(tx.i1, ty.i0)*(256-ty.w) + at(tx.i1, ty.i1)*ty.w
+					if want := (c0*(256-tx.w) + c1*tx.w + 1<<15) >> 16; int(g) != want {
+						t.Fatalf("%v: channel %d of %d,%d is %d, want %d", size, i, x, y, g, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	src := testImage(6, 4)
+	corner := src.RGBAAt(0, 0)
+	for _, tc := range []struct {
+		o    Orientation
+		w, h int
+		x, y int
+	}{
+		{Normal, 6, 4, 0, 0},
+		{UpsideDown, 6, 4, 5, 3},
+		{LeftSideUp, 4, 6, 0, 5},
+		{RightSideUp, 4, 6, 3, 0},
+	} {
+		dst := orient(t, src, tc.o)
+		if dst.Rect != image.Rect(0, 0, tc.w, tc.h) {
+			t.Errorf("%s: size %v", tc.o, dst.Rect)
+			continue
+		}
+		if got := dst.RGBAAt(tc.x, tc.y); got != corner {
+			t.Errorf("%s: top left corner is not at %d,%d", tc.o, tc.x, tc.y)
+		}
+	}
+	compare(t, "round trip", src, orient(t, orient(t, src, LeftSideUp), RightSideUp), 0)
+	if err := Orient(image.NewRGBA(image.Rect(0, 0, 6, 4)), src, LeftSideUp); err == nil {
+		t.Error("rotated into a framebuffer of the wrong size")
+	}
+}
+
+func TestPanelOrientation(t *testing.T) {
+	dev := drm.NewFakeDevice()
+	card := drm.NewWithBackend(dev)
+	crtc := dev.AddCRTC()
+	encoder := dev.AddEncoder(drm.ModeEncoderLVDS, []uint32{crtc})
+	edp := dev.AddConnector(drm.ModeConnectorEDP, []uint32{encoder})
+	hdmi := dev.AddConnector(drm.ModeConnectorHDMIA, []uint32{encoder})
+	prop := drm.ModeProperty{Name: "panel orientation", Flags: drm.ModePropEnum | drm.ModePropImmutable}
+	for i, name := range orientationNames {
+		prop.Values = append(prop.Values, uint64(i))
+		prop.Enums = append(prop.Enums, drm.ModePropertyEnum{Value: uint64(i), Name: name})
+	}
+	dev.AddProperty(edp, prop, uint64(RightSideUp))
+
+	if o, err := PanelOrientation(card, edp); err != nil || o != RightSideUp {
+		t.Errorf("eDP orientation is %s, %v", o, err)
+	}
+	if o, err := PanelOrientation(card, hdmi); err != nil || o != Normal {
+		t.Errorf("HDMI orientation is %s, %v", o, err)
+	}
+}
+
+// benchmarkEncode measures the copy of a 1920x1080 frame, which has 16.7 ms at
+// 60fps. On a 2 GHz Xeon VM core, XRGB8888 takes 5-8 ms per frame, and NV12,
+// the slowest, 8-15 ms.
+func benchmarkEncode(b *testing.B, format uint32) {
+	src := testImage(1920, 1080)
+	buf := newBuffer(b, format, 1920, 1080, 64)
+	b.SetBytes(int64(len(src.Pix)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := Encode(buf, src); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeXRGB8888(b *testing.B)    { benchmarkEncode(b, drm.FormatXRGB8888) }
+func BenchmarkEncodeRGB565(b *testing.B)      { benchmarkEncode(b, drm.FormatRGB565) }
+func BenchmarkEncodeBGR888(b *testing.B)      { benchmarkEncode(b, drm.FormatBGR888) }
+func BenchmarkEncodeXRGB2101010(b *testing.B) { benchmarkEncode(b, drm.FormatXRGB2101010) }
+func BenchmarkEncodeNV12(b *testing.B)        { benchmarkEncode(b, drm.FormatNV12) }
+
+func BenchmarkDecodeXRGB8888(b *testing.B) {
+	buf := newBuffer(b, drm.FormatXRGB8888, 1920, 1080, 64)
+	b.SetBytes(int64(len(buf.Planes[0])))
+	for i := 0; i < b.N; i++ {
+		if _, err := Decode(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkScale measures the scaling of a 1280x720 frame to 1920x1080, into an
+// image reused from frame to frame. On the same core as benchmarkEncode,
+// Nearest takes 6-9 ms per frame, and Bilinear 17-25 ms: more than a frame at
+// 60fps, so scale once rather than every frame with Bilinear.
+func benchmarkScale(b *testing.B, f Filter) {
+	src := testImage(1280, 720)
+	dst := image.NewRGBA(image.Rect(0, 0, 1920, 1080))
+	b.SetBytes(int64(len(dst.Pix)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Scale(dst, src, f)
+	}
+}
+
+func BenchmarkScaleNearest(b *testing.B)  { benchmarkScale(b, Nearest) }
+func BenchmarkScaleBilinear(b *testing.B) { benchmarkScale(b, Bilinear) }
+
+func BenchmarkOrient(b *testing.B) {
+	src := testImage(1920, 1080)
+	dst := image.NewRGBA(image.Rect(0, 0, 1080, 1920))
+	b.SetBytes(int64(len(src.Pix)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := Orient(dst, src, LeftSideUp); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
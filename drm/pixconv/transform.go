@@ -0,0 +1,239 @@
+package pixconv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+
+	"github.com/inahga/inahgo/drm"
+)
+
+// Filter is how Scale samples the source image.
+type Filter int
+
+const (
+	// Nearest takes the pixel nearest to each sample, which keeps edges sharp
+	// and suits integer factors, e.g. for pixel art and text.
+	Nearest Filter = iota
+	// Bilinear interpolates between the four pixels nearest to each sample.
+	// Shrinking by more than half skips pixels and aliases.
+	Bilinear
+)
+
+// Scale scales src to fill dst's bounds. Images of type *image.RGBA are scaled
+// without a conversion.
+func Scale(dst *image.RGBA, src image.Image, f Filter) {
+	if dst.Rect.Empty() || src.Bounds().Empty() {
+		return
+	}
+	s := toRGBA(src)
+	if f == Bilinear {
+		scaleBilinear(dst, s)
+	} else {
+		scaleNearest(dst, s)
+	}
+}
+
+// toRGBA returns img as an *image.RGBA, converting it if needed.
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(b)
+	draw.Draw(rgba, b, img, b.Min, draw.Src)
+	return rgba
+}
+
+func scaleNearest(dst, src *image.RGBA) {
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+	dw, dh := dst.Rect.Dx(), dst.Rect.Dy()
+	// The offsets in a source row of the pixel nearest to the center of each
+	// destination column.
+	xs := make([]int, dw)
+	for x := range xs {
+		xs[x] = (2*x + 1) * sw / (2 * dw) * 4
+	}
+	for y := 0; y < dh; y++ {
+		sy := (2*y + 1) * sh / (2 * dh)
+		s := src.Pix[src.PixOffset(sb.Min.X, sb.Min.Y+sy):]
+		i := dst.PixOffset(dst.Rect.Min.X, dst.Rect.Min.Y+y)
+		d := dst.Pix[i : i+4*dw]
+		for x, sx := range xs {
+			binary.LittleEndian.PutUint32(d[4*x:], binary.LittleEndian.Uint32(s[sx:]))
+		}
+	}
+}
+
+// tap is a sample between two pixels of a row or column: their offsets, and the
+// weight of the second out of 256.
+type tap struct {
+	i0, i1, w int
+}
+
+// taps returns the samples at the centers of n pixels spread over a row or
+// column of size pixels, each step bytes apart.
+func taps(n, size, step int) []tap {
+	ret := make([]tap, n)
+	for i := range ret {
+		// The center of the sample in source pixels, in 1/256ths, shifted so
+		// that pixel centers fall on whole numbers.
+		c := (2*i+1)*size*256/(2*n) - 128
+		if c < 0 {
+			c = 0
+		}
+		p := c >> 8
+		next := p + 1
+		if next >= size {
+			next = size - 1
+		}
+		ret[i] = tap{p * step, next * step, c & 0xff}
+	}
+	return ret
+}
+
+func scaleBilinear(dst, src *image.RGBA) {
+	sb := src.Bounds()
+	sw := sb.Dx()
+	dw, dh := dst.Rect.Dx(), dst.Rect.Dy()
+	xs := taps(dw, sw, 1)
+	ys := taps(dh, sb.Dy(), src.Stride)
+	origin := src.PixOffset(sb.Min.X, sb.Min.Y)
+	// Interpolate between the rows first, then along the interpolated row. The
+	// channels of a pixel are interpolated together, in the 32 bit lanes of
+	// rb, holding red and blue, and of ga, holding green and alpha.
+	rb := make([]uint64, sw)
+	ga := make([]uint64, sw)
+	for y, ty := range ys {
+		s0 := src.Pix[origin+ty.i0 : origin+ty.i0+4*sw]
+		s1 := src.Pix[origin+ty.i1 : origin+ty.i1+4*sw]
+		wy1 := uint64(ty.w)
+		wy0 := 256 - wy1
+		for x := range rb {
+			const m = 0x00ff00ff
+			p0, p1 := uint64(binary.LittleEndian.Uint32(s0[4*x:])), uint64(binary.LittleEndian.Uint32(s1[4*x:]))
+			e := (p0&m)*wy0 + (p1&m)*wy1
+			o := (p0>>8&m)*wy0 + (p1>>8&m)*wy1
+			rb[x] = e&0xffff | e<<16&0xffff00000000
+			ga[x] = o&0xffff | o<<16&0xffff00000000
+		}
+		i := dst.PixOffset(dst.Rect.Min.X, dst.Rect.Min.Y+y)
+		interpolate(dst.Pix[i:i+4*dw], rb, ga, xs)
+	}
+}
+
+// interpolate sets the pixels of d to the samples xs of the row of rb and ga.
+func interpolate(d []uint8, rb, ga []uint64, xs []tap) {
+	for x, tx := range xs {
+		const round = 1<<15 | 1<<47
+		const m = 0x000000ff000000ff
+		wx1 := uint64(tx.w)
+		wx0 := 256 - wx1
+		e := (rb[tx.i0]*wx0 + rb[tx.i1]*wx1 + round) >> 16 & m
+		o := (ga[tx.i0]*wx0 + ga[tx.i1]*wx1 + round) >> 16 & m
+		v := e | o<<8
+		binary.LittleEndian.PutUint32(d[4*x:], uint32(v|v>>16))
+	}
+}
+
+// Orientation is how a panel is mounted in its casing, as the "panel
+// orientation" property of its connector reports it. The values are those of
+// the property.
+type Orientation int
+
+const (
+	// Normal panels are mounted upright.
+	Normal Orientation = iota
+	// UpsideDown panels are mounted with their top at the bottom.
+	UpsideDown
+	// LeftSideUp panels are mounted with their left side at the top.
+	LeftSideUp
+	// RightSideUp panels are mounted with their right side at the top.
+	RightSideUp
+)
+
+var orientationNames = []string{"Normal", "Upside Down", "Left Side Up", "Right Side Up"}
+
+// String returns the name of the orientation in the property.
+func (o Orientation) String() string {
+	if o >= 0 && int(o) < len(orientationNames) {
+		return orientationNames[o]
+	}
+	return fmt.Sprintf("Orientation(%d)", int(o))
+}
+
+// ParseOrientation returns the orientation of a name of the property.
+func ParseOrientation(name string) (Orientation, error) {
+	for i, n := range orientationNames {
+		if n == name {
+			return Orientation(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown panel orientation %q", name)
+}
+
+// PanelOrientation returns the orientation of a connector's panel, or Normal if
+// the connector does not report one.
+func PanelOrientation(c *drm.Card, connectorID uint32) (Orientation, error) {
+	props, err := c.ObjectProperties(connectorID, drm.ModeObjectConnector)
+	if err != nil {
+		return 0, err
+	}
+	prop, ok := props["panel orientation"]
+	if !ok {
+		return Normal, nil
+	}
+	name, ok := prop.EnumName()
+	if !ok {
+		return 0, fmt.Errorf("connector %d: panel orientation has unknown value %d", connectorID, prop.Value)
+	}
+	return ParseOrientation(name)
+}
+
+// Size returns the size of a framebuffer showing an image of a size upright on
+// a panel of the orientation.
+func (o Orientation) Size(width, height int) (int, int) {
+	if o == LeftSideUp || o == RightSideUp {
+		return height, width
+	}
+	return width, height
+}
+
+// Orient rotates src to appear upright on a panel of the orientation, into dst,
+// whose size must be the one o.Size returns. Panels mounted left side up need
+// the image rotated counterclockwise, and those mounted right side up need it
+// rotated clockwise.
+func Orient(dst *image.RGBA, src image.Image, o Orientation) error {
+	s := toRGBA(src)
+	sb := s.Bounds()
+	w, h := sb.Dx(), sb.Dy()
+	if dw, dh := o.Size(w, h); dst.Rect.Dx() != dw || dst.Rect.Dy() != dh {
+		return fmt.Errorf("%s: %dx%d image into %v", o, w, h, dst.Rect)
+	}
+	origin := dst.PixOffset(dst.Rect.Min.X, dst.Rect.Min.Y)
+	for y := 0; y < h; y++ {
+		row := s.Pix[s.PixOffset(sb.Min.X, sb.Min.Y+y):]
+		// The offset in dst of the row's first pixel, and the step to the
+		// next one.
+		var i, step int
+		switch o {
+		case UpsideDown:
+			i, step = (h-1-y)*dst.Stride+(w-1)*4, -4
+		case LeftSideUp:
+			i, step = (w-1)*dst.Stride+y*4, -dst.Stride
+		case RightSideUp:
+			i, step = (h-1-y)*4, dst.Stride
+		default:
+			copy(dst.Pix[origin+y*dst.Stride:], row[:4*w])
+			continue
+		}
+		i += origin
+		for x := 0; x < w; x++ {
+			binary.LittleEndian.PutUint32(dst.Pix[i:], binary.LittleEndian.Uint32(row[4*x:]))
+			i += step
+		}
+	}
+	return nil
+}
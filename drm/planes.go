@@ -0,0 +1,353 @@
+package drm
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"sort"
+	"syscall"
+)
+
+// Plane types, the values of the "type" property of a plane.
+const (
+	PlaneTypeOverlay uint64 = iota
+	PlaneTypePrimary
+	PlaneTypeCursor
+)
+
+// Layer is an image to be shown on a CRTC.
+type Layer struct {
+	FBID   uint32
+	Format uint32
+	// Src is the part of the framebuffer to show, in pixels.
+	Src image.Rectangle
+	// Dst is where the layer is shown on the CRTC. The layer is scaled if its size
+	// differs from that of Src.
+	Dst image.Rectangle
+	// Z orders the layers. Layers with higher values are shown on top.
+	Z int
+}
+
+// PlaneAssignment is the result of AssignPlanes.
+type PlaneAssignment struct {
+	// Planes holds the ID of the plane each layer is scanned out from, in the
+	// order the layers were given, or 0 for layers that must be composited.
+	Planes []uint32
+	// Composited holds the indices of the layers that must be composited in
+	// software into the composition layer, from bottom to top.
+	Composited []int
+	// Request is the tested atomic request configuring the planes of the CRTC.
+	Request *AtomicRequest
+}
+
+// AssignPlanes picks the planes of a CRTC that scan out layers directly, so that
+// as few layers as possible are composited in software. Candidate assignments
+// are checked with TEST_ONLY atomic commits of req, which is left unmodified,
+// extended with the plane configuration. Flags are added to ModeAtomicTestOnly,
+// and should be those the returned request will be committed with.
+//
+// If any layer is composited, the composition layer, into which the caller
+// renders the composited layers, is shown on the primary plane below all other
+// planes. A layer is never scanned out directly if a composited layer above it
+// overlaps it. Composition may be nil, in which case every layer must be
+// scanned out directly.
+//
+// Planes in use by other CRTCs are left alone. The card must have
+// ClientCapAtomic and ClientCapUniversalPlanes set.
+func (c *Card) AssignPlanes(req *AtomicRequest, crtcID uint32, layers []Layer, composition *Layer,
+	flags uint32) (*PlaneAssignment, error) {
+	planes, err := c.crtcPlanes(crtcID)
+	if err != nil {
+		return nil, err
+	}
+	if req == nil {
+		req = &AtomicRequest{}
+	}
+
+	// Layers are assigned from bottom to top, to planes of increasing zpos.
+	order := make([]int, len(layers))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return layers[order[i]].Z < layers[order[j]].Z
+	})
+
+	s := planeSolver{
+		card:     c,
+		base:     req,
+		flags:    flags,
+		crtcID:   crtcID,
+		layers:   layers,
+		order:    order,
+		planes:   planes,
+		assigned: make([]int, len(layers)),
+		best:     -1,
+	}
+	// Try scanning out every layer directly first, which leaves the primary
+	// plane to the layers.
+	s.search(0, -1, 0)
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.result == nil && composition != nil {
+		s.composition = composition
+		s.search(0, -1, 0)
+		if s.err != nil {
+			return nil, s.err
+		}
+	}
+	if s.result == nil {
+		return nil, fmt.Errorf("crtc %d: no plane assignment for %d layers", crtcID, len(layers))
+	}
+	return s.result, nil
+}
+
+// crtcPlane is a plane that can be used by a CRTC, with the IDs of the
+// properties needed to configure it.
+type crtcPlane struct {
+	id      uint32
+	typ     uint64
+	zpos    uint64
+	formats []uint32
+
+	fbID, crtcID               uint32
+	srcX, srcY, srcW, srcH     uint32
+	crtcX, crtcY, crtcW, crtcH uint32
+}
+
+// supports returns whether the plane can scan out the format.
+func (p *crtcPlane) supports(format uint32) bool {
+	for _, f := range p.formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// crtcPlanes returns the planes that can be used by a CRTC and are not in use by
+// another CRTC, ordered by zpos.
+func (c *Card) crtcPlanes(crtcID uint32) ([]*crtcPlane, error) {
+	res, err := c.ModeGetResources()
+	if err != nil {
+		return nil, err
+	}
+	index := -1
+	for i, id := range res.CRTCIDs {
+		if id == crtcID {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("no crtc %d", crtcID)
+	}
+
+	ids, err := c.ModeGetPlaneResources()
+	if err != nil {
+		return nil, err
+	}
+	var ret []*crtcPlane
+	for _, id := range *ids {
+		plane, err := c.ModeGetPlane(id)
+		if err != nil {
+			return nil, err
+		}
+		if plane.PossibleCRTCs&(1<<index) == 0 || (plane.CRTCID != 0 && plane.CRTCID != crtcID) {
+			continue
+		}
+		props, err := c.ObjectProperties(id, ModeObjectPlane)
+		if err != nil {
+			return nil, err
+		}
+
+		p := crtcPlane{id: id, formats: plane.FormatTypes}
+		if typ, ok := props["type"]; ok {
+			p.typ = typ.Value
+		}
+		if zpos, ok := props["zpos"]; ok {
+			p.zpos = zpos.Value
+		} else {
+			// Without zpos, primary planes are at the bottom and cursor planes at
+			// the top.
+			p.zpos = map[uint64]uint64{PlaneTypePrimary: 0, PlaneTypeOverlay: 1, PlaneTypeCursor: 2}[p.typ]
+		}
+		for _, prop := range []struct {
+			name string
+			id   *uint32
+		}{
+			{"FB_ID", &p.fbID}, {"CRTC_ID", &p.crtcID},
+			{"SRC_X", &p.srcX}, {"SRC_Y", &p.srcY}, {"SRC_W", &p.srcW}, {"SRC_H", &p.srcH},
+			{"CRTC_X", &p.crtcX}, {"CRTC_Y", &p.crtcY}, {"CRTC_W", &p.crtcW}, {"CRTC_H", &p.crtcH},
+		} {
+			if *prop.id, err = props.ID(prop.name); err != nil {
+				return nil, fmt.Errorf("plane %d: %w", id, err)
+			}
+		}
+		ret = append(ret, &p)
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		if ret[i].zpos != ret[j].zpos {
+			return ret[i].zpos < ret[j].zpos
+		}
+		return ret[i].id < ret[j].id
+	})
+	return ret, nil
+}
+
+// planeSolver searches for the assignment of layers to planes that scans out
+// the most layers directly.
+type planeSolver struct {
+	card        *Card
+	base        *AtomicRequest
+	flags       uint32
+	crtcID      uint32
+	layers      []Layer
+	order       []int
+	planes      []*crtcPlane
+	composition *Layer
+
+	// assigned holds the index in planes of the layer at each position of order,
+	// or -1 if the layer is composited.
+	assigned []int
+	best     int
+	result   *PlaneAssignment
+	err      error
+}
+
+// search assigns the layer at position pos of order, and those above it, to
+// planes above the plane at index last. Direct is the number of layers below pos
+// that are scanned out directly.
+func (s *planeSolver) search(pos, last, direct int) {
+	if s.err != nil || direct+len(s.order)-pos <= s.best {
+		return
+	}
+	if pos == len(s.order) {
+		s.record(direct)
+		return
+	}
+	layer := &s.layers[s.order[pos]]
+
+	for i := last + 1; i < len(s.planes); i++ {
+		plane := s.planes[i]
+		if !plane.supports(layer.Format) ||
+			(s.composition != nil && plane.typ == PlaneTypePrimary) {
+			continue
+		}
+		s.assigned[pos] = i
+		ok, err := s.test(pos + 1)
+		if err != nil {
+			s.err = err
+			return
+		}
+		if ok {
+			s.search(pos+1, i, direct+1)
+		}
+	}
+
+	if s.composition == nil {
+		return
+	}
+	// Compositing the layer puts it below the layers already scanned out
+	// directly, which must not overlap it.
+	for p := 0; p < pos; p++ {
+		if s.assigned[p] >= 0 && s.layers[s.order[p]].Dst.Overlaps(layer.Dst) {
+			return
+		}
+	}
+	s.assigned[pos] = -1
+	s.search(pos+1, last, direct)
+}
+
+// record saves the current assignment, which has been tested unless no layer is
+// scanned out directly.
+func (s *planeSolver) record(direct int) {
+	if direct == 0 {
+		if ok, err := s.test(len(s.order)); err != nil || !ok {
+			s.err = err
+			return
+		}
+	}
+	req, err := s.request(len(s.order))
+	if err != nil {
+		s.err = err
+		return
+	}
+
+	ret := PlaneAssignment{Planes: make([]uint32, len(s.layers)), Request: req}
+	for pos, i := range s.assigned {
+		if i < 0 {
+			ret.Composited = append(ret.Composited, s.order[pos])
+		} else {
+			ret.Planes[s.order[pos]] = s.planes[i].id
+		}
+	}
+	s.best, s.result = direct, &ret
+}
+
+// test returns whether the kernel accepts the assignment of the layers below pos.
+func (s *planeSolver) test(pos int) (bool, error) {
+	req, err := s.request(pos)
+	if err != nil {
+		return false, err
+	}
+	err = s.card.ModeAtomicCommit(req, ModeAtomicTestOnly|s.flags, 0)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, syscall.EINVAL), errors.Is(err, syscall.ERANGE), errors.Is(err, syscall.ENOSPC):
+		return false, nil
+	}
+	return false, err
+}
+
+// request builds the atomic request for the assignment of the layers below pos.
+// The planes of the CRTC that are not assigned are disabled.
+func (s *planeSolver) request(pos int) (*AtomicRequest, error) {
+	req := s.base.Clone()
+	used := make(map[int]bool)
+	for p := 0; p < pos; p++ {
+		if i := s.assigned[p]; i >= 0 {
+			setPlane(req, s.planes[i], s.crtcID, &s.layers[s.order[p]])
+			used[i] = true
+		}
+	}
+	if s.composition != nil {
+		primary := -1
+		for i, plane := range s.planes {
+			if plane.typ == PlaneTypePrimary {
+				primary = i
+				break
+			}
+		}
+		if primary < 0 {
+			return nil, fmt.Errorf("crtc %d has no primary plane for composition", s.crtcID)
+		}
+		setPlane(req, s.planes[primary], s.crtcID, s.composition)
+		used[primary] = true
+	}
+	for i, plane := range s.planes {
+		if !used[i] {
+			req.AddProperty(plane.id, plane.fbID, 0)
+			req.AddProperty(plane.id, plane.crtcID, 0)
+		}
+	}
+	return req, nil
+}
+
+// setPlane adds the configuration of a plane showing layer on a CRTC to req.
+func setPlane(req *AtomicRequest, plane *crtcPlane, crtcID uint32, layer *Layer) {
+	// Source coordinates are in 16.16 fixed point, and CRTC coordinates are
+	// signed.
+	req.AddProperty(plane.id, plane.fbID, uint64(layer.FBID))
+	req.AddProperty(plane.id, plane.crtcID, uint64(crtcID))
+	req.AddProperty(plane.id, plane.srcX, uint64(layer.Src.Min.X)<<16)
+	req.AddProperty(plane.id, plane.srcY, uint64(layer.Src.Min.Y)<<16)
+	req.AddProperty(plane.id, plane.srcW, uint64(layer.Src.Dx())<<16)
+	req.AddProperty(plane.id, plane.srcH, uint64(layer.Src.Dy())<<16)
+	req.AddProperty(plane.id, plane.crtcX, uint64(int64(layer.Dst.Min.X)))
+	req.AddProperty(plane.id, plane.crtcY, uint64(int64(layer.Dst.Min.Y)))
+	req.AddProperty(plane.id, plane.crtcW, uint64(layer.Dst.Dx()))
+	req.AddProperty(plane.id, plane.crtcH, uint64(layer.Dst.Dy()))
+}
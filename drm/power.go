@@ -0,0 +1,98 @@
+package drm
+
+import "fmt"
+
+// PowerState is the power state of a display. The values correspond to those of
+// the legacy "DPMS" connector property.
+type PowerState uint64
+
+const (
+	PowerOn PowerState = iota
+	PowerStandby
+	PowerSuspend
+	PowerOff
+)
+
+func (p PowerState) String() string {
+	switch p {
+	case PowerOn:
+		return "On"
+	case PowerStandby:
+		return "Standby"
+	case PowerSuspend:
+		return "Suspend"
+	case PowerOff:
+		return "Off"
+	}
+	return fmt.Sprintf("PowerState(%d)", uint64(p))
+}
+
+// ConnectorPower returns the power state of a connector, from its "DPMS"
+// property.
+func (c *Card) ConnectorPower(connectorID uint32) (PowerState, error) {
+	props, err := c.ObjectProperties(connectorID, ModeObjectConnector)
+	if err != nil {
+		return 0, err
+	}
+	prop, ok := props["DPMS"]
+	if !ok {
+		return 0, fmt.Errorf("connector %d has no property DPMS", connectorID)
+	}
+	return PowerState(prop.Value), nil
+}
+
+// SetConnectorPower sets the power state of a connector through the legacy
+// "DPMS" property. Drivers implementing atomic modesetting treat Standby and
+// Suspend like Off.
+func (c *Card) SetConnectorPower(connectorID uint32, state PowerState) error {
+	return c.setConnectorProperty(connectorID, "DPMS", uint64(state))
+}
+
+// CRTCPower returns the power state of a CRTC, from its atomic "ACTIVE"
+// property. The card must have ClientCapAtomic set.
+func (c *Card) CRTCPower(crtcID uint32) (PowerState, error) {
+	props, err := c.ObjectProperties(crtcID, ModeObjectCrtc)
+	if err != nil {
+		return 0, err
+	}
+	prop, ok := props["ACTIVE"]
+	if !ok {
+		return 0, fmt.Errorf("crtc %d has no property ACTIVE", crtcID)
+	}
+	if prop.Value != 0 {
+		return PowerOn, nil
+	}
+	return PowerOff, nil
+}
+
+// SetCRTCPower sets the power state of a CRTC and the connectors it drives
+// through the atomic "ACTIVE" property, keeping the rest of its configuration so
+// that it can be turned back on. Atomic has no notion of Standby or Suspend,
+// so any state other than On turns the CRTC off. The card must have
+// ClientCapAtomic set.
+func (c *Card) SetCRTCPower(crtcID uint32, state PowerState) error {
+	var req AtomicRequest
+	if err := c.AddCRTCPower(&req, crtcID, state); err != nil {
+		return err
+	}
+	return c.ModeAtomicCommit(&req, ModeAtomicAllowModeset, 0)
+}
+
+// AddCRTCPower adds a change of the power state of a CRTC to an atomic request,
+// as SetCRTCPower does.
+func (c *Card) AddCRTCPower(req *AtomicRequest, crtcID uint32, state PowerState) error {
+	props, err := c.ObjectProperties(crtcID, ModeObjectCrtc)
+	if err != nil {
+		return err
+	}
+	id, err := props.ID("ACTIVE")
+	if err != nil {
+		return fmt.Errorf("crtc %d: %w", crtcID, err)
+	}
+	var active uint64
+	if state == PowerOn {
+		active = 1
+	}
+	req.AddProperty(crtcID, id, active)
+	return nil
+}
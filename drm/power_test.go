@@ -0,0 +1,42 @@
+package drm
+
+import "testing"
+
+func TestConnectorPower(t *testing.T) {
+	s := newFakeSetup(t)
+	for _, state := range []PowerState{PowerOn, PowerOff, PowerStandby, PowerSuspend, PowerOn} {
+		if err := s.card.SetConnectorPower(s.connector, state); err != nil {
+			t.Fatalf("set %s: %s", state, err)
+		}
+		if got, err := s.card.ConnectorPower(s.connector); got != state || err != nil {
+			t.Errorf("power is %s, %v, want %s", got, err, state)
+		}
+	}
+	if err := s.card.SetConnectorPower(s.connector, PowerState(7)); err == nil {
+		t.Error("invalid power state set")
+	}
+	if got, err := s.card.ConnectorPower(s.connector); got != PowerOn || err != nil {
+		t.Errorf("power is %s, %v after an invalid state", got, err)
+	}
+	if _, err := s.card.ConnectorPower(s.crtc); err == nil {
+		t.Error("power of a CRTC read as a connector")
+	}
+}
+
+func TestCRTCPower(t *testing.T) {
+	s := newFakeSetup(t)
+	s.modeset(t, s.framebuffer(t, 1920, 1080))
+	if err := s.card.SetClientCap(ClientCapAtomic, 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct{ set, want PowerState }{
+		{PowerOff, PowerOff}, {PowerOn, PowerOn}, {PowerStandby, PowerOff},
+	} {
+		if err := s.card.SetCRTCPower(s.crtc, tc.set); err != nil {
+			t.Fatalf("set %s: %s", tc.set, err)
+		}
+		if got, err := s.card.CRTCPower(s.crtc); got != tc.want || err != nil {
+			t.Errorf("after setting %s, power is %s, %v, want %s", tc.set, got, err, tc.want)
+		}
+	}
+}
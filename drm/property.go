@@ -0,0 +1,62 @@
+package drm
+
+import "fmt"
+
+// Property is a property of a mode object, along with its current value on
+// that object.
+type Property struct {
+	ModeProperty
+	Value uint64
+}
+
+// EnumName returns the name of the property's current value, if the property
+// is an enum.
+func (p *Property) EnumName() (string, bool) {
+	for _, enum := range p.Enums {
+		if enum.Value == p.Value {
+			return enum.Name, true
+		}
+	}
+	return "", false
+}
+
+// EnumValue returns the value of the named enum entry of the property.
+func (p *ModeProperty) EnumValue(name string) (uint64, error) {
+	for _, enum := range p.Enums {
+		if enum.Name == name {
+			return enum.Value, nil
+		}
+	}
+	return 0, fmt.Errorf("property %s has no value %q", p.Name, name)
+}
+
+// Properties holds the properties of a mode object, keyed by name.
+type Properties map[string]*Property
+
+// ID returns the ID of the named property.
+func (p Properties) ID(name string) (uint32, error) {
+	prop, ok := p[name]
+	if !ok {
+		return 0, fmt.Errorf("no property %s", name)
+	}
+	return prop.PropID, nil
+}
+
+// ObjectProperties returns the properties of a mode object, along with their
+// current values. Kind is one of the ModeObject* constants.
+func (c *Card) ObjectProperties(id, kind uint32) (Properties, error) {
+	obj, err := c.ModeObjGetProperties(id, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(Properties, len(obj.PropIDs))
+	for i, propID := range obj.PropIDs {
+		prop, err := c.ModeGetProperty(propID)
+		if err != nil {
+			return nil, err
+		}
+		ret[prop.Name] = &Property{ModeProperty: *prop, Value: obj.PropValues[i]}
+	}
+	return ret, nil
+}
@@ -0,0 +1,178 @@
+package drm
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/inahga/inahgo/drm/edid"
+)
+
+// Selector selects connectors the way people refer to them, rather than by ID.
+// A selector is a comma-separated list of terms, all of which a connector must
+// match:
+//
+//	NAME             the kernel or xrandr name, e.g. "eDP-1", "HDMI-A-1" or "HDMI-1"
+//	ID               the connector ID, e.g. "42"
+//	connected        connection status; also "disconnected" and "unknown"
+//	make=MAKE        the EDID manufacturer ID, e.g. "DEL"
+//	model=MODEL      the EDID monitor name, e.g. "DELL U2720Q", or product code
+//	serial=SERIAL    the EDID serial string or number
+//	first            the first matching connector, if several match
+//
+// Names and EDID values are matched case-insensitively. "first-connected" is
+// short for "connected,first".
+type Selector struct {
+	text  string
+	terms []selectorTerm
+	first bool
+}
+
+type selectorTerm struct {
+	key, value string
+}
+
+// ParseSelector parses a connector selector.
+func ParseSelector(s string) (*Selector, error) {
+	sel := Selector{text: s}
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		key, value := "name", term
+		if i := strings.IndexByte(term, '='); i >= 0 {
+			key, value = strings.ToLower(term[:i]), strings.TrimSpace(term[i+1:])
+		}
+		switch {
+		case term == "":
+			return nil, fmt.Errorf("invalid selector %q: empty term", s)
+		case key == "name" && term == "first":
+			sel.first = true
+			continue
+		case key == "name" && term == "first-connected":
+			sel.first = true
+			key, value = "status", "connected"
+		case key == "name" && (term == "connected" || term == "disconnected" || term == "unknown"):
+			key = "status"
+		case key == "name":
+			if _, err := strconv.ParseUint(term, 10, 32); err == nil {
+				key = "id"
+			}
+		case key == "id":
+			if _, err := strconv.ParseUint(value, 10, 32); err != nil {
+				return nil, fmt.Errorf("invalid selector %q: invalid id %q", s, value)
+			}
+		case key == "status":
+			if _, err := parseEnumName(connectionNames, "connection", value); err != nil {
+				return nil, fmt.Errorf("invalid selector %q: %w", s, err)
+			}
+		case key != "make" && key != "model" && key != "serial":
+			return nil, fmt.Errorf("invalid selector %q: unknown key %q", s, key)
+		}
+		sel.terms = append(sel.terms, selectorTerm{key: key, value: value})
+	}
+	return &sel, nil
+}
+
+func (s *Selector) String() string {
+	return s.text
+}
+
+// needsEDID returns whether the selector matches on the EDID.
+func (s *Selector) needsEDID() bool {
+	for _, term := range s.terms {
+		switch term.key {
+		case "make", "model", "serial":
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns whether a connector matches the selector, ignoring "first".
+// The EDID of the connector may be nil, in which case terms on the EDID do not
+// match.
+func (s *Selector) Match(conn *ModeConnector, e *edid.EDID) bool {
+	for _, term := range s.terms {
+		var ok bool
+		switch term.key {
+		case "name":
+			ok = strings.EqualFold(term.value, conn.Name()) || strings.EqualFold(term.value, conn.XrandrName())
+		case "id":
+			ok = term.value == strconv.FormatUint(uint64(conn.ID), 10)
+		case "status":
+			ok = term.value == ConnectionName(conn.Connection)
+		case "make":
+			ok = e != nil && strings.EqualFold(term.value, e.Manufacturer)
+		case "model":
+			ok = e != nil && (strings.EqualFold(term.value, e.Name) ||
+				term.value == strconv.Itoa(int(e.ProductCode)) ||
+				strings.EqualFold(term.value, fmt.Sprintf("0x%04x", e.ProductCode)))
+		case "serial":
+			ok = e != nil && ((e.SerialString != "" && strings.EqualFold(term.value, e.SerialString)) ||
+				(e.SerialNumber != 0 && term.value == strconv.FormatUint(uint64(e.SerialNumber), 10)))
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// SelectConnectors returns the connectors matching a selector, in the order of
+// the card's resources. Connectors whose EDID is missing or invalid do not match
+// terms on the EDID.
+func (c *Card) SelectConnectors(sel *Selector) ([]*ModeConnector, error) {
+	res, err := c.ModeGetResources()
+	if err != nil {
+		return nil, err
+	}
+	var ret []*ModeConnector
+	for _, id := range res.ConnectorIDs {
+		conn, err := c.ModeGetConnector(id)
+		if err != nil {
+			return nil, err
+		}
+		var e *edid.EDID
+		if sel.needsEDID() {
+			data, err := c.ConnectorEDID(id)
+			if err != nil {
+				return nil, err
+			}
+			if data != nil {
+				// An unparsable EDID is treated as a missing one.
+				e, _ = edid.Parse(data)
+			}
+		}
+		if sel.Match(conn, e) {
+			ret = append(ret, conn)
+			if sel.first {
+				break
+			}
+		}
+	}
+	return ret, nil
+}
+
+// ErrNoConnector is returned by SelectConnector if no connector matches.
+var ErrNoConnector = errors.New("no matching connector")
+
+// SelectConnector returns the connector matching a selector. It is an error if
+// no connector matches, or if several do and the selector does not include
+// "first".
+func (c *Card) SelectConnector(sel *Selector) (*ModeConnector, error) {
+	conns, err := c.SelectConnectors(sel)
+	if err != nil {
+		return nil, err
+	}
+	switch len(conns) {
+	case 0:
+		return nil, fmt.Errorf("%w %q", ErrNoConnector, sel)
+	case 1:
+		return conns[0], nil
+	}
+	var names []string
+	for _, conn := range conns {
+		names = append(names, conn.Name())
+	}
+	return nil, fmt.Errorf("selector %q matches several connectors: %s", sel, strings.Join(names, ", "))
+}
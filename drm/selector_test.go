@@ -0,0 +1,94 @@
+package drm
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+// testEDID returns a minimal EDID with the given manufacturer ID, monitor name
+// and serial string.
+func testEDID(manufacturer, name, serial string) []byte {
+	data := make([]byte, 128)
+	copy(data, []byte{0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00})
+	mfg := uint16(manufacturer[0]-'A'+1)<<10 | uint16(manufacturer[1]-'A'+1)<<5 | uint16(manufacturer[2]-'A'+1)
+	binary.BigEndian.PutUint16(data[8:], mfg)
+	binary.LittleEndian.PutUint16(data[10:], 0x1234)
+	data[18], data[19] = 1, 4
+	for i, d := range []struct {
+		tag  byte
+		text string
+	}{{0xfc, name}, {0xff, serial}} {
+		desc := data[54+18*i : 72+18*i]
+		desc[3] = d.tag
+		copy(desc[5:], d.text+"\n")
+	}
+	for _, b := range data[:127] {
+		data[127] -= b
+	}
+	return data
+}
+
+func TestSelectConnector(t *testing.T) {
+	s := newFakeSetup(t)
+	s.dev.SetBlobProperty(s.connector, "EDID", testEDID("DEL", "DELL U2720Q", "ABC123"))
+	dp := s.dev.AddConnector(ModeConnectorDisplayPort, []uint32{s.encoder})
+	edp := s.dev.AddConnector(ModeConnectorEDP, []uint32{s.encoder})
+	s.dev.Plug(edp, []ModeInfo{FakeMode(2560, 1600, 60)}, 300, 190, nil)
+
+	for _, test := range []struct {
+		selector string
+		want     uint32
+		err      bool
+	}{
+		{selector: "HDMI-A-1", want: s.connector},
+		{selector: "hdmi-1", want: s.connector},
+		{selector: "DP-1", want: dp},
+		{selector: "eDP-1", want: edp},
+		{selector: "name=eDP-1", want: edp},
+		{selector: "disconnected", want: dp},
+		{selector: "first-connected", want: s.connector},
+		{selector: "connected,first", want: s.connector},
+		{selector: "connected", err: true},
+		{selector: "make=del", want: s.connector},
+		{selector: "make=DEL,model=DELL U2720Q,serial=ABC123", want: s.connector},
+		{selector: "model=0x1234", want: s.connector},
+		{selector: "make=DEL,serial=XYZ", err: true},
+		{selector: "HDMI-A-2", err: true},
+	} {
+		sel, err := ParseSelector(test.selector)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := s.card.SelectConnector(sel)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: selected connector %d", test.selector, conn.ID)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.selector, err)
+		} else if conn.ID != test.want {
+			t.Errorf("%s: selected connector %d, want %d", test.selector, conn.ID, test.want)
+		}
+	}
+
+	sel, _ := ParseSelector(strconv.FormatUint(uint64(s.connector), 10))
+	if conn, err := s.card.SelectConnector(sel); err != nil || conn.ID != s.connector {
+		t.Errorf("selecting by id: %v, %v", conn, err)
+	}
+	sel, _ = ParseSelector("VGA-1")
+	if _, err := s.card.SelectConnector(sel); !errors.Is(err, ErrNoConnector) {
+		t.Errorf("expected ErrNoConnector, got %v", err)
+	}
+}
+
+func TestParseSelectorErrors(t *testing.T) {
+	for _, s := range []string{"", "HDMI-A-1,", "id=x", "status=on", "colour=red"} {
+		if _, err := ParseSelector(s); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+}
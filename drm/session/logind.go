@@ -0,0 +1,295 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/inahga/inahgo/drm"
+	"github.com/inahga/inahgo/internal/dbus"
+)
+
+const (
+	logindName          = "org.freedesktop.login1"
+	logindPath          = "/org/freedesktop/login1"
+	managerInterface    = "org.freedesktop.login1.Manager"
+	sessionInterface    = "org.freedesktop.login1.Session"
+	propertiesInterface = "org.freedesktop.DBus.Properties"
+)
+
+// Logind is a session of systemd-logind, which opens the cards of the seat for
+// the client and switches their master itself: it drops master when the
+// session leaves the foreground, and sets it again when it returns.
+type Logind struct {
+	conn *dbus.Conn
+	path dbus.ObjectPath
+	// owner is the unique name of logind on the bus, the only sender whose
+	// signals are trusted.
+	owner string
+
+	mu      sync.Mutex
+	devices map[[2]uint32]*device
+	active  bool
+}
+
+// device is a device taken from logind.
+type device struct {
+	path   string
+	paused bool
+}
+
+var _ Session = (*Logind)(nil)
+
+// OpenLogind takes control of the logind session of the process, over the
+// system bus at address, or at its usual address if address is empty. The
+// session is that of XDG_SESSION_ID if set, and otherwise that of the
+// process. Only one client can control a session at a time.
+func OpenLogind(ctx context.Context, address string) (*Logind, error) {
+	var conn *dbus.Conn
+	var err error
+	if address == "" {
+		conn, err = dbus.SystemBus()
+	} else {
+		conn, err = dbus.Dial(address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connect to system bus: %w", err)
+	}
+	l, err := openLogind(ctx, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func openLogind(ctx context.Context, conn *dbus.Conn) (*Logind, error) {
+	var reply []interface{}
+	var err error
+	if id := os.Getenv("XDG_SESSION_ID"); id != "" {
+		reply, err = conn.Call(ctx, logindName, logindPath, managerInterface, "GetSession", "s", id)
+	} else {
+		reply, err = conn.Call(ctx, logindName, logindPath, managerInterface, "GetSessionByPID", "u",
+			uint32(os.Getpid()))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get session: %w", err)
+	}
+	path, ok := firstValue(reply).(dbus.ObjectPath)
+	if !ok {
+		return nil, errors.New("get session: unexpected reply")
+	}
+	// The match rule below filters on the well-known name, but the bus sets
+	// the unique name as the sender of signals.
+	reply, err = conn.Call(ctx, "org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus",
+		"GetNameOwner", "s", logindName)
+	if err != nil {
+		return nil, fmt.Errorf("get name owner: %w", err)
+	}
+	owner, ok := firstValue(reply).(string)
+	if !ok {
+		return nil, errors.New("get name owner: unexpected reply")
+	}
+	l := &Logind{conn: conn, path: path, owner: owner, devices: make(map[[2]uint32]*device)}
+
+	rule := fmt.Sprintf("type='signal',sender='%s',interface='%s',path='%s'", logindName, sessionInterface, path)
+	if err := conn.AddMatch(ctx, rule); err != nil {
+		return nil, fmt.Errorf("add match: %w", err)
+	}
+	reply, err = conn.Call(ctx, logindName, path, propertiesInterface, "Get", "ss", sessionInterface, "Active")
+	if err != nil {
+		return nil, fmt.Errorf("get active: %w", err)
+	}
+	if v, ok := firstValue(reply).(dbus.Variant); ok {
+		l.active, _ = v.Value.(bool)
+	}
+	if _, err := conn.Call(ctx, logindName, path, sessionInterface, "TakeControl", "b", false); err != nil {
+		return nil, fmt.Errorf("take control: %w", err)
+	}
+	return l, nil
+}
+
+func firstValue(values []interface{}) interface{} {
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
+// closeFDs closes the descriptors among the values of a message that is not
+// handled.
+func closeFDs(values []interface{}) {
+	for _, v := range values {
+		if fd, ok := v.(dbus.UnixFD); ok {
+			syscall.Close(int(fd))
+		}
+	}
+}
+
+// Open has logind open a card. Its file is shared with logind, which drops and
+// sets its master on switches.
+func (l *Logind) Open(path string) (*drm.Card, error) {
+	major, minor, err := drm.DeviceNumber(path)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := l.conn.Call(context.Background(), logindName, l.path, sessionInterface, "TakeDevice",
+		"uu", major, minor)
+	if err != nil {
+		return nil, fmt.Errorf("take device %s: %w", path, err)
+	}
+	if len(reply) != 2 {
+		closeFDs(reply)
+		return nil, fmt.Errorf("take device %s: unexpected reply", path)
+	}
+	fd, ok := reply[0].(dbus.UnixFD)
+	inactive, ok2 := reply[1].(bool)
+	if !ok || !ok2 {
+		closeFDs(reply)
+		return nil, fmt.Errorf("take device %s: unexpected reply", path)
+	}
+	syscall.CloseOnExec(int(fd))
+
+	l.mu.Lock()
+	l.devices[[2]uint32{major, minor}] = &device{path: path, paused: inactive}
+	l.mu.Unlock()
+	return drm.New(os.NewFile(uintptr(fd), path)), nil
+}
+
+// Active returns whether the session is in the foreground, with none of its
+// cards paused.
+func (l *Logind) Active() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.active && !l.paused()
+}
+
+// paused returns whether any device is paused.
+func (l *Logind) paused() bool {
+	for _, d := range l.devices {
+		if d.paused {
+			return true
+		}
+	}
+	return false
+}
+
+// Run handles the pausing and resuming of the cards by logind, until the
+// context is canceled or the connection to the bus ends. The first card paused
+// calls h.Pause, and the last card resumed calls h.Resume. Errors acknowledging
+// a pause are passed to onError, if it is not nil; logind pauses the card
+// regardless once it stops waiting.
+//
+// Logind waits a short while for the client to acknowledge a pause before
+// dropping master regardless, so h.Pause must return promptly.
+func (l *Logind) Run(ctx context.Context, h Handler, onError func(error)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case m, ok := <-l.conn.Incoming():
+			if !ok {
+				return errors.New("connection to system bus closed")
+			}
+			if m.Type != dbus.TypeSignal || m.Sender != l.owner || m.Interface != sessionInterface ||
+				m.Path != l.path {
+				closeFDs(m.Body)
+				continue
+			}
+			switch m.Member {
+			case "PauseDevice":
+				if err := l.pauseDevice(ctx, m, h); err != nil && onError != nil {
+					onError(err)
+				}
+			case "ResumeDevice":
+				l.resumeDevice(m, h)
+			default:
+				closeFDs(m.Body)
+			}
+		}
+	}
+}
+
+// pauseDevice handles the PauseDevice signal, whose type is "pause" when
+// logind waits for PauseDeviceComplete, "force" when the device was paused
+// already, and "gone" when it was removed.
+func (l *Logind) pauseDevice(ctx context.Context, m *dbus.Message, h Handler) error {
+	if m.Signature != "uus" {
+		return nil
+	}
+	major, minor, kind := m.Body[0].(uint32), m.Body[1].(uint32), m.Body[2].(string)
+	l.mu.Lock()
+	d, ok := l.devices[[2]uint32{major, minor}]
+	if !ok {
+		l.mu.Unlock()
+		return nil
+	}
+	wasPaused := l.paused()
+	d.paused = true
+	if kind == "gone" {
+		delete(l.devices, [2]uint32{major, minor})
+	}
+	l.mu.Unlock()
+
+	if !wasPaused {
+		h.Pause()
+	}
+	if kind == "pause" {
+		if _, err := l.conn.Call(ctx, logindName, l.path, sessionInterface, "PauseDeviceComplete",
+			"uu", major, minor); err != nil {
+			return fmt.Errorf("pause device %s: %w", d.path, err)
+		}
+	}
+	return nil
+}
+
+// resumeDevice handles the ResumeDevice signal. Logind passes a file of the
+// device along, which for cards is the file already open, with master set
+// again, so it is closed.
+func (l *Logind) resumeDevice(m *dbus.Message, h Handler) {
+	if m.Signature != "uuh" {
+		closeFDs(m.Body)
+		return
+	}
+	major, minor, fd := m.Body[0].(uint32), m.Body[1].(uint32), m.Body[2].(dbus.UnixFD)
+	syscall.Close(int(fd))
+	l.mu.Lock()
+	d, ok := l.devices[[2]uint32{major, minor}]
+	if !ok || !d.paused {
+		l.mu.Unlock()
+		return
+	}
+	d.paused = false
+	l.active = true
+	resumed := !l.paused()
+	l.mu.Unlock()
+	if resumed {
+		h.Resume()
+	}
+}
+
+// Close releases the devices and the control of the session, and disconnects
+// from the bus.
+func (l *Logind) Close() error {
+	ctx := context.Background()
+	var first error
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for dev, d := range l.devices {
+		if _, err := l.conn.Call(ctx, logindName, l.path, sessionInterface, "ReleaseDevice",
+			"uu", dev[0], dev[1]); err != nil && first == nil {
+			first = fmt.Errorf("release device %s: %w", d.path, err)
+		}
+	}
+	l.devices = nil
+	if _, err := l.conn.Call(ctx, logindName, l.path, sessionInterface, "ReleaseControl", ""); err != nil && first == nil {
+		first = fmt.Errorf("release control: %w", err)
+	}
+	if err := l.conn.Close(); err != nil && first == nil {
+		first = err
+	}
+	return first
+}
@@ -0,0 +1,43 @@
+// Package session hands DRM master over between a client and the rest of the
+// system when the user switches virtual terminals, e.g. with Ctrl+Alt+Fn: the
+// client is paused and drops master when its VT is left, and takes master
+// again and is resumed when the VT is back.
+//
+// OpenVT manages the VT directly, which requires access to the tty and to the
+// card as master, usually root. OpenLogind has systemd-logind open the cards
+// and switch master instead, which any user of an active local session may do.
+package session
+
+import (
+	"context"
+
+	"github.com/inahga/inahgo/drm"
+)
+
+// Handler is notified when a session is paused and resumed. Its methods are
+// called by Run.
+type Handler interface {
+	// Pause is called when the session is about to lose DRM master. The cards
+	// must not be used for mode setting until Resume.
+	Pause()
+	// Resume is called once the session is master again. Another client may
+	// have changed the CRTCs meanwhile, so the display should be set up and
+	// drawn again.
+	Resume()
+}
+
+// Session is a session of a client using the cards of a seat.
+type Session interface {
+	// Open opens a card for the session. Callers close it, before closing the
+	// session.
+	Open(path string) (*drm.Card, error)
+	// Run handles the switches of the session, calling h, until the context
+	// is canceled or an error prevents switching. Errors that do not, e.g. a
+	// card failing to drop master, are passed to onError if it is not nil.
+	Run(ctx context.Context, h Handler, onError func(error)) error
+	// Active returns whether the session is in the foreground, with its cards
+	// master.
+	Active() bool
+	// Close returns the VT or the devices to the system.
+	Close() error
+}
@@ -0,0 +1,300 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/inahga/inahgo/drm"
+	"github.com/inahga/inahgo/internal/dbus"
+)
+
+// handler records the calls of a session.
+type handler struct {
+	calls chan string
+}
+
+func newHandler() *handler {
+	return &handler{calls: make(chan string, 4)}
+}
+
+func (h *handler) Pause()  { h.calls <- "pause" }
+func (h *handler) Resume() { h.calls <- "resume" }
+
+func (h *handler) expect(t *testing.T, want string) {
+	t.Helper()
+	select {
+	case got := <-h.calls:
+		if got != want {
+			t.Fatalf("handler called %s, want %s", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("handler not called %s", want)
+	}
+}
+
+const (
+	stubSession = dbus.ObjectPath("/org/freedesktop/login1/session/_31")
+	// stubOwner is the unique name of the stub on its bus.
+	stubOwner = ":1.0"
+)
+
+// stubLogind serves a private bus that answers as logind, and passes the
+// method calls of the session to calls.
+type stubLogind struct {
+	conn  chan *dbus.Conn
+	calls chan *dbus.Message
+}
+
+func newStubLogind(t *testing.T) (*stubLogind, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "bus")
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &stubLogind{conn: make(chan *dbus.Conn, 1), calls: make(chan *dbus.Message, 16)}
+	go func() {
+		uc, err := ln.AcceptUnix()
+		if err != nil {
+			return
+		}
+		c, err := dbus.Accept(uc)
+		if err != nil {
+			uc.Close()
+			return
+		}
+		s.conn <- c
+		for m := range c.Incoming() {
+			s.answer(c, m)
+		}
+	}()
+	return s, "unix:path=" + path
+}
+
+func (s *stubLogind) answer(c *dbus.Conn, m *dbus.Message) {
+	switch m.Member {
+	case "Hello":
+		c.Reply(m, "s", ":1.1")
+	case "GetNameOwner":
+		c.Reply(m, "s", stubOwner)
+	case "AddMatch":
+		c.Reply(m, "")
+	case "GetSessionByPID":
+		c.Reply(m, "o", stubSession)
+	case "Get":
+		c.Reply(m, "v", dbus.Variant{Signature: "b", Value: true})
+	case "TakeDevice":
+		f, err := os.Open(os.DevNull)
+		if err != nil {
+			c.ReplyError(m, "org.freedesktop.DBus.Error.Failed", err.Error())
+			return
+		}
+		c.Reply(m, "hb", dbus.UnixFD(f.Fd()), false)
+		f.Close()
+		s.calls <- m
+	case "TakeControl", "PauseDeviceComplete", "ReleaseDevice", "ReleaseControl":
+		c.Reply(m, "")
+		s.calls <- m
+	default:
+		c.ReplyError(m, "org.freedesktop.DBus.Error.UnknownMethod", m.Member)
+	}
+}
+
+// emit sends a signal of a session from sender, as the bus would relay it.
+func emit(t *testing.T, bus *dbus.Conn, sender string, path dbus.ObjectPath, member string, sig dbus.Signature, args ...interface{}) {
+	t.Helper()
+	if err := bus.Send(&dbus.Message{Type: dbus.TypeSignal, Sender: sender, Path: path,
+		Interface: sessionInterface, Member: member, Signature: sig, Body: args}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (s *stubLogind) expect(t *testing.T, member string) *dbus.Message {
+	t.Helper()
+	select {
+	case m := <-s.calls:
+		if m.Member != member || m.Path != stubSession {
+			t.Fatalf("logind received %s on %s, want %s", m.Member, m.Path, member)
+		}
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatalf("logind did not receive %s", member)
+	}
+	return nil
+}
+
+func TestLogind(t *testing.T) {
+	t.Setenv("XDG_SESSION_ID", "")
+	stub, addr := newStubLogind(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l, err := OpenLogind(ctx, addr)
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	bus := <-stub.conn
+	if m := stub.expect(t, "TakeControl"); m.Body[0] != false {
+		t.Errorf("take control forces: %v", m.Body)
+	}
+	card, err := l.Open(os.DevNull)
+	if err != nil {
+		t.Fatalf("open card: %s", err)
+	}
+	major, minor, err := drm.DeviceNumber(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := stub.expect(t, "TakeDevice"); m.Body[0] != major || m.Body[1] != minor {
+		t.Errorf("took device %v, want %d:%d", m.Body, major, minor)
+	}
+	if !l.Active() {
+		t.Error("session inactive")
+	}
+
+	h := newHandler()
+	done := make(chan error, 1)
+	go func() { done <- l.Run(ctx, h, func(err error) { t.Errorf("run: %s", err) }) }()
+
+	// Signals of other senders are ignored, or the pause would be acknowledged
+	// twice.
+	emit(t, bus, ":1.7", stubSession, "PauseDevice", "uus", major, minor, "pause")
+	emit(t, bus, stubOwner, stubSession, "PauseDevice", "uus", major, minor, "pause")
+	h.expect(t, "pause")
+	if m := stub.expect(t, "PauseDeviceComplete"); m.Body[0] != major || m.Body[1] != minor {
+		t.Errorf("completed pause of %v", m.Body)
+	}
+	if l.Active() {
+		t.Error("session active while paused")
+	}
+
+	// Signals of other sessions are ignored.
+	emit(t, bus, stubOwner, "/org/freedesktop/login1/session/_32", "PauseDevice", "uus", major, minor, "pause")
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emit(t, bus, stubOwner, stubSession, "ResumeDevice", "uuh", major, minor, dbus.UnixFD(f.Fd()))
+	f.Close()
+	h.expect(t, "resume")
+	if !l.Active() {
+		t.Error("session inactive once resumed")
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("run returned %v", err)
+	}
+	card.Close()
+	if err := l.Close(); err != nil {
+		t.Fatalf("close: %s", err)
+	}
+	stub.expect(t, "ReleaseDevice")
+	stub.expect(t, "ReleaseControl")
+	select {
+	case call := <-h.calls:
+		t.Errorf("handler called %s", call)
+	default:
+	}
+}
+
+// fakeTerminal records the VT ioctls.
+type fakeTerminal struct {
+	kd       int
+	mode     vtMode
+	relDisps chan int
+	closed   bool
+}
+
+func (f *fakeTerminal) kdMode() (int, error)     { return f.kd, nil }
+func (f *fakeTerminal) setKDMode(mode int) error { f.kd = mode; return nil }
+func (f *fakeTerminal) vtMode() (vtMode, error)  { return f.mode, nil }
+func (f *fakeTerminal) setVTMode(m vtMode) error { f.mode = m; return nil }
+func (f *fakeTerminal) relDisp(arg int) error    { f.relDisps <- arg; return nil }
+func (f *fakeTerminal) Close() error             { f.closed = true; return nil }
+
+func (f *fakeTerminal) expect(t *testing.T, want int) {
+	t.Helper()
+	select {
+	case got := <-f.relDisps:
+		if got != want {
+			t.Fatalf("VT_RELDISP %d, want %d", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no VT_RELDISP %d", want)
+	}
+}
+
+func TestVT(t *testing.T) {
+	tty := &fakeTerminal{kd: kdText, relDisps: make(chan int, 2)}
+	v, err := newVT(tty)
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	if tty.kd != kdGraphic {
+		t.Errorf("kd mode is %d", tty.kd)
+	}
+	if tty.mode.mode != vtProcess || tty.mode.relsig != int16(releaseSignal) || tty.mode.acqsig != int16(acquireSignal) {
+		t.Errorf("vt mode is %+v", tty.mode)
+	}
+	card := drm.NewWithBackend(drm.NewFakeDevice())
+	v.Add(card)
+	// A card that lost master already fails to drop it, which does not keep
+	// the VT from being released.
+	lost := drm.NewWithBackend(drm.NewFakeDevice())
+	if err := lost.DropMaster(); err != nil {
+		t.Fatal(err)
+	}
+	v.Add(lost)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := newHandler()
+	errs := make(chan error, 2)
+	done := make(chan error, 1)
+	go func() { done <- v.Run(ctx, h, func(err error) { errs <- err }) }()
+
+	syscall.Kill(os.Getpid(), releaseSignal)
+	h.expect(t, "pause")
+	tty.expect(t, 1)
+	if card.IsMaster() || v.Active() {
+		t.Error("card master after the VT was released")
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, syscall.EINVAL) {
+			t.Errorf("release reported %v", err)
+		}
+	default:
+		t.Error("failure to drop master not reported")
+	}
+	syscall.Kill(os.Getpid(), acquireSignal)
+	tty.expect(t, vtAckAcq)
+	h.expect(t, "resume")
+	if !card.IsMaster() || !lost.IsMaster() || !v.Active() {
+		t.Error("cards not master after the VT was acquired")
+	}
+	select {
+	case err := <-errs:
+		t.Errorf("acquire reported %v", err)
+	default:
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("run returned %v", err)
+	}
+	if err := v.Close(); err != nil {
+		t.Fatalf("close: %s", err)
+	}
+	if tty.kd != kdText || tty.mode.mode != vtAuto || !tty.closed {
+		t.Errorf("terminal not restored: %+v", tty)
+	}
+}
@@ -0,0 +1,298 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/inahga/inahgo/drm"
+)
+
+// Requests and modes of the VT ioctls, from linux/kd.h and linux/vt.h.
+const (
+	kdSetMode = 0x4B3A
+	kdGetMode = 0x4B3B
+	kdText    = 0x00
+	kdGraphic = 0x01
+
+	vtGetMode = 0x5601
+	vtSetMode = 0x5602
+	vtRelDisp = 0x5605
+	vtAuto    = 0x00
+	vtProcess = 0x01
+	vtAckAcq  = 0x02
+)
+
+// Signals the kernel sends to release and acquire the VT.
+const (
+	releaseSignal = syscall.SIGUSR1
+	acquireSignal = syscall.SIGUSR2
+)
+
+// vtMode is struct vt_mode.
+type vtMode struct {
+	mode   int8
+	waitv  int8
+	relsig int16
+	acqsig int16
+	frsig  int16
+}
+
+// terminal is the VT ioctls of a tty, which tests fake.
+type terminal interface {
+	kdMode() (int, error)
+	setKDMode(mode int) error
+	vtMode() (vtMode, error)
+	setVTMode(m vtMode) error
+	relDisp(arg int) error
+	Close() error
+}
+
+type ttyFile struct {
+	f *os.File
+}
+
+func (t ttyFile) ioctl(request, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, t.f.Fd(), request, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (t ttyFile) kdMode() (int, error) {
+	var mode int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, t.f.Fd(), kdGetMode,
+		uintptr(unsafe.Pointer(&mode))); errno != 0 {
+		return 0, errno
+	}
+	return int(mode), nil
+}
+
+func (t ttyFile) setKDMode(mode int) error {
+	return t.ioctl(kdSetMode, uintptr(mode))
+}
+
+func (t ttyFile) vtMode() (vtMode, error) {
+	var m vtMode
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, t.f.Fd(), vtGetMode,
+		uintptr(unsafe.Pointer(&m))); errno != 0 {
+		return m, errno
+	}
+	return m, nil
+}
+
+func (t ttyFile) setVTMode(m vtMode) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, t.f.Fd(), vtSetMode,
+		uintptr(unsafe.Pointer(&m))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (t ttyFile) relDisp(arg int) error {
+	return t.ioctl(vtRelDisp, uintptr(arg))
+}
+
+func (t ttyFile) Close() error {
+	return t.f.Close()
+}
+
+// VT is a session on the virtual terminal the client runs on. While it is
+// open, the VT is in graphics mode, so the kernel does not draw text over the
+// client's display, and VT switches wait for the client to drop master.
+//
+// The keyboard is left alone, so the kernel still switches VTs on Ctrl+Alt+Fn,
+// and reads the keys typed as input of the tty, which clients should read or
+// discard.
+type VT struct {
+	tty terminal
+	// kd and mode are the modes of the VT before it was opened.
+	kd   int
+	mode vtMode
+	sigs chan os.Signal
+
+	mu     sync.Mutex
+	cards  []*drm.Card
+	active bool
+}
+
+var _ Session = (*VT)(nil)
+
+// OpenVT opens a session on a VT, e.g. "/dev/tty1", or on the controlling
+// terminal of the process if path is empty. The process must not use SIGUSR1
+// and SIGUSR2 for anything else, as the kernel sends them to switch VTs.
+func OpenVT(path string) (*VT, error) {
+	if path == "" {
+		path = "/dev/tty"
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	v, err := newVT(ttyFile{f})
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return v, nil
+}
+
+func newVT(tty terminal) (*VT, error) {
+	kd, err := tty.kdMode()
+	if err != nil {
+		return nil, fmt.Errorf("not a virtual terminal: %w", err)
+	}
+	mode, err := tty.vtMode()
+	if err != nil {
+		return nil, fmt.Errorf("get vt mode: %w", err)
+	}
+	v := &VT{tty: tty, kd: kd, mode: mode, sigs: make(chan os.Signal, 4), active: true}
+
+	// The signals are caught before the kernel may send them, as they would
+	// otherwise terminate the process.
+	signal.Notify(v.sigs, releaseSignal, acquireSignal)
+	if err := tty.setKDMode(kdGraphic); err != nil {
+		signal.Stop(v.sigs)
+		return nil, fmt.Errorf("set graphics mode: %w", err)
+	}
+	err = tty.setVTMode(vtMode{mode: vtProcess, relsig: int16(releaseSignal), acqsig: int16(acquireSignal)})
+	if err != nil {
+		tty.setKDMode(kd)
+		signal.Stop(v.sigs)
+		return nil, fmt.Errorf("set vt mode: %w", err)
+	}
+	return v, nil
+}
+
+// Open opens a card, which Run drops and sets master of on VT switches.
+func (v *VT) Open(path string) (*drm.Card, error) {
+	card, err := drm.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	v.Add(card)
+	return card, nil
+}
+
+// Add adds a card opened otherwise, which Run drops and sets master of on VT
+// switches.
+func (v *VT) Add(card *drm.Card) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.cards = append(v.cards, card)
+}
+
+// Active returns whether the VT is in the foreground.
+func (v *VT) Active() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.active
+}
+
+// Run handles the VT switches until the context is canceled. Leaving the VT
+// calls h.Pause, then drops master of the cards, and lets the switch happen;
+// coming back sets master of the cards, then calls h.Resume. Errors dropping or
+// setting master of a card are passed to onError, if it is not nil, and do not
+// stop the switch. Run only returns early if the VT cannot be switched.
+//
+// A VT switch waits for the client to release the VT, so the client must keep
+// Run running while the session is open, and h.Pause must return promptly.
+func (v *VT) Run(ctx context.Context, h Handler, onError func(error)) error {
+	if onError == nil {
+		onError = func(error) {}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case sig := <-v.sigs:
+			var err error
+			switch sig {
+			case releaseSignal:
+				err = v.release(h, onError)
+			case acquireSignal:
+				err = v.acquire(h, onError)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// release pauses the client and lets the VT be switched away.
+func (v *VT) release(h Handler, onError func(error)) error {
+	v.mu.Lock()
+	active, cards := v.active, v.cards
+	v.active = false
+	v.mu.Unlock()
+
+	if active {
+		h.Pause()
+		for _, card := range cards {
+			if err := card.DropMaster(); err != nil {
+				onError(fmt.Errorf("drop master: %w", err))
+			}
+		}
+	}
+	// The switch is let happen even if a card could not drop master, as
+	// refusing it would leave the user stuck on this VT.
+	if err := v.tty.relDisp(1); err != nil {
+		return fmt.Errorf("release vt: %w", err)
+	}
+	return nil
+}
+
+// acquire acknowledges the switch back to the VT, and resumes the client. The
+// client is resumed even if a card could not set master, so that it can use
+// the others.
+func (v *VT) acquire(h Handler, onError func(error)) error {
+	if err := v.tty.relDisp(vtAckAcq); err != nil {
+		return fmt.Errorf("acquire vt: %w", err)
+	}
+	v.mu.Lock()
+	active, cards := v.active, v.cards
+	v.active = true
+	v.mu.Unlock()
+	if active {
+		return nil
+	}
+	for _, card := range cards {
+		if err := card.SetMaster(); err != nil {
+			onError(fmt.Errorf("set master: %w", err))
+		}
+	}
+	h.Resume()
+	return nil
+}
+
+// Close returns the VT to text mode and to switching without the client.
+func (v *VT) Close() error {
+	signal.Stop(v.sigs)
+	var first error
+	mode := v.mode
+	if mode.mode == vtProcess {
+		// The VT was left switching through a client, e.g. one that crashed,
+		// which the kernel would otherwise keep waiting for.
+		mode = vtMode{mode: vtAuto}
+	}
+	if err := v.tty.setVTMode(mode); err != nil {
+		first = fmt.Errorf("set vt mode: %w", err)
+	}
+	kd := v.kd
+	if kd == kdGraphic {
+		// Likewise, text mode shows the console again.
+		kd = kdText
+	}
+	if err := v.tty.setKDMode(kd); err != nil && first == nil {
+		first = fmt.Errorf("set text mode: %w", err)
+	}
+	if err := v.tty.Close(); err != nil && first == nil {
+		first = err
+	}
+	return first
+}
@@ -0,0 +1,532 @@
+package drm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// SnapshotVersion is the version of the snapshot schema written by Snapshot.
+// It is incremented whenever the schema changes incompatibly. Version 2 gives
+// types, states, mode flags and formats by name rather than by number.
+const SnapshotVersion = 2
+
+// Snapshot is the state of a card's mode objects, in a stable schema that can
+// be saved as JSON and replayed with NewReplay.
+type Snapshot struct {
+	Version      int                   `json:"version"`
+	Driver       SnapshotDriver        `json:"driver"`
+	MinWidth     uint32                `json:"min_width"`
+	MaxWidth     uint32                `json:"max_width"`
+	MinHeight    uint32                `json:"min_height"`
+	MaxHeight    uint32                `json:"max_height"`
+	CRTCs        []SnapshotCRTC        `json:"crtcs"`
+	Encoders     []SnapshotEncoder     `json:"encoders"`
+	Connectors   []SnapshotConnector   `json:"connectors"`
+	Planes       []SnapshotPlane       `json:"planes"`
+	Framebuffers []SnapshotFramebuffer `json:"framebuffers"`
+	Properties   []SnapshotProperty    `json:"properties"`
+	Blobs        []SnapshotBlob        `json:"blobs"`
+	// Errors lists the objects that could not be captured.
+	Errors []SnapshotError `json:"errors,omitempty"`
+}
+
+// SnapshotError is an object, e.g. "plane 31", that could not be captured.
+type SnapshotError struct {
+	Object string `json:"object"`
+	Error  string `json:"error"`
+}
+
+type SnapshotDriver struct {
+	Name       string `json:"name"`
+	Date       string `json:"date"`
+	Desc       string `json:"desc"`
+	Major      int32  `json:"major"`
+	Minor      int32  `json:"minor"`
+	PatchLevel int32  `json:"patch_level"`
+}
+
+// SnapshotMode is a mode. Its flags and type are named as by ModeFlagNames and
+// ModeTypeNames.
+type SnapshotMode struct {
+	Name       string   `json:"name"`
+	Clock      uint32   `json:"clock"`
+	HDisplay   uint16   `json:"hdisplay"`
+	HSyncStart uint16   `json:"hsync_start"`
+	HSyncEnd   uint16   `json:"hsync_end"`
+	HTotal     uint16   `json:"htotal"`
+	HSkew      uint16   `json:"hskew"`
+	VDisplay   uint16   `json:"vdisplay"`
+	VSyncStart uint16   `json:"vsync_start"`
+	VSyncEnd   uint16   `json:"vsync_end"`
+	VTotal     uint16   `json:"vtotal"`
+	VScan      uint16   `json:"vscan"`
+	VRefresh   uint32   `json:"vrefresh"`
+	Flags      []string `json:"flags"`
+	Type       []string `json:"type"`
+}
+
+// SnapshotPropertyValue is the value of a property of an object. The property
+// is described by the SnapshotProperty of the same ID, whose name is repeated
+// for readability.
+type SnapshotPropertyValue struct {
+	ID    uint32 `json:"id"`
+	Name  string `json:"name,omitempty"`
+	Value uint64 `json:"value"`
+}
+
+type SnapshotCRTC struct {
+	ID        uint32 `json:"id"`
+	FBID      uint32 `json:"fb_id"`
+	X         uint32 `json:"x"`
+	Y         uint32 `json:"y"`
+	GammaSize uint32 `json:"gamma_size"`
+	// Mode is nil if the CRTC is disabled.
+	Mode       *SnapshotMode           `json:"mode"`
+	Properties []SnapshotPropertyValue `json:"properties"`
+}
+
+// SnapshotEncoder is an encoder. Its type is named as by EncoderTypeName.
+type SnapshotEncoder struct {
+	ID             uint32 `json:"id"`
+	Type           string `json:"type"`
+	CRTCID         uint32 `json:"crtc_id"`
+	PossibleCRTCs  uint32 `json:"possible_crtcs"`
+	PossibleClones uint32 `json:"possible_clones"`
+}
+
+// SnapshotConnector is a connector. Its type, connection and subpixel order are
+// named as by ConnectorTypeName, ConnectionName and SubpixelName.
+type SnapshotConnector struct {
+	ID uint32 `json:"id"`
+	// Name is the kernel's name for the connector, e.g. "HDMI-A-1". It is
+	// informational, and ignored by NewReplay.
+	Name       string                  `json:"name,omitempty"`
+	Type       string                  `json:"type"`
+	TypeID     uint32                  `json:"type_id"`
+	Connection string                  `json:"connection"`
+	MMWidth    uint32                  `json:"mm_width"`
+	MMHeight   uint32                  `json:"mm_height"`
+	Subpixel   string                  `json:"subpixel"`
+	EncoderID  uint32                  `json:"encoder_id"`
+	Encoders   []uint32                `json:"encoders"`
+	Modes      []SnapshotMode          `json:"modes"`
+	Properties []SnapshotPropertyValue `json:"properties"`
+}
+
+// SnapshotPlane is a plane. Its formats are named as by FormatString.
+type SnapshotPlane struct {
+	ID            uint32                  `json:"id"`
+	CRTCID        uint32                  `json:"crtc_id"`
+	FBID          uint32                  `json:"fb_id"`
+	PossibleCRTCs uint32                  `json:"possible_crtcs"`
+	GammaSize     uint32                  `json:"gamma_size"`
+	Formats       []string                `json:"formats"`
+	Properties    []SnapshotPropertyValue `json:"properties"`
+	// InFormats maps the name of each format to the names of its supported
+	// modifiers, as decoded from the IN_FORMATS blob. It is informational, and
+	// ignored by NewReplay.
+	InFormats map[string][]string `json:"in_formats,omitempty"`
+}
+
+type SnapshotFramebuffer struct {
+	ID     uint32 `json:"id"`
+	Width  uint32 `json:"width"`
+	Height uint32 `json:"height"`
+	Pitch  uint32 `json:"pitch"`
+	Bpp    uint32 `json:"bpp"`
+	Depth  uint32 `json:"depth"`
+}
+
+type SnapshotProperty struct {
+	ID     uint32         `json:"id"`
+	Name   string         `json:"name"`
+	Flags  uint32         `json:"flags"`
+	Values []uint64       `json:"values"`
+	Enums  []SnapshotEnum `json:"enums"`
+}
+
+type SnapshotEnum struct {
+	Value uint64 `json:"value"`
+	Name  string `json:"name"`
+}
+
+type SnapshotBlob struct {
+	ID   uint32 `json:"id"`
+	Data []byte `json:"data"`
+}
+
+func snapshotMode(m cModeInfo) SnapshotMode {
+	return SnapshotMode{
+		Name:       cToGoString(m.name[:]),
+		Clock:      m.Clock,
+		HDisplay:   m.HDisplay,
+		HSyncStart: m.HSyncStart,
+		HSyncEnd:   m.HSyncEnd,
+		HTotal:     m.HTotal,
+		HSkew:      m.HSkew,
+		VDisplay:   m.VDisplay,
+		VSyncStart: m.VSyncStart,
+		VSyncEnd:   m.VSyncEnd,
+		VTotal:     m.VTotal,
+		VScan:      m.VScan,
+		VRefresh:   m.VRefresh,
+		Flags:      ModeFlagNames(m.Flags),
+		Type:       ModeTypeNames(m.Type),
+	}
+}
+
+func (m *SnapshotMode) modeInfo() (cModeInfo, error) {
+	ret := cModeInfo{
+		Clock:      m.Clock,
+		HDisplay:   m.HDisplay,
+		HSyncStart: m.HSyncStart,
+		HSyncEnd:   m.HSyncEnd,
+		HTotal:     m.HTotal,
+		HSkew:      m.HSkew,
+		VDisplay:   m.VDisplay,
+		VSyncStart: m.VSyncStart,
+		VSyncEnd:   m.VSyncEnd,
+		VTotal:     m.VTotal,
+		VScan:      m.VScan,
+		VRefresh:   m.VRefresh,
+	}
+	copy(ret.name[:displayModeLen-1], m.Name)
+	var err error
+	if ret.Flags, err = ParseModeFlags(m.Flags); err != nil {
+		return ret, fmt.Errorf("mode %s: %w", m.Name, err)
+	}
+	if ret.Type, err = ParseModeType(m.Type); err != nil {
+		return ret, fmt.Errorf("mode %s: %w", m.Name, err)
+	}
+	return ret, nil
+}
+
+// snapshotter collects the properties and blobs referenced by the objects of a
+// snapshot.
+type snapshotter struct {
+	card  *Card
+	snap  *Snapshot
+	props map[uint32]*ModeProperty
+	blobs map[uint32]bool
+}
+
+// fail records that an object could not be captured.
+func (s *snapshotter) fail(object string, id uint32, err error) {
+	s.snap.Errors = append(s.snap.Errors, SnapshotError{Object: fmt.Sprintf("%s %d", object, id),
+		Error: err.Error()})
+}
+
+// values records the properties of an object. Properties that cannot be
+// captured are omitted, and reported as errors of the object.
+func (s *snapshotter) values(object string, objectID uint32, ids []uint32,
+	values []uint64) []SnapshotPropertyValue {
+	ret := make([]SnapshotPropertyValue, 0, len(ids))
+	for i, id := range ids {
+		prop, ok := s.props[id]
+		if !ok {
+			var err error
+			if prop, err = s.card.ModeGetProperty(id); err != nil {
+				s.fail(object, objectID, fmt.Errorf("property %d: %w", id, err))
+				continue
+			}
+			s.props[id] = prop
+			s.snap.Properties = append(s.snap.Properties, SnapshotProperty{
+				ID:     id,
+				Name:   prop.Name,
+				Flags:  prop.Flags,
+				Values: prop.Values,
+			})
+			for _, enum := range prop.Enums {
+				p := &s.snap.Properties[len(s.snap.Properties)-1]
+				p.Enums = append(p.Enums, SnapshotEnum{Value: enum.Value, Name: enum.Name})
+			}
+		}
+		if prop.Flags&ModePropBlob != 0 && values[i] != 0 && !s.blobs[uint32(values[i])] {
+			// Blobs may be destroyed while the snapshot is taken.
+			blob, err := s.card.ModeGetBlob(uint32(values[i]))
+			switch {
+			case err == nil:
+				s.snap.Blobs = append(s.snap.Blobs, SnapshotBlob{ID: blob.ID, Data: blob.Data})
+			case !errors.Is(err, ErrNoSuchObject):
+				s.fail(object, objectID, fmt.Errorf("blob %d: %w", values[i], err))
+			}
+			s.blobs[uint32(values[i])] = true
+		}
+		ret = append(ret, SnapshotPropertyValue{ID: id, Name: prop.Name, Value: values[i]})
+	}
+	return ret
+}
+
+func (s *snapshotter) objectValues(object string, id, kind uint32) []SnapshotPropertyValue {
+	obj, err := s.card.ModeObjGetProperties(id, kind)
+	if err != nil {
+		s.fail(object, id, fmt.Errorf("properties: %w", err))
+		return nil
+	}
+	return s.values(object, id, obj.PropIDs, obj.PropValues)
+}
+
+// Snapshot captures the state of the card's mode objects. The client
+// capabilities of the card determine which planes and properties are visible,
+// as they do for the other methods.
+//
+// Capturing is best effort: objects that cannot be inspected, e.g. framebuffers
+// of other clients, are omitted and reported in the Errors of the snapshot. An
+// error is only returned if the card's resources cannot be listed.
+func (c *Card) Snapshot() (*Snapshot, error) {
+	snap := Snapshot{Version: SnapshotVersion}
+	s := snapshotter{card: c, snap: &snap, props: make(map[uint32]*ModeProperty),
+		blobs: make(map[uint32]bool)}
+
+	if ver, err := c.Version(); err != nil {
+		snap.Errors = append(snap.Errors, SnapshotError{Object: "driver", Error: err.Error()})
+	} else {
+		snap.Driver = SnapshotDriver{Name: ver.Name, Date: ver.Date, Desc: ver.Desc,
+			Major: ver.Major, Minor: ver.Minor, PatchLevel: ver.PatchLevel}
+	}
+
+	res, err := c.ModeGetResources()
+	if err != nil {
+		return nil, err
+	}
+	snap.MinWidth, snap.MaxWidth = res.MinWidth, res.MaxWidth
+	snap.MinHeight, snap.MaxHeight = res.MinHeight, res.MaxHeight
+
+	for _, id := range res.CRTCIDs {
+		crtc, err := c.ModeGetCRTC(id)
+		if err != nil {
+			s.fail("crtc", id, err)
+			continue
+		}
+		sc := SnapshotCRTC{ID: id, FBID: crtc.FBID, X: crtc.X, Y: crtc.Y, GammaSize: crtc.GammaSize}
+		if crtc.ModeValid != 0 {
+			mode := snapshotMode(crtc.cModeInfo)
+			sc.Mode = &mode
+		}
+		sc.Properties = s.objectValues("crtc", id, ModeObjectCrtc)
+		snap.CRTCs = append(snap.CRTCs, sc)
+	}
+
+	for _, id := range res.EncoderIDs {
+		enc, err := c.ModeGetEncoder(id)
+		if err != nil {
+			s.fail("encoder", id, err)
+			continue
+		}
+		snap.Encoders = append(snap.Encoders, SnapshotEncoder{ID: id, Type: EncoderTypeName(enc.Type),
+			CRTCID: enc.CRTCID, PossibleCRTCs: enc.PossibleCRTCs, PossibleClones: enc.PossibleClones})
+	}
+
+	for _, id := range res.ConnectorIDs {
+		conn, err := c.ModeGetConnector(id)
+		if err != nil {
+			s.fail("connector", id, err)
+			continue
+		}
+		sc := SnapshotConnector{
+			ID:         id,
+			Name:       conn.Name(),
+			Type:       ConnectorTypeName(conn.Type),
+			TypeID:     conn.TypeID,
+			Connection: ConnectionName(conn.Connection),
+			MMWidth:    conn.MMWidth,
+			MMHeight:   conn.MMHeight,
+			Subpixel:   SubpixelName(conn.Subpixel),
+			EncoderID:  conn.EncoderID,
+			Encoders:   conn.EncoderIDs,
+		}
+		for _, mode := range conn.Modes {
+			sc.Modes = append(sc.Modes, snapshotMode(mode.cModeInfo))
+		}
+		sc.Properties = s.values("connector", id, conn.PropIDs, conn.PropValues)
+		snap.Connectors = append(snap.Connectors, sc)
+	}
+
+	planes, err := c.ModeGetPlaneResources()
+	if err != nil {
+		snap.Errors = append(snap.Errors, SnapshotError{Object: "planes", Error: err.Error()})
+		planes = &ModePlaneResources{}
+	}
+	for _, id := range *planes {
+		plane, err := c.ModeGetPlane(id)
+		if err != nil {
+			s.fail("plane", id, err)
+			continue
+		}
+		sp := SnapshotPlane{ID: id, CRTCID: plane.CRTCID, FBID: plane.FBID,
+			PossibleCRTCs: plane.PossibleCRTCs, GammaSize: plane.GammaSize}
+		for _, format := range plane.FormatTypes {
+			sp.Formats = append(sp.Formats, FormatString(format))
+		}
+		sp.Properties = s.objectValues("plane", id, ModeObjectPlane)
+		if mods, err := c.PlaneFormatModifiers(id); err != nil {
+			s.fail("plane", id, fmt.Errorf("IN_FORMATS: %w", err))
+		} else {
+			sp.InFormats = make(map[string][]string, len(mods))
+			for format, list := range mods {
+				names := []string{}
+				for _, mod := range list {
+					names = append(names, ModifierName(mod))
+				}
+				sp.InFormats[FormatString(format)] = names
+			}
+		}
+		snap.Planes = append(snap.Planes, sp)
+	}
+
+	for _, id := range res.FBIDs {
+		fb, err := c.ModeGetFramebuffer(id)
+		if err != nil {
+			s.fail("framebuffer", id, err)
+			continue
+		}
+		snap.Framebuffers = append(snap.Framebuffers, SnapshotFramebuffer{ID: id, Width: fb.Width,
+			Height: fb.Height, Pitch: fb.Pitch, Bpp: fb.Bpp, Depth: fb.Depth})
+	}
+	return &snap, nil
+}
+
+// ReadSnapshot decodes a snapshot saved as JSON, checking that its schema
+// version is supported.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot: %w", err)
+	}
+	// The version is checked first, as snapshots of other versions may not
+	// decode at all.
+	var snap Snapshot
+	var version struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &version); err != nil {
+		return nil, fmt.Errorf("decode snapshot: %w", err)
+	}
+	if version.Version != SnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version.Version)
+	}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("decode snapshot: %w", err)
+	}
+	return &snap, nil
+}
+
+// NewReplay returns a read-only card serving the state of a snapshot, with the
+// same object and property IDs. Requests that would change the state fail with
+// EPERM. Like a real card, it hides atomic properties and non-overlay planes
+// until the corresponding client capabilities are set.
+func NewReplay(snap *Snapshot) (*Card, error) {
+	d := NewFakeDevice()
+	d.replay = true
+	d.driver = &snap.Driver
+	d.limits = [4]uint32{snap.MinWidth, snap.MaxWidth, snap.MinHeight, snap.MaxHeight}
+
+	for _, p := range snap.Properties {
+		prop := ModeProperty{PropID: p.ID, Name: p.Name, Flags: p.Flags, Values: p.Values}
+		for _, enum := range p.Enums {
+			prop.Enums = append(prop.Enums, ModePropertyEnum{Value: enum.Value, Name: enum.Name})
+		}
+		d.props[p.ID] = &prop
+	}
+	for _, b := range snap.Blobs {
+		d.blobs[b.ID] = &fakeBlob{data: b.Data}
+	}
+
+	add := func(id, kind uint32, values []SnapshotPropertyValue) (*fakeObject, error) {
+		if _, ok := d.objects[id]; ok || id == 0 {
+			return nil, fmt.Errorf("duplicate object %d", id)
+		}
+		obj := &fakeObject{kind: kind}
+		for _, v := range values {
+			if _, ok := d.props[v.ID]; !ok {
+				return nil, fmt.Errorf("object %d: unknown property %d", id, v.ID)
+			}
+			obj.propIDs = append(obj.propIDs, v.ID)
+			obj.propValues = append(obj.propValues, v.Value)
+		}
+		d.objects[id] = obj
+		return obj, nil
+	}
+
+	for _, sc := range snap.CRTCs {
+		obj, err := add(sc.ID, ModeObjectCrtc, sc.Properties)
+		if err != nil {
+			return nil, err
+		}
+		obj.crtc = &cModeCRTC{ID: sc.ID, FBID: sc.FBID, X: sc.X, Y: sc.Y, GammaSize: sc.GammaSize}
+		if sc.Mode != nil {
+			if obj.crtc.cModeInfo, err = sc.Mode.modeInfo(); err != nil {
+				return nil, fmt.Errorf("crtc %d: %w", sc.ID, err)
+			}
+			obj.crtc.ModeValid = 1
+		}
+		d.crtcs = append(d.crtcs, sc.ID)
+	}
+	for _, se := range snap.Encoders {
+		obj, err := add(se.ID, ModeObjectEncoder, nil)
+		if err != nil {
+			return nil, err
+		}
+		typ, err := parseEnumName(encoderTypeNames, "encoder type", se.Type)
+		if err != nil {
+			return nil, fmt.Errorf("encoder %d: %w", se.ID, err)
+		}
+		obj.encoder = &cModeGetEncoder{ID: se.ID, Type: typ, CRTCID: se.CRTCID,
+			PossibleCRTCs: se.PossibleCRTCs, PossibleClones: se.PossibleClones}
+		d.encoders = append(d.encoders, se.ID)
+	}
+	for _, sc := range snap.Connectors {
+		obj, err := add(sc.ID, ModeObjectConnector, sc.Properties)
+		if err != nil {
+			return nil, err
+		}
+		conn := fakeConnector{encoders: sc.Encoders}
+		conn.ID, conn.TypeID, conn.MMWidth, conn.MMHeight, conn.EncoderID = sc.ID, sc.TypeID,
+			sc.MMWidth, sc.MMHeight, sc.EncoderID
+		if conn.Type, err = parseEnumName(connectorTypeNames, "connector type", sc.Type); err != nil {
+			return nil, fmt.Errorf("connector %d: %w", sc.ID, err)
+		}
+		if conn.Connection, err = parseEnumName(connectionNames, "connection", sc.Connection); err != nil {
+			return nil, fmt.Errorf("connector %d: %w", sc.ID, err)
+		}
+		if conn.Subpixel, err = parseEnumName(subpixelNames, "subpixel order", sc.Subpixel); err != nil {
+			return nil, fmt.Errorf("connector %d: %w", sc.ID, err)
+		}
+		for i := range sc.Modes {
+			mode, err := sc.Modes[i].modeInfo()
+			if err != nil {
+				return nil, fmt.Errorf("connector %d: %w", sc.ID, err)
+			}
+			conn.modes = append(conn.modes, mode)
+		}
+		obj.connector = &conn
+		d.connectors = append(d.connectors, sc.ID)
+	}
+	for _, sp := range snap.Planes {
+		obj, err := add(sp.ID, ModeObjectPlane, sp.Properties)
+		if err != nil {
+			return nil, err
+		}
+		obj.plane = &fakePlane{possibleCRTCs: sp.PossibleCRTCs, crtcID: sp.CRTCID, fbID: sp.FBID,
+			gammaSize: sp.GammaSize}
+		for _, name := range sp.Formats {
+			format, err := ParseFormat(name)
+			if err != nil {
+				return nil, fmt.Errorf("plane %d: %w", sp.ID, err)
+			}
+			obj.plane.formats = append(obj.plane.formats, format)
+		}
+		d.planes = append(d.planes, sp.ID)
+	}
+	for _, sf := range snap.Framebuffers {
+		d.fbs[sf.ID] = &fakeFramebuffer{
+			cModeFBCmd2: cModeFBCmd2{ID: sf.ID, Width: sf.Width, Height: sf.Height,
+				Pitches: [4]uint32{sf.Pitch}},
+			bpp:   sf.Bpp,
+			depth: sf.Depth,
+		}
+	}
+	return NewWithBackend(d), nil
+}
@@ -0,0 +1,140 @@
+package drm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestSnapshotReplay(t *testing.T) {
+	s := newFakeSetup(t)
+	s.dev.SetBlobProperty(s.connector, "EDID", []byte("edid"))
+	s.modeset(t, s.framebuffer(t, 1920, 1080))
+	if err := s.card.SetClientCap(ClientCapAtomic, 1); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := s.card.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"type":"HDMI-A"`, `"connection":"connected"`, `"subpixel":"unknown"`,
+		`"type":"TMDS"`, `"flags":["phsync","nvsync"],"type":["driver"]`, `"formats":["XR24","AR24"]`} {
+		if !bytes.Contains(data, []byte(want)) {
+			t.Errorf("snapshot does not contain %s:\n%s", want, data)
+		}
+	}
+	loaded, err := ReadSnapshot(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, err := NewReplay(loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crtc, err := card.ModeGetCRTC(s.crtc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crtc.ModeValid == 0 || crtc.Name != "1920x1080" || crtc.FBID == 0 {
+		t.Errorf("unexpected crtc %+v", crtc)
+	}
+	conn, err := card.ModeGetConnector(s.connector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.EncoderID != s.encoder || len(conn.Modes) != 2 {
+		t.Errorf("unexpected connector %+v", conn.cModeGetConnector)
+	}
+	edid, err := card.ConnectorEDID(s.connector)
+	if err != nil || string(edid) != "edid" {
+		t.Errorf("unexpected EDID %q, %v", edid, err)
+	}
+	if _, err := card.ObjectProperties(s.crtc, ModeObjectCrtc); err != nil {
+		t.Error(err)
+	}
+
+	// The replayed card hides atomic state until asked, like the original.
+	if err := card.SetClientCap(ClientCapAtomic, 1); err != nil {
+		t.Fatal(err)
+	}
+	again, err := card.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, loaded) {
+		t.Errorf("snapshot of replay differs:\n%+v\n%+v", again, loaded)
+	}
+
+	err = card.ModeSetCRTC(ModeCRTC{cModeCRTC: cModeCRTC{ID: s.crtc}})
+	if !errors.Is(err, syscall.EPERM) {
+		t.Errorf("expected EPERM, got %v", err)
+	}
+}
+
+func TestReadSnapshotVersion(t *testing.T) {
+	for _, version := range []int{1, SnapshotVersion + 1} {
+		if _, err := ReadSnapshot(strings.NewReader(fmt.Sprintf(`{"version": %d}`, version))); err == nil {
+			t.Errorf("unsupported version %d accepted", version)
+		}
+	}
+	// Version 1 gave types as numbers.
+	v1 := `{"version": 1, "connectors": [{"id": 1, "type": 11, "connection": 1}]}`
+	if _, err := ReadSnapshot(strings.NewReader(v1)); err == nil || !strings.Contains(err.Error(), "version 1") {
+		t.Errorf("version 1 snapshot read with error %v", err)
+	}
+	if _, err := ReadSnapshot(strings.NewReader(`{}`)); err == nil {
+		t.Error("missing version accepted")
+	}
+}
+
+func TestReplayEmptyDriver(t *testing.T) {
+	// Snapshots whose driver could not be queried have no driver fields.
+	for _, driver := range []SnapshotDriver{{}, {Name: "fake", Major: 1}} {
+		card, err := NewReplay(&Snapshot{Version: SnapshotVersion, Driver: driver})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ver, err := card.Version()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ver.Name != driver.Name || ver.Date != "" || ver.Desc != "" || ver.Major != driver.Major {
+			t.Errorf("replayed version %+v of driver %+v", ver, driver)
+		}
+	}
+}
+
+func TestNewReplayUnknownNames(t *testing.T) {
+	for _, snap := range []Snapshot{
+		{Encoders: []SnapshotEncoder{{ID: 1, Type: "HDMI"}}},
+		{Connectors: []SnapshotConnector{{ID: 1, Type: "HDMI-A", Connection: "plugged", Subpixel: "none"}}},
+		{Connectors: []SnapshotConnector{{ID: 1, Type: "HDMI-A", Connection: "connected", Subpixel: "none",
+			Modes: []SnapshotMode{{Name: "1920x1080", Flags: []string{"vsync"}}}}}},
+		{Planes: []SnapshotPlane{{ID: 1, Formats: []string{"XRGB8888"}}}},
+	} {
+		snap.Version = SnapshotVersion
+		if _, err := NewReplay(&snap); err == nil {
+			t.Errorf("snapshot %+v accepted", snap)
+		}
+	}
+}
+
+func TestNewReplayUnknownProperty(t *testing.T) {
+	snap := Snapshot{Version: SnapshotVersion, CRTCs: []SnapshotCRTC{
+		{ID: 1, Properties: []SnapshotPropertyValue{{ID: 2, Value: 1}}},
+	}}
+	if _, err := NewReplay(&snap); err == nil {
+		t.Error("unknown property accepted")
+	}
+}
@@ -0,0 +1,225 @@
+// Package sysfs reads the state of DRM connectors from sysfs, which unlike the
+// device nodes is readable by any user. It reports less than the device: modes
+// only have a size, and connectors have no properties besides the EDID and
+// power state.
+package sysfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/inahga/inahgo/drm"
+	"github.com/inahga/inahgo/drm/edid"
+)
+
+// DefaultRoot is the sysfs directory of the DRM class.
+const DefaultRoot = "/sys/class/drm"
+
+// Connector is the state of a connector, from its directory in sysfs, e.g.
+// /sys/class/drm/card0-HDMI-A-1.
+type Connector struct {
+	// Card is the name of the card's primary node, e.g. "card0".
+	Card string
+	// ID is the ID of the connector, or 0 for older kernels, which do not
+	// report it.
+	ID uint32
+	// Type and TypeID are those of drm.ModeConnector, parsed from the name of
+	// the directory.
+	Type   uint32
+	TypeID uint32
+	// Connection is drm.ModeConnected, drm.ModeDisconnected or
+	// drm.ModeUnknownConnection.
+	Connection uint32
+	// Enabled is whether a CRTC drives the connector.
+	Enabled bool
+	// Power is the state of the connector's "DPMS" property.
+	Power drm.PowerState
+	// Modes are the modes of the connector, of which sysfs only reports the
+	// size and whether they are interlaced, in the order of
+	// drm.ModeConnector.Modes.
+	Modes []drm.ModeInfo
+	// EDID is the raw EDID of the connector, or nil if it has none.
+	EDID []byte
+}
+
+// Name returns the kernel's name for the connector, e.g. "HDMI-A-1".
+func (c *Connector) Name() string {
+	return drm.ConnectorName(c.Type, c.TypeID)
+}
+
+// ModeConnector returns the connector as drm.Card.ModeGetConnector would,
+// with the fields sysfs does not report left zero. It can be given to
+// drm.Selector.Match.
+func (c *Connector) ModeConnector() *drm.ModeConnector {
+	var conn drm.ModeConnector
+	conn.ID, conn.Type, conn.TypeID, conn.Connection = c.ID, c.Type, c.TypeID, c.Connection
+	conn.Modes = c.Modes
+	return &conn
+}
+
+// ParseEDID decodes the EDID of the connector, returning nil if it has none.
+func (c *Connector) ParseEDID() (*edid.EDID, error) {
+	if len(c.EDID) == 0 {
+		return nil, nil
+	}
+	return edid.Parse(c.EDID)
+}
+
+// Connectors returns the connectors of every card in root, such as
+// DefaultRoot, ordered by card and ID, or by name for kernels without IDs.
+func Connectors(root string) ([]*Connector, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	var ret []*Connector
+	for _, e := range entries {
+		card, _, ok := strings.Cut(e.Name(), "-")
+		if !ok || !strings.HasPrefix(card, "card") {
+			continue
+		}
+		conn, err := ReadConnector(filepath.Join(root, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, conn)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		a, b := ret[i], ret[j]
+		if a.Card != b.Card {
+			return cardNumber(a.Card) < cardNumber(b.Card)
+		}
+		if a.ID != b.ID {
+			return a.ID < b.ID
+		}
+		return a.Name() < b.Name()
+	})
+	return ret, nil
+}
+
+func cardNumber(card string) int {
+	n, _ := strconv.Atoi(strings.TrimPrefix(card, "card"))
+	return n
+}
+
+// ReadConnector reads the connector of a sysfs directory, e.g.
+// /sys/class/drm/card0-HDMI-A-1.
+func ReadConnector(dir string) (*Connector, error) {
+	card, name, ok := strings.Cut(filepath.Base(dir), "-")
+	if !ok {
+		return nil, fmt.Errorf("%s is not the directory of a connector", dir)
+	}
+	c := Connector{Card: card}
+	var err error
+	if c.Type, c.TypeID, err = drm.ParseConnectorName(name); err != nil {
+		return nil, fmt.Errorf("%s: %w", dir, err)
+	}
+	r := reader{dir: dir}
+
+	if id := r.attr("connector_id", true); id != "" {
+		n, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			r.fail("connector_id", err)
+		}
+		c.ID = uint32(n)
+	}
+	c.Connection = parseConnection(r.attr("status", false))
+	switch enabled := r.attr("enabled", false); enabled {
+	case "enabled":
+		c.Enabled = true
+	case "disabled", "":
+	default:
+		r.fail("enabled", fmt.Errorf("unknown value %q", enabled))
+	}
+	if dpms := r.attr("dpms", false); dpms != "" {
+		if c.Power, err = parsePower(dpms); err != nil {
+			r.fail("dpms", err)
+		}
+	}
+	for _, name := range strings.Split(r.attr("modes", false), "\n") {
+		if name != "" {
+			c.Modes = append(c.Modes, parseMode(name))
+		}
+	}
+	if c.EDID = r.file("edid", false); len(c.EDID) == 0 {
+		c.EDID = nil
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &c, nil
+}
+
+// reader reads the attributes of a directory, keeping the first error.
+type reader struct {
+	dir string
+	err error
+}
+
+func (r *reader) file(name string, optional bool) []byte {
+	if r.err != nil {
+		return nil
+	}
+	b, err := os.ReadFile(filepath.Join(r.dir, name))
+	if err != nil && !(optional && errors.Is(err, fs.ErrNotExist)) {
+		r.err = err
+	}
+	return b
+}
+
+func (r *reader) attr(name string, optional bool) string {
+	return string(bytes.TrimSpace(r.file(name, optional)))
+}
+
+func (r *reader) fail(name string, err error) {
+	if r.err == nil {
+		r.err = fmt.Errorf("%s: %w", filepath.Join(r.dir, name), err)
+	}
+}
+
+func parseConnection(s string) uint32 {
+	for _, c := range []uint32{drm.ModeConnected, drm.ModeDisconnected} {
+		if s == drm.ConnectionName(c) {
+			return c
+		}
+	}
+	return drm.ModeUnknownConnection
+}
+
+func parsePower(s string) (drm.PowerState, error) {
+	for p := drm.PowerOn; p <= drm.PowerOff; p++ {
+		if s == p.String() {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown power state %q", s)
+}
+
+// parseMode parses the name the kernel gives a mode, e.g. "1920x1080" or
+// "1920x1080i" for interlaced modes. Drivers may name modes otherwise, e.g.
+// those of TV connectors, which are left without a size.
+func parseMode(name string) drm.ModeInfo {
+	var mode drm.ModeInfo
+	mode.Name = name
+	size := name
+	if strings.HasSuffix(size, "i") {
+		size = strings.TrimSuffix(size, "i")
+		mode.Flags |= drm.ModeFlagInterlace
+	}
+	w, h, ok := strings.Cut(size, "x")
+	width, werr := strconv.ParseUint(w, 10, 16)
+	height, herr := strconv.ParseUint(h, 10, 16)
+	if !ok || werr != nil || herr != nil {
+		mode.Flags = 0
+		return mode
+	}
+	mode.HDisplay, mode.VDisplay = uint16(width), uint16(height)
+	return mode
+}
@@ -0,0 +1,108 @@
+package sysfs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/inahga/inahgo/drm"
+)
+
+// writeTree writes files below root, creating their directories.
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testEDID returns a minimal EDID of a display made by manufacturer.
+func testEDID(manufacturer string) string {
+	data := make([]byte, 128)
+	copy(data, []byte{0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00})
+	mfg := uint16(manufacturer[0]-'A'+1)<<10 | uint16(manufacturer[1]-'A'+1)<<5 | uint16(manufacturer[2]-'A'+1)
+	data[8], data[9] = byte(mfg>>8), byte(mfg)
+	data[18], data[19] = 1, 4
+	for _, b := range data[:127] {
+		data[127] -= b
+	}
+	return string(data)
+}
+
+func TestConnectors(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"version":                     "drm 1.1.0 20060810\n",
+		"card0/dev":                   "226:0\n",
+		"renderD128/dev":              "226:128\n",
+		"card0-HDMI-A-1/connector_id": "77\n",
+		"card0-HDMI-A-1/status":       "connected\n",
+		"card0-HDMI-A-1/enabled":      "enabled\n",
+		"card0-HDMI-A-1/dpms":         "On\n",
+		"card0-HDMI-A-1/modes":        "1920x1080\n1920x1080\n720x480i\n",
+		"card0-HDMI-A-1/edid":         testEDID("DEL"),
+		"card0-DP-1/connector_id":     "76\n",
+		"card0-DP-1/status":           "disconnected\n",
+		"card0-DP-1/enabled":          "disabled\n",
+		"card0-DP-1/dpms":             "Off\n",
+		"card0-DP-1/modes":            "",
+		"card0-DP-1/edid":             "",
+		"card1-eDP-1/status":          "connected\n",
+		"card1-eDP-1/enabled":         "disabled\n",
+		"card1-eDP-1/dpms":            "Standby\n",
+		"card1-eDP-1/modes":           "2560x1600\n",
+		"card1-eDP-1/edid":            "",
+	})
+
+	conns, err := Connectors(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range conns {
+		names = append(names, c.Card+"-"+c.Name())
+	}
+	if want := []string{"card0-DP-1", "card0-HDMI-A-1", "card1-eDP-1"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("connectors: got %v, want %v", names, want)
+	}
+
+	dp, hdmi, edp := conns[0], conns[1], conns[2]
+	if dp.ID != 76 || dp.Connection != drm.ModeDisconnected || dp.Enabled || dp.Power != drm.PowerOff ||
+		dp.Modes != nil || dp.EDID != nil {
+		t.Errorf("DP-1: got %+v", *dp)
+	}
+	if hdmi.ID != 77 || hdmi.Type != drm.ModeConnectorHDMIA || hdmi.TypeID != 1 ||
+		hdmi.Connection != drm.ModeConnected || !hdmi.Enabled || hdmi.Power != drm.PowerOn {
+		t.Errorf("HDMI-A-1: got %+v", *hdmi)
+	}
+	if len(hdmi.Modes) != 3 || hdmi.Modes[0].HDisplay != 1920 || hdmi.Modes[0].VDisplay != 1080 ||
+		hdmi.Modes[2].Name != "720x480i" || hdmi.Modes[2].Flags&drm.ModeFlagInterlace == 0 {
+		t.Errorf("HDMI-A-1 modes: got %+v", hdmi.Modes)
+	}
+	if edp.ID != 0 || edp.Power != drm.PowerStandby {
+		t.Errorf("eDP-1: got %+v", *edp)
+	}
+
+	// The connector can be matched by selectors like one from the device.
+	e, err := hdmi.ParseEDID()
+	if err != nil {
+		t.Fatalf("parse edid: %s", err)
+	}
+	sel, err := drm.ParseSelector("connected,make=DEL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sel.Match(hdmi.ModeConnector(), e) {
+		t.Errorf("%s does not match HDMI-A-1", sel)
+	}
+	if e, _ := dp.ParseEDID(); sel.Match(dp.ModeConnector(), e) {
+		t.Errorf("%s matches DP-1", sel)
+	}
+}
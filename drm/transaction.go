@@ -0,0 +1,398 @@
+package drm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrTransactionDone is returned when changing, confirming or reverting a
+// transaction that was already confirmed or reverted.
+var ErrTransactionDone = errors.New("transaction already confirmed or reverted")
+
+// Transaction records the display configuration of a card, so that it can be
+// restored after applying a new one unless the new one is confirmed, like the
+// "keep these display settings?" prompt of desktops.
+//
+// A card has at most one pending transaction. Closing the card or dropping
+// master reverts it, so that a process that exits or hands the display over
+// leaves it as it found it. Reverting requires being master: a transaction
+// pending when master is revoked by someone else fails to revert.
+type Transaction struct {
+	card *Card
+	// atomic is set if the state was recorded through atomic properties, which
+	// cover every CRTC, connector and plane. Otherwise the state is that of the
+	// legacy CRTC API: each CRTC's mode, framebuffer and connectors.
+	atomic bool
+	props  []savedProperty
+	crtcs  []savedCRTC
+
+	mu    sync.Mutex
+	timer *time.Timer
+	done  chan struct{}
+	err   error
+}
+
+type savedProperty struct {
+	objID  uint32
+	prop   *ModeProperty
+	value  uint64
+	isBlob bool
+	// blob holds the contents of a blob property, which the kernel may free
+	// once the property no longer refers to it.
+	blob []byte
+}
+
+type savedCRTC struct {
+	crtc       ModeCRTC
+	connectors []uint32
+}
+
+// Properties a client may not set, or which the kernel manages itself, that
+// are not restored.
+var unrestoredProperties = map[string]bool{
+	"DPMS":               true,
+	"link-status":        true,
+	"Content Protection": true,
+}
+
+// BeginTransaction records the current configuration of the card. If the card
+// has ClientCapAtomic set, the atomic properties of every CRTC, connector and
+// plane are recorded. Otherwise, the configuration of every CRTC is.
+func (c *Card) BeginTransaction() (*Transaction, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.transaction != nil {
+		return nil, errors.New("a transaction is already pending")
+	}
+
+	res, err := c.ModeGetResources()
+	if err != nil {
+		return nil, err
+	}
+	t := Transaction{card: c, done: make(chan struct{})}
+	if len(res.CRTCIDs) > 0 {
+		props, err := c.ObjectProperties(res.CRTCIDs[0], ModeObjectCrtc)
+		if err != nil {
+			return nil, fmt.Errorf("crtc %d: %w", res.CRTCIDs[0], err)
+		}
+		_, t.atomic = props["ACTIVE"]
+	}
+	if t.atomic {
+		err = t.saveAtomic(res)
+	} else {
+		err = t.saveLegacy(res)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.transaction = &t
+	return &t, nil
+}
+
+func (t *Transaction) saveAtomic(res *ModeResources) error {
+	c := t.card
+	planes, err := c.ModeGetPlaneResources()
+	if err != nil {
+		return err
+	}
+	objects := []struct {
+		ids  []uint32
+		kind uint32
+	}{
+		{res.CRTCIDs, ModeObjectCrtc},
+		{res.ConnectorIDs, ModeObjectConnector},
+		{*planes, ModeObjectPlane},
+	}
+	for _, objs := range objects {
+		for _, id := range objs.ids {
+			props, err := c.ObjectProperties(id, objs.kind)
+			if err != nil {
+				return fmt.Errorf("object %d: %w", id, err)
+			}
+			for _, prop := range props {
+				if prop.Flags&ModePropImmutable != 0 || unrestoredProperties[prop.Name] {
+					continue
+				}
+				saved := savedProperty{objID: id, prop: &prop.ModeProperty, value: prop.Value,
+					isBlob: prop.Flags&ModePropBlob != 0}
+				if saved.isBlob && prop.Value != 0 {
+					blob, err := c.ModeGetBlob(uint32(prop.Value))
+					if err != nil {
+						return fmt.Errorf("object %d: property %s: %w", id, prop.Name, err)
+					}
+					saved.blob = blob.Data
+				}
+				t.props = append(t.props, saved)
+			}
+		}
+	}
+	return nil
+}
+
+func (t *Transaction) saveLegacy(res *ModeResources) error {
+	c := t.card
+	connectors := make(map[uint32][]uint32)
+	for _, id := range res.ConnectorIDs {
+		conn, err := c.ModeGetConnector(id)
+		if err != nil {
+			return fmt.Errorf("connector %d: %w", id, err)
+		}
+		if conn.EncoderID == 0 {
+			continue
+		}
+		enc, err := c.ModeGetEncoder(conn.EncoderID)
+		if err != nil {
+			return fmt.Errorf("encoder %d: %w", conn.EncoderID, err)
+		}
+		if enc.CRTCID != 0 {
+			connectors[enc.CRTCID] = append(connectors[enc.CRTCID], id)
+		}
+	}
+	for _, id := range res.CRTCIDs {
+		crtc, err := c.ModeGetCRTC(id)
+		if err != nil {
+			return fmt.Errorf("crtc %d: %w", id, err)
+		}
+		t.crtcs = append(t.crtcs, savedCRTC{crtc: *crtc, connectors: connectors[id]})
+	}
+	return nil
+}
+
+// SetCRTC applies a configuration with ModeSetCRTC.
+func (t *Transaction) SetCRTC(set ModeCRTC) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ended() {
+		return ErrTransactionDone
+	}
+	return t.card.ModeSetCRTC(set)
+}
+
+// Commit applies an atomic request with ModeAtomicCommit.
+func (t *Transaction) Commit(req *AtomicRequest, flags uint32) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ended() {
+		return ErrTransactionDone
+	}
+	return t.card.ModeAtomicCommit(req, flags, 0)
+}
+
+// RevertAfter reverts the transaction once the timeout expires, unless it is
+// confirmed or reverted before. Calling it again restarts the timeout. The
+// result of the revert is reported by Err once Done is closed.
+func (t *Transaction) RevertAfter(timeout time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ended() {
+		return
+	}
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.timer = time.AfterFunc(timeout, func() {
+		// A revert racing with the timer finds the transaction ended.
+		t.Revert()
+	})
+}
+
+// RevertOnSignal reverts the transaction when the process receives one of the
+// signals. Without signals, it handles SIGINT, SIGTERM and SIGHUP. It stops
+// handling them once the transaction ends.
+//
+// An application that handles the signals itself passes its channel as
+// forward instead of registering it with signal.Notify, and receives the
+// signal once the transaction is reverted; as with signal.Notify, the send
+// does not block. If forward is nil, no other handler exists, so the signal is
+// delivered again once the transaction stops handling it, with its default
+// behavior, which usually terminates the process.
+func (t *Transaction) RevertOnSignal(forward chan<- os.Signal, sigs ...os.Signal) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGHUP}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	go func() {
+		defer signal.Stop(ch)
+		select {
+		case <-t.done:
+		case sig := <-ch:
+			t.Revert()
+			signal.Stop(ch)
+			if forward != nil {
+				select {
+				case forward <- sig:
+				default:
+				}
+				return
+			}
+			if s, ok := sig.(syscall.Signal); ok {
+				syscall.Kill(os.Getpid(), s)
+			}
+		}
+	}()
+}
+
+// Confirm keeps the current configuration, ending the transaction.
+func (t *Transaction) Confirm() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ended() {
+		return ErrTransactionDone
+	}
+	t.end(nil)
+	return nil
+}
+
+// Revert restores the recorded configuration, ending the transaction. The
+// transaction ends even if restoring fails.
+func (t *Transaction) Revert() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ended() {
+		return ErrTransactionDone
+	}
+	var err error
+	if t.atomic {
+		err = t.restoreAtomic()
+	} else {
+		err = t.restoreLegacy()
+	}
+	if err != nil {
+		err = fmt.Errorf("revert: %w", err)
+	}
+	t.end(err)
+	return err
+}
+
+// Done returns a channel that is closed once the transaction is confirmed or
+// reverted.
+func (t *Transaction) Done() <-chan struct{} {
+	return t.done
+}
+
+// Err returns the error of reverting the transaction, or nil if it was
+// confirmed, reverted successfully or is still pending.
+func (t *Transaction) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+func (t *Transaction) ended() bool {
+	select {
+	case <-t.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// end ends the transaction. The caller holds t.mu.
+func (t *Transaction) end(err error) {
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.err = err
+	close(t.done)
+
+	c := t.card
+	c.mu.Lock()
+	if c.transaction == t {
+		c.transaction = nil
+	}
+	c.mu.Unlock()
+}
+
+// restoreAtomic restores the properties that changed since they were recorded
+// in a single commit. Blobs are recreated from their recorded contents.
+func (t *Transaction) restoreAtomic() error {
+	c := t.card
+	var (
+		req     AtomicRequest
+		blobs   []uint32
+		current = make(map[uint32]Properties)
+	)
+	defer func() {
+		for _, id := range blobs {
+			c.ModeDestroyPropBlob(id)
+		}
+	}()
+	for _, saved := range t.props {
+		props, ok := current[saved.objID]
+		if !ok {
+			var err error
+			if props, err = c.ObjectProperties(saved.objID, ModeObjectAny); err != nil {
+				return fmt.Errorf("object %d: %w", saved.objID, err)
+			}
+			current[saved.objID] = props
+		}
+		now, ok := props[saved.prop.Name]
+		if !ok {
+			continue
+		}
+		if !saved.isBlob {
+			if now.Value != saved.value {
+				req.AddProperty(saved.objID, saved.prop.PropID, saved.value)
+			}
+			continue
+		}
+
+		if now.Value == saved.value {
+			continue
+		}
+		if now.Value != 0 && saved.value != 0 {
+			// A blob with the same contents is as good as the recorded one.
+			if blob, err := c.ModeGetBlob(uint32(now.Value)); err == nil && bytes.Equal(blob.Data, saved.blob) {
+				continue
+			}
+		}
+		value := uint64(0)
+		if saved.value != 0 {
+			id, err := c.ModeCreatePropBlob(saved.blob)
+			if err != nil {
+				return fmt.Errorf("object %d: property %s: %w", saved.objID, saved.prop.Name, err)
+			}
+			blobs = append(blobs, id)
+			value = uint64(id)
+		}
+		req.AddProperty(saved.objID, saved.prop.PropID, value)
+	}
+	if req.Len() == 0 {
+		return nil
+	}
+	return c.ModeAtomicCommit(&req, ModeAtomicAllowModeset, 0)
+}
+
+// restoreLegacy disables the CRTCs that were disabled, then sets the others, so
+// that connectors are free to move between CRTCs.
+func (t *Transaction) restoreLegacy() error {
+	var errs []error
+	for _, saved := range t.crtcs {
+		if saved.crtc.ModeValid != 0 && saved.crtc.FBID != 0 && len(saved.connectors) > 0 {
+			continue
+		}
+		var set ModeCRTC
+		set.ID = saved.crtc.ID
+		if err := t.card.ModeSetCRTC(set); err != nil {
+			errs = append(errs, fmt.Errorf("crtc %d: %w", set.ID, err))
+		}
+	}
+	for _, saved := range t.crtcs {
+		if saved.crtc.ModeValid == 0 || saved.crtc.FBID == 0 || len(saved.connectors) == 0 {
+			continue
+		}
+		set := saved.crtc
+		set.SetConnectors = saved.connectors
+		if err := t.card.ModeSetCRTC(set); err != nil {
+			errs = append(errs, fmt.Errorf("crtc %d: %w", set.ID, err))
+		}
+	}
+	return joinErrors(errs)
+}
@@ -0,0 +1,215 @@
+package drm
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// crtcMode returns the size of the mode of the fake setup's CRTC, or 0x0 if it
+// is disabled.
+func (s *fakeSetup) crtcMode(t *testing.T) (uint16, uint16) {
+	t.Helper()
+	crtc, err := s.card.ModeGetCRTC(s.crtc)
+	if err != nil {
+		t.Fatalf("get crtc: %s", err)
+	}
+	if crtc.ModeValid == 0 {
+		return 0, 0
+	}
+	return crtc.HDisplay, crtc.VDisplay
+}
+
+// atomicDisable returns a request disabling the fake setup's CRTC.
+func (s *fakeSetup) atomicDisable(t *testing.T) *AtomicRequest {
+	t.Helper()
+	var req AtomicRequest
+	for _, obj := range []struct {
+		id, kind uint32
+		names    []string
+	}{
+		{s.crtc, ModeObjectCrtc, []string{"ACTIVE", "MODE_ID"}},
+		{s.connector, ModeObjectConnector, []string{"CRTC_ID"}},
+		{s.dev.primaryPlane(s.crtc), ModeObjectPlane, []string{"FB_ID", "CRTC_ID"}},
+	} {
+		props, err := s.card.ObjectProperties(obj.id, obj.kind)
+		if err != nil {
+			t.Fatalf("properties: %s", err)
+		}
+		for _, name := range obj.names {
+			id, err := props.ID(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.AddProperty(obj.id, id, 0)
+		}
+	}
+	return &req
+}
+
+func TestTransactionRevertAtomic(t *testing.T) {
+	s := newFakeSetup(t)
+	s.modeset(t, s.framebuffer(t, 1920, 1080))
+	if err := s.card.SetClientCap(ClientCapAtomic, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := s.card.BeginTransaction()
+	if err != nil {
+		t.Fatalf("begin: %s", err)
+	}
+	if _, err := s.card.BeginTransaction(); err == nil {
+		t.Errorf("second pending transaction: no error")
+	}
+	if err := tx.Commit(s.atomicDisable(t), ModeAtomicAllowModeset); err != nil {
+		t.Fatalf("commit: %s", err)
+	}
+	if w, h := s.crtcMode(t); w != 0 || h != 0 {
+		t.Fatalf("crtc still shows %dx%d", w, h)
+	}
+
+	tx.RevertAfter(10 * time.Millisecond)
+	select {
+	case <-tx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("transaction was not reverted")
+	}
+	if err := tx.Err(); err != nil {
+		t.Fatalf("revert: %s", err)
+	}
+	if w, h := s.crtcMode(t); w != 1920 || h != 1080 {
+		t.Errorf("crtc shows %dx%d after revert, want 1920x1080", w, h)
+	}
+	if crtc, _ := s.dev.Property(s.connector, "CRTC_ID"); uint32(crtc) != s.crtc {
+		t.Errorf("connector is on crtc %d after revert, want %d", crtc, s.crtc)
+	}
+	if err := tx.Confirm(); !errors.Is(err, ErrTransactionDone) {
+		t.Errorf("confirm after revert: got %v, want ErrTransactionDone", err)
+	}
+	if _, err := s.card.BeginTransaction(); err != nil {
+		t.Errorf("begin after revert: %s", err)
+	}
+}
+
+func TestTransactionConfirm(t *testing.T) {
+	s := newFakeSetup(t)
+	s.modeset(t, s.framebuffer(t, 1920, 1080))
+	if err := s.card.SetClientCap(ClientCapAtomic, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := s.card.BeginTransaction()
+	if err != nil {
+		t.Fatalf("begin: %s", err)
+	}
+	tx.RevertAfter(time.Hour)
+	if err := tx.Commit(s.atomicDisable(t), ModeAtomicAllowModeset); err != nil {
+		t.Fatalf("commit: %s", err)
+	}
+	if err := tx.Confirm(); err != nil {
+		t.Fatalf("confirm: %s", err)
+	}
+	if err := tx.Revert(); !errors.Is(err, ErrTransactionDone) {
+		t.Errorf("revert after confirm: got %v, want ErrTransactionDone", err)
+	}
+	if err := tx.Commit(s.atomicDisable(t), ModeAtomicAllowModeset); !errors.Is(err, ErrTransactionDone) {
+		t.Errorf("commit after confirm: got %v, want ErrTransactionDone", err)
+	}
+	if w, h := s.crtcMode(t); w != 0 || h != 0 {
+		t.Errorf("crtc shows %dx%d after confirming it disabled", w, h)
+	}
+}
+
+func TestTransactionRevertLegacy(t *testing.T) {
+	s := newFakeSetup(t)
+	s.modeset(t, s.framebuffer(t, 1920, 1080))
+
+	tx, err := s.card.BeginTransaction()
+	if err != nil {
+		t.Fatalf("begin: %s", err)
+	}
+	conn, err := s.card.ModeGetConnector(s.connector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mode := conn.Modes[1]
+	set := ModeCRTC{cModeCRTC: cModeCRTC{ID: s.crtc, FBID: s.framebuffer(t, 1280, 720), ModeValid: 1,
+		cModeInfo: mode.cModeInfo}, Name: mode.Name, SetConnectors: []uint32{s.connector}}
+	if err := tx.SetCRTC(set); err != nil {
+		t.Fatalf("set crtc: %s", err)
+	}
+	if w, h := s.crtcMode(t); w != 1280 || h != 720 {
+		t.Fatalf("crtc shows %dx%d, want 1280x720", w, h)
+	}
+	if err := tx.Revert(); err != nil {
+		t.Fatalf("revert: %s", err)
+	}
+	if w, h := s.crtcMode(t); w != 1920 || h != 1080 {
+		t.Errorf("crtc shows %dx%d after revert, want 1920x1080", w, h)
+	}
+}
+
+func TestTransactionRevertOnDropMaster(t *testing.T) {
+	s := newFakeSetup(t)
+	s.modeset(t, s.framebuffer(t, 1920, 1080))
+	if err := s.card.SetClientCap(ClientCapAtomic, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := s.card.BeginTransaction()
+	if err != nil {
+		t.Fatalf("begin: %s", err)
+	}
+	if err := tx.Commit(s.atomicDisable(t), ModeAtomicAllowModeset); err != nil {
+		t.Fatalf("commit: %s", err)
+	}
+	if err := s.card.DropMaster(); err != nil {
+		t.Fatalf("drop master: %s", err)
+	}
+	select {
+	case <-tx.Done():
+	default:
+		t.Fatal("dropping master left the transaction pending")
+	}
+	if w, h := s.crtcMode(t); w != 1920 || h != 1080 {
+		t.Errorf("crtc shows %dx%d after dropping master, want 1920x1080", w, h)
+	}
+}
+
+func TestTransactionRevertOnSignal(t *testing.T) {
+	s := newFakeSetup(t)
+	s.modeset(t, s.framebuffer(t, 1920, 1080))
+	if err := s.card.SetClientCap(ClientCapAtomic, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := s.card.BeginTransaction()
+	if err != nil {
+		t.Fatalf("begin: %s", err)
+	}
+	if err := tx.Commit(s.atomicDisable(t), ModeAtomicAllowModeset); err != nil {
+		t.Fatalf("commit: %s", err)
+	}
+	forward := make(chan os.Signal, 1)
+	tx.RevertOnSignal(forward, syscall.SIGUSR1)
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	select {
+	case sig := <-forward:
+		if sig != syscall.SIGUSR1 {
+			t.Errorf("forwarded %s", sig)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("signal was not forwarded")
+	}
+	// The signal is forwarded once the transaction is reverted.
+	select {
+	case <-tx.Done():
+	default:
+		t.Fatal("transaction pending once the signal was forwarded")
+	}
+	if w, h := s.crtcMode(t); w != 1920 || h != 1080 {
+		t.Errorf("crtc shows %dx%d after the signal, want 1920x1080", w, h)
+	}
+}
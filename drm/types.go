@@ -1,5 +1,7 @@
 package drm
 
+import "time"
+
 // Most comments here are taken directly from drm/drm.h or drm/drm_mode.h
 
 const (
@@ -86,6 +88,7 @@ const (
 	ModeConnectorDPI
 	ModeConnectorWriteback
 	ModeConnectorSPI
+	ModeConnectorUSB
 )
 
 const (
@@ -112,6 +115,162 @@ const (
 	ModeObjectAny       uint32 = 0
 )
 
+// Subpixel orders of a connector's display.
+const (
+	ModeSubpixelUnknown uint32 = iota
+	ModeSubpixelHorizontalRGB
+	ModeSubpixelHorizontalBGR
+	ModeSubpixelVerticalRGB
+	ModeSubpixelVerticalBGR
+	ModeSubpixelNone
+)
+
+const (
+	ModeConnected         uint32 = 1
+	ModeDisconnected      uint32 = 2
+	ModeUnknownConnection uint32 = 3
+)
+
+const (
+	ModeTypeBuiltin   uint32 = 1 << 0                 // deprecated
+	ModeTypeClockC    uint32 = 1<<1 | ModeTypeBuiltin // deprecated
+	ModeTypeCrtcC     uint32 = 1<<2 | ModeTypeBuiltin // deprecated
+	ModeTypePreferred uint32 = 1 << 3
+	ModeTypeDefault   uint32 = 1 << 4 // deprecated
+	ModeTypeUserdef   uint32 = 1 << 5
+	ModeTypeDriver    uint32 = 1 << 6
+)
+
+const (
+	ModeFlagPHSync uint32 = 1 << iota
+	ModeFlagNHSync
+	ModeFlagPVSync
+	ModeFlagNVSync
+	ModeFlagInterlace
+	ModeFlagDblScan
+	ModeFlagCSync
+	ModeFlagPCSync
+	ModeFlagNCSync
+	ModeFlagHSkew // hskew provided
+	ModeFlagBCast // deprecated
+	ModeFlagPixMux
+	ModeFlagDblClk
+	ModeFlagClkDiv2
+)
+
+const (
+	// ModeFlag3DMask is the part of the mode flags holding the stereo 3D layout,
+	// which is only reported with ClientCapStereo3D.
+	ModeFlag3DMask uint32 = 0x1f << 14
+	// ModeFlagPicAspectMask is the part of the mode flags holding the picture
+	// aspect ratio, which is only reported with ClientCapAspectRatio.
+	ModeFlagPicAspectMask uint32 = 0x0f << 19
+)
+
+const (
+	// ModeFBInterlaced indicates the framebuffer is interlaced.
+	ModeFBInterlaced uint32 = 1 << 0
+	// ModeFBModifiers enables the modifier field of ModeAddFramebuffer2.
+	ModeFBModifiers uint32 = 1 << 1
+)
+
+const (
+	// ModePageFlipEvent requests that an event is sent when the page flip
+	// completes.
+	ModePageFlipEvent uint32 = 0x01
+	// ModePageFlipAsync requests that the flip happens as soon as possible,
+	// rather than waiting for vblank. This may cause tearing.
+	ModePageFlipAsync uint32 = 0x02
+)
+
+const (
+	// ModeAtomicTestOnly checks whether an atomic commit would succeed, without
+	// applying it.
+	ModeAtomicTestOnly uint32 = 0x0100
+	// ModeAtomicNonblock returns from an atomic commit without waiting for it to
+	// be applied.
+	ModeAtomicNonblock uint32 = 0x0200
+	// ModeAtomicAllowModeset allows an atomic commit to perform a full modeset.
+	ModeAtomicAllowModeset uint32 = 0x0400
+)
+
+const (
+	EventVblank       uint32 = 0x01
+	EventFlipComplete uint32 = 0x02
+	EventCRTCSequence uint32 = 0x03
+)
+
+// Values of the "link-status" connector property.
+const (
+	ModeLinkStatusGood uint64 = 0
+	ModeLinkStatusBad  uint64 = 1
+)
+
+// Metadata types of HDROutputMetadata, from CTA-861-G.
+const (
+	HDMIStaticMetadataType1 uint8 = 0
+)
+
+// Electro-optical transfer functions of HDROutputMetadata, from CTA-861-G.
+const (
+	EOTFTraditionalGammaSDR uint8 = iota
+	EOTFTraditionalGammaHDR
+	EOTFSMPTEST2084 // PQ
+	EOTFBT2100HLG
+)
+
+// Values of the "Colorspace" connector property. Not every driver or connector
+// type supports every value.
+const (
+	ColorspaceDefault         = "Default"
+	ColorspaceSMPTE170MYCC    = "SMPTE_170M_YCC"
+	ColorspaceBT709YCC        = "BT709_YCC"
+	ColorspaceXVYCC601        = "XVYCC_601"
+	ColorspaceXVYCC709        = "XVYCC_709"
+	ColorspaceSYCC601         = "SYCC_601"
+	ColorspaceOpYCC601        = "opYCC_601"
+	ColorspaceOpRGB           = "opRGB"
+	ColorspaceBT2020CYCC      = "BT2020_CYCC"
+	ColorspaceBT2020RGB       = "BT2020_RGB"
+	ColorspaceBT2020YCC       = "BT2020_YCC"
+	ColorspaceDCIP3RGBD65     = "DCI-P3_RGB_D65"
+	ColorspaceDCIP3RGBTheater = "DCI-P3_RGB_Theater"
+	ColorspaceRGBWideFixed    = "RGB_WIDE_FIXED"
+	ColorspaceRGBWideFloat    = "RGB_WIDE_FLOAT"
+	ColorspaceBT601YCC        = "BT601_YCC"
+)
+
+// Values of the "Broadcast RGB" connector property.
+const (
+	BroadcastRGBAutomatic = "Automatic"
+	BroadcastRGBFull      = "Full"
+	BroadcastRGBLimited   = "Limited 16:235"
+)
+
+// Values of the "scaling mode" connector property, which selects how the panel
+// or encoder scales modes smaller than the native one.
+const (
+	ScalingModeNone       = "None"
+	ScalingModeFull       = "Full"
+	ScalingModeCenter     = "Center"
+	ScalingModeFullAspect = "Full aspect"
+)
+
+// Bits of the "rotation" plane property. A value holds one of the ModeRotate*
+// bits, optionally combined with ModeReflect* bits which are applied before
+// rotating. Rotation is counter-clockwise.
+const (
+	ModeRotate0   uint64 = 1 << 0
+	ModeRotate90  uint64 = 1 << 1
+	ModeRotate180 uint64 = 1 << 2
+	ModeRotate270 uint64 = 1 << 3
+	ModeReflectX  uint64 = 1 << 4
+	ModeReflectY  uint64 = 1 << 5
+
+	ModeRotateMask  = ModeRotate0 | ModeRotate90 | ModeRotate180 | ModeRotate270
+	ModeReflectMask = ModeReflectX | ModeReflectY
+)
+
 type Version struct {
 	Major      int32
 	Minor      int32
@@ -159,8 +318,8 @@ type ModeCRTC struct {
 }
 
 type ModePropertyEnum struct {
-	Value uint64
-	Name  string
+	Value uint64 `json:"value"`
+	Name  string `json:"name"`
 }
 
 type ModeProperty struct {
@@ -205,3 +364,47 @@ type ModeDumbBuffer struct {
 type ModeFramebuffer struct {
 	cModeFBCmd
 }
+
+type ModeFramebuffer2 struct {
+	cModeFBCmd2
+}
+
+// Event is an event read from the card, e.g. upon completion of a page flip.
+type Event struct {
+	Type     uint32
+	UserData uint64
+	Sequence uint32
+	CRTCID   uint32
+	// Time is the time of the vblank, as measured by CLOCK_MONOTONIC.
+	Time time.Time
+}
+
+// Chromaticity is a CIE 1931 xy coordinate, in units of 0.00002.
+type Chromaticity struct {
+	X uint16
+	Y uint16
+}
+
+// HDROutputMetadata is the value of the "HDR_OUTPUT_METADATA" connector property.
+// It corresponds to struct hdr_output_metadata, which is sent to the sink as a
+// Dynamic Range and Mastering InfoFrame.
+type HDROutputMetadata struct {
+	MetadataType uint32
+	// InfoframeType is the type of the static metadata descriptor, e.g.
+	// HDMIStaticMetadataType1.
+	InfoframeType uint8
+	// EOTF is one of the EOTF* constants.
+	EOTF uint8
+	// DisplayPrimaries are the red, green and blue primaries of the mastering
+	// display.
+	DisplayPrimaries [3]Chromaticity
+	WhitePoint       Chromaticity
+	// MaxDisplayMasteringLuminance is in units of 1 cd/m2.
+	MaxDisplayMasteringLuminance uint16
+	// MinDisplayMasteringLuminance is in units of 0.0001 cd/m2.
+	MinDisplayMasteringLuminance uint16
+	// MaxCLL is the maximum content light level, in units of 1 cd/m2.
+	MaxCLL uint16
+	// MaxFALL is the maximum frame-average light level, in units of 1 cd/m2.
+	MaxFALL uint16
+}
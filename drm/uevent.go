@@ -0,0 +1,127 @@
+package drm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// Uevent is a kernel device event, as sent over the NETLINK_KOBJECT_UEVENT
+// socket.
+type Uevent struct {
+	Action  string
+	DevPath string
+	Env     map[string]string
+}
+
+// IsHotplug returns whether the event is a DRM hotplug event, which the kernel
+// sends when connectors change state, or when a connector's link-status or
+// content protection property changes.
+func (e *Uevent) IsHotplug() bool {
+	return e.Env["SUBSYSTEM"] == "drm" && e.Env["HOTPLUG"] == "1"
+}
+
+// ConnectorID returns the ID of the connector the event is about, if the kernel
+// included one.
+func (e *Uevent) ConnectorID() (uint32, bool) {
+	id, err := strconv.ParseUint(e.Env["CONNECTOR"], 10, 32)
+	return uint32(id), err == nil
+}
+
+// Device returns the device number of the device the event is about.
+func (e *Uevent) Device() (major, minor uint32, ok bool) {
+	maj, err := strconv.ParseUint(e.Env["MAJOR"], 10, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	min, err := strconv.ParseUint(e.Env["MINOR"], 10, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	return uint32(maj), uint32(min), true
+}
+
+// UeventConn receives kernel device events.
+type UeventConn struct {
+	f *os.File
+}
+
+// ueventGroupKernel is the netlink multicast group of events sent by the kernel,
+// as opposed to those rebroadcast by udev.
+const ueventGroupKernel = 1
+
+// ListenUevents opens a connection that receives kernel device events. It does
+// not require any privileges.
+func ListenUevents() (*UeventConn, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC,
+		syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, fmt.Errorf("socket: %w", err)
+	}
+	addr := syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: ueventGroupKernel}
+	if err := syscall.Bind(fd, &addr); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("bind: %w", err)
+	}
+	// A non-blocking file is managed by the runtime poller, so that Close
+	// interrupts a pending Read.
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("nonblock: %w", err)
+	}
+	return &UeventConn{f: os.NewFile(uintptr(fd), "uevent")}, nil
+}
+
+// Read blocks until the next event is received.
+func (u *UeventConn) Read() (*Uevent, error) {
+	buf := make([]byte, os.Getpagesize())
+	for {
+		n, err := u.f.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		if event := parseUevent(buf[:n]); event != nil {
+			return event, nil
+		}
+	}
+}
+
+func (u *UeventConn) Close() error {
+	return u.f.Close()
+}
+
+// parseUevent decodes a kernel event of the form "action@devpath\0KEY=VALUE\0...".
+// It returns nil for malformed events.
+func parseUevent(b []byte) *Uevent {
+	fields := bytes.Split(bytes.TrimRight(b, "\x00"), []byte{0})
+	if len(fields) == 0 {
+		return nil
+	}
+	at := bytes.IndexByte(fields[0], '@')
+	if at < 0 {
+		return nil
+	}
+
+	ret := Uevent{
+		Action:  string(fields[0][:at]),
+		DevPath: string(fields[0][at+1:]),
+		Env:     make(map[string]string, len(fields)-1),
+	}
+	for _, field := range fields[1:] {
+		if eq := bytes.IndexByte(field, '='); eq > 0 {
+			ret.Env[string(field[:eq])] = string(field[eq+1:])
+		}
+	}
+	return &ret
+}
+
+// Device returns the device number of the card's device node.
+func (c *Card) Device() (major, minor uint32, err error) {
+	if c.fd == nil {
+		return 0, 0, errors.New("card has no device file")
+	}
+	return fileDevice(c.fd)
+}
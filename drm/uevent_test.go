@@ -0,0 +1,64 @@
+package drm
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseUevent(t *testing.T) {
+	msg := strings.Join([]string{
+		"change@/devices/pci0000:00/0000:00:02.0/drm/card0",
+		"ACTION=change",
+		"DEVPATH=/devices/pci0000:00/0000:00:02.0/drm/card0",
+		"SUBSYSTEM=drm",
+		"HOTPLUG=1",
+		"CONNECTOR=95",
+		"PROPERTY=97",
+		"DEVNAME=dri/card0",
+		"DEVTYPE=drm_minor",
+		"SEQNUM=4242",
+		"MAJOR=226",
+		"MINOR=0",
+		"",
+	}, "\x00")
+	e := parseUevent([]byte(msg))
+	if e == nil {
+		t.Fatal("event not parsed")
+	}
+	if e.Action != "change" || e.DevPath != "/devices/pci0000:00/0000:00:02.0/drm/card0" ||
+		len(e.Env) != 11 || e.Env["DEVNAME"] != "dri/card0" {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if !e.IsHotplug() {
+		t.Error("hotplug event not recognized")
+	}
+	if major, minor, ok := e.Device(); major != 226 || minor != 0 || !ok {
+		t.Errorf("device %d:%d, %v", major, minor, ok)
+	}
+	if id, ok := e.ConnectorID(); id != 95 || !ok {
+		t.Errorf("connector %d, %v", id, ok)
+	}
+
+	// Values may contain '=', and fields without one are skipped.
+	e = parseUevent([]byte("add@/devices/virtual/misc/uinput\x00MODALIAS=a=b\x00junk\x00=x\x00"))
+	if e == nil || !reflect.DeepEqual(e.Env, map[string]string{"MODALIAS": "a=b"}) {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if e.IsHotplug() {
+		t.Error("event of another subsystem is a hotplug")
+	}
+	if _, _, ok := e.Device(); ok {
+		t.Error("device of an event without one")
+	}
+	if _, ok := e.ConnectorID(); ok {
+		t.Error("connector of an event without one")
+	}
+
+	// Events rebroadcast by udev start with a header rather than the action.
+	for _, msg := range []string{"", "\x00\x00", "libudev\x00\xfe\xed\xca\xfe", "change\x00SUBSYSTEM=drm\x00"} {
+		if e := parseUevent([]byte(msg)); e != nil {
+			t.Errorf("%q parsed as %+v", msg, e)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+// Package usage reads the GPU usage of DRM clients from the fdinfo files of
+// processes, as documented in the kernel's drm-usage-stats, and computes how
+// busy each client keeps the engines of its device.
+package usage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultProcRoot is where procfs is mounted.
+const DefaultProcRoot = "/proc"
+
+// Client is a DRM client: an open file description of a DRM device. Every file
+// descriptor referring to it, in any process, reports the same client ID.
+type Client struct {
+	// ID is the drm-client-id, unique among the clients of the system.
+	ID uint64
+	// Driver is the name of the driver, e.g. "i915" or "amdgpu".
+	Driver string
+	// PDev is the PCI address of the device, e.g. "0000:00:02.0", if reported.
+	PDev string
+	// Name is the name the client gave itself, if any.
+	Name string
+	// Processes are the processes holding a file descriptor of the client,
+	// ordered by PID.
+	Processes []Process
+	// Engines are the engines the client used, by name, e.g. "render".
+	Engines map[string]*Engine
+	// Memory is the memory of the client, by region name, e.g. "vram".
+	Memory map[string]*Memory
+}
+
+// Process is a process holding a DRM client.
+type Process struct {
+	PID int
+	// Comm is the command name of the process.
+	Comm string
+}
+
+// Engine is the usage of an engine by a client. Drivers report either the
+// time the engine was busy, or the cycles it spent, or both.
+type Engine struct {
+	// Busy is the time the engine spent on the client's work, from
+	// drm-engine-<name>.
+	Busy time.Duration
+	// Capacity is the number of engines of the type, from
+	// drm-engine-capacity-<name>. Busy can reach Capacity times the wall
+	// time.
+	Capacity int
+	// Cycles is the number of cycles the engine spent on the client's work,
+	// from drm-cycles-<name>.
+	Cycles uint64
+	// TotalCycles is the number of cycles the engine ran, from
+	// drm-total-cycles-<name>, or 0 if not reported.
+	TotalCycles uint64
+	// MaxFreq is the maximum frequency of the engine in Hz, from
+	// drm-maxfreq-<name>, or 0 if not reported.
+	MaxFreq uint64
+}
+
+// Memory is the memory of a region used by a client, in bytes.
+type Memory struct {
+	// Total is the size of the buffers the client can access, from
+	// drm-total-<region>, or the legacy drm-memory-<region>.
+	Total uint64
+	// Shared is the size of the buffers shared with other clients.
+	Shared uint64
+	// Resident is the size of the buffers backed by memory of the region.
+	Resident uint64
+	// Purgeable is the size of the resident buffers the kernel may discard.
+	Purgeable uint64
+	// Active is the size of the buffers in use by the GPU.
+	Active uint64
+}
+
+// Sample is the usage of all DRM clients at a point in time.
+type Sample struct {
+	Time time.Time
+	// Clients are ordered by driver, device and ID.
+	Clients []*Client
+}
+
+// Scan reads the fdinfo of every process under procRoot, such as
+// DefaultProcRoot, and returns the DRM clients found. Processes that exit, or
+// whose file descriptors cannot be read for lack of permission, are skipped,
+// as are malformed values in fdinfo files.
+func Scan(procRoot string) (*Sample, error) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return nil, err
+	}
+	s := Sample{Time: time.Now()}
+	clients := make(map[clientKey]*Client)
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil || !e.IsDir() {
+			continue
+		}
+		if err := scanProcess(filepath.Join(procRoot, e.Name()), pid, clients); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range clients {
+		sort.Slice(c.Processes, func(i, j int) bool { return c.Processes[i].PID < c.Processes[j].PID })
+		s.Clients = append(s.Clients, c)
+	}
+	sort.Slice(s.Clients, func(i, j int) bool {
+		a, b := s.Clients[i], s.Clients[j]
+		if a.Driver != b.Driver {
+			return a.Driver < b.Driver
+		}
+		if a.PDev != b.PDev {
+			return a.PDev < b.PDev
+		}
+		return a.ID < b.ID
+	})
+	return &s, nil
+}
+
+// clientKey identifies a client. Client IDs are unique, but the device is
+// included in case a driver numbers its clients on its own.
+type clientKey struct {
+	driver, pdev string
+	id           uint64
+}
+
+// skippable returns whether an error reading a process means it exited or is
+// not ours to look at.
+func skippable(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission)
+}
+
+func scanProcess(dir string, pid int, clients map[clientKey]*Client) error {
+	entries, err := os.ReadDir(filepath.Join(dir, "fdinfo"))
+	if err != nil {
+		if skippable(err) {
+			return nil
+		}
+		return err
+	}
+	var comm string
+	seen := make(map[*Client]bool)
+	for _, e := range entries {
+		path := filepath.Join(dir, "fdinfo", e.Name())
+		c, err := readFDInfo(path)
+		if err != nil {
+			if skippable(err) {
+				continue
+			}
+			return err
+		}
+		if c == nil {
+			continue
+		}
+		key := clientKey{c.Driver, c.PDev, c.ID}
+		if known, ok := clients[key]; ok {
+			c = known
+		} else {
+			clients[key] = c
+		}
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+		if comm == "" {
+			comm = readComm(dir)
+		}
+		c.Processes = append(c.Processes, Process{PID: pid, Comm: comm})
+	}
+	return nil
+}
+
+func readComm(dir string) string {
+	b, err := os.ReadFile(filepath.Join(dir, "comm"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(string(b), "\n")
+}
+
+// readFDInfo parses an fdinfo file, returning nil if it is not of a DRM
+// client.
+func readFDInfo(path string) (*Client, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c, err := parseFDInfo(bufio.NewScanner(f))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+func parseFDInfo(sc *bufio.Scanner) (*Client, error) {
+	c := Client{Engines: make(map[string]*Engine), Memory: make(map[string]*Memory)}
+	var hasID bool
+	for sc.Scan() {
+		key, value, ok := strings.Cut(sc.Text(), ":")
+		if !ok || !strings.HasPrefix(key, "drm-") {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if err := c.set(strings.TrimPrefix(key, "drm-"), value, &hasID); err != nil {
+			// A malformed value is skipped rather than hiding the other keys,
+			// and the other clients, from the sample.
+			continue
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if c.Driver == "" || !hasID {
+		return nil, nil
+	}
+	for _, e := range c.Engines {
+		if e.Capacity == 0 {
+			e.Capacity = 1
+		}
+	}
+	return &c, nil
+}
+
+// set sets the field of the fdinfo key, without its drm- prefix. Unknown keys
+// are ignored, as drivers may add their own. If the value is malformed, an error
+// is returned and the client is left unchanged.
+func (c *Client) set(key, value string, hasID *bool) error {
+	switch key {
+	case "driver":
+		c.Driver = value
+		return nil
+	case "pdev":
+		c.PDev = value
+		return nil
+	case "client-id":
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		c.ID, *hasID = id, true
+		return nil
+	case "client-name":
+		c.Name = value
+		return nil
+	}
+
+	// Longer prefixes go first, as drm-engine-capacity-<name> also has the
+	// prefix of drm-engine-<name>.
+	for _, f := range []struct {
+		prefix string
+		set    func(name, value string) error
+	}{
+		{"engine-capacity-", func(name, v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return err
+			}
+			c.engine(name).Capacity = n
+			return nil
+		}},
+		{"engine-", func(name, v string) error {
+			ns, err := parseUnit(v, map[string]uint64{"ns": 1})
+			if err != nil {
+				return err
+			}
+			c.engine(name).Busy = time.Duration(ns)
+			return nil
+		}},
+		{"total-cycles-", func(name, v string) error {
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				return err
+			}
+			c.engine(name).TotalCycles = n
+			return nil
+		}},
+		{"cycles-", func(name, v string) error {
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				return err
+			}
+			c.engine(name).Cycles = n
+			return nil
+		}},
+		{"maxfreq-", func(name, v string) error {
+			hz, err := parseUnit(v, map[string]uint64{"Hz": 1, "KHz": 1e3, "MHz": 1e6, "GHz": 1e9})
+			if err != nil {
+				return err
+			}
+			c.engine(name).MaxFreq = hz
+			return nil
+		}},
+		{"memory-", c.memory(func(m *Memory) *uint64 { return &m.Total })},
+		{"total-", c.memory(func(m *Memory) *uint64 { return &m.Total })},
+		{"shared-", c.memory(func(m *Memory) *uint64 { return &m.Shared })},
+		{"resident-", c.memory(func(m *Memory) *uint64 { return &m.Resident })},
+		{"purgeable-", c.memory(func(m *Memory) *uint64 { return &m.Purgeable })},
+		{"active-", c.memory(func(m *Memory) *uint64 { return &m.Active })},
+	} {
+		if name := strings.TrimPrefix(key, f.prefix); name != key && name != "" {
+			return f.set(name, value)
+		}
+	}
+	return nil
+}
+
+func (c *Client) engine(name string) *Engine {
+	e, ok := c.Engines[name]
+	if !ok {
+		e = new(Engine)
+		c.Engines[name] = e
+	}
+	return e
+}
+
+// memory returns a setter of the field of a region's Memory.
+func (c *Client) memory(field func(*Memory) *uint64) func(name, value string) error {
+	return func(name, value string) error {
+		n, err := parseUnit(value, map[string]uint64{"B": 1, "KiB": 1 << 10, "MiB": 1 << 20, "GiB": 1 << 30})
+		if err != nil {
+			return err
+		}
+		m, ok := c.Memory[name]
+		if !ok {
+			m = new(Memory)
+			c.Memory[name] = m
+		}
+		*field(m) = n
+		return nil
+	}
+}
+
+// parseUnit parses an unsigned integer followed by one of units, returning it
+// multiplied by the unit's factor. A value without a unit is taken as is.
+func parseUnit(s string, units map[string]uint64) (uint64, error) {
+	num, unit, _ := strings.Cut(s, " ")
+	n, err := strconv.ParseUint(num, 10, 64)
+	if err != nil || unit == "" {
+		return n, err
+	}
+	factor, ok := units[unit]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", unit)
+	}
+	return n * factor, nil
+}
@@ -0,0 +1,110 @@
+package usage
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func scan(t *testing.T, root string, at time.Time) *Sample {
+	t.Helper()
+	s, err := Scan(root)
+	if err != nil {
+		t.Fatalf("scan %s: %s", root, err)
+	}
+	s.Time = at
+	return s
+}
+
+func TestScan(t *testing.T) {
+	s := scan(t, "testdata/before", time.Time{})
+	var ids []uint64
+	for _, c := range s.Clients {
+		ids = append(ids, c.ID)
+	}
+	if want := []uint64{12, 7, 3, 4}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("clients: got %v, want %v", ids, want)
+	}
+
+	i915 := s.Clients[1]
+	if want := []Process{{100, "Xorg"}, {101, "Xorg-helper"}}; !reflect.DeepEqual(i915.Processes, want) {
+		t.Errorf("i915 processes: got %v, want %v", i915.Processes, want)
+	}
+	if i915.PDev != "0000:00:02.0" {
+		t.Errorf("i915 pdev: got %q", i915.PDev)
+	}
+	if want := (Engine{Busy: time.Second, Capacity: 1}); *i915.Engines["render"] != want {
+		t.Errorf("i915 render: got %+v, want %+v", *i915.Engines["render"], want)
+	}
+	if c := i915.Engines["video"].Capacity; c != 2 {
+		t.Errorf("i915 video capacity: got %d, want 2", c)
+	}
+	if want := (Memory{Total: 2 << 20, Resident: 1 << 20}); *i915.Memory["system0"] != want {
+		t.Errorf("i915 memory: got %+v, want %+v", *i915.Memory["system0"], want)
+	}
+
+	amdgpu := s.Clients[0]
+	if amdgpu.Memory["vram"].Total != 4<<20 || amdgpu.Memory["gtt"].Total != 512<<10 {
+		t.Errorf("amdgpu legacy memory: got vram %+v, gtt %+v", *amdgpu.Memory["vram"], *amdgpu.Memory["gtt"])
+	}
+	panfrost := s.Clients[2]
+	if want := (Engine{Cycles: 1000, MaxFreq: 800e6, Capacity: 1}); *panfrost.Engines["fragment"] != want {
+		t.Errorf("panfrost fragment: got %+v, want %+v", *panfrost.Engines["fragment"], want)
+	}
+	if name := s.Clients[3].Name; name != "compositor" {
+		t.Errorf("xe client name: got %q, want compositor", name)
+	}
+}
+
+func TestScanCorrupt(t *testing.T) {
+	s := scan(t, "testdata/corrupt", time.Time{})
+	// The fdinfo with an invalid client ID is not of a client, and the
+	// malformed values of the other client are skipped.
+	if len(s.Clients) != 2 || s.Clients[0].ID != 2 || s.Clients[1].ID != 1 {
+		t.Fatalf("clients: got %+v", s.Clients)
+	}
+	amdgpu, i915 := s.Clients[0], s.Clients[1]
+	if want := (Engine{Busy: 100, Capacity: 1}); !reflect.DeepEqual(amdgpu.Engines, map[string]*Engine{"gfx": &want}) {
+		t.Errorf("amdgpu engines: got %+v", amdgpu.Engines)
+	}
+	if want := (Memory{Total: 1 << 20}); !reflect.DeepEqual(amdgpu.Memory, map[string]*Memory{"gtt": &want}) {
+		t.Errorf("amdgpu memory: got %+v", amdgpu.Memory)
+	}
+	if want := []Process{{600, "good"}}; !reflect.DeepEqual(i915.Processes, want) {
+		t.Errorf("i915 processes: got %v, want %v", i915.Processes, want)
+	}
+	if i915.Engines["render"].Busy != 5000 {
+		t.Errorf("i915 render: got %+v", *i915.Engines["render"])
+	}
+}
+
+func TestUtilization(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	prev := scan(t, "testdata/before", start)
+	cur := scan(t, "testdata/after", start.Add(time.Second))
+
+	want := map[uint64]map[string]float64{
+		12: {"gfx": 0.9, "dec": 0},
+		7:  {"render": 0.5, "video": 0.125},
+		3:  {"fragment": 0.5},
+		4:  {"rcs": 0.25},
+	}
+	for _, u := range Utilization(prev, cur) {
+		for name, v := range u.Engines {
+			if w := want[u.Client.ID][name]; math.Abs(v-w) > 1e-9 {
+				t.Errorf("client %d engine %s: got %g, want %g", u.Client.ID, name, v, w)
+			}
+		}
+		if len(u.Engines) != len(want[u.Client.ID]) {
+			t.Errorf("client %d: got engines %v", u.Client.ID, u.Engines)
+		}
+	}
+
+	// A client that appeared since the previous sample is compared against
+	// zero usage.
+	u := Utilization(&Sample{Time: start}, cur)
+	if v := u[0].Engines["gfx"]; v != 1 {
+		t.Errorf("new client: got %g, want 1, clamped", v)
+	}
+}
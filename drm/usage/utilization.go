@@ -0,0 +1,83 @@
+package usage
+
+import "time"
+
+// Usage is how busy a client kept the engines of its device between two
+// samples.
+type Usage struct {
+	Client *Client
+	// Engines are the utilization of each engine, from 0 to 1, where 1 means
+	// the client kept every engine of the type busy.
+	Engines map[string]float64
+}
+
+// Max returns the utilization of the client's busiest engine.
+func (u *Usage) Max() float64 {
+	var max float64
+	for _, v := range u.Engines {
+		if v > max {
+			max = v
+		}
+	}
+	return max
+}
+
+// Utilization returns the usage of each client of cur between the samples. For
+// engines reporting busy time, utilization is the busy time divided by the time
+// between the samples and the engine's capacity. For those reporting cycles,
+// it is the cycles divided by the total cycles, or by the cycles the maximum
+// frequency allows if there is no total. Clients new in cur are compared
+// against zero usage.
+func Utilization(prev, cur *Sample) []Usage {
+	before := make(map[clientKey]*Client, len(prev.Clients))
+	for _, c := range prev.Clients {
+		before[clientKey{c.Driver, c.PDev, c.ID}] = c
+	}
+	elapsed := cur.Time.Sub(prev.Time)
+
+	usage := make([]Usage, 0, len(cur.Clients))
+	for _, c := range cur.Clients {
+		u := Usage{Client: c, Engines: make(map[string]float64, len(c.Engines))}
+		old := before[clientKey{c.Driver, c.PDev, c.ID}]
+		for name, e := range c.Engines {
+			var was Engine
+			if old != nil && old.Engines[name] != nil {
+				was = *old.Engines[name]
+			}
+			u.Engines[name] = utilization(&was, e, elapsed)
+		}
+		usage = append(usage, u)
+	}
+	return usage
+}
+
+func utilization(prev, cur *Engine, elapsed time.Duration) float64 {
+	var u float64
+	switch {
+	case cur.Busy > 0 || cur.Cycles == 0:
+		if elapsed <= 0 || cur.Busy < prev.Busy {
+			return 0
+		}
+		capacity := cur.Capacity
+		if capacity < 1 {
+			capacity = 1
+		}
+		u = float64(cur.Busy-prev.Busy) / float64(elapsed) / float64(capacity)
+	case cur.TotalCycles > 0:
+		if cur.TotalCycles <= prev.TotalCycles || cur.Cycles < prev.Cycles {
+			return 0
+		}
+		u = float64(cur.Cycles-prev.Cycles) / float64(cur.TotalCycles-prev.TotalCycles)
+	case cur.MaxFreq > 0:
+		if elapsed <= 0 || cur.Cycles < prev.Cycles {
+			return 0
+		}
+		u = float64(cur.Cycles-prev.Cycles) / (float64(cur.MaxFreq) * elapsed.Seconds())
+	}
+	// Counters and clocks are not read at the same instant, which can make
+	// the ratio exceed 1 slightly.
+	if u > 1 {
+		u = 1
+	}
+	return u
+}
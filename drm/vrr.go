@@ -0,0 +1,132 @@
+package drm
+
+import (
+	"fmt"
+	"time"
+)
+
+// VRRCapable returns whether the sink attached to a connector supports variable
+// refresh rate, i.e. adaptive sync.
+func (c *Card) VRRCapable(connectorID uint32) (bool, error) {
+	props, err := c.ObjectProperties(connectorID, ModeObjectConnector)
+	if err != nil {
+		return false, err
+	}
+	prop, ok := props["vrr_capable"]
+	return ok && prop.Value != 0, nil
+}
+
+// VRREnabled returns whether variable refresh rate is enabled on a CRTC.
+func (c *Card) VRREnabled(crtcID uint32) (bool, error) {
+	props, err := c.ObjectProperties(crtcID, ModeObjectCrtc)
+	if err != nil {
+		return false, err
+	}
+	prop, ok := props["VRR_ENABLED"]
+	return ok && prop.Value != 0, nil
+}
+
+// SetVRR enables or disables variable refresh rate on a CRTC. It only takes
+// effect if the connectors driven by the CRTC are VRRCapable.
+func (c *Card) SetVRR(crtcID uint32, enabled bool) error {
+	props, err := c.ObjectProperties(crtcID, ModeObjectCrtc)
+	if err != nil {
+		return err
+	}
+	id, err := props.ID("VRR_ENABLED")
+	if err != nil {
+		return fmt.Errorf("crtc %d: %w", crtcID, err)
+	}
+	var value uint64
+	if enabled {
+		value = 1
+	}
+	return c.ModeObjSetProperty(crtcID, ModeObjectCrtc, id, value)
+}
+
+// Presenter flips framebuffers on a CRTC and waits for each flip to complete.
+// With variable refresh rate enabled, frames can be presented at irregular
+// intervals, which the presenter keeps within the refresh range of the display.
+type Presenter struct {
+	card   *Card
+	crtcID uint32
+
+	minInterval time.Duration
+	maxInterval time.Duration
+	last        time.Time
+
+	// pending holds the events read along with the flips that were not theirs.
+	pending []Event
+}
+
+// NewPresenter returns a presenter for the given CRTC, which must already be
+// driving a framebuffer, e.g. after ModeSetCRTC.
+func (c *Card) NewPresenter(crtcID uint32) *Presenter {
+	return &Presenter{card: c, crtcID: crtcID}
+}
+
+// SetRefreshRange limits how often frames are presented to maxHz, e.g. the upper
+// bound of the display's VRR range. Frames presented later than 1/minHz after
+// the previous one cause the display to repeat the previous frame, so callers
+// should present at least that often.
+func (p *Presenter) SetRefreshRange(minHz, maxHz float64) {
+	p.minInterval, p.maxInterval = 0, 0
+	if maxHz > 0 {
+		p.minInterval = time.Duration(float64(time.Second) / maxHz)
+	}
+	if minHz > 0 {
+		p.maxInterval = time.Duration(float64(time.Second) / minHz)
+	}
+}
+
+// Deadline returns the latest time at which the next frame should be presented
+// to stay within the refresh range, or the zero time if there is no lower bound.
+func (p *Presenter) Deadline() time.Time {
+	if p.maxInterval == 0 || p.last.IsZero() {
+		return time.Time{}
+	}
+	return p.last.Add(p.maxInterval)
+}
+
+// Present flips to the framebuffer at the given time, or as soon as possible
+// after it if that is too soon after the previous frame. It blocks until the flip
+// has completed, and returns the flip completion event. Other events read from
+// the card meanwhile, e.g. flips of other CRTCs, are kept for Events.
+func (p *Presenter) Present(fbID uint32, at time.Time) (*Event, error) {
+	if earliest := p.last.Add(p.minInterval); at.Before(earliest) {
+		at = earliest
+	}
+	if d := time.Until(at); d > 0 {
+		time.Sleep(d)
+	}
+
+	if err := p.card.ModePageFlip(p.crtcID, fbID, ModePageFlipEvent, uint64(p.crtcID)); err != nil {
+		return nil, err
+	}
+	for {
+		events, err := p.card.ReadEvents()
+		if err != nil {
+			return nil, err
+		}
+		for i, event := range events {
+			// Older kernels do not report the CRTC, so also match on user data.
+			if event.Type == EventFlipComplete &&
+				(event.CRTCID == p.crtcID || event.UserData == uint64(p.crtcID)) {
+				p.pending = append(p.pending, events[i+1:]...)
+				// Event times are on CLOCK_MONOTONIC, so pace by the local clock.
+				p.last = time.Now()
+				return &event, nil
+			}
+			p.pending = append(p.pending, event)
+		}
+	}
+}
+
+// Events returns the events of the card read by Present that were not the
+// flips it waited for, and forgets them. Callers sharing the card with a
+// presenter should handle them as if they had read them with ReadEvents.
+func (p *Presenter) Events() []Event {
+	ret := p.pending
+	p.pending = nil
+	return ret
+}
@@ -0,0 +1,100 @@
+package drm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetVRR(t *testing.T) {
+	s := newFakeSetup(t)
+	if ok, err := s.card.VRRCapable(s.connector); ok || err != nil {
+		t.Errorf("connector without vrr_capable is capable: %v, %v", ok, err)
+	}
+	if err := s.card.SetVRR(s.crtc, true); err == nil {
+		t.Error("VRR enabled on a CRTC without VRR_ENABLED")
+	}
+
+	s.dev.AddProperty(s.connector, ModeProperty{Name: "vrr_capable", Flags: ModePropRange | ModePropImmutable,
+		Values: []uint64{0, 1}}, 1)
+	s.dev.AddProperty(s.crtc, ModeProperty{Name: "VRR_ENABLED", Flags: ModePropRange,
+		Values: []uint64{0, 1}}, 0)
+	if ok, err := s.card.VRRCapable(s.connector); !ok || err != nil {
+		t.Errorf("connector not capable: %v, %v", ok, err)
+	}
+	for _, enabled := range []bool{true, false} {
+		if err := s.card.SetVRR(s.crtc, enabled); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := s.card.VRREnabled(s.crtc); ok != enabled || err != nil {
+			t.Errorf("VRR enabled is %v, %v, want %v", ok, err, enabled)
+		}
+	}
+}
+
+func TestPresenter(t *testing.T) {
+	s := newFakeSetup(t)
+	s.modeset(t, s.framebuffer(t, 1920, 1080))
+	p := s.card.NewPresenter(s.crtc)
+	p.SetRefreshRange(48, 100)
+	if !p.Deadline().IsZero() {
+		t.Errorf("deadline %v before the first frame", p.Deadline())
+	}
+
+	fbs := []uint32{s.framebuffer(t, 1920, 1080), s.framebuffer(t, 1920, 1080)}
+	ev, err := p.Present(fbs[0], time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := time.Now()
+	if ev.Type != EventFlipComplete || ev.CRTCID != s.crtc {
+		t.Errorf("unexpected event %+v", ev)
+	}
+	if d := p.Deadline().Sub(first); d <= 0 || d > time.Second/48 {
+		t.Errorf("deadline %v after the first frame", d)
+	}
+
+	// Frames are not presented faster than the maximum refresh rate.
+	if _, err := p.Present(fbs[1], time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(first); d < time.Second/100-time.Millisecond {
+		t.Errorf("second frame presented after %v", d)
+	}
+	crtc, err := s.card.ModeGetCRTC(s.crtc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crtc.FBID != fbs[1] {
+		t.Errorf("crtc shows framebuffer %d, want %d", crtc.FBID, fbs[1])
+	}
+}
+
+func TestPresenterKeepsEvents(t *testing.T) {
+	s := newFakeSetup(t)
+	s.modeset(t, s.framebuffer(t, 1920, 1080))
+	crtc2 := s.dev.AddCRTC()
+	_, conn2 := s.dev.AddOutput(ModeConnectorHDMIA, []uint32{crtc2})
+	s.dev.Plug(conn2, []ModeInfo{FakeMode(1920, 1080, 60)}, 600, 340, nil)
+	mode := FakeMode(1920, 1080, 60)
+	set := ModeCRTC{cModeCRTC: cModeCRTC{ID: crtc2, FBID: s.framebuffer(t, 1920, 1080), ModeValid: 1,
+		cModeInfo: mode.cModeInfo}, Name: mode.Name, SetConnectors: []uint32{conn2}}
+	if err := s.card.ModeSetCRTC(set); err != nil {
+		t.Fatal(err)
+	}
+
+	// The flip of the other CRTC is read along with that of the presenter.
+	if err := s.card.ModePageFlip(crtc2, s.framebuffer(t, 1920, 1080), ModePageFlipEvent, 7); err != nil {
+		t.Fatal(err)
+	}
+	p := s.card.NewPresenter(s.crtc)
+	if _, err := p.Present(s.framebuffer(t, 1920, 1080), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	events := p.Events()
+	if len(events) != 1 || events[0].CRTCID != crtc2 || events[0].UserData != 7 {
+		t.Errorf("kept events %+v", events)
+	}
+	if events := p.Events(); len(events) != 0 {
+		t.Errorf("events %+v kept twice", events)
+	}
+}
@@ -0,0 +1,177 @@
+package drm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"time"
+)
+
+// Writeback captures the composited output of a CRTC into memory through a
+// writeback connector, i.e. a connector of type ModeConnectorWriteback. The card
+// must have ClientCapAtomic and ClientCapWritebackConnectors set.
+type Writeback struct {
+	card  *Card
+	props Properties
+
+	ConnectorID uint32
+	// Formats lists the pixel formats the connector can write.
+	Formats []uint32
+}
+
+// NewWriteback prepares the given writeback connector for capturing.
+func (c *Card) NewWriteback(connectorID uint32) (*Writeback, error) {
+	props, err := c.ObjectProperties(connectorID, ModeObjectConnector)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"CRTC_ID", "WRITEBACK_FB_ID", "WRITEBACK_OUT_FENCE_PTR",
+		"WRITEBACK_PIXEL_FORMATS"} {
+		if _, ok := props[name]; !ok {
+			return nil, fmt.Errorf("connector %d is not a writeback connector: no property %s",
+				connectorID, name)
+		}
+	}
+
+	ret := Writeback{card: c, props: props, ConnectorID: connectorID}
+	if blobID := props["WRITEBACK_PIXEL_FORMATS"].Value; blobID != 0 {
+		blob, err := c.ModeGetBlob(uint32(blobID))
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i+4 <= len(blob.Data); i += 4 {
+			ret.Formats = append(ret.Formats, binary.LittleEndian.Uint32(blob.Data[i:]))
+		}
+	}
+	return &ret, nil
+}
+
+// Attach adds properties to req that route the CRTC to the writeback connector
+// and write its next frame into the given framebuffer. The returned fence
+// signals once the frame has been written, after req has been committed. If the
+// connector is not yet attached to the CRTC, the commit needs
+// ModeAtomicAllowModeset.
+func (w *Writeback) Attach(req *AtomicRequest, crtcID, fbID uint32) *OutFence {
+	fence := newOutFence()
+	req.AddProperty(w.ConnectorID, w.props["CRTC_ID"].PropID, uint64(crtcID))
+	req.AddProperty(w.ConnectorID, w.props["WRITEBACK_FB_ID"].PropID, uint64(fbID))
+	req.AddProperty(w.ConnectorID, w.props["WRITEBACK_OUT_FENCE_PTR"].PropID, fence.ptr())
+	return fence
+}
+
+// Detach disconnects the writeback connector from its CRTC.
+func (w *Writeback) Detach() error {
+	var req AtomicRequest
+	req.AddProperty(w.ConnectorID, w.props["CRTC_ID"].PropID, 0)
+	return w.card.ModeAtomicCommit(&req, ModeAtomicAllowModeset, 0)
+}
+
+// Capture writes the next frame of the CRTC into a new buffer of the given pixel
+// format, and returns it as an image. The CRTC must be active, and the card must
+// have been opened for writing so that the buffer can be mapped. The connector
+// remains attached to the CRTC so that subsequent captures avoid a modeset; call
+// Detach when done.
+func (w *Writeback) Capture(crtcID, format uint32, timeout time.Duration) (image.Image, error) {
+	if !w.supports(format) {
+		return nil, fmt.Errorf("writeback connector %d does not support format %s",
+			w.ConnectorID, FormatString(format))
+	}
+	info := LookupFormat(format)
+	if _, ok := frameLayout(format); !ok || info == nil {
+		return nil, fmt.Errorf("cannot decode format %s", FormatString(format))
+	}
+
+	crtc, err := w.card.ModeGetCRTC(crtcID)
+	if err != nil {
+		return nil, err
+	}
+	if crtc.ModeValid == 0 {
+		return nil, fmt.Errorf("crtc %d is not active", crtcID)
+	}
+	width, height := uint32(crtc.HDisplay), uint32(crtc.VDisplay)
+
+	dumb, err := w.card.ModeCreateDumb(height, width, info.BPP())
+	if err != nil {
+		return nil, err
+	}
+	defer w.card.ModeDestroyDumb(dumb.Handle)
+
+	fb, err := w.card.ModeAddFramebuffer2(width, height, format, 0,
+		[4]uint32{dumb.Handle}, [4]uint32{dumb.Pitch}, [4]uint32{}, [4]uint64{})
+	if err != nil {
+		return nil, err
+	}
+	defer w.card.ModeRemoveFramebuffer(fb.ID)
+
+	mem, err := w.card.MmapDumb(dumb)
+	if err != nil {
+		return nil, err
+	}
+	defer w.card.Munmap(mem)
+
+	var req AtomicRequest
+	fence := w.Attach(&req, crtcID, fb.ID)
+	if err := w.card.ModeAtomicCommit(&req, ModeAtomicAllowModeset, 0); err != nil {
+		return nil, err
+	}
+	defer fence.Close()
+	if err := fence.Wait(timeout); err != nil {
+		return nil, err
+	}
+	return decodeFrame(mem, int(dumb.Pitch), int(width), int(height), format)
+}
+
+func (w *Writeback) supports(format uint32) bool {
+	for _, f := range w.Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// frameLayout returns the byte offsets of the red, green, blue and alpha
+// channels of a pixel of the 32 bit RGB formats decodeFrame decodes, and false
+// for other formats.
+func frameLayout(format uint32) ([4]int, bool) {
+	switch format {
+	case FormatXRGB8888, FormatARGB8888:
+		return [4]int{2, 1, 0, 3}, true
+	case FormatXBGR8888, FormatABGR8888:
+		return [4]int{0, 1, 2, 3}, true
+	case FormatRGBX8888, FormatRGBA8888:
+		return [4]int{3, 2, 1, 0}, true
+	case FormatBGRX8888, FormatBGRA8888:
+		return [4]int{1, 2, 3, 0}, true
+	}
+	return [4]int{}, false
+}
+
+// decodeFrame copies a 32 bit RGB frame into an image.
+func decodeFrame(mem []byte, pitch, width, height int, format uint32) (image.Image, error) {
+	layout, ok := frameLayout(format)
+	if !ok {
+		return nil, fmt.Errorf("cannot decode format %s", FormatString(format))
+	}
+	r, g, b, a := layout[0], layout[1], layout[2], layout[3]
+	if len(mem) < pitch*height {
+		return nil, errors.New("frame is smaller than its dimensions")
+	}
+
+	alpha := LookupFormat(format).HasAlpha
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		row := mem[y*pitch:]
+		for x := 0; x < width; x++ {
+			px := row[x*4 : x*4+4]
+			c := color.NRGBA{R: px[r], G: px[g], B: px[b], A: 0xff}
+			if alpha {
+				c.A = px[a]
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img, nil
+}
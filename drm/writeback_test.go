@@ -0,0 +1,171 @@
+package drm
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeback adds a writeback connector for the CRTC of s, and sets the client
+// caps it needs.
+func (s *fakeSetup) writeback(t *testing.T, formats ...uint32) *Writeback {
+	t.Helper()
+	wb := s.dev.AddWriteback([]uint32{s.crtc}, formats)
+	for _, c := range []uint64{ClientCapAtomic, ClientCapWritebackConnectors} {
+		if err := s.card.SetClientCap(c, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w, err := s.card.NewWriteback(wb)
+	if err != nil {
+		t.Fatalf("new writeback: %s", err)
+	}
+	return w
+}
+
+// paint fills the dumb buffer of an XRGB8888 framebuffer with a color per
+// pixel, returning the color of x, y.
+func (s *fakeSetup) paint(t *testing.T, fbID uint32) func(x, y int) [3]uint8 {
+	t.Helper()
+	fb, err := s.card.ModeGetFramebuffer(fbID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem, ok := s.dev.Dumb(fb.Handle)
+	if !ok {
+		t.Fatalf("framebuffer %d has no dumb buffer", fbID)
+	}
+	color := func(x, y int) [3]uint8 { return [3]uint8{uint8(x), uint8(y), uint8(x ^ y)} }
+	for y := 0; y < int(fb.Height); y++ {
+		for x := 0; x < int(fb.Width); x++ {
+			c := color(x, y)
+			binary.LittleEndian.PutUint32(mem[y*int(fb.Pitch)+4*x:],
+				uint32(c[0])<<16|uint32(c[1])<<8|uint32(c[2]))
+		}
+	}
+	return color
+}
+
+func TestWritebackCapture(t *testing.T) {
+	s := newFakeSetup(t)
+	fb := s.framebuffer(t, 1920, 1080)
+	color := s.paint(t, fb)
+	s.modeset(t, fb)
+	w := s.writeback(t, FormatXRGB8888)
+	if len(w.Formats) != 1 || w.Formats[0] != FormatXRGB8888 {
+		t.Errorf("formats are %v", w.Formats)
+	}
+
+	dumbs, fbs := len(s.dev.dumbs), len(s.dev.fbs)
+	img, err := w.Capture(s.crtc, FormatXRGB8888, time.Second)
+	if err != nil {
+		t.Fatalf("capture: %s", err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 1920, 1080) {
+		t.Fatalf("captured %v", img.Bounds())
+	}
+	for _, p := range []image.Point{{0, 0}, {1, 0}, {0, 1}, {300, 200}, {1919, 1079}} {
+		r, g, b, a := img.At(p.X, p.Y).RGBA()
+		want := color(p.X, p.Y)
+		if got := [3]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)}; got != want || a != 0xffff {
+			t.Errorf("pixel %v is %v, alpha %#x, want %v", p, got, a, want)
+		}
+	}
+	// The capture buffer is released, and the connector stays attached.
+	if len(s.dev.dumbs) != dumbs || len(s.dev.fbs) != fbs {
+		t.Errorf("capture left %d dumb buffers and %d framebuffers, want %d and %d",
+			len(s.dev.dumbs), len(s.dev.fbs), dumbs, fbs)
+	}
+	if crtc, _ := s.dev.Property(w.ConnectorID, "CRTC_ID"); crtc != uint64(s.crtc) {
+		t.Errorf("writeback connector is on crtc %d", crtc)
+	}
+	if err := w.Detach(); err != nil {
+		t.Fatalf("detach: %s", err)
+	}
+	if crtc, _ := s.dev.Property(w.ConnectorID, "CRTC_ID"); crtc != 0 {
+		t.Errorf("detached connector is on crtc %d", crtc)
+	}
+}
+
+func TestWritebackFence(t *testing.T) {
+	s := newFakeSetup(t)
+	fb := s.framebuffer(t, 1920, 1080)
+	color := s.paint(t, fb)
+	s.modeset(t, fb)
+	w := s.writeback(t, FormatXRGB8888)
+
+	out := s.framebuffer(t, 1920, 1080)
+	info, err := s.card.ModeGetFramebuffer(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem, _ := s.dev.Dumb(info.Handle)
+
+	// Until the hardware has written the frame, the fence does not signal and
+	// the buffer holds nothing.
+	s.dev.HoldWriteback(true)
+	var req AtomicRequest
+	fence := w.Attach(&req, s.crtc, out)
+	if err := s.card.ModeAtomicCommit(&req, ModeAtomicAllowModeset, 0); err != nil {
+		t.Fatalf("commit: %s", err)
+	}
+	defer fence.Close()
+	if fence.Fd() < 0 {
+		t.Fatal("commit did not fill in the fence")
+	}
+	if err := fence.Wait(10 * time.Millisecond); !errors.Is(err, ErrFenceTimeout) {
+		t.Fatalf("wait on a pending writeback returned %v", err)
+	}
+	if binary.LittleEndian.Uint32(mem[4*300:]) != 0 {
+		t.Error("pending writeback wrote the buffer")
+	}
+
+	s.dev.HoldWriteback(false)
+	if err := fence.Wait(time.Second); err != nil {
+		t.Fatalf("wait: %s", err)
+	}
+	want := color(300, 0)
+	if got := binary.LittleEndian.Uint32(mem[4*300:]); got != uint32(want[0])<<16|uint32(want[1])<<8|uint32(want[2]) {
+		t.Errorf("pixel 300,0 is %#x, want %v", got, want)
+	}
+
+	// The job is not kept in the connector's state, and a fence needs one.
+	if v, _ := s.dev.Property(w.ConnectorID, "WRITEBACK_FB_ID"); v != 0 {
+		t.Errorf("WRITEBACK_FB_ID is %d after the commit", v)
+	}
+	req = AtomicRequest{}
+	stray := newOutFence()
+	req.AddProperty(w.ConnectorID, w.props["WRITEBACK_OUT_FENCE_PTR"].PropID, stray.ptr())
+	if err := s.card.ModeAtomicCommit(&req, 0, 0); err == nil {
+		t.Error("fence without a writeback framebuffer committed")
+	}
+
+	// Captures give up on fences that do not signal.
+	s.dev.HoldWriteback(true)
+	defer s.dev.HoldWriteback(false)
+	if _, err := w.Capture(s.crtc, FormatXRGB8888, 10*time.Millisecond); !errors.Is(err, ErrFenceTimeout) {
+		t.Errorf("capture of a pending writeback returned %v", err)
+	}
+}
+
+func TestWritebackRejectsUndecodableFormat(t *testing.T) {
+	s := newFakeSetup(t)
+	s.modeset(t, s.framebuffer(t, 1920, 1080))
+	w := s.writeback(t, FormatRGB565, FormatXRGB8888)
+	dumbs := len(s.dev.dumbs)
+	_, err := w.Capture(s.crtc, FormatRGB565, time.Second)
+	if err == nil || !strings.Contains(err.Error(), "cannot decode") {
+		t.Fatalf("capture of RGB565 returned %v", err)
+	}
+	// The format is rejected before a buffer is made and the connector
+	// attached.
+	if len(s.dev.dumbs) != dumbs {
+		t.Error("capture created a dumb buffer")
+	}
+	if crtc, _ := s.dev.Property(w.ConnectorID, "CRTC_ID"); crtc != 0 {
+		t.Errorf("capture attached the connector to crtc %d", crtc)
+	}
+}
@@ -0,0 +1,462 @@
+package dbus
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Conn is a connection to a message bus, or to a peer. Its methods may be
+// called concurrently.
+type Conn struct {
+	uc *net.UnixConn
+	// name is the unique name the bus assigned the connection.
+	name string
+
+	// wmu serializes writes, so that serials are sent in order.
+	wmu    sync.Mutex
+	serial uint32
+
+	mu      sync.Mutex
+	pending map[uint32]chan *Message
+	// queue holds the incoming messages not yet received from incoming, which
+	// wake signals.
+	queue []*Message
+	wake  chan struct{}
+	// err is why the connection ended, once it has.
+	err error
+
+	incoming chan *Message
+	done     chan struct{}
+}
+
+// DefaultSystemBusAddress is the address of the system bus, unless
+// DBUS_SYSTEM_BUS_ADDRESS is set.
+const DefaultSystemBusAddress = "unix:path=/run/dbus/system_bus_socket"
+
+// maxFDs bounds the descriptors received with a single read.
+const maxFDs = 16
+
+// SystemBus connects to the system bus.
+func SystemBus() (*Conn, error) {
+	addr := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS")
+	if addr == "" {
+		addr = DefaultSystemBusAddress
+	}
+	return Dial(addr)
+}
+
+// Dial connects to the bus at a D-Bus address, e.g.
+// "unix:path=/run/dbus/system_bus_socket", authenticates as the process's user,
+// and registers with the bus. Of several addresses separated by semicolons,
+// the first that can be connected to is used. Only Unix sockets are supported.
+func Dial(address string) (*Conn, error) {
+	var err error
+	for _, addr := range strings.Split(address, ";") {
+		var raddr *net.UnixAddr
+		if raddr, err = parseAddress(addr); err != nil {
+			continue
+		}
+		var uc *net.UnixConn
+		if uc, err = net.DialUnix("unix", nil, raddr); err != nil {
+			continue
+		}
+		var c *Conn
+		if c, err = connect(uc); err != nil {
+			uc.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+	if err == nil {
+		err = fmt.Errorf("no address in %q", address)
+	}
+	return nil, err
+}
+
+// connect authenticates on a new connection to a bus, and registers with it.
+func connect(uc *net.UnixConn) (*Conn, error) {
+	if err := authenticate(uc); err != nil {
+		return nil, fmt.Errorf("authenticate: %w", err)
+	}
+	c := newConn(uc)
+	reply, err := c.Call(context.Background(), "org.freedesktop.DBus", "/org/freedesktop/DBus",
+		"org.freedesktop.DBus", "Hello", "")
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("hello: %w", err)
+	}
+	if len(reply) > 0 {
+		c.name, _ = reply[0].(string)
+	}
+	return c, nil
+}
+
+// parseAddress parses a D-Bus address of the unix transport.
+func parseAddress(addr string) (*net.UnixAddr, error) {
+	transport, params, _ := strings.Cut(addr, ":")
+	if transport != "unix" {
+		return nil, fmt.Errorf("unsupported transport in address %q", addr)
+	}
+	for _, param := range strings.Split(params, ",") {
+		key, value, _ := strings.Cut(param, "=")
+		value, err := url.PathUnescape(value)
+		if err != nil {
+			return nil, fmt.Errorf("address %q: %w", addr, err)
+		}
+		switch key {
+		case "path":
+			return &net.UnixAddr{Name: value, Net: "unix"}, nil
+		case "abstract":
+			return &net.UnixAddr{Name: "@" + value, Net: "unix"}, nil
+		}
+	}
+	return nil, fmt.Errorf("no path in address %q", addr)
+}
+
+// authenticate performs the client side of the authentication, with the
+// EXTERNAL mechanism, and negotiates passing descriptors.
+func authenticate(uc *net.UnixConn) error {
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err := uc.Write([]byte("\x00AUTH EXTERNAL " + uid + "\r\n")); err != nil {
+		return err
+	}
+	line, err := readLine(uc)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		return fmt.Errorf("rejected: %q", line)
+	}
+	if _, err := uc.Write([]byte("NEGOTIATE_UNIX_FD\r\n")); err != nil {
+		return err
+	}
+	if line, err = readLine(uc); err != nil {
+		return err
+	}
+	if line != "AGREE_UNIX_FD" {
+		return fmt.Errorf("passing descriptors refused: %q", line)
+	}
+	_, err = uc.Write([]byte("BEGIN\r\n"))
+	return err
+}
+
+// Accept performs the server side of the authentication on a connection from
+// a client, e.g. to serve a private bus in tests, and returns the connection.
+// The client is not asked to prove who it is.
+func Accept(uc *net.UnixConn) (*Conn, error) {
+	var nul [1]byte
+	if _, err := uc.Read(nul[:]); err != nil {
+		return nil, err
+	}
+	for authenticated := false; ; {
+		line, err := readLine(uc)
+		if err != nil {
+			return nil, err
+		}
+		var reply string
+		switch {
+		case strings.HasPrefix(line, "AUTH EXTERNAL"):
+			authenticated = true
+			reply = "OK " + hex.EncodeToString(make([]byte, 16))
+		case strings.HasPrefix(line, "AUTH"):
+			reply = "REJECTED EXTERNAL"
+		case line == "NEGOTIATE_UNIX_FD" && authenticated:
+			reply = "AGREE_UNIX_FD"
+		case line == "BEGIN" && authenticated:
+			return newConn(uc), nil
+		default:
+			reply = "ERROR"
+		}
+		if _, err := uc.Write([]byte(reply + "\r\n")); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readLine reads a line of the authentication, a byte at a time so as not to
+// read past it.
+func readLine(uc *net.UnixConn) (string, error) {
+	var line []byte
+	var b [1]byte
+	for !strings.HasSuffix(string(line), "\r\n") {
+		if len(line) > 4096 {
+			return "", errors.New("authentication line too long")
+		}
+		if _, err := uc.Read(b[:]); err != nil {
+			return "", err
+		}
+		line = append(line, b[0])
+	}
+	return string(line[:len(line)-2]), nil
+}
+
+func newConn(uc *net.UnixConn) *Conn {
+	c := &Conn{
+		uc:       uc,
+		pending:  make(map[uint32]chan *Message),
+		wake:     make(chan struct{}, 1),
+		incoming: make(chan *Message),
+		done:     make(chan struct{}),
+	}
+	go c.read()
+	go c.forward()
+	return c
+}
+
+// Name returns the unique name the bus assigned the connection.
+func (c *Conn) Name() string {
+	return c.name
+}
+
+// Incoming returns the channel of the signals and method calls received, which
+// is closed once the connection ends. Messages are queued until they are
+// received from it, so that receiving them late never holds up replies.
+func (c *Conn) Incoming() <-chan *Message {
+	return c.incoming
+}
+
+// Close closes the connection. Pending calls fail.
+func (c *Conn) Close() error {
+	return c.uc.Close()
+}
+
+// Call calls a method, and returns the values of its reply. An error reply is
+// returned as an *Error.
+func (c *Conn) Call(ctx context.Context, dest string, path ObjectPath, iface, member string, sig Signature, args ...interface{}) ([]interface{}, error) {
+	m := &Message{Type: TypeMethodCall, Destination: dest, Path: path, Interface: iface,
+		Member: member, Signature: sig, Body: args}
+	ch := make(chan *Message, 1)
+	serial, err := c.send(m, ch)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case reply, ok := <-ch:
+		if !ok {
+			return nil, c.closeErr()
+		}
+		if reply.Type == TypeError {
+			e := &Error{Name: reply.ErrorName}
+			if len(reply.Body) > 0 {
+				e.Message, _ = reply.Body[0].(string)
+			}
+			return nil, e
+		}
+		return reply.Body, nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, serial)
+		c.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// AddMatch asks the bus to route messages matching a rule to the connection,
+// e.g. "type='signal',sender='org.freedesktop.login1'".
+func (c *Conn) AddMatch(ctx context.Context, rule string) error {
+	_, err := c.Call(ctx, "org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus",
+		"AddMatch", "s", rule)
+	return err
+}
+
+// Emit sends a signal.
+func (c *Conn) Emit(path ObjectPath, iface, member string, sig Signature, args ...interface{}) error {
+	_, err := c.send(&Message{Type: TypeSignal, Path: path, Interface: iface, Member: member,
+		Signature: sig, Body: args}, nil)
+	return err
+}
+
+// Send sends a message as is but for its serial, e.g. a signal with the
+// Sender a bus would set, from a connection standing in for the bus.
+func (c *Conn) Send(m *Message) error {
+	_, err := c.send(m, nil)
+	return err
+}
+
+// Reply replies to a method call with values.
+func (c *Conn) Reply(call *Message, sig Signature, args ...interface{}) error {
+	_, err := c.send(&Message{Type: TypeMethodReturn, ReplySerial: call.Serial,
+		Destination: call.Sender, Signature: sig, Body: args}, nil)
+	return err
+}
+
+// ReplyError replies to a method call with an error.
+func (c *Conn) ReplyError(call *Message, name, message string) error {
+	_, err := c.send(&Message{Type: TypeError, ReplySerial: call.Serial, Destination: call.Sender,
+		ErrorName: name, Signature: "s", Body: []interface{}{message}}, nil)
+	return err
+}
+
+// send sends a message with the next serial, which it returns. If reply is
+// not nil, it receives the reply to the message.
+func (c *Conn) send(m *Message, reply chan *Message) (uint32, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.serial++
+	if c.serial == 0 {
+		c.serial++
+	}
+	m.Serial = c.serial
+	buf, fds, err := m.marshal()
+	if err != nil {
+		return 0, err
+	}
+	if reply != nil {
+		c.mu.Lock()
+		if c.err != nil {
+			c.mu.Unlock()
+			return 0, c.err
+		}
+		c.pending[m.Serial] = reply
+		c.mu.Unlock()
+	}
+
+	var oob []byte
+	if len(fds) > 0 {
+		oob = syscall.UnixRights(fds...)
+	}
+	for len(buf) > 0 {
+		n, _, err := c.uc.WriteMsgUnix(buf, oob, nil)
+		if err != nil {
+			if reply != nil {
+				c.mu.Lock()
+				delete(c.pending, m.Serial)
+				c.mu.Unlock()
+			}
+			return 0, err
+		}
+		buf, oob = buf[n:], nil
+	}
+	return m.Serial, nil
+}
+
+func (c *Conn) closeErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// read reads messages until the connection ends, and routes replies to their
+// calls and the rest to incoming.
+func (c *Conn) read() {
+	var (
+		buf []byte
+		// fds holds the descriptors received and not yet used by a message.
+		fds []int
+		b   = make([]byte, 4096)
+		oob = make([]byte, syscall.CmsgSpace(maxFDs*4))
+		err error
+	)
+	for err == nil {
+		var n, oobn int
+		n, oobn, _, _, err = c.uc.ReadMsgUnix(b, oob)
+		if oobn > 0 {
+			fds = append(fds, parseRights(oob[:oobn])...)
+		}
+		if n <= 0 {
+			if err == nil {
+				err = io.EOF
+			}
+			break
+		}
+		buf = append(buf, b[:n]...)
+		for err == nil {
+			var size int
+			if size, err = messageSize(buf); err != nil || size == 0 || len(buf) < size {
+				break
+			}
+			var m *Message
+			var nfds int
+			if m, nfds, err = unmarshal(buf[:size], fds); err != nil {
+				break
+			}
+			buf, fds = buf[size:], fds[nfds:]
+			c.dispatch(m)
+		}
+	}
+	for _, fd := range fds {
+		syscall.Close(fd)
+	}
+
+	c.mu.Lock()
+	if errors.Is(err, net.ErrClosed) || err == io.EOF {
+		err = errors.New("connection closed")
+	}
+	c.err = err
+	for serial, ch := range c.pending {
+		close(ch)
+		delete(c.pending, serial)
+	}
+	c.mu.Unlock()
+	close(c.done)
+}
+
+// parseRights returns the descriptors of the SCM_RIGHTS messages of oob.
+func parseRights(oob []byte) []int {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil
+	}
+	var fds []int
+	for _, msg := range msgs {
+		if rights, err := syscall.ParseUnixRights(&msg); err == nil {
+			fds = append(fds, rights...)
+		}
+	}
+	return fds
+}
+
+func (c *Conn) dispatch(m *Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if m.Type == TypeMethodReturn || m.Type == TypeError {
+		if ch, ok := c.pending[m.ReplySerial]; ok {
+			delete(c.pending, m.ReplySerial)
+			ch <- m
+		}
+		return
+	}
+	c.queue = append(c.queue, m)
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// forward sends the queued messages to incoming, so that the reader never
+// waits for them to be received, and closes it once the connection ends.
+func (c *Conn) forward() {
+	defer close(c.incoming)
+	for {
+		c.mu.Lock()
+		var m *Message
+		if len(c.queue) > 0 {
+			m = c.queue[0]
+			c.queue = c.queue[1:]
+		}
+		c.mu.Unlock()
+		if m == nil {
+			select {
+			case <-c.wake:
+				continue
+			case <-c.done:
+				return
+			}
+		}
+		select {
+		case c.incoming <- m:
+		case <-c.done:
+			return
+		}
+	}
+}
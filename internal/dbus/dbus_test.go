@@ -0,0 +1,152 @@
+package dbus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestMarshal(t *testing.T) {
+	m := &Message{
+		Type:        TypeMethodCall,
+		Serial:      7,
+		Path:        "/org/example",
+		Interface:   "org.example.Thing",
+		Member:      "Do",
+		Destination: "org.example",
+		Signature:   "ybnqixtdsogva{sv}(us)as",
+		Body: []interface{}{
+			byte(1), true, int16(-2), uint16(3), int32(-4), int64(-5), uint64(6), 0.5,
+			"text", ObjectPath("/a/b"), Signature("a{sv}"), Variant{"ai", []interface{}{int32(1), int32(2)}},
+			[]interface{}{[]interface{}{"k", Variant{"s", "v"}}},
+			[]interface{}{uint32(9), "x"},
+			[]interface{}{},
+		},
+	}
+	buf, fds, err := m.marshal()
+	if err != nil {
+		t.Fatalf("marshal: %s", err)
+	}
+	if len(fds) != 0 {
+		t.Errorf("marshal passes %d descriptors", len(fds))
+	}
+	if size, err := messageSize(buf); err != nil || size != len(buf) {
+		t.Fatalf("size is %d, %v, want %d", size, err, len(buf))
+	}
+	got, _, err := unmarshal(buf, nil)
+	if err != nil {
+		t.Fatalf("unmarshal: %s", err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("round trip is\n%+v\nwant\n%+v", got, m)
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	for _, m := range []*Message{
+		{Type: TypeSignal, Signature: "u", Body: []interface{}{int32(1)}},
+		{Type: TypeSignal, Signature: "uu", Body: []interface{}{uint32(1)}},
+		{Type: TypeSignal, Signature: "", Body: []interface{}{uint32(1)}},
+		{Type: TypeSignal, Signature: "a{uuu}", Body: []interface{}{[]interface{}{}}},
+		{Type: TypeSignal, Signature: "(u", Body: []interface{}{[]interface{}{}}},
+	} {
+		if _, _, err := m.marshal(); err == nil {
+			t.Errorf("marshal of %q %v succeeded", m.Signature, m.Body)
+		}
+	}
+}
+
+// serve accepts a connection on a private bus, and answers Hello and Echo.
+func serve(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "bus")
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		uc, err := ln.AcceptUnix()
+		if err != nil {
+			return
+		}
+		c, err := Accept(uc)
+		if err != nil {
+			uc.Close()
+			return
+		}
+		defer c.Close()
+		for m := range c.Incoming() {
+			switch m.Member {
+			case "Hello":
+				c.Reply(m, "s", ":1.1")
+			case "Echo":
+				c.Reply(m, m.Signature, m.Body...)
+				c.Emit("/org/example", "org.example.Thing", "Echoed", m.Signature, m.Body...)
+			default:
+				c.ReplyError(m, "org.freedesktop.DBus.Error.UnknownMethod", "no "+m.Member)
+			}
+		}
+	}()
+	return "unix:path=" + path
+}
+
+func TestConn(t *testing.T) {
+	c, err := Dial(serve(t))
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+	if c.Name() != ":1.1" {
+		t.Errorf("name is %q", c.Name())
+	}
+
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ctx := context.Background()
+	reply, err := c.Call(ctx, "org.example", "/org/example", "org.example.Thing", "Echo", "sh",
+		"hello", UnixFD(f.Fd()))
+	if err != nil {
+		t.Fatalf("echo: %s", err)
+	}
+	if len(reply) != 2 || reply[0] != "hello" {
+		t.Fatalf("echo replied %v", reply)
+	}
+	// The descriptor received is a new one of the same file.
+	fd := int(reply[1].(UnixFD))
+	defer syscall.Close(fd)
+	var want, got syscall.Stat_t
+	syscall.Fstat(int(f.Fd()), &want)
+	syscall.Fstat(fd, &got)
+	if fd == int(f.Fd()) || got.Ino != want.Ino {
+		t.Errorf("received descriptor %d of inode %d, want a copy of %d", fd, got.Ino, f.Fd())
+	}
+
+	signal := <-c.Incoming()
+	if signal.Type != TypeSignal || signal.Member != "Echoed" || signal.Body[0] != "hello" {
+		t.Errorf("signal is %+v", signal)
+	}
+	syscall.Close(int(signal.Body[1].(UnixFD)))
+
+	_, err = c.Call(ctx, "org.example", "/org/example", "org.example.Thing", "Missing", "")
+	var e *Error
+	if !errors.As(err, &e) || e.Name != "org.freedesktop.DBus.Error.UnknownMethod" || e.Message != "no Missing" {
+		t.Errorf("missing method returned %v", err)
+	}
+
+	c.Close()
+	if _, err := c.Call(ctx, "org.example", "/org/example", "org.example.Thing", "Echo", ""); err == nil {
+		t.Error("call on a closed connection succeeded")
+	}
+	if _, ok := <-c.Incoming(); ok {
+		t.Error("incoming not closed")
+	}
+}
@@ -0,0 +1,703 @@
+// Package dbus is a minimal D-Bus client: enough to call the methods of system
+// services, such as systemd-logind, and receive their signals over a Unix
+// socket, including the file descriptors they pass.
+//
+// Values are sent and received as these Go types, by signature code:
+//
+//	y byte         b bool         n int16        q uint16
+//	i int32        u uint32       x int64        t uint64
+//	d float64      s string       o ObjectPath   g Signature
+//	h UnixFD       v Variant      a []interface{} (...) []interface{}
+//
+// The entries of dictionaries, a{...}, are []interface{} of a key and a value.
+package dbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ObjectPath is the path of an object, e.g. "/org/freedesktop/login1".
+type ObjectPath string
+
+// Signature is the signature of a sequence of values, e.g. "a{sv}".
+type Signature string
+
+// UnixFD is a file descriptor passed along with a message. Received
+// descriptors belong to the receiver, which must close them.
+type UnixFD int
+
+// Variant is a value along with its signature.
+type Variant struct {
+	Signature Signature
+	Value     interface{}
+}
+
+// MessageType is the type of a message.
+type MessageType uint8
+
+const (
+	TypeMethodCall MessageType = iota + 1
+	TypeMethodReturn
+	TypeError
+	TypeSignal
+)
+
+// FlagNoReplyExpected marks method calls whose reply, if any, is ignored.
+const FlagNoReplyExpected = 0x1
+
+// Message is a D-Bus message.
+type Message struct {
+	Type   MessageType
+	Flags  uint8
+	Serial uint32
+
+	Path        ObjectPath
+	Interface   string
+	Member      string
+	ErrorName   string
+	ReplySerial uint32
+	Destination string
+	Sender      string
+
+	Signature Signature
+	Body      []interface{}
+}
+
+// Error is an error reply to a method call.
+type Error struct {
+	Name    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Name
+	}
+	return e.Name + ": " + e.Message
+}
+
+// Codes of the header fields.
+const (
+	fieldPath        = 1
+	fieldInterface   = 2
+	fieldMember      = 3
+	fieldErrorName   = 4
+	fieldReplySerial = 5
+	fieldDestination = 6
+	fieldSender      = 7
+	fieldSignature   = 8
+	fieldUnixFDs     = 9
+)
+
+// maxMessageSize is the largest message the specification allows.
+const maxMessageSize = 128 << 20
+
+// marshal encodes a message, and returns the descriptors to pass along with it.
+func (m *Message) marshal() ([]byte, []int, error) {
+	body := encoder{}
+	if err := body.values(string(m.Signature), m.Body); err != nil {
+		return nil, nil, err
+	}
+
+	type field struct {
+		code byte
+		v    Variant
+	}
+	var fields []field
+	add := func(code byte, sig Signature, v interface{}) {
+		fields = append(fields, field{code, Variant{sig, v}})
+	}
+	if m.Path != "" {
+		add(fieldPath, "o", m.Path)
+	}
+	if m.Interface != "" {
+		add(fieldInterface, "s", m.Interface)
+	}
+	if m.Member != "" {
+		add(fieldMember, "s", m.Member)
+	}
+	if m.ErrorName != "" {
+		add(fieldErrorName, "s", m.ErrorName)
+	}
+	if m.ReplySerial != 0 {
+		add(fieldReplySerial, "u", m.ReplySerial)
+	}
+	if m.Destination != "" {
+		add(fieldDestination, "s", m.Destination)
+	}
+	if m.Sender != "" {
+		add(fieldSender, "s", m.Sender)
+	}
+	if m.Signature != "" {
+		add(fieldSignature, "g", m.Signature)
+	}
+	if len(body.fds) > 0 {
+		add(fieldUnixFDs, "u", uint32(len(body.fds)))
+	}
+
+	h := encoder{}
+	h.buf = append(h.buf, 'l', byte(m.Type), m.Flags, 1)
+	h.uint32(uint32(len(body.buf)))
+	h.uint32(m.Serial)
+	start := h.arrayStart(8)
+	for _, f := range fields {
+		h.align(8)
+		h.buf = append(h.buf, f.code)
+		if err := h.value("v", f.v); err != nil {
+			return nil, nil, err
+		}
+	}
+	h.arrayEnd(start)
+	h.align(8)
+	return append(h.buf, body.buf...), body.fds, nil
+}
+
+// messageSize returns the size of the message starting buf, or 0 if buf holds
+// less than its fixed header.
+func messageSize(buf []byte) (int, error) {
+	if len(buf) < 16 {
+		return 0, nil
+	}
+	order, err := byteOrder(buf[0])
+	if err != nil {
+		return 0, err
+	}
+	fields := int(order.Uint32(buf[12:]))
+	size := 16 + fields
+	size += -size & 7
+	size += int(order.Uint32(buf[4:]))
+	if fields > maxMessageSize || size > maxMessageSize {
+		return 0, fmt.Errorf("message of %d bytes too large", size)
+	}
+	return size, nil
+}
+
+func byteOrder(b byte) (binary.ByteOrder, error) {
+	switch b {
+	case 'l':
+		return binary.LittleEndian, nil
+	case 'B':
+		return binary.BigEndian, nil
+	}
+	return nil, fmt.Errorf("invalid byte order %q", b)
+}
+
+// unmarshal decodes a whole message. fds are the descriptors received and not
+// yet used by earlier messages; it returns how many of them are the message's,
+// which UnixFD values of its body refer to.
+func unmarshal(buf []byte, fds []int) (*Message, int, error) {
+	order, err := byteOrder(buf[0])
+	if err != nil {
+		return nil, 0, err
+	}
+	if buf[3] != 1 {
+		return nil, 0, fmt.Errorf("unsupported protocol version %d", buf[3])
+	}
+	m := Message{Type: MessageType(buf[1]), Flags: buf[2], Serial: order.Uint32(buf[8:])}
+	bodyLen := int(order.Uint32(buf[4:]))
+
+	h := decoder{order: order, buf: buf[:len(buf)-bodyLen], pos: 12}
+	v, err := h.value("a(yv)")
+	if err != nil {
+		return nil, 0, fmt.Errorf("header: %w", err)
+	}
+	var nfds uint32
+	for _, f := range v.([]interface{}) {
+		f := f.([]interface{})
+		value := f[1].(Variant).Value
+		var ok bool
+		switch f[0].(byte) {
+		case fieldPath:
+			m.Path, ok = value.(ObjectPath)
+		case fieldInterface:
+			m.Interface, ok = value.(string)
+		case fieldMember:
+			m.Member, ok = value.(string)
+		case fieldErrorName:
+			m.ErrorName, ok = value.(string)
+		case fieldReplySerial:
+			m.ReplySerial, ok = value.(uint32)
+		case fieldDestination:
+			m.Destination, ok = value.(string)
+		case fieldSender:
+			m.Sender, ok = value.(string)
+		case fieldSignature:
+			m.Signature, ok = value.(Signature)
+		case fieldUnixFDs:
+			nfds, ok = value.(uint32)
+		default:
+			// Unknown fields are ignored.
+			ok = true
+		}
+		if !ok {
+			return nil, 0, fmt.Errorf("header field %d has type %T", f[0], value)
+		}
+	}
+	if int64(nfds) > int64(len(fds)) {
+		return nil, 0, fmt.Errorf("%d descriptors expected, %d received", nfds, len(fds))
+	}
+
+	body := decoder{order: order, buf: buf[len(buf)-bodyLen:], fds: fds[:nfds]}
+	for sig := string(m.Signature); sig != ""; {
+		var t string
+		if t, sig, err = nextType(sig); err != nil {
+			return nil, 0, err
+		}
+		v, err := body.value(t)
+		if err != nil {
+			return nil, 0, fmt.Errorf("body: %w", err)
+		}
+		m.Body = append(m.Body, v)
+	}
+	if body.pos != len(body.buf) {
+		return nil, 0, errors.New("body longer than its signature")
+	}
+	return &m, int(nfds), nil
+}
+
+// nextType splits the first complete type off a signature.
+func nextType(sig string) (string, string, error) {
+	if sig == "" {
+		return "", "", errors.New("missing type in signature")
+	}
+	switch sig[0] {
+	case 'y', 'b', 'n', 'q', 'i', 'u', 'x', 't', 'd', 's', 'o', 'g', 'h', 'v':
+		return sig[:1], sig[1:], nil
+	case 'a':
+		elem, rest, err := nextType(sig[1:])
+		if err != nil {
+			return "", "", err
+		}
+		return "a" + elem, rest, nil
+	case '(', '{':
+		end := byte(')')
+		if sig[0] == '{' {
+			end = '}'
+		}
+		rest := sig[1:]
+		for n := 0; ; n++ {
+			if rest == "" {
+				return "", "", fmt.Errorf("unterminated %q in signature", sig[0])
+			}
+			if rest[0] == end {
+				if n == 0 || (end == '}' && n != 2) {
+					return "", "", fmt.Errorf("invalid %q in signature", sig[0])
+				}
+				return sig[:len(sig)-len(rest)+1], rest[1:], nil
+			}
+			var err error
+			if _, rest, err = nextType(rest); err != nil {
+				return "", "", err
+			}
+		}
+	}
+	return "", "", fmt.Errorf("invalid type %q in signature", sig[0])
+}
+
+// alignment returns the alignment of a type.
+func alignment(t byte) int {
+	switch t {
+	case 'n', 'q':
+		return 2
+	case 'b', 'i', 'u', 'h', 's', 'o', 'a':
+		return 4
+	case 'x', 't', 'd', '(', '{':
+		return 8
+	}
+	return 1
+}
+
+// encoder encodes values in little endian byte order. Alignment is relative to
+// the start of buf, which must be aligned to 8 in the message.
+type encoder struct {
+	buf []byte
+	fds []int
+}
+
+func (e *encoder) align(n int) {
+	for len(e.buf)%n != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *encoder) uint16(v uint16) {
+	e.align(2)
+	e.buf = append(e.buf, byte(v), byte(v>>8))
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.align(4)
+	e.buf = append(e.buf, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func (e *encoder) uint64(v uint64) {
+	e.align(8)
+	e.buf = append(e.buf, byte(v), byte(v>>8), byte(v>>16), byte(v>>24),
+		byte(v>>32), byte(v>>40), byte(v>>48), byte(v>>56))
+}
+
+// array is an array being encoded.
+type array struct {
+	// length is the offset of the array's length, and start that of its first
+	// element.
+	length, start int
+}
+
+// arrayStart writes the length of an array, to be filled by arrayEnd, and
+// aligns its first element.
+func (e *encoder) arrayStart(elemAlign int) array {
+	e.uint32(0)
+	a := array{length: len(e.buf) - 4}
+	e.align(elemAlign)
+	a.start = len(e.buf)
+	return a
+}
+
+func (e *encoder) arrayEnd(a array) {
+	binary.LittleEndian.PutUint32(e.buf[a.length:], uint32(len(e.buf)-a.start))
+}
+
+// values encodes a sequence of values of the signature sig.
+func (e *encoder) values(sig string, vs []interface{}) error {
+	n := 0
+	for ; sig != ""; n++ {
+		t, rest, err := nextType(sig)
+		if err != nil {
+			return err
+		}
+		if n >= len(vs) {
+			return fmt.Errorf("missing value of type %s", t)
+		}
+		if err := e.value(t, vs[n]); err != nil {
+			return err
+		}
+		sig = rest
+	}
+	if n != len(vs) {
+		return fmt.Errorf("%d values for %d types", len(vs), n)
+	}
+	return nil
+}
+
+// value encodes a value of the complete type t.
+func (e *encoder) value(t string, v interface{}) error {
+	mismatch := func() error {
+		return fmt.Errorf("cannot encode %T as %s", v, t)
+	}
+	switch t[0] {
+	case 'y':
+		b, ok := v.(byte)
+		if !ok {
+			return mismatch()
+		}
+		e.buf = append(e.buf, b)
+	case 'b':
+		b, ok := v.(bool)
+		if !ok {
+			return mismatch()
+		}
+		var u uint32
+		if b {
+			u = 1
+		}
+		e.uint32(u)
+	case 'n', 'q':
+		var u uint16
+		switch v := v.(type) {
+		case int16:
+			if t[0] != 'n' {
+				return mismatch()
+			}
+			u = uint16(v)
+		case uint16:
+			if t[0] != 'q' {
+				return mismatch()
+			}
+			u = v
+		default:
+			return mismatch()
+		}
+		e.uint16(u)
+	case 'i':
+		i, ok := v.(int32)
+		if !ok {
+			return mismatch()
+		}
+		e.uint32(uint32(i))
+	case 'u':
+		u, ok := v.(uint32)
+		if !ok {
+			return mismatch()
+		}
+		e.uint32(u)
+	case 'x':
+		i, ok := v.(int64)
+		if !ok {
+			return mismatch()
+		}
+		e.uint64(uint64(i))
+	case 't':
+		u, ok := v.(uint64)
+		if !ok {
+			return mismatch()
+		}
+		e.uint64(u)
+	case 'd':
+		f, ok := v.(float64)
+		if !ok {
+			return mismatch()
+		}
+		e.uint64(math.Float64bits(f))
+	case 's', 'o':
+		var s string
+		switch v := v.(type) {
+		case string:
+			s = v
+		case ObjectPath:
+			s = string(v)
+		default:
+			return mismatch()
+		}
+		e.uint32(uint32(len(s)))
+		e.buf = append(append(e.buf, s...), 0)
+	case 'g':
+		s, ok := v.(Signature)
+		if !ok {
+			return mismatch()
+		}
+		if len(s) > 255 {
+			return fmt.Errorf("signature %q too long", s)
+		}
+		e.buf = append(append(append(e.buf, byte(len(s))), s...), 0)
+	case 'h':
+		fd, ok := v.(UnixFD)
+		if !ok {
+			return mismatch()
+		}
+		e.uint32(uint32(len(e.fds)))
+		e.fds = append(e.fds, int(fd))
+	case 'v':
+		variant, ok := v.(Variant)
+		if !ok {
+			return mismatch()
+		}
+		sig := variant.Signature
+		if t, rest, err := nextType(string(sig)); err != nil || rest != "" || t == "" {
+			return fmt.Errorf("invalid variant signature %q", sig)
+		}
+		if err := e.value("g", sig); err != nil {
+			return err
+		}
+		return e.value(string(sig), variant.Value)
+	case 'a':
+		elems, ok := v.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		start := e.arrayStart(alignment(t[1]))
+		for _, elem := range elems {
+			if err := e.value(t[1:], elem); err != nil {
+				return err
+			}
+		}
+		e.arrayEnd(start)
+	case '(', '{':
+		fields, ok := v.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		e.align(8)
+		return e.values(t[1:len(t)-1], fields)
+	default:
+		return mismatch()
+	}
+	return nil
+}
+
+// decoder decodes values. Alignment is relative to the start of buf.
+type decoder struct {
+	order binary.ByteOrder
+	buf   []byte
+	pos   int
+	fds   []int
+}
+
+var errShort = errors.New("value past the end of the message")
+
+func (d *decoder) align(n int) error {
+	pos := d.pos + (-d.pos & (n - 1))
+	if pos > len(d.buf) {
+		return errShort
+	}
+	d.pos = pos
+	return nil
+}
+
+func (d *decoder) read(n, align int) ([]byte, error) {
+	if err := d.align(align); err != nil {
+		return nil, err
+	}
+	if len(d.buf)-d.pos < n {
+		return nil, errShort
+	}
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *decoder) uint32() (uint32, error) {
+	b, err := d.read(4, 4)
+	if err != nil {
+		return 0, err
+	}
+	return d.order.Uint32(b), nil
+}
+
+func (d *decoder) uint64() (uint64, error) {
+	b, err := d.read(8, 8)
+	if err != nil {
+		return 0, err
+	}
+	return d.order.Uint64(b), nil
+}
+
+// string reads a string of n bytes and its terminating NUL.
+func (d *decoder) string(n int) (string, error) {
+	b, err := d.read(n+1, 1)
+	if err != nil {
+		return "", err
+	}
+	if b[n] != 0 {
+		return "", errors.New("string not terminated")
+	}
+	return string(b[:n]), nil
+}
+
+// value decodes a value of the complete type t.
+func (d *decoder) value(t string) (interface{}, error) {
+	switch t[0] {
+	case 'y':
+		b, err := d.read(1, 1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0], nil
+	case 'b':
+		u, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		if u > 1 {
+			return nil, fmt.Errorf("invalid boolean %d", u)
+		}
+		return u == 1, nil
+	case 'n', 'q':
+		b, err := d.read(2, 2)
+		if err != nil {
+			return nil, err
+		}
+		if t[0] == 'n' {
+			return int16(d.order.Uint16(b)), nil
+		}
+		return d.order.Uint16(b), nil
+	case 'i':
+		u, err := d.uint32()
+		return int32(u), err
+	case 'u':
+		return d.uint32()
+	case 'x':
+		u, err := d.uint64()
+		return int64(u), err
+	case 't':
+		return d.uint64()
+	case 'd':
+		u, err := d.uint64()
+		return math.Float64frombits(u), err
+	case 's', 'o':
+		n, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		if int64(n) > int64(len(d.buf)) {
+			return nil, errShort
+		}
+		s, err := d.string(int(n))
+		if t[0] == 'o' {
+			return ObjectPath(s), err
+		}
+		return s, err
+	case 'g':
+		n, err := d.read(1, 1)
+		if err != nil {
+			return nil, err
+		}
+		s, err := d.string(int(n[0]))
+		return Signature(s), err
+	case 'h':
+		i, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		if int(i) >= len(d.fds) {
+			return nil, fmt.Errorf("descriptor %d not received", i)
+		}
+		return UnixFD(d.fds[i]), nil
+	case 'v':
+		sig, err := d.value("g")
+		if err != nil {
+			return nil, err
+		}
+		vt, rest, err := nextType(string(sig.(Signature)))
+		if err != nil || rest != "" {
+			return nil, fmt.Errorf("invalid variant signature %q", sig)
+		}
+		v, err := d.value(vt)
+		return Variant{sig.(Signature), v}, err
+	case 'a':
+		n, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		if err := d.align(alignment(t[1])); err != nil {
+			return nil, err
+		}
+		end := d.pos + int(n)
+		if n > maxMessageSize || end > len(d.buf) {
+			return nil, errShort
+		}
+		elems := []interface{}{}
+		for d.pos < end {
+			elem, err := d.value(t[1:])
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, elem)
+		}
+		if d.pos != end {
+			return nil, errors.New("array longer than its length")
+		}
+		return elems, nil
+	case '(', '{':
+		if err := d.align(8); err != nil {
+			return nil, err
+		}
+		var fields []interface{}
+		for sig := t[1 : len(t)-1]; sig != ""; {
+			ft, rest, err := nextType(sig)
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.value(ft)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, v)
+			sig = rest
+		}
+		return fields, nil
+	}
+	return nil, fmt.Errorf("invalid type %q", t)
+}
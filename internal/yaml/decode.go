@@ -0,0 +1,415 @@
+package yaml
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Unmarshal decodes a YAML document into v, as encoding/json would decode the
+// equivalent JSON document.
+//
+// Only the subset of YAML used by configuration files is supported: block
+// mappings and sequences, flow sequences and mappings on a single line, plain,
+// single-quoted and double-quoted scalars, and comments. Anchors, tags, block
+// scalars and multiple documents are not.
+func Unmarshal(data []byte, v interface{}) error {
+	j, err := ToJSON(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(j, v)
+}
+
+// ToJSON converts a YAML document to JSON. See Unmarshal for the supported
+// subset of YAML.
+func ToJSON(data []byte) ([]byte, error) {
+	p := parser{}
+	for i, text := range strings.Split(string(data), "\n") {
+		text = stripComment(strings.TrimRight(text, " \t\r"))
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("yaml: line %d: tabs are not allowed for indentation", i+1)
+		}
+		p.lines = append(p.lines, line{num: i + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	if len(p.lines) == 0 {
+		return []byte("null"), nil
+	}
+	v, err := p.block(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return json.Marshal(v)
+}
+
+type line struct {
+	num    int
+	indent int
+	text   string
+}
+
+type parser struct {
+	lines []line
+	pos   int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	num := p.lines[len(p.lines)-1].num
+	if p.pos < len(p.lines) {
+		num = p.lines[p.pos].num
+	}
+	return fmt.Errorf("yaml: line %d: %s", num, fmt.Sprintf(format, args...))
+}
+
+// stripComment removes a comment from a line, leaving "#" in quoted strings
+// and plain scalars alone.
+func stripComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.ContainsRune(" [{,:-", rune(s[i-1])) {
+				quote = c
+			}
+		case c == '#' && (i == 0 || s[i-1] == ' '):
+			return strings.TrimRight(s[:i], " ")
+		}
+	}
+	return s
+}
+
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitKey splits a mapping entry into its key and value, or returns ok false if
+// the text is not a mapping entry.
+func splitKey(text string) (key, value string, ok bool) {
+	if text[0] == '[' || text[0] == '{' {
+		return "", "", false
+	}
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case i == 0 && (c == '"' || c == '\''):
+			quote = c
+		case c == ':' && (i == len(text)-1 || text[i+1] == ' '):
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// block parses the collection or scalar starting at the current line, which is
+// at the given indentation.
+func (p *parser) block(indent int) (interface{}, error) {
+	l := p.lines[p.pos]
+	switch {
+	case isSequenceItem(l.text):
+		return p.sequence(indent)
+	default:
+		if _, _, ok := splitKey(l.text); ok {
+			return p.mapping(indent)
+		}
+	}
+	v, err := scalar(l.text)
+	if err != nil {
+		return nil, p.errorf("%s", err)
+	}
+	p.pos++
+	return v, nil
+}
+
+func (p *parser) sequence(indent int) (interface{}, error) {
+	ret := []interface{}{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isSequenceItem(p.lines[p.pos].text) {
+		l := p.lines[p.pos]
+		rest := strings.TrimLeft(l.text[1:], " ")
+		if rest == "" {
+			p.pos++
+			v, err := p.nested(indent, false)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, v)
+			continue
+		}
+		// The item starts on the line of the dash. It is parsed as if it started
+		// on a line of its own, indented to where it starts.
+		p.lines[p.pos] = line{num: l.num, indent: l.indent + len(l.text) - len(rest), text: rest}
+		v, err := p.block(p.lines[p.pos].indent)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, v)
+	}
+	return ret, nil
+}
+
+func (p *parser) mapping(indent int) (interface{}, error) {
+	ret := make(map[string]interface{})
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent {
+		l := p.lines[p.pos]
+		if isSequenceItem(l.text) {
+			return nil, p.errorf("unexpected sequence item in mapping")
+		}
+		key, value, ok := splitKey(l.text)
+		if !ok {
+			return nil, p.errorf("expected a mapping entry, got %q", l.text)
+		}
+		k, err := scalar(key)
+		if err != nil {
+			return nil, p.errorf("%s", err)
+		}
+		name := keyName(k)
+		if _, ok := ret[name]; ok {
+			return nil, p.errorf("duplicate key %q", name)
+		}
+		p.pos++
+
+		var v interface{}
+		if value == "" {
+			// Sequences in mappings may be indented as much as their key.
+			if v, err = p.nested(indent, true); err != nil {
+				return nil, err
+			}
+		} else if v, err = scalar(value); err != nil {
+			p.pos--
+			return nil, p.errorf("%s", err)
+		}
+		ret[name] = v
+	}
+	return ret, nil
+}
+
+// keyName returns the JSON object key of a mapping key.
+func keyName(k interface{}) string {
+	if k == nil {
+		return "null"
+	}
+	return fmt.Sprint(k)
+}
+
+// nested parses the value of an entry whose value starts on the next line, or
+// returns nil if there is none.
+func (p *parser) nested(indent int, sequenceAtIndent bool) (interface{}, error) {
+	if p.pos == len(p.lines) {
+		return nil, nil
+	}
+	next := p.lines[p.pos]
+	switch {
+	case next.indent > indent:
+		return p.block(next.indent)
+	case next.indent == indent && sequenceAtIndent && isSequenceItem(next.text):
+		return p.sequence(indent)
+	}
+	return nil, nil
+}
+
+// scalar parses a scalar or a flow collection.
+func scalar(s string) (interface{}, error) {
+	f := scanner{s: s}
+	v, err := f.value()
+	if err != nil {
+		return nil, err
+	}
+	if f.skipSpace(); f.i < len(f.s) {
+		return nil, fmt.Errorf("unexpected %q after value", f.s[f.i:])
+	}
+	return v, nil
+}
+
+// scanner parses flow collections and scalars.
+type scanner struct {
+	s string
+	i int
+	// depth is the nesting of flow collections, inside which commas and
+	// brackets end plain scalars.
+	depth int
+}
+
+func (f *scanner) skipSpace() {
+	for f.i < len(f.s) && f.s[f.i] == ' ' {
+		f.i++
+	}
+}
+
+func (f *scanner) value() (interface{}, error) {
+	f.skipSpace()
+	if f.i == len(f.s) {
+		return nil, nil
+	}
+	switch f.s[f.i] {
+	case '[':
+		return f.collection(']')
+	case '{':
+		return f.collection('}')
+	case '"':
+		end := f.i + 1
+		for ; end < len(f.s) && f.s[end] != '"'; end++ {
+			if f.s[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(f.s) {
+			return nil, fmt.Errorf("unterminated string %s", f.s[f.i:])
+		}
+		var ret string
+		if err := json.Unmarshal([]byte(f.s[f.i:end+1]), &ret); err != nil {
+			return nil, fmt.Errorf("invalid string %s", f.s[f.i:end+1])
+		}
+		f.i = end + 1
+		return ret, nil
+	case '\'':
+		var b strings.Builder
+		for end := f.i + 1; end < len(f.s); end++ {
+			if f.s[end] != '\'' {
+				b.WriteByte(f.s[end])
+			} else if end+1 < len(f.s) && f.s[end+1] == '\'' {
+				b.WriteByte('\'')
+				end++
+			} else {
+				f.i = end + 1
+				return b.String(), nil
+			}
+		}
+		return nil, fmt.Errorf("unterminated string %s", f.s[f.i:])
+	case '&', '*', '!', '|', '>', '%', '@', '`':
+		return nil, fmt.Errorf("unsupported syntax %q", f.s[f.i:])
+	}
+
+	start := f.i
+	for f.i < len(f.s) {
+		c := f.s[f.i]
+		if f.depth > 0 && (c == ',' || c == ']' || c == '}' ||
+			(c == ':' && (f.i+1 == len(f.s) || f.s[f.i+1] == ' '))) {
+			break
+		}
+		f.i++
+	}
+	return plainScalar(strings.TrimSpace(f.s[start:f.i])), nil
+}
+
+func (f *scanner) collection(end byte) (interface{}, error) {
+	f.i++
+	f.depth++
+	defer func() { f.depth-- }()
+
+	var (
+		list []interface{}
+		m    = make(map[string]interface{})
+	)
+	for {
+		f.skipSpace()
+		if f.i < len(f.s) && f.s[f.i] == end {
+			f.i++
+			break
+		}
+		v, err := f.value()
+		if err != nil {
+			return nil, err
+		}
+		f.skipSpace()
+		if end == '}' {
+			if f.i == len(f.s) || f.s[f.i] != ':' {
+				return nil, fmt.Errorf("expected ':' in %s", f.s)
+			}
+			f.i++
+			value, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			name := keyName(v)
+			if _, ok := m[name]; ok {
+				return nil, fmt.Errorf("duplicate key %q", name)
+			}
+			m[name] = value
+		} else {
+			list = append(list, v)
+		}
+		f.skipSpace()
+		if f.i < len(f.s) && f.s[f.i] == ',' {
+			f.i++
+			continue
+		}
+		if f.i == len(f.s) || f.s[f.i] != end {
+			return nil, fmt.Errorf("unterminated collection %s", f.s)
+		}
+	}
+	if end == '}' {
+		return m, nil
+	}
+	if list == nil {
+		list = []interface{}{}
+	}
+	return list, nil
+}
+
+// plainScalar resolves a plain scalar to null, a boolean, a number or a string,
+// following the YAML 1.2 core schema.
+func plainScalar(s string) interface{} {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	if n, ok := integer(s); ok {
+		return n
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil && !strings.ContainsAny(s, "xXpP_") &&
+		!strings.EqualFold(s, "inf") && !strings.EqualFold(s, "nan") &&
+		!strings.EqualFold(s, "infinity") {
+		return json.Number(strconv.FormatFloat(f, 'g', -1, 64))
+	}
+	return s
+}
+
+// integer parses the integers of the YAML 1.2 core schema: decimal, and
+// hexadecimal and octal with the 0x and 0o prefixes.
+func integer(s string) (json.Number, bool) {
+	base, digits := 10, strings.TrimLeft(s, "+-")
+	switch {
+	case strings.HasPrefix(s, "0x"):
+		base, digits = 16, s[2:]
+	case strings.HasPrefix(s, "0o"):
+		base, digits = 8, s[2:]
+	case len(s)-len(digits) > 1:
+		return "", false
+	}
+	if digits == "" || strings.ContainsRune(digits, '_') {
+		return "", false
+	}
+	n, err := strconv.ParseUint(digits, base, 64)
+	if err != nil {
+		return "", false
+	}
+	ret := strconv.FormatUint(n, 10)
+	if strings.HasPrefix(s, "-") {
+		ret = "-" + ret
+	}
+	return json.Number(ret), true
+}
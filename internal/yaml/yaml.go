@@ -0,0 +1,163 @@
+// Package yaml encodes values as YAML. Values are first encoded as JSON, so
+// that struct tags and MarshalJSON methods apply, and the JSON is then written
+// as block-style YAML with the same field order.
+package yaml
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// node is a decoded JSON value that keeps the order of object keys.
+type node struct {
+	// kind is one of '{', '[' or 0 for scalars.
+	kind   byte
+	keys   []string
+	values []*node
+	// scalar is the YAML representation of a scalar.
+	scalar string
+}
+
+// Marshal returns the YAML encoding of v.
+func Marshal(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return FromJSON(data)
+}
+
+// FromJSON converts a JSON document to YAML.
+func FromJSON(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	n, err := decode(dec)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if n.kind == 0 || len(n.values) == 0 {
+		b.WriteString(flow(n))
+		b.WriteByte('\n')
+	} else {
+		write(&b, n, 0)
+	}
+	return b.Bytes(), nil
+}
+
+func decode(dec *json.Decoder) (*node, error) {
+	tok, err := dec.Token()
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+
+	switch tok := tok.(type) {
+	case json.Delim:
+		n := node{kind: byte(tok)}
+		for dec.More() {
+			if n.kind == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				n.keys = append(n.keys, key.(string))
+			}
+			value, err := decode(dec)
+			if err != nil {
+				return nil, err
+			}
+			n.values = append(n.values, value)
+		}
+		// The closing delimiter.
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return &n, nil
+	case string:
+		return &node{scalar: quote(tok)}, nil
+	case json.Number:
+		return &node{scalar: tok.String()}, nil
+	case bool:
+		return &node{scalar: fmt.Sprint(tok)}, nil
+	case nil:
+		return &node{scalar: "null"}, nil
+	}
+	return nil, fmt.Errorf("unexpected JSON token %v", tok)
+}
+
+// quote returns a string as a plain scalar if it cannot be mistaken for
+// anything else, and double-quoted otherwise.
+func quote(s string) string {
+	if plain(s) {
+		return s
+	}
+	// JSON string escapes are valid in YAML double-quoted scalars.
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func plain(s string) bool {
+	if s == "" || strings.TrimSpace(s) != s || strings.ContainsAny(s, "\"'\\\n\t#{}[],&*!|>%@`") ||
+		strings.Contains(s, ": ") || strings.HasSuffix(s, ":") || strings.ContainsAny(s[:1], "-?:") {
+		return false
+	}
+	switch strings.ToLower(s) {
+	case "null", "~", "true", "false", "yes", "no", "on", "off", "y", "n":
+		return false
+	}
+	// Anything that starts like a number might be read as one.
+	return !strings.ContainsAny(s[:1], "0123456789.+")
+}
+
+// flow returns the representation of a scalar or an empty collection.
+func flow(n *node) string {
+	switch n.kind {
+	case '{':
+		return "{}"
+	case '[':
+		return "[]"
+	}
+	return n.scalar
+}
+
+// write writes the entries of a non-empty collection, at the given
+// indentation.
+func write(b *bytes.Buffer, n *node, indent int) {
+	pad := strings.Repeat("  ", indent)
+	for i, value := range n.values {
+		b.WriteString(pad)
+		if n.kind == '{' {
+			b.WriteString(quote(n.keys[i]))
+			b.WriteByte(':')
+		} else {
+			b.WriteByte('-')
+		}
+		if value.kind == 0 || len(value.values) == 0 {
+			b.WriteByte(' ')
+			b.WriteString(flow(value))
+			b.WriteByte('\n')
+			continue
+		}
+		if n.kind == '[' {
+			// The first entry of a collection in a list shares the line of the
+			// dash.
+			var first bytes.Buffer
+			write(&first, value, indent+1)
+			b.WriteByte(' ')
+			b.Write(first.Bytes()[len(pad)+2:])
+			continue
+		}
+		b.WriteByte('\n')
+		// Lists in objects are not indented further, as is customary.
+		if n.kind == '{' && value.kind == '[' {
+			write(b, value, indent)
+		} else {
+			write(b, value, indent+1)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package yaml
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarshal(t *testing.T) {
+	v := struct {
+		Name   string            `json:"name"`
+		Values []int             `json:"values"`
+		Empty  []int             `json:"empty"`
+		Nested []interface{}     `json:"nested"`
+		Map    map[string]string `json:"map"`
+	}{
+		Name:   "HDMI-A-1",
+		Values: []int{1, 2},
+		Empty:  []int{},
+		Nested: []interface{}{
+			map[string]interface{}{"a": "yes", "b": []string{"x: y", ""}},
+			[]int{3},
+			nil,
+		},
+		Map: map[string]string{"1": "#", "key": "1.5"},
+	}
+	got, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `name: HDMI-A-1
+values:
+- 1
+- 2
+empty: []
+nested:
+- a: "yes"
+  b:
+  - "x: y"
+  - ""
+- - 3
+- null
+map:
+  "1": "#"
+  key: "1.5"
+`
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestToJSON(t *testing.T) {
+	for _, test := range []struct {
+		yaml, json string
+	}{
+		{"", "null"},
+		{"a: 1\nb: [1, 2]\n", `{"a":1,"b":[1,2]}`},
+		{"# comment\nname: HDMI-A-1 # trailing\ncolor: '#fff'\n", `{"color":"#fff","name":"HDMI-A-1"}`},
+		{"list:\n- a\n-  b: 1\n   c: x y\n- - 1\n  - 2\n", `{"list":["a",{"b":1,"c":"x y"},[1,2]]}`},
+		{"list:\n  - 010\n  - 0x10\n  - -1.5e3\n  - 1_000\n  - yes\n  - ~\n", `{"list":[10,16,-1500,"1_000","yes",null]}`},
+		{"pos: {x: 0, y: -1080}\nempty: {}\nnone: []\n", `{"empty":{},"none":[],"pos":{"x":0,"y":-1080}}`},
+		{"nested:\n  a:\n    b: \"q\\\"t\"\n  c: 'it''s'\n", `{"nested":{"a":{"b":"q\"t"},"c":"it's"}}`},
+		{"key:\n", `{"key":null}`},
+	} {
+		got, err := ToJSON([]byte(test.yaml))
+		if err != nil {
+			t.Errorf("%q: %s", test.yaml, err)
+		} else if string(got) != test.json {
+			t.Errorf("%q: got %s, want %s", test.yaml, got, test.json)
+		}
+	}
+
+	for _, bad := range []string{"a: 1\na: 2\n", "a: 1\n  b: 2\n", "a: [1, 2\n", "a: &x 1\n", "- a\nb: 1\n", "a: |\n  x\n"} {
+		if _, err := ToJSON([]byte(bad)); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
+
+func TestToJSONErrors(t *testing.T) {
+	for _, test := range []struct {
+		name, yaml, err string
+	}{
+		{"dedented mapping", "a:\n  b: 1\n c: 2\n", "line 3: unexpected indentation"},
+		{"overindented mapping", "a:\n  b: 1\n    c: 2\n", "line 3: unexpected indentation"},
+		{"indented after scalar", "a: 1\n  b: 2\n", "line 2: unexpected indentation"},
+		{"dedented sequence", "list:\n  - a\n - b\n", "line 3: unexpected indentation"},
+		{"mapping after sequence", "a:\n  - x\n  c: 1\n", "line 3: unexpected indentation"},
+		{"tab", "a:\n\tb: 1\n", "line 2: tabs are not allowed"},
+		{"tab after spaces", "a:\n  \tb: 1\n", "line 2: tabs are not allowed"},
+		{"unterminated double quote", "a: 1\nb: \"x\n", "line 2: unterminated string"},
+		{"unterminated single quote", "a: 'it''s\n", "line 1: unterminated string"},
+		{"unterminated document", "\"a: 1\n", "line 1: unterminated string"},
+		{"text after quote", "a: \"x\" y\n", `line 1: unexpected "y" after value`},
+		{"duplicate key", "a: 1\nb: 2\na: 3\n", `line 3: duplicate key "a"`},
+		{"duplicate quoted key", "'a': 1\na: 2\n", `line 2: duplicate key "a"`},
+		{"duplicate nested key", "a:\n  b: 1\n  b: 2\n", `line 3: duplicate key "b"`},
+		{"duplicate flow key", "a: {b: 1, b: 2}\n", `line 1: duplicate key "b"`},
+	} {
+		got, err := ToJSON([]byte(test.yaml))
+		if err == nil {
+			t.Errorf("%s: got %s, want an error", test.name, got)
+		} else if want := "yaml: " + test.err; !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("%s: got error %q, want %q", test.name, err, want)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	type point struct{ X, Y int }
+	in := struct {
+		Name   string
+		Points []point
+		Tags   map[string]string
+		Scale  float64
+		On     bool
+	}{"a: b", []point{{1, 2}, {-3, 4}}, map[string]string{"k": "#v", "": "empty"}, 1.25, true}
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := in
+	out.Points, out.Tags = nil, nil
+	if err := Unmarshal(data, &out); err != nil {
+		t.Fatalf("%s: %s", data, err)
+	}
+	if out.Name != in.Name || len(out.Points) != 2 || out.Points[1] != in.Points[1] ||
+		out.Tags["k"] != "#v" || out.Tags[""] != "empty" || out.Scale != 1.25 || !out.On {
+		t.Errorf("round trip of\n%s\ngave %+v", data, out)
+	}
+}